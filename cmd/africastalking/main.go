@@ -15,6 +15,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"git.defalsify.org/vise.git/engine"
 	"git.defalsify.org/vise.git/logging"
@@ -22,9 +23,19 @@ import (
 
 	"git.grassecon.net/urdt/ussd/config"
 	"git.grassecon.net/urdt/ussd/initializers"
+	"git.grassecon.net/urdt/ussd/internal/community"
+	"git.grassecon.net/urdt/ussd/internal/encryption"
+	"git.grassecon.net/urdt/ussd/internal/escrow"
 	"git.grassecon.net/urdt/ussd/internal/handlers"
 	httpserver "git.grassecon.net/urdt/ussd/internal/http"
+	"git.grassecon.net/urdt/ussd/internal/notify"
+	"git.grassecon.net/urdt/ussd/internal/operator"
+	"git.grassecon.net/urdt/ussd/internal/provision"
+	"git.grassecon.net/urdt/ussd/internal/risk"
+	"git.grassecon.net/urdt/ussd/internal/schedule"
 	"git.grassecon.net/urdt/ussd/internal/storage"
+	"git.grassecon.net/urdt/ussd/internal/subject"
+	"git.grassecon.net/urdt/ussd/internal/terms"
 	"git.grassecon.net/urdt/ussd/remote"
 )
 
@@ -79,6 +90,18 @@ func (arp *atRequestParser) GetSessionId(rq any) (string, error) {
 	return phoneNumber, nil
 }
 
+// GetTransportSessionId returns the session id Africa's Talking gives each dial.
+func (arp *atRequestParser) GetTransportSessionId(rq any) (string, error) {
+	rqv, ok := rq.(*http.Request)
+	if !ok {
+		return "", handlers.ErrInvalidRequest
+	}
+	if err := rqv.ParseForm(); err != nil {
+		return "", fmt.Errorf("failed to parse form data: %v", err)
+	}
+	return rqv.FormValue("sessionId"), nil
+}
+
 func (arp *atRequestParser) GetInput(rq any) ([]byte, error) {
 	rqv, ok := rq.(*http.Request)
 	if !ok {
@@ -98,6 +121,27 @@ func (arp *atRequestParser) GetInput(rq any) ([]byte, error) {
 	return []byte(parts[len(parts)-1]), nil
 }
 
+// GetInputHistory returns all the input of the session, which Africa's Talking sends in text with every request.
+func (arp *atRequestParser) GetInputHistory(rq any) ([][]byte, error) {
+	rqv, ok := rq.(*http.Request)
+	if !ok {
+		return nil, handlers.ErrInvalidRequest
+	}
+	if err := rqv.ParseForm(); err != nil {
+		return nil, fmt.Errorf("failed to parse form data: %v", err)
+	}
+
+	history := [][]byte{}
+	text := rqv.FormValue("text")
+	if text == "" {
+		return history, nil
+	}
+	for _, v := range strings.Split(text, "*") {
+		history = append(history, []byte(v))
+	}
+	return history, nil
+}
+
 func main() {
 	config.LoadConfig()
 
@@ -108,6 +152,9 @@ func main() {
 	var engineDebug bool
 	var host string
 	var port uint
+	var reconcileInterval uint
+	var scheduleInterval uint
+	var escrowInterval uint
 	flag.StringVar(&dbDir, "dbdir", ".state", "database dir to read from")
 	flag.StringVar(&resourceDir, "resourcedir", path.Join("services", "registration"), "resource dir")
 	flag.StringVar(&database, "db", "gdbm", "database to be used")
@@ -115,6 +162,9 @@ func main() {
 	flag.UintVar(&size, "s", 160, "max size of output")
 	flag.StringVar(&host, "h", initializers.GetEnv("HOST", "127.0.0.1"), "http host")
 	flag.UintVar(&port, "p", initializers.GetEnvUint("PORT", 7123), "http port")
+	flag.UintVar(&reconcileInterval, "reconcile-interval", initializers.GetEnvUint("RECONCILE_INTERVAL", 60), "seconds between account provisioning reconciliation runs")
+	flag.UintVar(&scheduleInterval, "schedule-interval", initializers.GetEnvUint("SCHEDULE_INTERVAL", 60), "seconds between runs of the due scheduled transfers")
+	flag.UintVar(&escrowInterval, "escrow-interval", initializers.GetEnvUint("ESCROW_INTERVAL", 60), "seconds between runs releasing and returning escrowed transfers")
 	flag.Parse()
 
 	logg.Infof("start command", "build", build, "dbdir", dbDir, "resourcedir", resourceDir, "outputsize", size)
@@ -170,12 +220,71 @@ func main() {
 		os.Exit(1)
 	}
 
+	riskEngine, err := risk.LoadEngine(config.RiskRulesPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	lhs.SetRiskEngine(riskEngine)
+
+	termsRegistry, err := terms.LoadRegistry(config.TermsPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	lhs.SetTermsRegistry(termsRegistry)
+
+	communityRegistry, err := community.LoadRegistry(config.CommunityRegistry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	lhs.SetCommunityRegistry(communityRegistry)
+
+	keyring, err := encryption.LoadKeyring(config.KeyringPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	lhs.SetKeyring(keyring)
+
 	accountService := remote.AccountService{}
-	hl, err := lhs.GetHandler(&accountService)
+	notifier := notify.NewNotifier()
+	lhs.SetNotifier(notifier)
+	simChecker := operator.NewSimChecker()
+	lhs.SetSimChecker(simChecker)
+	provisionIndexDb, err := menuStorageService.GetUserdataHandle(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	provisioner := provision.NewProvisioner(lhs.GetUserdataStore(), &accountService, notifier).WithIndexStore(provisionIndexDb)
+	lhs.SetProvisioner(provisioner)
+	escrowService := escrow.NewDefaultService(lhs.GetUserdataStore(), &accountService, notifier)
+	lhs.SetEscrowService(escrowService)
+
+	// the workers each use their own handle to the user data database
+	provisionerDb, err := menuStorageService.GetUserdataHandle(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	go provisioner.WithStore(lhs.NewUserdataStore(provisionerDb)).Run(ctx, time.Duration(reconcileInterval)*time.Second)
+
+	schedulerDb, err := menuStorageService.GetUserdataHandle(ctx)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, err.Error())
 		os.Exit(1)
 	}
+	scheduler := schedule.NewScheduler(lhs.NewUserdataStore(schedulerDb), &accountService, notifier).WithRiskEngine(riskEngine).WithSimChecker(simChecker)
+	go scheduler.Run(ctx, time.Duration(scheduleInterval)*time.Second)
+
+	escrowDb, err := menuStorageService.GetUserdataHandle(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	go escrowService.WithStore(lhs.NewUserdataStore(escrowDb)).Run(ctx, time.Duration(escrowInterval)*time.Second)
 
 	stateStore, err := menuStorageService.GetStateStore(ctx)
 	if err != nil {
@@ -183,6 +292,13 @@ func main() {
 		os.Exit(1)
 	}
 	defer stateStore.Close()
+	lhs.SetStateStore(stateStore)
+
+	hl, err := lhs.GetHandler(&accountService)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
 
 	rp := &atRequestParser{}
 	bsh := handlers.NewBaseSessionHandler(cfg, rs, stateStore, userdataStore, rp, hl)
@@ -190,6 +306,32 @@ func main() {
 
 	mux := http.NewServeMux()
 	mux.Handle(initializers.GetEnv("AT_ENDPOINT", "/"), sh)
+	if config.AdminApiToken != "" {
+		subjectDb, err := menuStorageService.GetUserdataHandle(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		subjectStateDb, err := menuStorageService.GetStateHandle(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		subjectService := subject.NewService(lhs.NewUserdataStore(subjectDb), subjectStateDb)
+		mux.Handle(httpserver.SubjectPath, httpserver.NewSubjectHandler(subjectService, config.AdminApiToken))
+
+		portDb, err := menuStorageService.GetUserdataHandle(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		portStateDb, err := menuStorageService.GetStateHandle(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		mux.Handle(httpserver.PortPath, httpserver.NewPortHandler(lhs.NewUserdataStore(portDb), portStateDb, config.AdminApiToken))
+	}
 
 	s := &http.Server{
 		Addr:    fmt.Sprintf("%s:%s", host, strconv.Itoa(int(port))),
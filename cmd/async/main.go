@@ -8,6 +8,7 @@ import (
 	"os/signal"
 	"path"
 	"syscall"
+	"time"
 
 	"git.defalsify.org/vise.git/engine"
 	"git.defalsify.org/vise.git/logging"
@@ -15,8 +16,17 @@ import (
 
 	"git.grassecon.net/urdt/ussd/config"
 	"git.grassecon.net/urdt/ussd/initializers"
+	"git.grassecon.net/urdt/ussd/internal/community"
+	"git.grassecon.net/urdt/ussd/internal/encryption"
+	"git.grassecon.net/urdt/ussd/internal/escrow"
 	"git.grassecon.net/urdt/ussd/internal/handlers"
+	"git.grassecon.net/urdt/ussd/internal/notify"
+	"git.grassecon.net/urdt/ussd/internal/operator"
+	"git.grassecon.net/urdt/ussd/internal/provision"
+	"git.grassecon.net/urdt/ussd/internal/risk"
+	"git.grassecon.net/urdt/ussd/internal/schedule"
 	"git.grassecon.net/urdt/ussd/internal/storage"
+	"git.grassecon.net/urdt/ussd/internal/terms"
 	"git.grassecon.net/urdt/ussd/remote"
 )
 
@@ -53,6 +63,9 @@ func main() {
 	var engineDebug bool
 	var host string
 	var port uint
+	var reconcileInterval uint
+	var scheduleInterval uint
+	var escrowInterval uint
 	flag.StringVar(&sessionId, "session-id", "075xx2123", "session id")
 	flag.StringVar(&dbDir, "dbdir", ".state", "database dir to read from")
 	flag.StringVar(&resourceDir, "resourcedir", path.Join("services", "registration"), "resource dir")
@@ -61,6 +74,9 @@ func main() {
 	flag.UintVar(&size, "s", 160, "max size of output")
 	flag.StringVar(&host, "h", initializers.GetEnv("HOST", "127.0.0.1"), "http host")
 	flag.UintVar(&port, "p", initializers.GetEnvUint("PORT", 7123), "http port")
+	flag.UintVar(&reconcileInterval, "reconcile-interval", initializers.GetEnvUint("RECONCILE_INTERVAL", 60), "seconds between account provisioning reconciliation runs")
+	flag.UintVar(&scheduleInterval, "schedule-interval", initializers.GetEnvUint("SCHEDULE_INTERVAL", 60), "seconds between runs of the due scheduled transfers")
+	flag.UintVar(&escrowInterval, "escrow-interval", initializers.GetEnvUint("ESCROW_INTERVAL", 60), "seconds between runs releasing and returning escrowed transfers")
 	flag.Parse()
 
 	logg.Infof("start command", "dbdir", dbDir, "resourcedir", resourceDir, "outputsize", size, "sessionId", sessionId)
@@ -106,13 +122,72 @@ func main() {
 
 	lhs, err := handlers.NewLocalHandlerService(ctx, pfp, true, dbResource, cfg, rs)
 	lhs.SetDataStore(&userdataStore)
+
+	riskEngine, err := risk.LoadEngine(config.RiskRulesPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	lhs.SetRiskEngine(riskEngine)
+
+	termsRegistry, err := terms.LoadRegistry(config.TermsPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	lhs.SetTermsRegistry(termsRegistry)
+
+	communityRegistry, err := community.LoadRegistry(config.CommunityRegistry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	lhs.SetCommunityRegistry(communityRegistry)
+
+	keyring, err := encryption.LoadKeyring(config.KeyringPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	lhs.SetKeyring(keyring)
+
 	accountService := remote.AccountService{}
+	notifier := notify.NewNotifier()
+	lhs.SetNotifier(notifier)
+	simChecker := operator.NewSimChecker()
+	lhs.SetSimChecker(simChecker)
+	provisionIndexDb, err := menuStorageService.GetUserdataHandle(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	provisioner := provision.NewProvisioner(lhs.GetUserdataStore(), &accountService, notifier).WithIndexStore(provisionIndexDb)
+	lhs.SetProvisioner(provisioner)
+	escrowService := escrow.NewDefaultService(lhs.GetUserdataStore(), &accountService, notifier)
+	lhs.SetEscrowService(escrowService)
 
-	hl, err := lhs.GetHandler(&accountService)
+	// the workers each use their own handle to the user data database
+	provisionerDb, err := menuStorageService.GetUserdataHandle(ctx)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, err.Error())
 		os.Exit(1)
 	}
+	go provisioner.WithStore(lhs.NewUserdataStore(provisionerDb)).Run(ctx, time.Duration(reconcileInterval)*time.Second)
+
+	schedulerDb, err := menuStorageService.GetUserdataHandle(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	scheduler := schedule.NewScheduler(lhs.NewUserdataStore(schedulerDb), &accountService, notifier).WithRiskEngine(riskEngine).WithSimChecker(simChecker)
+	go scheduler.Run(ctx, time.Duration(scheduleInterval)*time.Second)
+
+	escrowDb, err := menuStorageService.GetUserdataHandle(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	go escrowService.WithStore(lhs.NewUserdataStore(escrowDb)).Run(ctx, time.Duration(escrowInterval)*time.Second)
 
 	stateStore, err := menuStorageService.GetStateStore(ctx)
 	if err != nil {
@@ -120,6 +195,13 @@ func main() {
 		os.Exit(1)
 	}
 	defer stateStore.Close()
+	lhs.SetStateStore(stateStore)
+
+	hl, err := lhs.GetHandler(&accountService)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
 
 	rp := &asyncRequestParser{
 		sessionId: sessionId,
@@ -130,6 +212,7 @@ func main() {
 		Ctx:    ctx,
 		Writer: os.Stdout,
 		Config: cfg,
+		UserId: sessionId,
 	}
 
 	cint := make(chan os.Signal)
@@ -12,8 +12,11 @@ import (
 	"git.defalsify.org/vise.git/resource"
 	"git.grassecon.net/urdt/ussd/config"
 	"git.grassecon.net/urdt/ussd/initializers"
+	"git.grassecon.net/urdt/ussd/internal/encryption"
 	"git.grassecon.net/urdt/ussd/internal/handlers"
+	"git.grassecon.net/urdt/ussd/internal/risk"
 	"git.grassecon.net/urdt/ussd/internal/storage"
+	"git.grassecon.net/urdt/ussd/internal/terms"
 	"git.grassecon.net/urdt/ussd/remote"
 )
 
@@ -82,6 +85,12 @@ func main() {
 		os.Exit(1)
 	}
 
+	stateStore, err := menuStorageService.GetStateStore(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
 	dbResource, ok := rs.(*resource.DbResource)
 	if !ok {
 		fmt.Fprintf(os.Stderr, err.Error())
@@ -97,6 +106,28 @@ func main() {
 		os.Exit(1)
 	}
 
+	riskEngine, err := risk.LoadEngine(config.RiskRulesPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	lhs.SetRiskEngine(riskEngine)
+
+	termsRegistry, err := terms.LoadRegistry(config.TermsPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	lhs.SetTermsRegistry(termsRegistry)
+
+	keyring, err := encryption.LoadKeyring(config.KeyringPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	lhs.SetKeyring(keyring)
+	lhs.SetStateStore(stateStore)
+
 	accountService := remote.AccountService{}
 	hl, err := lhs.GetHandler(&accountService)
 	if err != nil {
@@ -0,0 +1,118 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+
+	"git.defalsify.org/vise.git/db"
+)
+
+const (
+	aliasPattern = `^[a-z][a-z0-9_]{2,15}$`
+)
+
+var (
+	ErrInvalidAlias = errors.New("invalid alias")
+	ErrAliasTaken   = errors.New("alias already taken")
+)
+
+var (
+	// aliasMu guards the alias reverse lookups, so that an alias cannot be claimed by two accounts at once.
+	//
+	// The store has no conditional writes, so the guard only holds within the process; only one process may
+	// serve requests on the user data database at a time.
+	aliasMu sync.Mutex
+)
+
+// NormalizeAlias trims and lowercases the given alias.
+func NormalizeAlias(alias string) string {
+	return strings.ToLower(strings.TrimSpace(alias))
+}
+
+// IsValidAlias checks whether the alias is 3 to 16 characters long, starts with a letter
+// and only contains letters, digits and underscores.
+//
+// Aliases can never be confused with phone numbers or addresses, since they must start with a letter.
+func IsValidAlias(alias string) bool {
+	match, _ := regexp.MatchString(aliasPattern, alias)
+	return match
+}
+
+// ResolveAlias returns the session id (phone number) that has claimed the given alias.
+func ResolveAlias(ctx context.Context, store DataStore, alias string) (string, error) {
+	alias = NormalizeAlias(alias)
+	v, err := store.ReadEntry(ctx, alias, DATA_ALIAS_REVERSE)
+	if err != nil {
+		return "", err
+	}
+	if len(v) == 0 {
+		return "", db.NewErrNotFound([]byte(alias))
+	}
+	return string(v), nil
+}
+
+// ClaimAlias assigns the alias to the given session id, releasing any alias previously held by it.
+func ClaimAlias(ctx context.Context, store DataStore, sessionId string, alias string) error {
+	alias = NormalizeAlias(alias)
+	if !IsValidAlias(alias) {
+		return ErrInvalidAlias
+	}
+	aliasMu.Lock()
+	defer aliasMu.Unlock()
+
+	owner, err := ResolveAlias(ctx, store, alias)
+	if err == nil {
+		if owner == sessionId {
+			return nil
+		}
+		return ErrAliasTaken
+	}
+	if !db.IsNotFound(err) {
+		return err
+	}
+
+	current, err := store.ReadEntry(ctx, sessionId, DATA_ALIAS)
+	if err == nil && len(current) > 0 {
+		err = store.WriteEntry(ctx, string(current), DATA_ALIAS_REVERSE, []byte{})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = store.WriteEntry(ctx, alias, DATA_ALIAS_REVERSE, []byte(sessionId))
+	if err != nil {
+		return err
+	}
+	return store.WriteEntry(ctx, sessionId, DATA_ALIAS, []byte(alias))
+}
+
+// ReleaseAlias frees the alias claimed by the session id, so that it no longer resolves to it.
+func ReleaseAlias(ctx context.Context, store DataStore, sessionId string) error {
+	aliasMu.Lock()
+	defer aliasMu.Unlock()
+
+	alias, err := store.ReadEntry(ctx, sessionId, DATA_ALIAS)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if len(alias) == 0 {
+		return nil
+	}
+	owner, err := ResolveAlias(ctx, store, string(alias))
+	if err != nil {
+		if db.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if owner != sessionId {
+		return nil
+	}
+	return store.WriteEntry(ctx, string(alias), DATA_ALIAS_REVERSE, []byte{})
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestIsValidAlias(t *testing.T) {
+	assert.True(t, IsValidAlias("mama_mboga"))
+	assert.True(t, IsValidAlias("shop42"))
+	assert.False(t, IsValidAlias("ab"))
+	assert.False(t, IsValidAlias("0712345678"))
+	assert.False(t, IsValidAlias("0xabc"))
+	assert.False(t, IsValidAlias("Shop42"))
+	assert.False(t, IsValidAlias("averyveryverylongalias"))
+}
+
+func TestClaimAlias(t *testing.T) {
+	ctx, store := InitializeTestDb(t)
+
+	err := ClaimAlias(ctx, store, "0711111111", "Shop42")
+	assert.NoError(t, err)
+
+	owner, err := ResolveAlias(ctx, store, "shop42")
+	assert.NoError(t, err)
+	assert.Equal(t, "0711111111", owner)
+
+	// claiming the same alias again is a no-op
+	err = ClaimAlias(ctx, store, "0711111111", "shop42")
+	assert.NoError(t, err)
+
+	err = ClaimAlias(ctx, store, "0722222222", "shop42")
+	assert.Equal(t, ErrAliasTaken, err)
+
+	err = ClaimAlias(ctx, store, "0722222222", "4shop")
+	assert.Equal(t, ErrInvalidAlias, err)
+
+	// changing alias releases the old one
+	err = ClaimAlias(ctx, store, "0711111111", "duka")
+	assert.NoError(t, err)
+	_, err = ResolveAlias(ctx, store, "shop42")
+	assert.Error(t, err)
+
+	err = ClaimAlias(ctx, store, "0722222222", "shop42")
+	assert.NoError(t, err)
+}
+
+func TestClaimAliasConcurrent(t *testing.T) {
+	ctx, store := InitializeTestDb(t)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = ClaimAlias(ctx, store, fmt.Sprintf("07%08d", i), "shop42")
+		}(i)
+	}
+	wg.Wait()
+
+	var claimed int
+	for i, err := range errs {
+		if err == nil {
+			claimed++
+			owner, err := ResolveAlias(ctx, store, "shop42")
+			assert.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("07%08d", i), owner)
+			continue
+		}
+		assert.Equal(t, ErrAliasTaken, err)
+	}
+	assert.Equal(t, 1, claimed)
+}
@@ -0,0 +1,188 @@
+package common
+
+import (
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidAmount     = errors.New("invalid amount")
+	ErrAmountPrecision   = errors.New("amount has more decimals than the token")
+	ErrAmountNotPositive = errors.New("amount must be more than zero")
+)
+
+// Amount is an exact amount of a token, kept as an integer in the smallest unit of the token.
+type Amount struct {
+	value    *big.Int
+	decimals int
+}
+
+// NewAmount creates an Amount from an integer in the smallest unit of the token, as balances are given by the API.
+func NewAmount(raw string, decimals string) (Amount, error) {
+	dec, err := parseDecimals(decimals)
+	if err != nil {
+		return Amount{}, err
+	}
+	v, ok := new(big.Int).SetString(raw, 10)
+	if !ok || v.Sign() < 0 {
+		return Amount{}, ErrInvalidAmount
+	}
+	return Amount{value: v, decimals: dec}, nil
+}
+
+// ParseAmount parses a decimal amount of a token with the given decimals, like "1,000.50".
+//
+// Commas are accepted as thousands separators. It fails if the amount has more decimals than the token.
+func ParseAmount(s string, decimals string) (Amount, error) {
+	dec, err := parseDecimals(decimals)
+	if err != nil {
+		return Amount{}, err
+	}
+	return parseAmount(s, dec, false)
+}
+
+// ParseDecimal parses a decimal amount like "1000.5", keeping as many decimals as it is written with.
+//
+// It is for amounts that are not tied to a token, like configured limits, and can be compared with
+// and added to amounts of any token.
+func ParseDecimal(s string) (Amount, error) {
+	_, frac, _ := strings.Cut(strings.TrimSpace(s), ".")
+	return parseAmount(s, len(frac), false)
+}
+
+// ParseTransferAmount parses an amount entered by the user for a transfer, which must be more than zero.
+func ParseTransferAmount(s string, decimals string) (Amount, error) {
+	a, err := ParseAmount(s, decimals)
+	if err != nil {
+		return a, err
+	}
+	if a.Sign() <= 0 {
+		return a, ErrAmountNotPositive
+	}
+	return a, nil
+}
+
+// Raw returns the amount as an integer in the smallest unit of the token, as transfers are given to the API.
+func (a Amount) Raw() string {
+	return a.int().String()
+}
+
+// String returns the amount in whole tokens, without trailing zeros.
+func (a Amount) String() string {
+	digits := a.int().String()
+	if a.decimals == 0 {
+		return digits
+	}
+	if len(digits) <= a.decimals {
+		digits = strings.Repeat("0", a.decimals-len(digits)+1) + digits
+	}
+	whole := digits[:len(digits)-a.decimals]
+	frac := strings.TrimRight(digits[len(digits)-a.decimals:], "0")
+	if frac == "" {
+		return whole
+	}
+	return whole + "." + frac
+}
+
+// Sign returns -1, 0 or 1 depending on the sign of the amount.
+func (a Amount) Sign() int {
+	return a.int().Sign()
+}
+
+// Cmp compares the amount with another, which may have different decimals.
+//
+// It returns -1 if a is less than b, 0 if they are equal and 1 if a is more than b.
+func (a Amount) Cmp(b Amount) int {
+	x := a.int()
+	y := b.int()
+	if a.decimals < b.decimals {
+		x = scale(x, b.decimals-a.decimals)
+	} else if b.decimals < a.decimals {
+		y = scale(y, a.decimals-b.decimals)
+	}
+	return x.Cmp(y)
+}
+
+// Add returns the sum of the amount and another, which may have different decimals.
+//
+// The sum has the larger of the two decimals.
+func (a Amount) Add(b Amount) Amount {
+	x := a.int()
+	y := b.int()
+	dec := a.decimals
+	if a.decimals < b.decimals {
+		x = scale(x, b.decimals-a.decimals)
+		dec = b.decimals
+	} else if b.decimals < a.decimals {
+		y = scale(y, a.decimals-b.decimals)
+	}
+	return Amount{value: new(big.Int).Add(x, y), decimals: dec}
+}
+
+func (a Amount) int() *big.Int {
+	if a.value == nil {
+		return new(big.Int)
+	}
+	return a.value
+}
+
+func scale(v *big.Int, decimals int) *big.Int {
+	m := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	return m.Mul(m, v)
+}
+
+func parseDecimals(decimals string) (int, error) {
+	dec, err := strconv.Atoi(decimals)
+	if err != nil {
+		return 0, err
+	}
+	if dec < 0 {
+		return 0, errors.New("invalid token decimals")
+	}
+	return dec, nil
+}
+
+// parseAmount parses a decimal amount with the given decimals. Decimals beyond those of the token are
+// truncated if truncate is set, otherwise they are only accepted if they are zeros.
+func parseAmount(s string, decimals int, truncate bool) (Amount, error) {
+	s = strings.TrimSpace(s)
+	whole, frac, _ := strings.Cut(s, ".")
+	if strings.Contains(whole, ",") {
+		groups := strings.Split(whole, ",")
+		for i, g := range groups {
+			if len(g) > 3 || len(g) == 0 || (i > 0 && len(g) != 3) {
+				return Amount{}, ErrInvalidAmount
+			}
+		}
+		whole = strings.Join(groups, "")
+	}
+	if whole == "" && frac == "" {
+		return Amount{}, ErrInvalidAmount
+	}
+	if !isDigits(whole) || !isDigits(frac) {
+		return Amount{}, ErrInvalidAmount
+	}
+	if len(frac) > decimals {
+		if !truncate && strings.TrimRight(frac[decimals:], "0") != "" {
+			return Amount{}, ErrAmountPrecision
+		}
+		frac = frac[:decimals]
+	}
+	frac += strings.Repeat("0", decimals-len(frac))
+	v, ok := new(big.Int).SetString("0"+whole+frac, 10)
+	if !ok {
+		return Amount{}, ErrInvalidAmount
+	}
+	return Amount{value: v, decimals: decimals}, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
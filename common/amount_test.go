@@ -0,0 +1,145 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		decimals string
+		raw      string
+		want     string
+		err      error
+	}{
+		{
+			name:     "whole number",
+			input:    "50",
+			decimals: "6",
+			raw:      "50000000",
+			want:     "50",
+		},
+		{
+			name:     "thousands separator",
+			input:    "1,000.50",
+			decimals: "2",
+			raw:      "100050",
+			want:     "1000.5",
+		},
+		{
+			name:     "full precision",
+			input:    "0.123456789012345678",
+			decimals: "18",
+			raw:      "123456789012345678",
+			want:     "0.123456789012345678",
+		},
+		{
+			name:     "trailing zeros beyond the decimals",
+			input:    "4.100",
+			decimals: "1",
+			raw:      "41",
+			want:     "4.1",
+		},
+		{
+			name:     "too many decimals",
+			input:    "4.105",
+			decimals: "2",
+			err:      ErrAmountPrecision,
+		},
+		{
+			name:     "misplaced separator",
+			input:    "10,00",
+			decimals: "2",
+			err:      ErrInvalidAmount,
+		},
+		{
+			name:     "negative",
+			input:    "-5",
+			decimals: "2",
+			err:      ErrInvalidAmount,
+		},
+		{
+			name:     "not a number",
+			input:    "0.02ms",
+			decimals: "2",
+			err:      ErrInvalidAmount,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := ParseAmount(tt.input, tt.decimals)
+			if tt.err != nil {
+				assert.Equal(t, tt.err, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.raw, a.Raw())
+			assert.Equal(t, tt.want, a.String())
+		})
+	}
+}
+
+func TestParseTransferAmount(t *testing.T) {
+	_, err := ParseTransferAmount("0.00", "2")
+	assert.Equal(t, ErrAmountNotPositive, err)
+
+	a, err := ParseTransferAmount("0.01", "2")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", a.Raw())
+}
+
+func TestAmountCmp(t *testing.T) {
+	balance, err := NewAmount("1000000000000000001", "18")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.000000000000000001", balance.String())
+
+	a, err := ParseAmount("1", "18")
+	assert.NoError(t, err)
+	assert.Equal(t, -1, a.Cmp(balance))
+
+	a, err = ParseAmount("1.000000000000000001", "18")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, a.Cmp(balance))
+
+	b, err := ParseAmount("1.01", "2")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, b.Cmp(balance))
+	assert.Equal(t, -1, balance.Cmp(b))
+}
+
+func TestAmountAdd(t *testing.T) {
+	a, err := ParseAmount("0.1", "6")
+	assert.NoError(t, err)
+	b, err := ParseAmount("0.2", "6")
+	assert.NoError(t, err)
+	sum := a.Add(b)
+	assert.Equal(t, "0.3", sum.String())
+	assert.Equal(t, "300000", sum.Raw())
+
+	c, err := ParseAmount("1.01", "2")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.31", sum.Add(c).String())
+	assert.Equal(t, "1.31", Amount{}.Add(sum).Add(c).String())
+}
+
+func TestParseDecimal(t *testing.T) {
+	a, err := ParseDecimal("2000")
+	assert.NoError(t, err)
+	assert.Equal(t, "2000", a.Raw())
+
+	b, err := ParseDecimal("1,999.999")
+	assert.NoError(t, err)
+	assert.Equal(t, "1999999", b.Raw())
+	assert.Equal(t, -1, b.Cmp(a))
+
+	balance, err := NewAmount("2000000000000000000001", "18")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, balance.Cmp(a))
+
+	_, err = ParseDecimal("1.2.3")
+	assert.Error(t, err)
+}
@@ -0,0 +1,95 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+)
+
+const (
+	// DefaultAuthMaxAge is how long a PIN entry authorizes sensitive operations when no max age is configured.
+	DefaultAuthMaxAge = 5 * time.Minute
+)
+
+// AuthToken records a successful PIN entry.
+type AuthToken struct {
+	Id       string
+	IssuedAt time.Time
+}
+
+// NewAuthToken creates a new AuthToken issued at the current time.
+func NewAuthToken() (AuthToken, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return AuthToken{}, err
+	}
+	return AuthToken{
+		Id:       hex.EncodeToString(b),
+		IssuedAt: time.Now(),
+	}, nil
+}
+
+// String serializes the token as "<id>:<unix timestamp>".
+func (t AuthToken) String() string {
+	return fmt.Sprintf("%s:%d", t.Id, t.IssuedAt.Unix())
+}
+
+// ParseAuthToken parses a token serialized with String.
+func ParseAuthToken(v []byte) (AuthToken, error) {
+	parts := strings.SplitN(string(v), ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return AuthToken{}, fmt.Errorf("invalid auth token: %q", v)
+	}
+	ts, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return AuthToken{}, fmt.Errorf("invalid auth token timestamp: %v", err)
+	}
+	return AuthToken{
+		Id:       parts[0],
+		IssuedAt: time.Unix(ts, 0),
+	}, nil
+}
+
+// IsFresh checks whether the token was issued less than maxAge before now.
+func (t AuthToken) IsFresh(maxAge time.Duration, now time.Time) bool {
+	if t.IssuedAt.After(now) {
+		return false
+	}
+	return now.Sub(t.IssuedAt) < maxAge
+}
+
+// IssueAuthToken creates and stores a new AuthToken for the session id, replacing any previous one.
+func IssueAuthToken(ctx context.Context, store DataStore, sessionId string) (AuthToken, error) {
+	t, err := NewAuthToken()
+	if err != nil {
+		return t, err
+	}
+	err = store.WriteEntry(ctx, sessionId, DATA_AUTH_TOKEN, []byte(t.String()))
+	return t, err
+}
+
+// ReadAuthToken returns the stored AuthToken for the session id.
+//
+// A not found error is returned if no token has been issued, or it has been revoked.
+func ReadAuthToken(ctx context.Context, store DataStore, sessionId string) (AuthToken, error) {
+	v, err := store.ReadEntry(ctx, sessionId, DATA_AUTH_TOKEN)
+	if err != nil {
+		return AuthToken{}, err
+	}
+	if len(v) == 0 {
+		return AuthToken{}, db.NewErrNotFound([]byte(sessionId))
+	}
+	return ParseAuthToken(v)
+}
+
+// RevokeAuthToken invalidates the stored AuthToken for the session id.
+func RevokeAuthToken(ctx context.Context, store DataStore, sessionId string) error {
+	return store.WriteEntry(ctx, sessionId, DATA_AUTH_TOKEN, []byte{})
+}
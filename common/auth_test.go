@@ -0,0 +1,51 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestAuthTokenRoundTrip(t *testing.T) {
+	tok, err := NewAuthToken()
+	assert.NoError(t, err)
+
+	parsed, err := ParseAuthToken([]byte(tok.String()))
+	assert.NoError(t, err)
+	assert.Equal(t, tok.Id, parsed.Id)
+	assert.Equal(t, tok.IssuedAt.Unix(), parsed.IssuedAt.Unix())
+
+	_, err = ParseAuthToken([]byte("foo"))
+	assert.Error(t, err)
+	_, err = ParseAuthToken([]byte("foo:bar"))
+	assert.Error(t, err)
+}
+
+func TestAuthTokenIsFresh(t *testing.T) {
+	now := time.Now()
+	tok := AuthToken{Id: "a1", IssuedAt: now.Add(-time.Minute)}
+	assert.True(t, tok.IsFresh(2*time.Minute, now))
+	assert.False(t, tok.IsFresh(time.Minute, now))
+	assert.False(t, tok.IsFresh(time.Minute, now.Add(-2*time.Minute)))
+}
+
+func TestIssueAndRevokeAuthToken(t *testing.T) {
+	ctx, store := InitializeTestDb(t)
+	sessionId := "+254712345678"
+
+	_, err := ReadAuthToken(ctx, store, sessionId)
+	assert.True(t, db.IsNotFound(err))
+
+	tok, err := IssueAuthToken(ctx, store, sessionId)
+	assert.NoError(t, err)
+	stored, err := ReadAuthToken(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, tok.Id, stored.Id)
+
+	err = RevokeAuthToken(ctx, store, sessionId)
+	assert.NoError(t, err)
+	_, err = ReadAuthToken(ctx, store, sessionId)
+	assert.True(t, db.IsNotFound(err))
+}
@@ -0,0 +1,120 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"git.defalsify.org/vise.git/db"
+)
+
+const (
+	// MaxBatchRecipients is the most recipients a single batch transfer can pay.
+	MaxBatchRecipients = 5
+)
+
+const (
+	// BatchPending marks a batch entry that has not been sent yet.
+	BatchPending = "pending"
+	// BatchSent marks a batch entry whose transfer was accepted by the API.
+	BatchSent = "sent"
+	// BatchFailed marks a batch entry whose transfer failed.
+	BatchFailed = "failed"
+)
+
+var (
+	ErrBatchFull = errors.New("batch has the most recipients allowed")
+)
+
+// BatchEntry is a recipient of a batch transfer and the amount they are paid.
+type BatchEntry struct {
+	// Recipient is the phone number, alias or address as entered by the user.
+	Recipient string
+	// Address is the address the recipient resolved to.
+	Address string
+	// Amount is the amount in whole tokens of the active voucher.
+	Amount     string
+	Status     string
+	TrackingId string
+}
+
+// ReadBatch returns the entries of the batch transfer of the session id.
+func ReadBatch(ctx context.Context, store DataStore, sessionId string) ([]BatchEntry, error) {
+	v, err := store.ReadEntry(ctx, sessionId, DATA_BATCH)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var r []BatchEntry
+	for _, line := range strings.Split(string(v), "\n") {
+		if line == "" {
+			continue
+		}
+		e, err := parseBatchEntry(line)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, e)
+	}
+	return r, nil
+}
+
+// WriteBatch replaces the entries of the batch transfer of the session id.
+func WriteBatch(ctx context.Context, store DataStore, sessionId string, entries []BatchEntry) error {
+	var lines []string
+	for _, e := range entries {
+		lines = append(lines, formatBatchEntry(e))
+	}
+	return store.WriteEntry(ctx, sessionId, DATA_BATCH, []byte(strings.Join(lines, "\n")))
+}
+
+// AddBatchEntry adds a pending entry to the batch transfer of the session id, and returns all entries.
+//
+// It fails with ErrBatchFull if the batch already has MaxBatchRecipients entries.
+func AddBatchEntry(ctx context.Context, store DataStore, sessionId string, e BatchEntry) ([]BatchEntry, error) {
+	entries, err := ReadBatch(ctx, store, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) >= MaxBatchRecipients {
+		return entries, ErrBatchFull
+	}
+	e.Status = BatchPending
+	e.TrackingId = ""
+	entries = append(entries, e)
+	return entries, WriteBatch(ctx, store, sessionId, entries)
+}
+
+// BatchTotal returns the sum of the amounts of the entries, in a token with the given decimals.
+func BatchTotal(entries []BatchEntry, decimals string) (Amount, error) {
+	var total Amount
+	for _, e := range entries {
+		a, err := ParseAmount(e.Amount, decimals)
+		if err != nil {
+			return total, err
+		}
+		total = total.Add(a)
+	}
+	return total, nil
+}
+
+func formatBatchEntry(e BatchEntry) string {
+	return strings.Join([]string{e.Recipient, e.Address, e.Amount, e.Status, e.TrackingId}, "|")
+}
+
+func parseBatchEntry(s string) (BatchEntry, error) {
+	parts := strings.Split(s, "|")
+	if len(parts) != 5 {
+		return BatchEntry{}, fmt.Errorf("invalid batch entry: %q", s)
+	}
+	return BatchEntry{
+		Recipient:  parts[0],
+		Address:    parts[1],
+		Amount:     parts[2],
+		Status:     parts[3],
+		TrackingId: parts[4],
+	}, nil
+}
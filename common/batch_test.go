@@ -0,0 +1,48 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestBatch(t *testing.T) {
+	sessionId := "+254712345678"
+	ctx, store := InitializeTestDb(t)
+
+	entries, err := ReadBatch(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+
+	for i := 0; i < MaxBatchRecipients; i++ {
+		entries, err = AddBatchEntry(ctx, store, sessionId, BatchEntry{
+			Recipient: "+25471234567" + string(rune('0'+i)),
+			Address:   "0x" + string(rune('a'+i)),
+			Amount:    "1.5",
+		})
+		assert.NoError(t, err)
+	}
+	_, err = AddBatchEntry(ctx, store, sessionId, BatchEntry{Recipient: "alice", Address: "0xf", Amount: "1"})
+	assert.Equal(t, ErrBatchFull, err)
+
+	total, err := BatchTotal(entries, "6")
+	assert.NoError(t, err)
+	assert.Equal(t, "7.5", total.String())
+
+	entries[1].Status = BatchSent
+	entries[1].TrackingId = "d95a7e83-196c-4fd0-866fSGAGA"
+	entries[2].Status = BatchFailed
+	err = WriteBatch(ctx, store, sessionId, entries)
+	assert.NoError(t, err)
+
+	r, err := ReadBatch(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, entries, r)
+	assert.Equal(t, BatchPending, r[0].Status)
+
+	err = WriteBatch(ctx, store, sessionId, nil)
+	assert.NoError(t, err)
+	r, err = ReadBatch(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(r))
+}
@@ -0,0 +1,131 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+
+	"git.grassecon.net/urdt/ussd/internal/storage"
+)
+
+const (
+	// closedAccountsKey is the key of the index of closed accounts.
+	closedAccountsKey = "accounts"
+)
+
+var (
+	ErrAccountClosed = errors.New("account is closed")
+)
+
+var (
+	// closureMu guards the index of closed accounts.
+	closureMu sync.Mutex
+)
+
+// CloseAccount marks the account of the session id as closed, and adds it to the index of closed accounts.
+//
+// Closing an account that is already closed keeps the original closing time.
+func CloseAccount(ctx context.Context, store DataStore, sessionId string) (time.Time, error) {
+	closedAt, closed, err := ReadClosure(ctx, store, sessionId)
+	if err != nil {
+		return closedAt, err
+	}
+	if closed {
+		return closedAt, nil
+	}
+	closedAt = time.Now()
+	err = store.WriteEntry(ctx, sessionId, DATA_ACCOUNT_CLOSED, []byte(strconv.FormatInt(closedAt.Unix(), 10)))
+	if err != nil {
+		return closedAt, err
+	}
+	return closedAt, updateClosedAccounts(ctx, store, sessionId, true)
+}
+
+// ReopenAccount lifts the closure of the account of the session id, and removes it from the index of closed accounts.
+//
+// It is meant for admins approving the reactivation of an account.
+func ReopenAccount(ctx context.Context, store DataStore, sessionId string) error {
+	_, closed, err := ReadClosure(ctx, store, sessionId)
+	if err != nil {
+		return err
+	}
+	if !closed {
+		return fmt.Errorf("account %s is not closed", sessionId)
+	}
+	err = store.WriteEntry(ctx, sessionId, DATA_ACCOUNT_CLOSED, []byte{})
+	if err != nil {
+		return err
+	}
+	return updateClosedAccounts(ctx, store, sessionId, false)
+}
+
+// ReadClosure returns the time the account of the session id was closed, and whether it is closed.
+func ReadClosure(ctx context.Context, store DataStore, sessionId string) (time.Time, bool, error) {
+	v, err := store.ReadEntry(ctx, sessionId, DATA_ACCOUNT_CLOSED)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, err
+	}
+	if len(v) == 0 {
+		return time.Time{}, false, nil
+	}
+	ts, err := strconv.ParseInt(string(v), 10, 64)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid closure timestamp: %v", err)
+	}
+	return time.Unix(ts, 0), true, nil
+}
+
+// ClosedAccounts returns the index of closed accounts.
+func ClosedAccounts(ctx context.Context, store DataStore) ([]string, error) {
+	closureMu.Lock()
+	defer closureMu.Unlock()
+	return readClosedAccounts(ctx, store)
+}
+
+// readClosedAccounts reads the index of closed accounts.
+//
+// The index is kept outside of any session.
+func readClosedAccounts(ctx context.Context, store DataStore) ([]string, error) {
+	store.SetSession("")
+	v, err := storage.NewSubPrefixDb(store, []byte("closure")).Get(ctx, []byte(closedAccountsKey))
+	if err != nil {
+		if db.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, nil
+	}
+	return strings.Split(string(v), "\n"), nil
+}
+
+// updateClosedAccounts adds the session id to the index of closed accounts, or removes it.
+func updateClosedAccounts(ctx context.Context, store DataStore, sessionId string, closed bool) error {
+	closureMu.Lock()
+	defer closureMu.Unlock()
+	sessionIds, err := readClosedAccounts(ctx, store)
+	if err != nil {
+		return err
+	}
+	var r []string
+	for _, v := range sessionIds {
+		if v != sessionId {
+			r = append(r, v)
+		}
+	}
+	if closed {
+		r = append(r, sessionId)
+	}
+	store.SetSession("")
+	return storage.NewSubPrefixDb(store, []byte("closure")).Put(ctx, []byte(closedAccountsKey), []byte(strings.Join(r, "\n")))
+}
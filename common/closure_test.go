@@ -0,0 +1,39 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestCloseAccount(t *testing.T) {
+	ctx, store := InitializeTestDb(t)
+	sessionIds := []string{"+254712345678", "+254787654321"}
+
+	_, closed, err := ReadClosure(ctx, store, sessionIds[0])
+	assert.NoError(t, err)
+	assert.False(t, closed)
+	assert.Error(t, ReopenAccount(ctx, store, sessionIds[0]))
+
+	var closedAt []int64
+	for _, sessionId := range sessionIds {
+		ts, err := CloseAccount(ctx, store, sessionId)
+		assert.NoError(t, err)
+		closedAt = append(closedAt, ts.Unix())
+	}
+	ts, err := CloseAccount(ctx, store, sessionIds[0])
+	assert.NoError(t, err)
+	assert.Equal(t, closedAt[0], ts.Unix())
+
+	r, err := ClosedAccounts(ctx, store)
+	assert.NoError(t, err)
+	assert.Equal(t, sessionIds, r)
+
+	assert.NoError(t, ReopenAccount(ctx, store, sessionIds[0]))
+	_, closed, err = ReadClosure(ctx, store, sessionIds[0])
+	assert.NoError(t, err)
+	assert.False(t, closed)
+	r, err = ClosedAccounts(ctx, store)
+	assert.NoError(t, err)
+	assert.Equal(t, sessionIds[1:], r)
+}
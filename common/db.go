@@ -33,6 +33,27 @@ const (
 	DATA_ACTIVE_DECIMAL
 	DATA_ACTIVE_ADDRESS
 	DATA_TRANSACTIONS
+	DATA_ALIAS
+	DATA_ALIAS_REVERSE
+	DATA_PIN_HISTORY
+	DATA_AUTH_TOKEN
+	DATA_TRANSFER_LOG
+	DATA_ACCOUNT_FROZEN
+	DATA_GUARDIANS
+	DATA_RECOVERY_REQUEST
+	DATA_TERMS_ACCEPTANCE
+	DATA_NUMBER_CHANGE
+	DATA_PORTED_TO
+	DATA_ACCOUNT_CLOSED
+	DATA_LANGUAGE_CODE
+	DATA_ACTIVE_BAL_UPDATED
+	DATA_BATCH
+	DATA_PIN_CHANGE_REQUIRED
+	DATA_PAYMENT_REQUESTS
+	DATA_PAYMENT_REQUEST_DRAFT
+	DATA_SCHEDULES
+	DATA_SCHEDULE_DRAFT
+	DATA_KNOWN_RECIPIENTS
 )
 
 var (
@@ -0,0 +1,296 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+
+	"git.grassecon.net/urdt/ussd/internal/storage"
+)
+
+const (
+	// MaxClaims is the most escrowed transfers an unregistered number can have waiting for it.
+	MaxClaims = 5
+	// DefaultClaimTTL is how long an escrowed transfer waits to be claimed when no expiry is configured.
+	DefaultClaimTTL = 30 * 24 * time.Hour
+	// claimNumbersKey is the key of the index of numbers with escrowed transfers.
+	claimNumbersKey = "numbers"
+)
+
+const (
+	// ClaimPending is the status of a claim whose transfer to the escrow account has not been confirmed yet.
+	ClaimPending = "pending"
+	// ClaimHeld is the status of a claim whose transfer is held by the escrow account.
+	ClaimHeld = "held"
+	// ClaimReleasing is the status of a claim whose transfer is being sent to the recipient.
+	ClaimReleasing = "releasing"
+	// ClaimRefunding is the status of a claim whose transfer is being returned to the sender.
+	ClaimRefunding = "refunding"
+)
+
+var (
+	ErrClaimsFull  = errors.New("too many escrowed transfers")
+	ErrNoClaim     = errors.New("no such escrowed transfer")
+	ErrClaimStatus = errors.New("escrowed transfer has another status")
+)
+
+var (
+	// escrowMu guards the escrowed transfers and their index.
+	escrowMu sync.Mutex
+)
+
+// Claim is a transfer sent to a number that is not registered yet, held by the escrow account until the number
+// registers and claims it.
+type Claim struct {
+	// Id identifies the claim among the claims of the number.
+	Id string
+	// Sender is the session id of the sender, and SenderAddress the address the transfer is returned to.
+	Sender        string
+	SenderAddress string
+	// Recipient is the normalized phone number the transfer was sent to.
+	Recipient string
+	// Amount is the amount in whole tokens of the voucher.
+	Amount string
+	// Symbol, Address and Decimals describe the voucher that was sent.
+	Symbol    string
+	Address   string
+	Decimals  string
+	CreatedAt time.Time
+	// Status tells how far the transfer has got. A claim left pending, releasing or refunding was interrupted
+	// before the outcome of its transfer was known, and has to be resolved by hand.
+	Status string
+	// TrackingId is the tracking id of the transfer to the escrow account.
+	TrackingId string
+}
+
+// String serializes the claim as "|" separated fields.
+func (c Claim) String() string {
+	var created string
+	if !c.CreatedAt.IsZero() {
+		created = strconv.FormatInt(c.CreatedAt.Unix(), 10)
+	}
+	return strings.Join([]string{c.Id, c.Sender, c.SenderAddress, c.Recipient, c.Amount, c.Symbol, c.Address, c.Decimals, created, c.Status, c.TrackingId}, "|")
+}
+
+// ParseClaim parses a claim serialized with String.
+func ParseClaim(v []byte) (Claim, error) {
+	var c Claim
+	parts := strings.Split(string(v), "|")
+	if len(parts) != 11 {
+		return c, fmt.Errorf("invalid claim: %q", v)
+	}
+	c.Id = parts[0]
+	c.Sender = parts[1]
+	c.SenderAddress = parts[2]
+	c.Recipient = parts[3]
+	c.Amount = parts[4]
+	c.Symbol = parts[5]
+	c.Address = parts[6]
+	c.Decimals = parts[7]
+	if parts[8] != "" {
+		ts, err := strconv.ParseInt(parts[8], 10, 64)
+		if err != nil {
+			return c, fmt.Errorf("invalid claim timestamp: %v", err)
+		}
+		c.CreatedAt = time.Unix(ts, 0)
+	}
+	c.Status = parts[9]
+	c.TrackingId = parts[10]
+	return c, nil
+}
+
+// IsExpired checks whether the claim is too old to be claimed, and should be returned to the sender.
+func (c Claim) IsExpired(now time.Time, ttl time.Duration) bool {
+	return now.Sub(c.CreatedAt) >= ttl
+}
+
+// ExpiresAt returns when the claim expires.
+func (c Claim) ExpiresAt(ttl time.Duration) time.Time {
+	return c.CreatedAt.Add(ttl)
+}
+
+// escrowDb returns the store of escrowed transfers, which is kept outside of any session.
+func escrowDb(store DataStore) *storage.SubPrefixDb {
+	store.SetSession("")
+	return storage.NewSubPrefixDb(store, []byte("escrow"))
+}
+
+// ReadClaims returns the escrowed transfers waiting for the normalized phone number, oldest first.
+func ReadClaims(ctx context.Context, store DataStore, number string) ([]Claim, error) {
+	escrowMu.Lock()
+	defer escrowMu.Unlock()
+	return readClaims(ctx, store, number)
+}
+
+func readClaims(ctx context.Context, store DataStore, number string) ([]Claim, error) {
+	v, err := escrowDb(store).Get(ctx, []byte(number))
+	if err != nil {
+		if db.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var r []Claim
+	for _, line := range strings.Split(string(v), "\n") {
+		if line == "" {
+			continue
+		}
+		c, err := ParseClaim([]byte(line))
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, c)
+	}
+	return r, nil
+}
+
+func writeClaims(ctx context.Context, store DataStore, number string, claims []Claim) error {
+	var lines []string
+	for _, c := range claims {
+		lines = append(lines, c.String())
+	}
+	err := escrowDb(store).Put(ctx, []byte(number), []byte(strings.Join(lines, "\n")))
+	if err != nil {
+		return err
+	}
+	return updateClaimNumbers(ctx, store, number, len(claims) > 0)
+}
+
+// AddClaim adds an escrowed transfer for its recipient, and returns it with its id. A claim without a status
+// is held.
+//
+// A number can have at most MaxClaims escrowed transfers waiting for it.
+func AddClaim(ctx context.Context, store DataStore, c Claim) (Claim, error) {
+	escrowMu.Lock()
+	defer escrowMu.Unlock()
+	claims, err := readClaims(ctx, store, c.Recipient)
+	if err != nil {
+		return c, err
+	}
+	if len(claims) >= MaxClaims {
+		return c, ErrClaimsFull
+	}
+	id := time.Now().UnixNano()
+	for _, v := range claims {
+		n, _ := strconv.ParseInt(v.Id, 10, 64)
+		if n >= id {
+			id = n + 1
+		}
+	}
+	c.Id = strconv.FormatInt(id, 10)
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now()
+	}
+	if c.Status == "" {
+		c.Status = ClaimHeld
+	}
+	return c, writeClaims(ctx, store, c.Recipient, append(claims, c))
+}
+
+// MarkClaim changes the status of the escrowed transfer with the given id from the claims of the normalized
+// phone number, and returns it. It fails with ErrClaimStatus if the claim does not have the status from, so
+// that only one of the callers racing for a claim gets it.
+func MarkClaim(ctx context.Context, store DataStore, number string, id string, from string, to string) (Claim, error) {
+	return updateClaim(ctx, store, number, id, from, func(c *Claim) {
+		c.Status = to
+	})
+}
+
+// HoldClaim records that the transfer of the pending escrowed transfer with the given id has been made, with
+// its tracking id, and returns it.
+func HoldClaim(ctx context.Context, store DataStore, number string, id string, trackingId string) (Claim, error) {
+	return updateClaim(ctx, store, number, id, ClaimPending, func(c *Claim) {
+		c.Status = ClaimHeld
+		c.TrackingId = trackingId
+	})
+}
+
+func updateClaim(ctx context.Context, store DataStore, number string, id string, from string, update func(*Claim)) (Claim, error) {
+	escrowMu.Lock()
+	defer escrowMu.Unlock()
+	claims, err := readClaims(ctx, store, number)
+	if err != nil {
+		return Claim{}, err
+	}
+	for i, c := range claims {
+		if c.Id != id {
+			continue
+		}
+		if c.Status != from {
+			return c, ErrClaimStatus
+		}
+		update(&claims[i])
+		return claims[i], writeClaims(ctx, store, number, claims)
+	}
+	return Claim{}, ErrNoClaim
+}
+
+// RemoveClaim takes the escrowed transfer with the given id from the claims of the normalized phone number,
+// once it is released or returned, or its transfer to the escrow account failed, and returns it.
+func RemoveClaim(ctx context.Context, store DataStore, number string, id string) (Claim, error) {
+	escrowMu.Lock()
+	defer escrowMu.Unlock()
+	claims, err := readClaims(ctx, store, number)
+	if err != nil {
+		return Claim{}, err
+	}
+	for i, c := range claims {
+		if c.Id == id {
+			return c, writeClaims(ctx, store, number, append(claims[:i], claims[i+1:]...))
+		}
+	}
+	return Claim{}, ErrNoClaim
+}
+
+// ClaimNumbers returns the numbers with escrowed transfers waiting for them.
+func ClaimNumbers(ctx context.Context, store DataStore) ([]string, error) {
+	escrowMu.Lock()
+	defer escrowMu.Unlock()
+	return readClaimNumbers(ctx, store)
+}
+
+func readClaimNumbers(ctx context.Context, store DataStore) ([]string, error) {
+	v, err := escrowDb(store).Get(ctx, []byte(claimNumbersKey))
+	if err != nil {
+		if db.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, nil
+	}
+	return strings.Split(string(v), "\n"), nil
+}
+
+// updateClaimNumbers adds the number to the index of numbers with escrowed transfers, or removes it.
+func updateClaimNumbers(ctx context.Context, store DataStore, number string, claimed bool) error {
+	numbers, err := readClaimNumbers(ctx, store)
+	if err != nil {
+		return err
+	}
+	var r []string
+	var found bool
+	for _, v := range numbers {
+		if v == number {
+			found = true
+			if !claimed {
+				continue
+			}
+		}
+		r = append(r, v)
+	}
+	if found == claimed {
+		return nil
+	}
+	if claimed {
+		r = append(r, number)
+	}
+	return escrowDb(store).Put(ctx, []byte(claimNumbersKey), []byte(strings.Join(r, "\n")))
+}
@@ -0,0 +1,83 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestClaims(t *testing.T) {
+	number := "+254712345678"
+	ctx, store := InitializeTestDb(t)
+	ttl := time.Hour
+
+	claims, err := ReadClaims(ctx, store, number)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(claims))
+
+	c, err := AddClaim(ctx, store, Claim{
+		Sender:        "+254787654321",
+		SenderAddress: "0x5523058cdFfe5F3c1EaDADD5015E55C6E00fb439",
+		Recipient:     number,
+		Amount:        "2.5",
+		Symbol:        "SRF",
+		Address:       "0xd4c288865Ce",
+		Decimals:      "6",
+		CreatedAt:     time.Unix(time.Now().Unix(), 0),
+	})
+	assert.NoError(t, err)
+	assert.NotEqual(t, "", c.Id)
+	assert.False(t, c.IsExpired(time.Now(), ttl))
+	assert.True(t, c.IsExpired(c.ExpiresAt(ttl), ttl))
+	claims, err = ReadClaims(ctx, store, number)
+	assert.NoError(t, err)
+	assert.Equal(t, []Claim{c}, claims)
+	numbers, err := ClaimNumbers(ctx, store)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{number}, numbers)
+
+	for i := 1; i < MaxClaims; i++ {
+		_, err = AddClaim(ctx, store, Claim{Recipient: number})
+		assert.NoError(t, err)
+	}
+	_, err = AddClaim(ctx, store, Claim{Recipient: number})
+	assert.Equal(t, ErrClaimsFull, err)
+
+	// only one caller gets to release or return a claim
+	assert.Equal(t, ClaimHeld, c.Status)
+	marked, err := MarkClaim(ctx, store, number, c.Id, ClaimHeld, ClaimReleasing)
+	assert.NoError(t, err)
+	assert.Equal(t, ClaimReleasing, marked.Status)
+	_, err = MarkClaim(ctx, store, number, c.Id, ClaimHeld, ClaimRefunding)
+	assert.Equal(t, ErrClaimStatus, err)
+	_, err = HoldClaim(ctx, store, number, c.Id, "1234567890")
+	assert.Equal(t, ErrClaimStatus, err)
+	removed, err := RemoveClaim(ctx, store, number, c.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, marked, removed)
+	_, err = RemoveClaim(ctx, store, number, c.Id)
+	assert.Equal(t, ErrNoClaim, err)
+	_, err = MarkClaim(ctx, store, number, c.Id, ClaimHeld, ClaimReleasing)
+	assert.Equal(t, ErrNoClaim, err)
+
+	// a pending claim is held once its transfer is made
+	c, err = AddClaim(ctx, store, Claim{Recipient: number, Status: ClaimPending})
+	assert.NoError(t, err)
+	held, err := HoldClaim(ctx, store, number, c.Id, "1234567890")
+	assert.NoError(t, err)
+	assert.Equal(t, ClaimHeld, held.Status)
+	assert.Equal(t, "1234567890", held.TrackingId)
+
+	claims, err = ReadClaims(ctx, store, number)
+	assert.NoError(t, err)
+	assert.Equal(t, MaxClaims, len(claims))
+	assert.Equal(t, held, claims[len(claims)-1])
+	for _, c := range claims {
+		_, err = RemoveClaim(ctx, store, number, c.Id)
+		assert.NoError(t, err)
+	}
+	numbers, err = ClaimNumbers(ctx, store)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(numbers))
+}
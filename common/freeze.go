@@ -0,0 +1,60 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+)
+
+const (
+	// DefaultFreezeCoolingOff is how long a frozen account stays frozen before the user can unfreeze it with the PIN.
+	DefaultFreezeCoolingOff = 24 * time.Hour
+)
+
+// FreezeAccount marks the account of the session id as frozen, blocking outgoing transfers.
+//
+// Freezing an account that is already frozen keeps the original freeze time.
+func FreezeAccount(ctx context.Context, store DataStore, sessionId string) (time.Time, error) {
+	frozenAt, frozen, err := ReadFreeze(ctx, store, sessionId)
+	if err != nil {
+		return frozenAt, err
+	}
+	if frozen {
+		return frozenAt, nil
+	}
+	frozenAt = time.Now()
+	err = store.WriteEntry(ctx, sessionId, DATA_ACCOUNT_FROZEN, []byte(strconv.FormatInt(frozenAt.Unix(), 10)))
+	return frozenAt, err
+}
+
+// UnfreezeAccount lifts the freeze of the account of the session id.
+func UnfreezeAccount(ctx context.Context, store DataStore, sessionId string) error {
+	return store.WriteEntry(ctx, sessionId, DATA_ACCOUNT_FROZEN, []byte{})
+}
+
+// ReadFreeze returns the time the account of the session id was frozen, and whether it is frozen.
+func ReadFreeze(ctx context.Context, store DataStore, sessionId string) (time.Time, bool, error) {
+	v, err := store.ReadEntry(ctx, sessionId, DATA_ACCOUNT_FROZEN)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, err
+	}
+	if len(v) == 0 {
+		return time.Time{}, false, nil
+	}
+	ts, err := strconv.ParseInt(string(v), 10, 64)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid freeze timestamp: %v", err)
+	}
+	return time.Unix(ts, 0), true, nil
+}
+
+// UnfreezeAllowedAt returns the time after which the user can unfreeze an account frozen at frozenAt.
+func UnfreezeAllowedAt(frozenAt time.Time, coolingOff time.Duration) time.Time {
+	return frozenAt.Add(coolingOff)
+}
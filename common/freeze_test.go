@@ -0,0 +1,46 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestFreezeAccount(t *testing.T) {
+	ctx, store := InitializeTestDb(t)
+	sessionId := "+254712345678"
+
+	_, frozen, err := ReadFreeze(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.False(t, frozen)
+
+	frozenAt, err := FreezeAccount(ctx, store, sessionId)
+	assert.NoError(t, err)
+
+	// freezing again keeps the original freeze time
+	err = store.WriteEntry(ctx, sessionId, DATA_ACCOUNT_FROZEN, []byte("1700000000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := FreezeAccount(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Unix(1700000000, 0), again)
+	assert.NotEqual(t, frozenAt.Unix(), again.Unix())
+
+	readAt, frozen, err := ReadFreeze(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.True(t, frozen)
+	assert.Equal(t, again, readAt)
+
+	err = UnfreezeAccount(ctx, store, sessionId)
+	assert.NoError(t, err)
+	_, frozen, err = ReadFreeze(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.False(t, frozen)
+}
+
+func TestUnfreezeAllowedAt(t *testing.T) {
+	frozenAt := time.Unix(1700000000, 0)
+	assert.Equal(t, time.Unix(1700086400, 0), UnfreezeAllowedAt(frozenAt, DefaultFreezeCoolingOff))
+}
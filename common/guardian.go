@@ -0,0 +1,230 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+)
+
+const (
+	// MaxGuardians is the maximum number of guardians an account can nominate.
+	MaxGuardians = 3
+	// DefaultRecoveryQuorum is the number of guardian approvals needed to recover an account when no quorum is configured.
+	DefaultRecoveryQuorum = 2
+	// RecoveryRequestTTL is how long guardians have to approve a recovery request.
+	RecoveryRequestTTL = 24 * time.Hour
+)
+
+var (
+	ErrGuardianSelf         = errors.New("cannot be own guardian")
+	ErrGuardianUnregistered = errors.New("guardian is not registered")
+	ErrGuardianExists       = errors.New("guardian already added")
+	ErrGuardianLimit        = errors.New("too many guardians")
+	ErrGuardianNotFound     = errors.New("guardian not found")
+	ErrTooFewGuardians      = errors.New("too few guardians for recovery")
+	ErrNotGuardian          = errors.New("not a guardian of the account")
+	ErrNoRecoveryRequest    = errors.New("no pending recovery request")
+)
+
+// ReadGuardians returns the phone numbers of the guardians nominated by the session id.
+func ReadGuardians(ctx context.Context, store DataStore, sessionId string) ([]string, error) {
+	v, err := store.ReadEntry(ctx, sessionId, DATA_GUARDIANS)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, nil
+	}
+	return strings.Split(string(v), "\n"), nil
+}
+
+func writeGuardians(ctx context.Context, store DataStore, sessionId string, guardians []string) error {
+	return store.WriteEntry(ctx, sessionId, DATA_GUARDIANS, []byte(strings.Join(guardians, "\n")))
+}
+
+// AddGuardian adds a registered user as a guardian of the session id.
+func AddGuardian(ctx context.Context, store DataStore, sessionId string, guardian string) error {
+	if guardian == sessionId {
+		return ErrGuardianSelf
+	}
+	_, err := store.ReadEntry(ctx, guardian, DATA_PUBLIC_KEY)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return ErrGuardianUnregistered
+		}
+		return err
+	}
+	guardians, err := ReadGuardians(ctx, store, sessionId)
+	if err != nil {
+		return err
+	}
+	for _, g := range guardians {
+		if g == guardian {
+			return ErrGuardianExists
+		}
+	}
+	if len(guardians) >= MaxGuardians {
+		return ErrGuardianLimit
+	}
+	return writeGuardians(ctx, store, sessionId, append(guardians, guardian))
+}
+
+// RemoveGuardian removes a guardian of the session id.
+func RemoveGuardian(ctx context.Context, store DataStore, sessionId string, guardian string) error {
+	guardians, err := ReadGuardians(ctx, store, sessionId)
+	if err != nil {
+		return err
+	}
+	for i, g := range guardians {
+		if g == guardian {
+			return writeGuardians(ctx, store, sessionId, append(guardians[:i], guardians[i+1:]...))
+		}
+	}
+	return ErrGuardianNotFound
+}
+
+// RecoveryRequest is a request by a locked out user for their guardians to approve a PIN reset.
+type RecoveryRequest struct {
+	RequestedAt time.Time
+	// Approvals holds the phone numbers of the guardians that have approved the request.
+	Approvals []string
+}
+
+// String serializes the request as "<unix timestamp>|<comma separated approvals>".
+func (r RecoveryRequest) String() string {
+	return fmt.Sprintf("%d|%s", r.RequestedAt.Unix(), strings.Join(r.Approvals, ","))
+}
+
+// ParseRecoveryRequest parses a request serialized with String.
+func ParseRecoveryRequest(v []byte) (RecoveryRequest, error) {
+	var r RecoveryRequest
+	parts := strings.SplitN(string(v), "|", 2)
+	if len(parts) != 2 {
+		return r, fmt.Errorf("invalid recovery request: %q", v)
+	}
+	ts, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return r, fmt.Errorf("invalid recovery request timestamp: %v", err)
+	}
+	r.RequestedAt = time.Unix(ts, 0)
+	if parts[1] != "" {
+		r.Approvals = strings.Split(parts[1], ",")
+	}
+	return r, nil
+}
+
+// IsExpired checks whether the request is too old to be approved.
+func (r RecoveryRequest) IsExpired(now time.Time) bool {
+	return now.Sub(r.RequestedAt) >= RecoveryRequestTTL
+}
+
+// ReadRecoveryRequest returns the pending recovery request of the session id.
+//
+// ErrNoRecoveryRequest is returned if there is no request, or it has expired.
+func ReadRecoveryRequest(ctx context.Context, store DataStore, sessionId string) (RecoveryRequest, error) {
+	v, err := store.ReadEntry(ctx, sessionId, DATA_RECOVERY_REQUEST)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return RecoveryRequest{}, ErrNoRecoveryRequest
+		}
+		return RecoveryRequest{}, err
+	}
+	if len(v) == 0 {
+		return RecoveryRequest{}, ErrNoRecoveryRequest
+	}
+	r, err := ParseRecoveryRequest(v)
+	if err != nil {
+		return r, err
+	}
+	if r.IsExpired(time.Now()) {
+		return r, ErrNoRecoveryRequest
+	}
+	return r, nil
+}
+
+// RequestRecovery opens a recovery request for the session id.
+//
+// The session id must have at least quorum guardians. If a request is already pending, it is returned unchanged
+// and created is false.
+func RequestRecovery(ctx context.Context, store DataStore, sessionId string, quorum int) (r RecoveryRequest, created bool, err error) {
+	guardians, err := ReadGuardians(ctx, store, sessionId)
+	if err != nil {
+		return r, false, err
+	}
+	if len(guardians) < quorum {
+		return r, false, ErrTooFewGuardians
+	}
+	r, err = ReadRecoveryRequest(ctx, store, sessionId)
+	if err == nil {
+		return r, false, nil
+	}
+	if err != ErrNoRecoveryRequest {
+		return r, false, err
+	}
+	r = RecoveryRequest{
+		RequestedAt: time.Now(),
+	}
+	err = store.WriteEntry(ctx, sessionId, DATA_RECOVERY_REQUEST, []byte(r.String()))
+	return r, err == nil, err
+}
+
+// ApproveRecovery records the approval by guardian of the pending recovery request of the session id.
+//
+// The approvals of the returned request only include those of current guardians of the session id.
+func ApproveRecovery(ctx context.Context, store DataStore, sessionId string, guardian string) (RecoveryRequest, error) {
+	guardians, err := ReadGuardians(ctx, store, sessionId)
+	if err != nil {
+		return RecoveryRequest{}, err
+	}
+	var isGuardian bool
+	for _, g := range guardians {
+		if g == guardian {
+			isGuardian = true
+			break
+		}
+	}
+	if !isGuardian {
+		return RecoveryRequest{}, ErrNotGuardian
+	}
+	r, err := ReadRecoveryRequest(ctx, store, sessionId)
+	if err != nil {
+		return r, err
+	}
+
+	// approvals of guardians removed since they approved no longer count towards the quorum
+	var approvals []string
+	var approved bool
+	for _, a := range r.Approvals {
+		for _, g := range guardians {
+			if a == g {
+				approvals = append(approvals, a)
+				break
+			}
+		}
+		if a == guardian {
+			approved = true
+		}
+	}
+	if !approved {
+		approvals = append(approvals, guardian)
+	}
+	if approved && len(approvals) == len(r.Approvals) {
+		return r, nil
+	}
+	r.Approvals = approvals
+	err = store.WriteEntry(ctx, sessionId, DATA_RECOVERY_REQUEST, []byte(r.String()))
+	return r, err
+}
+
+// ClearRecoveryRequest removes the pending recovery request of the session id.
+func ClearRecoveryRequest(ctx context.Context, store DataStore, sessionId string) error {
+	return store.WriteEntry(ctx, sessionId, DATA_RECOVERY_REQUEST, []byte{})
+}
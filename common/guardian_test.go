@@ -0,0 +1,117 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestGuardians(t *testing.T) {
+	ctx, store := InitializeTestDb(t)
+	sessionId := "+254712345678"
+	guardians := []string{"+254700000001", "+254700000002", "+254700000003", "+254700000004"}
+	for _, g := range guardians {
+		err := store.WriteEntry(ctx, g, DATA_PUBLIC_KEY, []byte("0x"+g))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assert.Equal(t, ErrGuardianSelf, AddGuardian(ctx, store, sessionId, sessionId))
+	assert.Equal(t, ErrGuardianUnregistered, AddGuardian(ctx, store, sessionId, "+254799999999"))
+	for _, g := range guardians[:3] {
+		assert.NoError(t, AddGuardian(ctx, store, sessionId, g))
+	}
+	assert.Equal(t, ErrGuardianExists, AddGuardian(ctx, store, sessionId, guardians[0]))
+	assert.Equal(t, ErrGuardianLimit, AddGuardian(ctx, store, sessionId, guardians[3]))
+
+	assert.NoError(t, RemoveGuardian(ctx, store, sessionId, guardians[1]))
+	assert.Equal(t, ErrGuardianNotFound, RemoveGuardian(ctx, store, sessionId, guardians[1]))
+	r, err := ReadGuardians(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{guardians[0], guardians[2]}, r)
+}
+
+func TestRecovery(t *testing.T) {
+	ctx, store := InitializeTestDb(t)
+	sessionId := "+254712345678"
+	guardians := []string{"+254700000001", "+254700000002"}
+	for _, g := range guardians {
+		err := store.WriteEntry(ctx, g, DATA_PUBLIC_KEY, []byte("0x"+g))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := ApproveRecovery(ctx, store, sessionId, guardians[0])
+	assert.Equal(t, ErrNotGuardian, err)
+
+	err = AddGuardian(ctx, store, sessionId, guardians[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = RequestRecovery(ctx, store, sessionId, 2)
+	assert.Equal(t, ErrTooFewGuardians, err)
+	_, err = ApproveRecovery(ctx, store, sessionId, guardians[0])
+	assert.Equal(t, ErrNoRecoveryRequest, err)
+
+	err = AddGuardian(ctx, store, sessionId, guardians[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, created, err := RequestRecovery(ctx, store, sessionId, 2)
+	assert.NoError(t, err)
+	assert.True(t, created)
+	_, created, err = RequestRecovery(ctx, store, sessionId, 2)
+	assert.NoError(t, err)
+	assert.False(t, created)
+
+	r, err := ApproveRecovery(ctx, store, sessionId, guardians[0])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{guardians[0]}, r.Approvals)
+	// approving twice is counted once
+	r, err = ApproveRecovery(ctx, store, sessionId, guardians[0])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{guardians[0]}, r.Approvals)
+	r, err = ApproveRecovery(ctx, store, sessionId, guardians[1])
+	assert.NoError(t, err)
+	assert.Equal(t, guardians, r.Approvals)
+
+	// the approval of a removed guardian no longer counts towards the quorum
+	replacement := "+254700000003"
+	err = store.WriteEntry(ctx, replacement, DATA_PUBLIC_KEY, []byte("0x"+replacement))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RemoveGuardian(ctx, store, sessionId, guardians[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddGuardian(ctx, store, sessionId, replacement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = ApproveRecovery(ctx, store, sessionId, guardians[1])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{guardians[1]}, r.Approvals)
+	r, err = ReadRecoveryRequest(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{guardians[1]}, r.Approvals)
+	r, err = ApproveRecovery(ctx, store, sessionId, replacement)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{guardians[1], replacement}, r.Approvals)
+
+	// expired requests cannot be approved
+	expired := RecoveryRequest{RequestedAt: time.Now().Add(-RecoveryRequestTTL)}
+	err = store.WriteEntry(ctx, sessionId, DATA_RECOVERY_REQUEST, []byte(expired.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ApproveRecovery(ctx, store, sessionId, guardians[1])
+	assert.Equal(t, ErrNoRecoveryRequest, err)
+
+	assert.NoError(t, ClearRecoveryRequest(ctx, store, sessionId))
+	_, err = ReadRecoveryRequest(ctx, store, sessionId)
+	assert.Equal(t, ErrNoRecoveryRequest, err)
+}
@@ -2,7 +2,10 @@ package common
 
 import (
 	"encoding/hex"
+	"fmt"
 	"strings"
+
+	"golang.org/x/crypto/sha3"
 )
 
 func NormalizeHex(s string) (string, error) {
@@ -29,3 +32,51 @@ func IsSameHex(left string, right string) bool {
 	}
 	return strings.Compare(bl, br) == 0
 }
+
+// ToChecksumAddress returns the EIP-55 mixed-case checksum encoding of the given address.
+func ToChecksumAddress(s string) (string, error) {
+	addr, err := NormalizeHex(s)
+	if err != nil {
+		return "", err
+	}
+	if len(addr) != 40 {
+		return "", fmt.Errorf("invalid address length: %d", len(addr))
+	}
+
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte(addr))
+	digest := h.Sum(nil)
+
+	r := []byte(addr)
+	for i, c := range r {
+		if c < 'a' {
+			continue
+		}
+		nibble := digest[i/2]
+		if i%2 == 0 {
+			nibble >>= 4
+		}
+		if nibble&0x0f >= 8 {
+			r[i] = c - 32
+		}
+	}
+	return "0x" + string(r), nil
+}
+
+// IsValidAddress checks whether the given string is a 0x-prefixed 20 byte address.
+//
+// Single-case addresses are accepted as is, mixed-case addresses must match their checksum.
+func IsValidAddress(s string) bool {
+	if !strings.HasPrefix(s, "0x") {
+		return false
+	}
+	checksummed, err := ToChecksumAddress(s)
+	if err != nil {
+		return false
+	}
+	v := s[2:]
+	if v == strings.ToLower(v) || v == strings.ToUpper(v) {
+		return true
+	}
+	return s == checksummed
+}
@@ -0,0 +1,69 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestToChecksumAddress(t *testing.T) {
+	addrs := []string{
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+		"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb",
+	}
+	for _, addr := range addrs {
+		r, err := ToChecksumAddress(addr)
+		assert.NoError(t, err)
+		assert.Equal(t, addr, r)
+	}
+
+	_, err := ToChecksumAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeA")
+	assert.Error(t, err)
+}
+
+func TestIsValidAddress(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  bool
+	}{
+		{
+			name:  "checksummed",
+			input: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+			want:  true,
+		},
+		{
+			name:  "lowercase",
+			input: "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed",
+			want:  true,
+		},
+		{
+			name:  "bad checksum",
+			input: "0x5AAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+			want:  false,
+		},
+		{
+			name:  "missing prefix",
+			input: "5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+			want:  false,
+		},
+		{
+			name:  "too short",
+			input: "0x5aAeb6053F",
+			want:  false,
+		},
+		{
+			name:  "not hex",
+			input: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeg",
+			want:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsValidAddress(tt.input))
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"git.defalsify.org/vise.git/db"
+
+	"git.grassecon.net/urdt/ussd/internal/storage"
+)
+
+const (
+	// merchantTillsKey is the key of the index of till numbers.
+	merchantTillsKey = "tills"
+)
+
+var (
+	ErrInvalidTill      = errors.New("till number must have 4 to 7 digits")
+	ErrMerchantNotFound = errors.New("merchant not found")
+)
+
+var (
+	tillRegex = regexp.MustCompile(`^\d{4,7}$`)
+	// merchantMu guards the index of till numbers.
+	merchantMu sync.Mutex
+)
+
+// Merchant is a shop paid through a short till number instead of the phone number of its owner.
+type Merchant struct {
+	Till      string
+	PublicKey string
+	Name      string
+	// Phone is the number the receipts of the payments to the merchant are sent to.
+	Phone string
+	// Vouchers are the symbols or contract addresses of the vouchers the merchant accepts.
+	Vouchers []string
+}
+
+// IsValidTill checks whether the till number has the expected format.
+func IsValidTill(till string) bool {
+	return tillRegex.MatchString(till)
+}
+
+// Accepts checks whether the merchant accepts the voucher with the given symbol and contract address.
+func (m Merchant) Accepts(symbol string, address string) bool {
+	for _, v := range m.Vouchers {
+		if strings.EqualFold(v, symbol) || strings.EqualFold(v, address) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m Merchant) validate() error {
+	if !IsValidTill(m.Till) {
+		return ErrInvalidTill
+	}
+	if !IsValidAddress(m.PublicKey) {
+		return fmt.Errorf("invalid public key: %q", m.PublicKey)
+	}
+	if m.Name == "" || strings.ContainsAny(m.Name, "|\n") {
+		return fmt.Errorf("invalid name: %q", m.Name)
+	}
+	if m.Phone == "" || strings.ContainsAny(m.Phone, "|\n") {
+		return fmt.Errorf("invalid phone number: %q", m.Phone)
+	}
+	if len(m.Vouchers) == 0 {
+		return fmt.Errorf("no accepted vouchers")
+	}
+	for _, v := range m.Vouchers {
+		if v == "" || strings.ContainsAny(v, "|,\n") {
+			return fmt.Errorf("invalid voucher: %q", v)
+		}
+	}
+	return nil
+}
+
+// String serializes the merchant without its till number, as "|" separated fields.
+func (m Merchant) String() string {
+	return strings.Join([]string{m.PublicKey, m.Name, m.Phone, strings.Join(m.Vouchers, ",")}, "|")
+}
+
+func parseMerchant(till string, v []byte) (Merchant, error) {
+	parts := strings.Split(string(v), "|")
+	if len(parts) != 4 {
+		return Merchant{}, fmt.Errorf("invalid merchant: %q", v)
+	}
+	return Merchant{
+		Till:      till,
+		PublicKey: parts[0],
+		Name:      parts[1],
+		Phone:     parts[2],
+		Vouchers:  strings.Split(parts[3], ","),
+	}, nil
+}
+
+// merchantDb returns the store of merchants, which is kept outside of any session.
+func merchantDb(store DataStore) *storage.SubPrefixDb {
+	store.SetSession("")
+	return storage.NewSubPrefixDb(store, []byte("merchant"))
+}
+
+// RegisterMerchant adds the merchant under its till number, or replaces the details of the merchant already using it.
+//
+// It is meant for admins.
+func RegisterMerchant(ctx context.Context, store DataStore, m Merchant) error {
+	err := m.validate()
+	if err != nil {
+		return err
+	}
+	m.PublicKey, err = ToChecksumAddress(m.PublicKey)
+	if err != nil {
+		return err
+	}
+	err = merchantDb(store).Put(ctx, []byte(m.Till), []byte(m.String()))
+	if err != nil {
+		return err
+	}
+	return updateTills(ctx, store, m.Till, true)
+}
+
+// ReadMerchant returns the merchant with the till number.
+//
+// ErrMerchantNotFound is returned if no merchant uses it.
+func ReadMerchant(ctx context.Context, store DataStore, till string) (Merchant, error) {
+	if !IsValidTill(till) {
+		return Merchant{}, ErrMerchantNotFound
+	}
+	v, err := merchantDb(store).Get(ctx, []byte(till))
+	if err != nil {
+		if db.IsNotFound(err) {
+			return Merchant{}, ErrMerchantNotFound
+		}
+		return Merchant{}, err
+	}
+	if len(v) == 0 {
+		return Merchant{}, ErrMerchantNotFound
+	}
+	return parseMerchant(till, v)
+}
+
+// RemoveMerchant frees the till number of a merchant.
+//
+// It is meant for admins.
+func RemoveMerchant(ctx context.Context, store DataStore, till string) error {
+	_, err := ReadMerchant(ctx, store, till)
+	if err != nil {
+		return err
+	}
+	err = merchantDb(store).Put(ctx, []byte(till), []byte{})
+	if err != nil {
+		return err
+	}
+	return updateTills(ctx, store, till, false)
+}
+
+// Merchants returns all merchants, in the order they were first registered.
+func Merchants(ctx context.Context, store DataStore) ([]Merchant, error) {
+	merchantMu.Lock()
+	tills, err := readTills(ctx, store)
+	merchantMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	var r []Merchant
+	for _, till := range tills {
+		m, err := ReadMerchant(ctx, store, till)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, m)
+	}
+	return r, nil
+}
+
+func readTills(ctx context.Context, store DataStore) ([]string, error) {
+	v, err := merchantDb(store).Get(ctx, []byte(merchantTillsKey))
+	if err != nil {
+		if db.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, nil
+	}
+	return strings.Split(string(v), "\n"), nil
+}
+
+// updateTills adds the till number to the index of till numbers, or removes it.
+func updateTills(ctx context.Context, store DataStore, till string, used bool) error {
+	merchantMu.Lock()
+	defer merchantMu.Unlock()
+	tills, err := readTills(ctx, store)
+	if err != nil {
+		return err
+	}
+	var r []string
+	var found bool
+	for _, v := range tills {
+		if v == till {
+			found = true
+			if !used {
+				continue
+			}
+		}
+		r = append(r, v)
+	}
+	if used && !found {
+		r = append(r, till)
+	}
+	return merchantDb(store).Put(ctx, []byte(merchantTillsKey), []byte(strings.Join(r, "\n")))
+}
@@ -0,0 +1,64 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestMerchant(t *testing.T) {
+	ctx, store := InitializeTestDb(t)
+	m := Merchant{
+		Till:      "12345",
+		PublicKey: "0x5523058cdffe5f3c1eadadd5015e55c6e00fb439",
+		Name:      "Mama Mboga",
+		Phone:     "+254712345678",
+		Vouchers:  []string{"SRF", "0xd4c288865Ce"},
+	}
+
+	_, err := ReadMerchant(ctx, store, m.Till)
+	assert.Equal(t, ErrMerchantNotFound, err)
+	_, err = ReadMerchant(ctx, store, "+254712345678")
+	assert.Equal(t, ErrMerchantNotFound, err)
+
+	invalid := m
+	invalid.Till = "12"
+	assert.Equal(t, ErrInvalidTill, RegisterMerchant(ctx, store, invalid))
+	invalid = m
+	invalid.Vouchers = nil
+	assert.Error(t, RegisterMerchant(ctx, store, invalid))
+	invalid = m
+	invalid.Name = "Mama|Mboga"
+	assert.Error(t, RegisterMerchant(ctx, store, invalid))
+
+	err = RegisterMerchant(ctx, store, m)
+	assert.NoError(t, err)
+	r, err := ReadMerchant(ctx, store, m.Till)
+	assert.NoError(t, err)
+	m.PublicKey = "0x5523058cdFfe5F3c1EaDADD5015E55C6E00fb439"
+	assert.Equal(t, m, r)
+	assert.True(t, r.Accepts("srf", "0x41c188d63Qa"))
+	assert.True(t, r.Accepts("MILO", "0xD4C288865CE"))
+	assert.False(t, r.Accepts("MILO", "0x41c188d63Qa"))
+
+	// registering the till again replaces the details
+	other := m
+	other.Till = "7654321"
+	err = RegisterMerchant(ctx, store, other)
+	assert.NoError(t, err)
+	m.Name = "Mama Mboga Shop"
+	err = RegisterMerchant(ctx, store, m)
+	assert.NoError(t, err)
+	merchants, err := Merchants(ctx, store)
+	assert.NoError(t, err)
+	assert.Equal(t, []Merchant{m, other}, merchants)
+
+	err = RemoveMerchant(ctx, store, m.Till)
+	assert.NoError(t, err)
+	_, err = ReadMerchant(ctx, store, m.Till)
+	assert.Equal(t, ErrMerchantNotFound, err)
+	assert.Equal(t, ErrMerchantNotFound, RemoveMerchant(ctx, store, m.Till))
+	merchants, err = Merchants(ctx, store)
+	assert.NoError(t, err)
+	assert.Equal(t, []Merchant{other}, merchants)
+}
@@ -0,0 +1,197 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+)
+
+const (
+	// MaxPaymentRequests is the most pending payment requests an account can receive.
+	MaxPaymentRequests = 5
+	// DefaultPaymentRequestTTL is how long a payment request can be approved when no expiry is configured.
+	DefaultPaymentRequestTTL = 72 * time.Hour
+)
+
+var (
+	ErrPaymentRequestSelf   = errors.New("cannot request payment from self")
+	ErrPaymentRequestExists = errors.New("payment request already pending")
+	ErrPaymentRequestsFull  = errors.New("too many pending payment requests")
+	ErrNoPaymentRequest     = errors.New("no pending payment request")
+)
+
+// PaymentRequest is a request by one account for another to pay an amount of a voucher.
+type PaymentRequest struct {
+	// Requester is the session id of the account to be paid.
+	Requester string
+	// Payer is the session id of the account asked to pay.
+	Payer string
+	// Amount is the amount in whole tokens of the voucher.
+	Amount string
+	// Symbol, Address and Decimals describe the voucher to be paid.
+	Symbol    string
+	Address   string
+	Decimals  string
+	CreatedAt time.Time
+	// Seen is set once the payer has been told about the request on dialing in.
+	Seen bool
+}
+
+// String serializes the request as "|" separated fields.
+func (r PaymentRequest) String() string {
+	var seen string
+	if r.Seen {
+		seen = "1"
+	}
+	var created string
+	if !r.CreatedAt.IsZero() {
+		created = strconv.FormatInt(r.CreatedAt.Unix(), 10)
+	}
+	return strings.Join([]string{r.Requester, r.Payer, r.Amount, r.Symbol, r.Address, r.Decimals, created, seen}, "|")
+}
+
+// ParsePaymentRequest parses a request serialized with String.
+func ParsePaymentRequest(v []byte) (PaymentRequest, error) {
+	var r PaymentRequest
+	parts := strings.Split(string(v), "|")
+	if len(parts) != 8 {
+		return r, fmt.Errorf("invalid payment request: %q", v)
+	}
+	r.Requester = parts[0]
+	r.Payer = parts[1]
+	r.Amount = parts[2]
+	r.Symbol = parts[3]
+	r.Address = parts[4]
+	r.Decimals = parts[5]
+	if parts[6] != "" {
+		ts, err := strconv.ParseInt(parts[6], 10, 64)
+		if err != nil {
+			return r, fmt.Errorf("invalid payment request timestamp: %v", err)
+		}
+		r.CreatedAt = time.Unix(ts, 0)
+	}
+	r.Seen = parts[7] == "1"
+	return r, nil
+}
+
+// IsExpired checks whether the request is too old to be approved.
+func (r PaymentRequest) IsExpired(now time.Time, ttl time.Duration) bool {
+	return now.Sub(r.CreatedAt) >= ttl
+}
+
+// ReadPaymentRequests returns the pending payment requests to the session id, oldest first.
+//
+// Requests older than ttl have expired and are left out.
+func ReadPaymentRequests(ctx context.Context, store DataStore, sessionId string, ttl time.Duration) ([]PaymentRequest, error) {
+	v, err := store.ReadEntry(ctx, sessionId, DATA_PAYMENT_REQUESTS)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	now := time.Now()
+	var r []PaymentRequest
+	for _, line := range strings.Split(string(v), "\n") {
+		if line == "" {
+			continue
+		}
+		pr, err := ParsePaymentRequest([]byte(line))
+		if err != nil {
+			return nil, err
+		}
+		if pr.IsExpired(now, ttl) {
+			continue
+		}
+		r = append(r, pr)
+	}
+	return r, nil
+}
+
+func writePaymentRequests(ctx context.Context, store DataStore, sessionId string, requests []PaymentRequest) error {
+	var lines []string
+	for _, r := range requests {
+		lines = append(lines, r.String())
+	}
+	return store.WriteEntry(ctx, sessionId, DATA_PAYMENT_REQUESTS, []byte(strings.Join(lines, "\n")))
+}
+
+// AddPaymentRequest adds a request to the pending payment requests of its payer.
+//
+// A requester can only have one pending request to the same payer, and a payer at most MaxPaymentRequests.
+func AddPaymentRequest(ctx context.Context, store DataStore, r PaymentRequest, ttl time.Duration) error {
+	if r.Requester == r.Payer {
+		return ErrPaymentRequestSelf
+	}
+	requests, err := ReadPaymentRequests(ctx, store, r.Payer, ttl)
+	if err != nil {
+		return err
+	}
+	for _, pr := range requests {
+		if pr.Requester == r.Requester {
+			return ErrPaymentRequestExists
+		}
+	}
+	if len(requests) >= MaxPaymentRequests {
+		return ErrPaymentRequestsFull
+	}
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now()
+	}
+	r.Seen = false
+	return writePaymentRequests(ctx, store, r.Payer, append(requests, r))
+}
+
+// RemovePaymentRequest removes the pending request of requester to the session id, once it is approved or declined,
+// and returns it.
+func RemovePaymentRequest(ctx context.Context, store DataStore, sessionId string, requester string, ttl time.Duration) (PaymentRequest, error) {
+	requests, err := ReadPaymentRequests(ctx, store, sessionId, ttl)
+	if err != nil {
+		return PaymentRequest{}, err
+	}
+	for i, r := range requests {
+		if r.Requester == requester {
+			return r, writePaymentRequests(ctx, store, sessionId, append(requests[:i], requests[i+1:]...))
+		}
+	}
+	return PaymentRequest{}, ErrNoPaymentRequest
+}
+
+// MarkPaymentRequestsSeen marks the pending payment requests to the session id as seen,
+// and returns how many of them had not been seen before.
+func MarkPaymentRequestsSeen(ctx context.Context, store DataStore, sessionId string, ttl time.Duration) (int, error) {
+	requests, err := ReadPaymentRequests(ctx, store, sessionId, ttl)
+	if err != nil {
+		return 0, err
+	}
+	var unseen int
+	for i := range requests {
+		if !requests[i].Seen {
+			requests[i].Seen = true
+			unseen++
+		}
+	}
+	if unseen == 0 {
+		return 0, nil
+	}
+	return unseen, writePaymentRequests(ctx, store, sessionId, requests)
+}
+
+// ReadPaymentRequestDraft returns the payment request the session id is entering.
+func ReadPaymentRequestDraft(ctx context.Context, store DataStore, sessionId string) (PaymentRequest, error) {
+	v, err := store.ReadEntry(ctx, sessionId, DATA_PAYMENT_REQUEST_DRAFT)
+	if err != nil {
+		return PaymentRequest{}, err
+	}
+	return ParsePaymentRequest(v)
+}
+
+// WritePaymentRequestDraft saves the payment request the session id is entering, until it is sent.
+func WritePaymentRequestDraft(ctx context.Context, store DataStore, sessionId string, r PaymentRequest) error {
+	return store.WriteEntry(ctx, sessionId, DATA_PAYMENT_REQUEST_DRAFT, []byte(r.String()))
+}
@@ -0,0 +1,85 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestPaymentRequests(t *testing.T) {
+	payer := "+254712345678"
+	ctx, store := InitializeTestDb(t)
+	ttl := time.Hour
+
+	requests, err := ReadPaymentRequests(ctx, store, payer, ttl)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(requests))
+
+	r := PaymentRequest{
+		Requester: "+254787654321",
+		Payer:     payer,
+		Amount:    "2.5",
+		Symbol:    "SRF",
+		Address:   "0xd4c288865Ce",
+		Decimals:  "6",
+		CreatedAt: time.Unix(time.Now().Unix(), 0),
+	}
+	err = AddPaymentRequest(ctx, store, r, ttl)
+	assert.NoError(t, err)
+	err = AddPaymentRequest(ctx, store, r, ttl)
+	assert.Equal(t, ErrPaymentRequestExists, err)
+	err = AddPaymentRequest(ctx, store, PaymentRequest{Requester: payer, Payer: payer}, ttl)
+	assert.Equal(t, ErrPaymentRequestSelf, err)
+
+	// an expired request is dropped, and makes room for a new one
+	expired := r
+	expired.Requester = "+254711111111"
+	expired.CreatedAt = time.Now().Add(-2 * ttl)
+	err = AddPaymentRequest(ctx, store, expired, ttl)
+	assert.NoError(t, err)
+	requests, err = ReadPaymentRequests(ctx, store, payer, ttl)
+	assert.NoError(t, err)
+	assert.Equal(t, []PaymentRequest{r}, requests)
+
+	for i := 1; i < MaxPaymentRequests; i++ {
+		other := r
+		other.Requester = "+25472222222" + string(rune('0'+i))
+		err = AddPaymentRequest(ctx, store, other, ttl)
+		assert.NoError(t, err)
+	}
+	other := r
+	other.Requester = "+254733333333"
+	err = AddPaymentRequest(ctx, store, other, ttl)
+	assert.Equal(t, ErrPaymentRequestsFull, err)
+
+	unseen, err := MarkPaymentRequestsSeen(ctx, store, payer, ttl)
+	assert.NoError(t, err)
+	assert.Equal(t, MaxPaymentRequests, unseen)
+	unseen, err = MarkPaymentRequestsSeen(ctx, store, payer, ttl)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, unseen)
+
+	removed, err := RemovePaymentRequest(ctx, store, payer, r.Requester, ttl)
+	assert.NoError(t, err)
+	assert.Equal(t, r.Amount, removed.Amount)
+	assert.True(t, removed.Seen)
+	_, err = RemovePaymentRequest(ctx, store, payer, r.Requester, ttl)
+	assert.Equal(t, ErrNoPaymentRequest, err)
+	requests, err = ReadPaymentRequests(ctx, store, payer, ttl)
+	assert.NoError(t, err)
+	assert.Equal(t, MaxPaymentRequests-1, len(requests))
+}
+
+func TestPaymentRequestDraft(t *testing.T) {
+	sessionId := "+254787654321"
+	ctx, store := InitializeTestDb(t)
+
+	draft := PaymentRequest{Requester: sessionId, Payer: "+254712345678"}
+	err := WritePaymentRequestDraft(ctx, store, sessionId, draft)
+	assert.NoError(t, err)
+	r, err := ReadPaymentRequestDraft(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, draft, r)
+	assert.True(t, r.CreatedAt.IsZero())
+}
@@ -0,0 +1,44 @@
+package common
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+const (
+	// DefaultCountryCode is the calling code assumed for numbers entered without one.
+	DefaultCountryCode = "254"
+)
+
+var (
+	ErrInvalidPhoneNumber = errors.New("invalid phone number")
+)
+
+var (
+	intlPhoneRegex  = regexp.MustCompile(`^\+[1-9]\d{7,14}$`)
+	phoneCleanRegex = regexp.MustCompile(`[\s\-().]`)
+)
+
+// NormalizePhoneNumber returns the phone number in international format, as used for session ids.
+//
+// Numbers in local format, such as 0712345678, and numbers without the leading "+" or with a leading "00"
+// are accepted. Local numbers are taken to be in the country of DefaultCountryCode.
+func NormalizePhoneNumber(number string) (string, error) {
+	n := phoneCleanRegex.ReplaceAllString(number, "")
+	switch {
+	case strings.HasPrefix(n, "+"):
+	case strings.HasPrefix(n, "00"):
+		n = "+" + n[2:]
+	case strings.HasPrefix(n, "0") && len(n) == 10:
+		n = "+" + DefaultCountryCode + n[1:]
+	case strings.HasPrefix(n, DefaultCountryCode) && len(n) == len(DefaultCountryCode)+9:
+		n = "+" + n
+	case len(n) == 9:
+		n = "+" + DefaultCountryCode + n
+	}
+	if !intlPhoneRegex.MatchString(n) {
+		return "", ErrInvalidPhoneNumber
+	}
+	return n, nil
+}
@@ -0,0 +1,23 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestNormalizePhoneNumber(t *testing.T) {
+	for _, v := range []string{"+254712345678", "0712345678", "254712345678", "712345678", "00254712345678", "0712 345 678", "+254-712-345-678"} {
+		n, err := NormalizePhoneNumber(v)
+		assert.NoError(t, err, v)
+		assert.Equal(t, "+254712345678", n, v)
+	}
+	n, err := NormalizePhoneNumber("+256772123456")
+	assert.NoError(t, err)
+	assert.Equal(t, "+256772123456", n)
+
+	for _, v := range []string{"", "12345", "07123456789012345", "+0712345678", "07123abc78"} {
+		_, err := NormalizePhoneNumber(v)
+		assert.Equal(t, ErrInvalidPhoneNumber, err, v)
+	}
+}
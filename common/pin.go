@@ -0,0 +1,229 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+
+	"git.defalsify.org/vise.git/db"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// MinPinLength is the shortest PIN length a deployment can configure.
+	MinPinLength = 4
+	// MaxPinLength is the longest PIN length a deployment can configure.
+	MaxPinLength = 6
+	// DefaultPinHistory is the number of previous PINs that cannot be reused when none is configured.
+	DefaultPinHistory = 3
+)
+
+var (
+	ErrPinLength = errors.New("pin has wrong length")
+	ErrPinWeak   = errors.New("pin is a sequential or repeated pattern")
+	ErrPinYob    = errors.New("pin contains year of birth")
+	ErrPinReused = errors.New("pin was recently used")
+)
+
+// PinPolicy decides whether a PIN is acceptable as a new account PIN.
+type PinPolicy struct {
+	length  int
+	history int
+}
+
+// NewPinPolicy creates a new PinPolicy.
+//
+// The length is clamped to MinPinLength..MaxPinLength. A history of 0 uses DefaultPinHistory.
+func NewPinPolicy(length uint, history uint) *PinPolicy {
+	l := int(length)
+	if l < MinPinLength {
+		l = MinPinLength
+	}
+	if l > MaxPinLength {
+		l = MaxPinLength
+	}
+	if history == 0 {
+		history = DefaultPinHistory
+	}
+	return &PinPolicy{
+		length:  l,
+		history: int(history),
+	}
+}
+
+// Length returns the number of digits a PIN must have.
+func (p *PinPolicy) Length() int {
+	return p.length
+}
+
+// IsValidFormat checks whether the PIN consists of exactly the configured number of digits.
+func (p *PinPolicy) IsValidFormat(pin string) bool {
+	if len(pin) != p.length {
+		return false
+	}
+	for _, c := range pin {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Generate returns a random PIN of the configured length that is not a weak pattern.
+func (p *PinPolicy) Generate() (string, error) {
+	max := big.NewInt(10)
+	for {
+		b := make([]byte, p.length)
+		for i := range b {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return "", err
+			}
+			b[i] = byte('0' + n.Int64())
+		}
+		pin := string(b)
+		if !IsWeakPin(pin) {
+			return pin, nil
+		}
+	}
+}
+
+// IsWeakPin checks whether the PIN is an ascending or descending run of digits (1234, 9876, 8901),
+// or a repetition of a shorter block of digits (0000, 1212, 123123).
+func IsWeakPin(pin string) bool {
+	if len(pin) < 2 {
+		return true
+	}
+	return isSequential(pin) || isRepeated(pin)
+}
+
+func isSequential(pin string) bool {
+	step := (int(pin[1]) - int(pin[0]) + 10) % 10
+	if step != 1 && step != 9 {
+		return false
+	}
+	for i := 2; i < len(pin); i++ {
+		if (int(pin[i])-int(pin[i-1])+10)%10 != step {
+			return false
+		}
+	}
+	return true
+}
+
+func isRepeated(pin string) bool {
+	for k := 1; k <= len(pin)/2; k++ {
+		if len(pin)%k != 0 {
+			continue
+		}
+		if strings.Repeat(pin[:k], len(pin)/k) == pin {
+			return true
+		}
+	}
+	return false
+}
+
+// hashPin hashes the PIN with its own random salt, slowly enough that the few possible PINs cannot be tried out quickly.
+//
+// The hash does not depend on the session id, so the history can be moved with the account to another number.
+func hashPin(pin string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(h), nil
+}
+
+// Check returns an error describing why the PIN cannot be used as the new PIN for the given session id.
+func (p *PinPolicy) Check(ctx context.Context, store DataStore, sessionId string, pin string) error {
+	if !p.IsValidFormat(pin) {
+		return ErrPinLength
+	}
+	if IsWeakPin(pin) {
+		return ErrPinWeak
+	}
+
+	yob, err := store.ReadEntry(ctx, sessionId, DATA_YOB)
+	if err != nil {
+		if !db.IsNotFound(err) {
+			return err
+		}
+	} else if len(yob) > 0 && strings.Contains(pin, string(yob)) {
+		return ErrPinYob
+	}
+
+	current, err := store.ReadEntry(ctx, sessionId, DATA_ACCOUNT_PIN)
+	if err != nil {
+		if !db.IsNotFound(err) {
+			return err
+		}
+	} else if string(current) == pin {
+		return ErrPinReused
+	}
+
+	history, err := p.readHistory(ctx, store, sessionId)
+	if err != nil {
+		return err
+	}
+	for _, v := range history {
+		if bcrypt.CompareHashAndPassword([]byte(v), []byte(pin)) == nil {
+			return ErrPinReused
+		}
+	}
+	return nil
+}
+
+// Record adds the PIN to the list of recently used PINs for the session id.
+func (p *PinPolicy) Record(ctx context.Context, store DataStore, sessionId string, pin string) error {
+	history, err := p.readHistory(ctx, store, sessionId)
+	if err != nil {
+		return err
+	}
+	hashed, err := hashPin(pin)
+	if err != nil {
+		return err
+	}
+	history = append([]string{hashed}, history...)
+	if len(history) > p.history {
+		history = history[:p.history]
+	}
+	return store.WriteEntry(ctx, sessionId, DATA_PIN_HISTORY, []byte(strings.Join(history, "\n")))
+}
+
+func (p *PinPolicy) readHistory(ctx context.Context, store DataStore, sessionId string) ([]string, error) {
+	v, err := store.ReadEntry(ctx, sessionId, DATA_PIN_HISTORY)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, nil
+	}
+	return strings.Split(string(v), "\n"), nil
+}
+
+// RequirePinChange marks the PIN of the session id as one that has to be changed the next time the user dials in,
+// like the initial PIN of a pre-registered account.
+func RequirePinChange(ctx context.Context, store DataStore, sessionId string) error {
+	return store.WriteEntry(ctx, sessionId, DATA_PIN_CHANGE_REQUIRED, []byte("1"))
+}
+
+// ClearPinChange lifts the requirement to change the PIN of the session id, once it has been changed.
+func ClearPinChange(ctx context.Context, store DataStore, sessionId string) error {
+	return store.WriteEntry(ctx, sessionId, DATA_PIN_CHANGE_REQUIRED, []byte{})
+}
+
+// IsPinChangeRequired checks whether the PIN of the session id has to be changed before the account can be used.
+func IsPinChangeRequired(ctx context.Context, store DataStore, sessionId string) (bool, error) {
+	v, err := store.ReadEntry(ctx, sessionId, DATA_PIN_CHANGE_REQUIRED)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return len(v) > 0, nil
+}
@@ -0,0 +1,117 @@
+package common
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestIsWeakPin(t *testing.T) {
+	tests := []struct {
+		pin      string
+		expected bool
+	}{
+		{"1234", true},
+		{"9876", true},
+		{"8901", true},
+		{"0000", true},
+		{"1212", true},
+		{"123123", true},
+		{"4831", false},
+		{"2580", false},
+		{"12345", true},
+		{"102938", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pin, func(t *testing.T) {
+			assert.Equal(t, tt.expected, IsWeakPin(tt.pin))
+		})
+	}
+}
+
+func TestNewPinPolicyLength(t *testing.T) {
+	assert.Equal(t, 4, NewPinPolicy(0, 0).Length())
+	assert.Equal(t, 5, NewPinPolicy(5, 0).Length())
+	assert.Equal(t, 6, NewPinPolicy(9, 0).Length())
+}
+
+func TestPinPolicyCheck(t *testing.T) {
+	ctx, store := InitializeTestDb(t)
+	sessionId := "+254712345678"
+	p := NewPinPolicy(4, 2)
+
+	err := store.WriteEntry(ctx, sessionId, DATA_YOB, []byte("1984"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, ErrPinLength, p.Check(ctx, store, sessionId, "48310"))
+	assert.Equal(t, ErrPinLength, p.Check(ctx, store, sessionId, "48a1"))
+	assert.Equal(t, ErrPinWeak, p.Check(ctx, store, sessionId, "4444"))
+	assert.Equal(t, ErrPinYob, p.Check(ctx, store, sessionId, "1984"))
+	assert.NoError(t, p.Check(ctx, store, sessionId, "4831"))
+
+	for _, pin := range []string{"4831", "5927", "3816"} {
+		err = p.Record(ctx, store, sessionId, pin)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Equal(t, ErrPinReused, p.Check(ctx, store, sessionId, "3816"))
+	assert.Equal(t, ErrPinReused, p.Check(ctx, store, sessionId, "5927"))
+	// only the last two pins are remembered
+	assert.NoError(t, p.Check(ctx, store, sessionId, "4831"))
+
+	// the history is salted, and still applies when it is moved to another number
+	history, err := store.ReadEntry(ctx, sessionId, DATA_PIN_HISTORY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, string(history), "3816")
+	other := "+254787654321"
+	err = store.WriteEntry(ctx, other, DATA_PIN_HISTORY, history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ErrPinReused, p.Check(ctx, store, other, "3816"))
+	err = p.Record(ctx, store, other, "3816")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes, err := store.ReadEntry(ctx, other, DATA_PIN_HISTORY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(string(hashes), "\n")
+	assert.NotEqual(t, parts[0], parts[1])
+}
+
+func TestPinPolicyGenerate(t *testing.T) {
+	p := NewPinPolicy(6, 0)
+	for i := 0; i < 20; i++ {
+		pin, err := p.Generate()
+		assert.NoError(t, err)
+		assert.True(t, p.IsValidFormat(pin))
+		assert.False(t, IsWeakPin(pin))
+	}
+}
+
+func TestPinChangeRequired(t *testing.T) {
+	sessionId := "+254712345678"
+	ctx, store := InitializeTestDb(t)
+
+	required, err := IsPinChangeRequired(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.False(t, required)
+
+	assert.NoError(t, RequirePinChange(ctx, store, sessionId))
+	required, err = IsPinChangeRequired(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.True(t, required)
+
+	assert.NoError(t, ClearPinChange(ctx, store, sessionId))
+	required, err = IsPinChangeRequired(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.False(t, required)
+}
@@ -0,0 +1,434 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+
+	"git.grassecon.net/urdt/ussd/internal/storage"
+)
+
+const (
+	// NumberChangeTTL is how long the code sent to the new number can be used.
+	NumberChangeTTL = 15 * time.Minute
+	// NumberChangeAttempts is how many times a wrong code can be entered before the request is cancelled.
+	NumberChangeAttempts = 3
+	// numberChangeCodeLength is the number of digits of the code sent to the new number.
+	numberChangeCodeLength = 6
+	// maxRedirects is the longest chain of number changes followed when resolving a number.
+	maxRedirects = 8
+)
+
+var (
+	ErrPortSameNumber   = errors.New("new number is the same as the current number")
+	ErrPortNoAccount    = errors.New("no account to move")
+	ErrPortTargetInUse  = errors.New("new number already has an account")
+	ErrAccountPorted    = errors.New("account has moved to another number")
+	ErrNoNumberChange   = errors.New("no pending number change")
+	ErrNumberChangeCode = errors.New("wrong number change code")
+	ErrTooManyRedirects = errors.New("too many number change redirects")
+)
+
+var (
+	// portedDataTyps are the entries keyed by session id that are moved on a number change.
+	//
+	// The public key is moved last, as it marks the new number as having an account.
+	portedDataTyps = []DataTyp{
+		DATA_ACCOUNT,
+		DATA_ACCOUNT_CREATED,
+		DATA_TRACKING_ID,
+		DATA_CUSTODIAL_ID,
+		DATA_ACCOUNT_PIN,
+		DATA_ACCOUNT_STATUS,
+		DATA_FIRST_NAME,
+		DATA_FAMILY_NAME,
+		DATA_YOB,
+		DATA_LOCATION,
+		DATA_GENDER,
+		DATA_OFFERINGS,
+		DATA_RECIPIENT,
+		DATA_AMOUNT,
+		DATA_TEMPORARY_VALUE,
+		DATA_BATCH,
+		DATA_PAYMENT_REQUESTS,
+		DATA_PAYMENT_REQUEST_DRAFT,
+		DATA_SCHEDULES,
+		DATA_SCHEDULE_DRAFT,
+		DATA_ACTIVE_SYM,
+		DATA_ACTIVE_BAL,
+		DATA_ACTIVE_BAL_UPDATED,
+		DATA_BLOCKED_NUMBER,
+		DATA_ACTIVE_DECIMAL,
+		DATA_ACTIVE_ADDRESS,
+		DATA_TRANSACTIONS,
+		DATA_ALIAS,
+		DATA_PIN_HISTORY,
+		DATA_PIN_CHANGE_REQUIRED,
+		DATA_TRANSFER_LOG,
+		DATA_KNOWN_RECIPIENTS,
+		DATA_ACCOUNT_FROZEN,
+		DATA_GUARDIANS,
+		DATA_RECOVERY_REQUEST,
+		DATA_TERMS_ACCEPTANCE,
+		DATA_LANGUAGE_CODE,
+		DATA_PUBLIC_KEY,
+	}
+	// clearedDataTyps are the entries keyed by session id that are removed from the old number but not moved.
+	clearedDataTyps = []DataTyp{
+		DATA_AUTH_TOKEN,
+		DATA_NUMBER_CHANGE,
+	}
+)
+
+// ReadPortedTo returns the number the account of the session id was moved to, or an empty string if it has not been moved.
+func ReadPortedTo(ctx context.Context, store DataStore, sessionId string) (string, error) {
+	v, err := store.ReadEntry(ctx, sessionId, DATA_PORTED_TO)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return string(v), nil
+}
+
+// ResolvePorted follows the number changes of the session id, and returns the number the account is held by now.
+func ResolvePorted(ctx context.Context, store DataStore, sessionId string) (string, error) {
+	for i := 0; i < maxRedirects; i++ {
+		to, err := ReadPortedTo(ctx, store, sessionId)
+		if err != nil {
+			return sessionId, err
+		}
+		if to == "" {
+			return sessionId, nil
+		}
+		sessionId = to
+	}
+	return sessionId, ErrTooManyRedirects
+}
+
+func hasAccount(ctx context.Context, store DataStore, sessionId string) (bool, error) {
+	v, err := store.ReadEntry(ctx, sessionId, DATA_PUBLIC_KEY)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return len(v) > 0, nil
+}
+
+// CheckPortTarget checks whether the account of the session id can be moved to the number.
+func CheckPortTarget(ctx context.Context, store DataStore, sessionId string, to string) error {
+	if to == sessionId {
+		return ErrPortSameNumber
+	}
+	ok, err := hasAccount(ctx, store, sessionId)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrPortNoAccount
+	}
+	_, closed, err := ReadClosure(ctx, store, sessionId)
+	if err != nil {
+		return err
+	}
+	if closed {
+		return ErrAccountClosed
+	}
+	ok, err = hasAccount(ctx, store, to)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return ErrPortTargetInUse
+	}
+	return nil
+}
+
+// PortAccount moves the account of the session id to the new number, and leaves a redirect to the new number on the old one.
+//
+// The store cannot write several entries at once. All entries are copied to the new number first, and the move takes
+// effect when the redirect is written. The old entries are then cleared. If the move is interrupted after the redirect
+// was written, calling PortAccount again completes it.
+//
+// The menu state of the old number is copied to the new number if a state store is given. Entries held by other accounts
+// that refer to the old number, such as guardians, are not changed.
+func PortAccount(ctx context.Context, store DataStore, stateStore db.Db, sessionId string, to string) error {
+	portedTo, err := ReadPortedTo(ctx, store, sessionId)
+	if err != nil {
+		return err
+	}
+	if portedTo != "" && portedTo != to {
+		return ErrAccountPorted
+	}
+	if portedTo == "" {
+		err = CheckPortTarget(ctx, store, sessionId, to)
+		if err != nil {
+			return err
+		}
+		err = copyAccount(ctx, store, stateStore, sessionId, to)
+		if err != nil {
+			return err
+		}
+		err = store.WriteEntry(ctx, to, DATA_PORTED_TO, []byte{})
+		if err != nil {
+			return err
+		}
+		err = store.WriteEntry(ctx, sessionId, DATA_PORTED_TO, []byte(to))
+		if err != nil {
+			return err
+		}
+	}
+	err = finishPort(ctx, store, sessionId, to)
+	if err != nil {
+		return err
+	}
+	logg.InfoCtxf(ctx, "account moved to new number", "from", sessionId, "to", to)
+	return nil
+}
+
+func copyAccount(ctx context.Context, store DataStore, stateStore db.Db, sessionId string, to string) error {
+	for _, typ := range portedDataTyps {
+		v, err := store.ReadEntry(ctx, sessionId, typ)
+		if err != nil {
+			if db.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		err = store.WriteEntry(ctx, to, typ, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	voucherDb := storage.NewSubPrefixDb(store, []byte("vouchers"))
+	for _, key := range VoucherKeys {
+		store.SetSession(sessionId)
+		v, err := voucherDb.Get(ctx, []byte(key))
+		if err != nil {
+			if db.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		store.SetSession(to)
+		err = voucherDb.Put(ctx, []byte(key), v)
+		if err != nil {
+			return err
+		}
+	}
+
+	if stateStore != nil {
+		stateStore.SetPrefix(db.DATATYPE_STATE)
+		stateStore.SetSession("")
+		v, err := stateStore.Get(ctx, []byte(sessionId))
+		if err != nil {
+			if !db.IsNotFound(err) {
+				return err
+			}
+		} else {
+			err = stateStore.Put(ctx, []byte(to), v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// finishPort points the reverse lookups to the new number and clears the entries of the old number.
+func finishPort(ctx context.Context, store DataStore, sessionId string, to string) error {
+	publicKey, err := store.ReadEntry(ctx, to, DATA_PUBLIC_KEY)
+	if err != nil {
+		return err
+	}
+	publicKeyNormalized, err := NormalizeHex(string(publicKey))
+	if err != nil {
+		return err
+	}
+	err = store.WriteEntry(ctx, publicKeyNormalized, DATA_PUBLIC_KEY_REVERSE, []byte(to))
+	if err != nil {
+		return err
+	}
+
+	alias, err := store.ReadEntry(ctx, to, DATA_ALIAS)
+	if err != nil && !db.IsNotFound(err) {
+		return err
+	}
+	if len(alias) > 0 {
+		err = portAlias(ctx, store, sessionId, to, string(alias))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, typs := range [][]DataTyp{portedDataTyps, clearedDataTyps} {
+		for _, typ := range typs {
+			err = store.WriteEntry(ctx, sessionId, typ, []byte{})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	voucherDb := storage.NewSubPrefixDb(store, []byte("vouchers"))
+	for _, key := range VoucherKeys {
+		store.SetSession(sessionId)
+		err = voucherDb.Put(ctx, []byte(key), []byte{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// portAlias points the alias moved to the new number to it, unless the alias has been claimed by another
+// account since it was moved, in which case the new number is left without an alias.
+func portAlias(ctx context.Context, store DataStore, sessionId string, to string, alias string) error {
+	aliasMu.Lock()
+	defer aliasMu.Unlock()
+
+	owner, err := ResolveAlias(ctx, store, alias)
+	if err != nil && !db.IsNotFound(err) {
+		return err
+	}
+	if err == nil && owner != sessionId && owner != to {
+		return store.WriteEntry(ctx, to, DATA_ALIAS, []byte{})
+	}
+	return store.WriteEntry(ctx, alias, DATA_ALIAS_REVERSE, []byte(to))
+}
+
+// NumberChange is a request by a user to move their account to a new number, pending the code sent to the new number.
+type NumberChange struct {
+	To          string
+	RequestedAt time.Time
+	// CodeHash is the hash of the code sent to the new number.
+	CodeHash string
+	Attempts int
+}
+
+// String serializes the request as "<new number>|<unix timestamp>|<code hash>|<attempts>".
+func (c NumberChange) String() string {
+	return fmt.Sprintf("%s|%d|%s|%d", c.To, c.RequestedAt.Unix(), c.CodeHash, c.Attempts)
+}
+
+// ParseNumberChange parses a request serialized with String.
+func ParseNumberChange(v []byte) (NumberChange, error) {
+	var c NumberChange
+	parts := strings.Split(string(v), "|")
+	if len(parts) != 4 {
+		return c, fmt.Errorf("invalid number change request: %q", v)
+	}
+	ts, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return c, fmt.Errorf("invalid number change timestamp: %v", err)
+	}
+	attempts, err := strconv.Atoi(parts[3])
+	if err != nil {
+		return c, fmt.Errorf("invalid number change attempts: %v", err)
+	}
+	c.To = parts[0]
+	c.RequestedAt = time.Unix(ts, 0)
+	c.CodeHash = parts[2]
+	c.Attempts = attempts
+	return c, nil
+}
+
+// IsExpired checks whether the code can no longer be used.
+func (c NumberChange) IsExpired(now time.Time) bool {
+	return now.Sub(c.RequestedAt) >= NumberChangeTTL
+}
+
+func hashNumberChangeCode(sessionId string, to string, code string) string {
+	h := sha256.Sum256([]byte(sessionId + ":" + to + ":" + code))
+	return hex.EncodeToString(h[:])
+}
+
+// ReadNumberChange returns the pending number change of the session id.
+//
+// ErrNoNumberChange is returned if there is no request, or it has expired.
+func ReadNumberChange(ctx context.Context, store DataStore, sessionId string) (NumberChange, error) {
+	v, err := store.ReadEntry(ctx, sessionId, DATA_NUMBER_CHANGE)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return NumberChange{}, ErrNoNumberChange
+		}
+		return NumberChange{}, err
+	}
+	if len(v) == 0 {
+		return NumberChange{}, ErrNoNumberChange
+	}
+	c, err := ParseNumberChange(v)
+	if err != nil {
+		return c, err
+	}
+	if c.IsExpired(time.Now()) {
+		return c, ErrNoNumberChange
+	}
+	return c, nil
+}
+
+// RequestNumberChange opens a request to move the account of the session id to the new number, replacing any pending request.
+//
+// The returned code must be sent to the new number, and entered to confirm the change.
+func RequestNumberChange(ctx context.Context, store DataStore, sessionId string, to string) (string, error) {
+	err := CheckPortTarget(ctx, store, sessionId, to)
+	if err != nil {
+		return "", err
+	}
+	max := big.NewInt(1)
+	max.Exp(big.NewInt(10), big.NewInt(numberChangeCodeLength), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	code := fmt.Sprintf("%0*d", numberChangeCodeLength, n)
+	c := NumberChange{
+		To:          to,
+		RequestedAt: time.Now(),
+		CodeHash:    hashNumberChangeCode(sessionId, to, code),
+	}
+	err = store.WriteEntry(ctx, sessionId, DATA_NUMBER_CHANGE, []byte(c.String()))
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// ConfirmNumberChange checks the code of the pending number change of the session id, and returns the new number.
+//
+// After NumberChangeAttempts wrong codes the request is cancelled.
+func ConfirmNumberChange(ctx context.Context, store DataStore, sessionId string, code string) (string, error) {
+	c, err := ReadNumberChange(ctx, store, sessionId)
+	if err != nil {
+		return "", err
+	}
+	if hashNumberChangeCode(sessionId, c.To, code) != c.CodeHash {
+		c.Attempts++
+		if c.Attempts >= NumberChangeAttempts {
+			err = store.WriteEntry(ctx, sessionId, DATA_NUMBER_CHANGE, []byte{})
+		} else {
+			err = store.WriteEntry(ctx, sessionId, DATA_NUMBER_CHANGE, []byte(c.String()))
+		}
+		if err != nil {
+			return "", err
+		}
+		return "", ErrNumberChangeCode
+	}
+	err = store.WriteEntry(ctx, sessionId, DATA_NUMBER_CHANGE, []byte{})
+	if err != nil {
+		return "", err
+	}
+	return c.To, nil
+}
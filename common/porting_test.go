@@ -0,0 +1,152 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"git.grassecon.net/urdt/ussd/internal/storage"
+)
+
+func TestPortAccount(t *testing.T) {
+	ctx, store := InitializeTestDb(t)
+	from := "+254712345678"
+	to := "+254787654321"
+	publicKey := "0x0A1B2C3D4E5F"
+
+	assert.Equal(t, ErrPortNoAccount, PortAccount(ctx, store, nil, from, to))
+	for typ, v := range map[DataTyp]string{
+		DATA_PUBLIC_KEY: publicKey,
+		DATA_FIRST_NAME: "Jane",
+	} {
+		err := store.WriteEntry(ctx, from, typ, []byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := ClaimAlias(ctx, store, from, "jane")
+	if err != nil {
+		t.Fatal(err)
+	}
+	voucherDb := storage.NewSubPrefixDb(store, []byte("vouchers"))
+	store.SetSession(from)
+	err = voucherDb.Put(ctx, []byte("sym"), []byte("1:SRF"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, ErrPortSameNumber, PortAccount(ctx, store, nil, from, from))
+	err = store.WriteEntry(ctx, "+254700000000", DATA_PUBLIC_KEY, []byte("0x01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ErrPortTargetInUse, PortAccount(ctx, store, nil, from, "+254700000000"))
+
+	assert.NoError(t, PortAccount(ctx, store, nil, from, to))
+
+	v, err := store.ReadEntry(ctx, to, DATA_FIRST_NAME)
+	assert.NoError(t, err)
+	assert.Equal(t, "Jane", string(v))
+	v, err = store.ReadEntry(ctx, from, DATA_FIRST_NAME)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(v))
+	store.SetSession(to)
+	v, err = voucherDb.Get(ctx, []byte("sym"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1:SRF", string(v))
+
+	publicKeyNormalized, err := NormalizeHex(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err = store.ReadEntry(ctx, publicKeyNormalized, DATA_PUBLIC_KEY_REVERSE)
+	assert.NoError(t, err)
+	assert.Equal(t, to, string(v))
+	holder, err := ResolveAlias(ctx, store, "jane")
+	assert.NoError(t, err)
+	assert.Equal(t, to, holder)
+
+	holder, err = ResolvePorted(ctx, store, from)
+	assert.NoError(t, err)
+	assert.Equal(t, to, holder)
+	ok, err := hasAccount(ctx, store, from)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// completing an interrupted move again is harmless, moving elsewhere is not
+	assert.NoError(t, PortAccount(ctx, store, nil, from, to))
+	assert.Equal(t, ErrAccountPorted, PortAccount(ctx, store, nil, from, "+254711111111"))
+}
+
+func TestNumberChange(t *testing.T) {
+	ctx, store := InitializeTestDb(t)
+	from := "+254712345678"
+	to := "+254787654321"
+	err := store.WriteEntry(ctx, from, DATA_PUBLIC_KEY, []byte("0x0A"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ConfirmNumberChange(ctx, store, from, "000000")
+	assert.Equal(t, ErrNoNumberChange, err)
+
+	code, err := RequestNumberChange(ctx, store, from, to)
+	assert.NoError(t, err)
+	assert.Equal(t, numberChangeCodeLength, len(code))
+	wrong := "x" + code[1:]
+	for i := 0; i < NumberChangeAttempts; i++ {
+		_, err = ConfirmNumberChange(ctx, store, from, wrong)
+		assert.Equal(t, ErrNumberChangeCode, err)
+	}
+	_, err = ConfirmNumberChange(ctx, store, from, code)
+	assert.Equal(t, ErrNoNumberChange, err)
+
+	code, err = RequestNumberChange(ctx, store, from, to)
+	assert.NoError(t, err)
+	_, err = ConfirmNumberChange(ctx, store, from, wrong)
+	assert.Equal(t, ErrNumberChangeCode, err)
+	r, err := ConfirmNumberChange(ctx, store, from, code)
+	assert.NoError(t, err)
+	assert.Equal(t, to, r)
+	_, err = ReadNumberChange(ctx, store, from)
+	assert.Equal(t, ErrNoNumberChange, err)
+}
+
+func TestPortAlias(t *testing.T) {
+	ctx, store := InitializeTestDb(t)
+	from := "+254712345678"
+	to := "+254787654321"
+	other := "+254711111111"
+
+	err := ClaimAlias(ctx, store, from, "jane")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.WriteEntry(ctx, to, DATA_ALIAS, []byte("jane"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = portAlias(ctx, store, from, to, "jane")
+	assert.NoError(t, err)
+	holder, err := ResolveAlias(ctx, store, "jane")
+	assert.NoError(t, err)
+	assert.Equal(t, to, holder)
+
+	// an alias claimed by another account since it was moved stays with that account
+	err = ReleaseAlias(ctx, store, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ClaimAlias(ctx, store, other, "jane")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = portAlias(ctx, store, from, to, "jane")
+	assert.NoError(t, err)
+	holder, err = ResolveAlias(ctx, store, "jane")
+	assert.NoError(t, err)
+	assert.Equal(t, other, holder)
+	alias, err := store.ReadEntry(ctx, to, DATA_ALIAS)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(alias))
+}
@@ -0,0 +1,345 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+
+	"git.grassecon.net/urdt/ussd/internal/storage"
+)
+
+const (
+	// MaxSchedules is the most scheduled transfers an account can have.
+	MaxSchedules = 5
+	// scheduledAccountsKey is the key of the index of accounts with scheduled transfers.
+	scheduledAccountsKey = "accounts"
+)
+
+// Frequency is how often a scheduled transfer is made.
+type Frequency string
+
+const (
+	// FrequencyOnce is set for a transfer made once, at a future date.
+	FrequencyOnce Frequency = "once"
+	// FrequencyWeekly is set for a transfer made every week from its first date.
+	FrequencyWeekly Frequency = "weekly"
+	// FrequencyMonthly is set for a transfer made every month, on the day of the month of its first date.
+	FrequencyMonthly Frequency = "monthly"
+)
+
+var (
+	ErrSchedulesFull = errors.New("too many scheduled transfers")
+	ErrNoSchedule    = errors.New("no such scheduled transfer")
+)
+
+var (
+	// scheduleMu guards the scheduled transfers of the accounts.
+	scheduleMu sync.Mutex
+	// scheduleIndexMu guards the index of accounts with scheduled transfers.
+	scheduleIndexMu sync.Mutex
+)
+
+// Schedule is a transfer to be made from an account at a future date, once or repeatedly.
+type Schedule struct {
+	// Id identifies the schedule among the schedules of the account.
+	Id string
+	// Recipient is the recipient as entered by the user, and RecipientAddress the address it was resolved to.
+	Recipient        string
+	RecipientAddress string
+	// Amount is the amount in whole tokens of the voucher.
+	Amount string
+	// Symbol, Address and Decimals describe the voucher to be sent.
+	Symbol    string
+	Address   string
+	Decimals  string
+	Frequency Frequency
+	// Start is the date of the first transfer.
+	Start time.Time
+	// Next is the date of the next transfer.
+	Next time.Time
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+func parseTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	ts, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts, 0), nil
+}
+
+// String serializes the schedule as "|" separated fields.
+func (s Schedule) String() string {
+	return strings.Join([]string{
+		s.Id,
+		s.Recipient,
+		s.RecipientAddress,
+		s.Amount,
+		s.Symbol,
+		s.Address,
+		s.Decimals,
+		string(s.Frequency),
+		formatTime(s.Start),
+		formatTime(s.Next),
+	}, "|")
+}
+
+// ParseSchedule parses a schedule serialized with String.
+func ParseSchedule(v []byte) (Schedule, error) {
+	var s Schedule
+	parts := strings.Split(string(v), "|")
+	if len(parts) != 10 {
+		return s, fmt.Errorf("invalid schedule: %q", v)
+	}
+	s.Id = parts[0]
+	s.Recipient = parts[1]
+	s.RecipientAddress = parts[2]
+	s.Amount = parts[3]
+	s.Symbol = parts[4]
+	s.Address = parts[5]
+	s.Decimals = parts[6]
+	s.Frequency = Frequency(parts[7])
+	var err error
+	s.Start, err = parseTime(parts[8])
+	if err != nil {
+		return s, fmt.Errorf("invalid schedule start: %v", err)
+	}
+	s.Next, err = parseTime(parts[9])
+	if err != nil {
+		return s, fmt.Errorf("invalid schedule next date: %v", err)
+	}
+	return s, nil
+}
+
+// IsDue checks whether the next transfer of the schedule should be made.
+func (s Schedule) IsDue(now time.Time) bool {
+	return !s.Next.After(now)
+}
+
+// following returns the date of the first transfer of the schedule after now, and false if there is none.
+//
+// Transfers missed while the schedule was not run are skipped rather than made all at once.
+func (s Schedule) following(now time.Time) (time.Time, bool) {
+	next := s.Next
+	for !next.After(now) {
+		switch s.Frequency {
+		case FrequencyWeekly:
+			next = next.AddDate(0, 0, 7)
+		case FrequencyMonthly:
+			next = nextMonth(next, s.Start.Day())
+		default:
+			return time.Time{}, false
+		}
+	}
+	return next, true
+}
+
+// nextMonth returns the time on the given day of the month after t, or on the last day of that month if it is shorter.
+func nextMonth(t time.Time, day int) time.Time {
+	y, m, _ := t.Date()
+	last := time.Date(y, m+2, 0, 0, 0, 0, 0, t.Location()).Day()
+	if day > last {
+		day = last
+	}
+	return time.Date(y, m+1, day, t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+}
+
+// ReadSchedules returns the scheduled transfers of the session id, in the order they were made.
+func ReadSchedules(ctx context.Context, store DataStore, sessionId string) ([]Schedule, error) {
+	v, err := store.ReadEntry(ctx, sessionId, DATA_SCHEDULES)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var r []Schedule
+	for _, line := range strings.Split(string(v), "\n") {
+		if line == "" {
+			continue
+		}
+		s, err := ParseSchedule([]byte(line))
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, s)
+	}
+	return r, nil
+}
+
+func writeSchedules(ctx context.Context, store DataStore, sessionId string, schedules []Schedule) error {
+	var lines []string
+	for _, s := range schedules {
+		lines = append(lines, s.String())
+	}
+	err := store.WriteEntry(ctx, sessionId, DATA_SCHEDULES, []byte(strings.Join(lines, "\n")))
+	if err != nil {
+		return err
+	}
+	return updateScheduledAccounts(ctx, store, sessionId, len(schedules) > 0)
+}
+
+// AddSchedule adds a scheduled transfer to the session id, and returns it with its id.
+//
+// An account can have at most MaxSchedules scheduled transfers.
+func AddSchedule(ctx context.Context, store DataStore, sessionId string, s Schedule) (Schedule, error) {
+	scheduleMu.Lock()
+	defer scheduleMu.Unlock()
+	schedules, err := ReadSchedules(ctx, store, sessionId)
+	if err != nil {
+		return s, err
+	}
+	if len(schedules) >= MaxSchedules {
+		return s, ErrSchedulesFull
+	}
+	id := time.Now().UnixNano()
+	for _, v := range schedules {
+		n, _ := strconv.ParseInt(v.Id, 10, 64)
+		if n >= id {
+			id = n + 1
+		}
+	}
+	s.Id = strconv.FormatInt(id, 10)
+	if s.Next.IsZero() {
+		s.Next = s.Start
+	}
+	return s, writeSchedules(ctx, store, sessionId, append(schedules, s))
+}
+
+// RemoveSchedule cancels the scheduled transfer of the session id with the given id, and returns it.
+func RemoveSchedule(ctx context.Context, store DataStore, sessionId string, id string) (Schedule, error) {
+	scheduleMu.Lock()
+	defer scheduleMu.Unlock()
+	schedules, err := ReadSchedules(ctx, store, sessionId)
+	if err != nil {
+		return Schedule{}, err
+	}
+	for i, s := range schedules {
+		if s.Id == id {
+			return s, writeSchedules(ctx, store, sessionId, append(schedules[:i], schedules[i+1:]...))
+		}
+	}
+	return Schedule{}, ErrNoSchedule
+}
+
+// AdvanceSchedule moves the scheduled transfer of the session id with the given id to its next date after now,
+// or removes it if it has no more transfers to make. It returns the schedule as it was, and whether it was removed.
+//
+// It is called before the due transfer is made, so that a transfer is never made twice.
+func AdvanceSchedule(ctx context.Context, store DataStore, sessionId string, id string, now time.Time) (Schedule, bool, error) {
+	scheduleMu.Lock()
+	defer scheduleMu.Unlock()
+	schedules, err := ReadSchedules(ctx, store, sessionId)
+	if err != nil {
+		return Schedule{}, false, err
+	}
+	for i, s := range schedules {
+		if s.Id != id {
+			continue
+		}
+		next, ok := s.following(now)
+		if !ok {
+			return s, true, writeSchedules(ctx, store, sessionId, append(schedules[:i], schedules[i+1:]...))
+		}
+		schedules[i].Next = next
+		return s, false, writeSchedules(ctx, store, sessionId, schedules)
+	}
+	return Schedule{}, false, ErrNoSchedule
+}
+
+// ReadScheduleDraft returns the scheduled transfer the session id is entering.
+func ReadScheduleDraft(ctx context.Context, store DataStore, sessionId string) (Schedule, error) {
+	v, err := store.ReadEntry(ctx, sessionId, DATA_SCHEDULE_DRAFT)
+	if err != nil {
+		return Schedule{}, err
+	}
+	return ParseSchedule(v)
+}
+
+// WriteScheduleDraft saves the scheduled transfer the session id is entering, until it is made.
+func WriteScheduleDraft(ctx context.Context, store DataStore, sessionId string, s Schedule) error {
+	return store.WriteEntry(ctx, sessionId, DATA_SCHEDULE_DRAFT, []byte(s.String()))
+}
+
+// ScheduledAccounts returns the session ids in the index of accounts with scheduled transfers.
+func ScheduledAccounts(ctx context.Context, store DataStore) ([]string, error) {
+	scheduleIndexMu.Lock()
+	defer scheduleIndexMu.Unlock()
+	return readScheduledAccounts(ctx, store)
+}
+
+// ReindexSchedules adds the session id to the index of accounts with scheduled transfers if it has any,
+// and removes it otherwise.
+//
+// It is used when the schedules of the session id may have been moved to another number or erased.
+func ReindexSchedules(ctx context.Context, store DataStore, sessionId string) error {
+	scheduleMu.Lock()
+	defer scheduleMu.Unlock()
+	schedules, err := ReadSchedules(ctx, store, sessionId)
+	if err != nil {
+		return err
+	}
+	return updateScheduledAccounts(ctx, store, sessionId, len(schedules) > 0)
+}
+
+// readScheduledAccounts reads the index of accounts with scheduled transfers.
+//
+// The index is kept outside of any session.
+func readScheduledAccounts(ctx context.Context, store DataStore) ([]string, error) {
+	store.SetSession("")
+	v, err := storage.NewSubPrefixDb(store, []byte("schedule")).Get(ctx, []byte(scheduledAccountsKey))
+	if err != nil {
+		if db.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, nil
+	}
+	return strings.Split(string(v), "\n"), nil
+}
+
+// updateScheduledAccounts adds the session id to the index of accounts with scheduled transfers, or removes it.
+func updateScheduledAccounts(ctx context.Context, store DataStore, sessionId string, scheduled bool) error {
+	scheduleIndexMu.Lock()
+	defer scheduleIndexMu.Unlock()
+	sessionIds, err := readScheduledAccounts(ctx, store)
+	if err != nil {
+		return err
+	}
+	var r []string
+	var found bool
+	for _, v := range sessionIds {
+		if v == sessionId {
+			found = true
+			if !scheduled {
+				continue
+			}
+		}
+		r = append(r, v)
+	}
+	if found == scheduled {
+		return nil
+	}
+	if scheduled {
+		r = append(r, sessionId)
+	}
+	store.SetSession("")
+	return storage.NewSubPrefixDb(store, []byte("schedule")).Put(ctx, []byte(scheduledAccountsKey), []byte(strings.Join(r, "\n")))
+}
@@ -0,0 +1,90 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestSchedules(t *testing.T) {
+	sessionId := "+254712345678"
+	ctx, store := InitializeTestDb(t)
+	start := time.Date(2026, time.January, 31, 8, 0, 0, 0, time.Local)
+
+	schedules, err := ReadSchedules(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(schedules))
+
+	s, err := AddSchedule(ctx, store, sessionId, Schedule{
+		Recipient:        "+254787654321",
+		RecipientAddress: "0x41c188d63Qa",
+		Amount:           "2.5",
+		Symbol:           "SRF",
+		Address:          "0xd4c288865Ce",
+		Decimals:         "6",
+		Frequency:        FrequencyMonthly,
+		Start:            start,
+	})
+	assert.NoError(t, err)
+	assert.NotEqual(t, "", s.Id)
+	assert.Equal(t, start, s.Next)
+	schedules, err = ReadSchedules(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, []Schedule{s}, schedules)
+	sessionIds, err := ScheduledAccounts(ctx, store)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{sessionId}, sessionIds)
+
+	assert.False(t, s.IsDue(start.Add(-time.Minute)))
+	assert.True(t, s.IsDue(start))
+
+	// monthly transfers keep to the day of the first one, or the last day of shorter months
+	_, removed, err := AdvanceSchedule(ctx, store, sessionId, s.Id, start)
+	assert.NoError(t, err)
+	assert.False(t, removed)
+	schedules, err = ReadSchedules(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2026, time.February, 28, 8, 0, 0, 0, time.Local), schedules[0].Next)
+	_, _, err = AdvanceSchedule(ctx, store, sessionId, s.Id, schedules[0].Next)
+	assert.NoError(t, err)
+	schedules, err = ReadSchedules(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2026, time.March, 31, 8, 0, 0, 0, time.Local), schedules[0].Next)
+
+	// missed transfers are skipped
+	weekly, err := AddSchedule(ctx, store, sessionId, Schedule{Frequency: FrequencyWeekly, Start: start})
+	assert.NoError(t, err)
+	_, _, err = AdvanceSchedule(ctx, store, sessionId, weekly.Id, start.AddDate(0, 0, 15))
+	assert.NoError(t, err)
+	schedules, err = ReadSchedules(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, start.AddDate(0, 0, 21), schedules[1].Next)
+
+	once, err := AddSchedule(ctx, store, sessionId, Schedule{Frequency: FrequencyOnce, Start: start})
+	assert.NoError(t, err)
+	_, removed, err = AdvanceSchedule(ctx, store, sessionId, once.Id, start)
+	assert.NoError(t, err)
+	assert.True(t, removed)
+	_, _, err = AdvanceSchedule(ctx, store, sessionId, once.Id, start)
+	assert.Equal(t, ErrNoSchedule, err)
+
+	for i := 2; i < MaxSchedules; i++ {
+		_, err = AddSchedule(ctx, store, sessionId, Schedule{Frequency: FrequencyOnce, Start: start})
+		assert.NoError(t, err)
+	}
+	_, err = AddSchedule(ctx, store, sessionId, Schedule{Frequency: FrequencyOnce, Start: start})
+	assert.Equal(t, ErrSchedulesFull, err)
+
+	schedules, err = ReadSchedules(ctx, store, sessionId)
+	assert.NoError(t, err)
+	for _, s := range schedules {
+		_, err = RemoveSchedule(ctx, store, sessionId, s.Id)
+		assert.NoError(t, err)
+	}
+	_, err = RemoveSchedule(ctx, store, sessionId, s.Id)
+	assert.Equal(t, ErrNoSchedule, err)
+	sessionIds, err = ScheduledAccounts(ctx, store)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(sessionIds))
+}
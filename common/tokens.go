@@ -3,9 +3,7 @@ package common
 import (
 	"context"
 	"errors"
-	"math/big"
 	"reflect"
-	"strconv"
 )
 
 type TransactionData struct {
@@ -18,29 +16,19 @@ type TransactionData struct {
 	ActiveAddress  string
 }
 
+// ParseAndScaleAmount scales a decimal amount to an integer in the smallest unit of a token with the given decimals.
+//
+// Decimals beyond those of the token are truncated.
 func ParseAndScaleAmount(storedAmount, activeDecimal string) (string, error) {
-	// Parse token decimal
-	tokenDecimal, err := strconv.Atoi(activeDecimal)
+	tokenDecimal, err := parseDecimals(activeDecimal)
 	if err != nil {
-
 		return "", err
 	}
-
-	// Parse amount
-	amount, _, err := big.ParseFloat(storedAmount, 10, 0, big.ToZero)
+	amount, err := parseAmount(storedAmount, tokenDecimal, true)
 	if err != nil {
 		return "", err
 	}
-
-	// Scale the amount
-	multiplier := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(tokenDecimal)), nil))
-	finalAmount := new(big.Float).Mul(amount, multiplier)
-
-	// Convert finalAmount to a string
-	finalAmountStr := new(big.Int)
-	finalAmount.Int(finalAmountStr)
-
-	return finalAmountStr.String(), nil
+	return amount.Raw(), nil
 }
 
 func ReadTransactionData(ctx context.Context, store DataStore, sessionId string) (TransactionData, error) {
@@ -42,26 +42,37 @@ func ProcessVouchers(holdings []dataserviceapi.TokenHoldings) VoucherMetadata {
 	return data
 }
 
+// ScaleDownBalance formats a balance in the smallest unit of a token as an exact amount in whole tokens.
+//
+// The balance is taken to have no decimals if the decimals are invalid.
 func ScaleDownBalance(balance, decimals string) string {
-	// Convert balance and decimals to big.Float
-	bal := new(big.Float)
-	bal.SetString(balance)
-
-	dec, ok := new(big.Int).SetString(decimals, 10)
-	if !ok {
-		dec = big.NewInt(0) // Default to 0 decimals in case of conversion failure
+	amount, err := NewAmount(balance, decimals)
+	if err != nil {
+		amount, err = NewAmount(balance, "0")
+		if err != nil {
+			return "0"
+		}
 	}
+	return amount.String()
+}
 
-	divisor := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), dec, nil))
-	scaledBalance := new(big.Float).Quo(bal, divisor)
-
-	// Return the scaled balance without trailing decimals if it's an integer
-	if scaledBalance.IsInt() {
-		return scaledBalance.Text('f', 0)
+// HeldVouchers returns the holdings with a balance above zero.
+func HeldVouchers(holdings []dataserviceapi.TokenHoldings) []dataserviceapi.TokenHoldings {
+	var r []dataserviceapi.TokenHoldings
+	for _, h := range holdings {
+		bal, ok := new(big.Int).SetString(h.Balance, 10)
+		if !ok || bal.Sign() <= 0 {
+			continue
+		}
+		r = append(r, h)
 	}
-	return scaledBalance.Text('f', -1)
+	return r
 }
 
+// VoucherKeys are the entries of the "vouchers" sub-prefix held for each session id,
+// caching the vouchers and the last transfers of the account.
+var VoucherKeys = []string{"sym", "bal", "deci", "addr", "txfrom", "txto", "txval", "txaddr", "txhash", "txdate", "txsym", "txdeci"}
+
 // GetVoucherData retrieves and matches voucher data
 func GetVoucherData(ctx context.Context, db storage.PrefixDb, input string) (*dataserviceapi.TokenHoldings, error) {
 	keys := []string{"sym", "bal", "deci", "addr"}
@@ -160,6 +171,8 @@ func UpdateVoucherData(ctx context.Context, store DataStore, sessionId string, d
 		DATA_ACTIVE_BAL:     []byte(data.Balance),
 		DATA_ACTIVE_DECIMAL: []byte(data.TokenDecimals),
 		DATA_ACTIVE_ADDRESS: []byte(data.ContractAddress),
+		// the balance is refreshed from the data indexer the next time it is shown
+		DATA_ACTIVE_BAL_UPDATED: []byte{},
 	}
 
 	// Write active data
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"net/url"
 
 	"git.grassecon.net/urdt/ussd/initializers"
@@ -21,6 +22,31 @@ var (
 	custodialURLBase string
 	dataURLBase      string
 	BearerToken      string
+	SmsURL           string
+	SmsToken         string
+	SimCheckURL      string
+)
+
+var (
+	PinLength         uint
+	PinHistory        uint
+	AuthMaxAge        uint
+	FreezeCoolingOff  uint
+	RecoveryQuorum    uint
+	SimSwapWindow     uint
+	SimCheckCacheTtl  uint
+	BalanceTtl        uint
+	PaymentRequestTtl uint
+	EscrowTtl         uint
+)
+
+var (
+	RiskRulesPath     string
+	TermsPath         string
+	CommunityRegistry string
+	KeyringPath       string
+	AdminApiToken     string
+	EscrowAddress     string
 )
 
 var (
@@ -40,6 +66,25 @@ func setBase() error {
 	custodialURLBase = initializers.GetEnv("CUSTODIAL_URL_BASE", "http://localhost:5003")
 	dataURLBase = initializers.GetEnv("DATA_URL_BASE", "http://localhost:5006")
 	BearerToken = initializers.GetEnv("BEARER_TOKEN", "")
+	SmsURL = initializers.GetEnv("SMS_URL", "")
+	SmsToken = initializers.GetEnv("SMS_TOKEN", "")
+	SimCheckURL = initializers.GetEnv("SIM_CHECK_URL", "")
+	PinLength = initializers.GetEnvUint("PIN_LENGTH", 4)
+	PinHistory = initializers.GetEnvUint("PIN_HISTORY", 3)
+	AuthMaxAge = initializers.GetEnvUint("AUTH_MAX_AGE", 300)
+	FreezeCoolingOff = initializers.GetEnvUint("FREEZE_COOLING_OFF", 86400)
+	RecoveryQuorum = initializers.GetEnvUint("RECOVERY_QUORUM", 2)
+	SimSwapWindow = initializers.GetEnvUint("SIM_SWAP_WINDOW", 259200)
+	SimCheckCacheTtl = initializers.GetEnvUint("SIM_CHECK_CACHE_TTL", 3600)
+	BalanceTtl = initializers.GetEnvUint("BALANCE_TTL", 60)
+	PaymentRequestTtl = initializers.GetEnvUint("PAYMENT_REQUEST_TTL", 259200)
+	EscrowTtl = initializers.GetEnvUint("ESCROW_TTL", 2592000)
+	RiskRulesPath = initializers.GetEnv("RISK_RULES_PATH", "")
+	TermsPath = initializers.GetEnv("TERMS_PATH", "")
+	CommunityRegistry = initializers.GetEnv("COMMUNITY_REGISTRY", "")
+	KeyringPath = initializers.GetEnv("KEYRING_PATH", "")
+	AdminApiToken = initializers.GetEnv("ADMIN_API_TOKEN", "")
+	EscrowAddress = initializers.GetEnv("ESCROW_ADDRESS", "")
 
 	_, err = url.JoinPath(custodialURLBase, "/foo")
 	if err != nil {
@@ -49,6 +94,18 @@ func setBase() error {
 	if err != nil {
 		return err
 	}
+	if PinLength < 4 || PinLength > 6 {
+		return fmt.Errorf("PIN_LENGTH must be between 4 and 6, got %d", PinLength)
+	}
+	if RecoveryQuorum < 1 || RecoveryQuorum > 3 {
+		return fmt.Errorf("RECOVERY_QUORUM must be between 1 and 3, got %d", RecoveryQuorum)
+	}
+	if SmsURL != "" {
+		_, err = url.Parse(SmsURL)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/config"
+	"git.grassecon.net/urdt/ussd/initializers"
+	"git.grassecon.net/urdt/ussd/internal/storage"
+)
+
+func init() {
+	initializers.LoadEnvVariables()
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] list|reactivate [session id]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// Reports and reactivates closed accounts.
+//
+// The list command prints the closed accounts with the time they were closed. The reactivate
+// command lifts the closure of the account of a session id (phone number), once an admin has
+// approved it.
+func main() {
+	config.LoadConfig()
+
+	var dbDir string
+	var database string
+	flag.StringVar(&database, "db", "gdbm", "database to be used")
+	flag.StringVar(&dbDir, "dbdir", ".state", "database dir to read from")
+	flag.Usage = usage
+	flag.Parse()
+
+	cmd := flag.Arg(0)
+	if (cmd == "list" && flag.NArg() != 1) || (cmd == "reactivate" && flag.NArg() != 2) {
+		usage()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, "Database", database)
+
+	menuStorageService := storage.NewMenuStorageService(dbDir, "")
+	userdataStore, err := menuStorageService.GetUserdataDb(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	defer userdataStore.Close()
+	store := &common.UserDataStore{Db: userdataStore}
+
+	switch cmd {
+	case "list":
+		sessionIds, err := common.ClosedAccounts(ctx, store)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to list closed accounts: %v\n", err)
+			os.Exit(1)
+		}
+		for _, sessionId := range sessionIds {
+			closedAt, _, err := common.ReadClosure(ctx, store, sessionId)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to read closure of %s: %v\n", sessionId, err)
+				os.Exit(1)
+			}
+			fmt.Printf("%s\t%s\n", sessionId, closedAt.UTC().Format(time.RFC3339))
+		}
+	case "reactivate":
+		sessionId := flag.Arg(1)
+		err = common.ReopenAccount(ctx, store, sessionId)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to reactivate account: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("reactivated account of %s\n", sessionId)
+	default:
+		usage()
+		os.Exit(1)
+	}
+}
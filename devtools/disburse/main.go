@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/config"
+	"git.grassecon.net/urdt/ussd/initializers"
+	"git.grassecon.net/urdt/ussd/internal/disburse"
+	"git.grassecon.net/urdt/ussd/internal/encryption"
+	"git.grassecon.net/urdt/ussd/internal/storage"
+	"git.grassecon.net/urdt/ussd/remote"
+)
+
+func init() {
+	initializers.LoadEnvVariables()
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] <csv file>\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// Pays the rows of a CSV with the columns phone, amount and voucher from a program account.
+//
+// Every transfer is recorded in a progress file, so that the command can be run again after a crash
+// or a failure without paying anyone twice. A report of the outcome of every row is written as CSV.
+func main() {
+	config.LoadConfig()
+
+	var dbDir string
+	var database string
+	var keyringPath string
+	var from string
+	var progressPath string
+	var reportPath string
+	var rate float64
+	var dryRun bool
+	var retryFailed bool
+	flag.StringVar(&database, "db", "gdbm", "database to be used")
+	flag.StringVar(&dbDir, "dbdir", ".state", "database dir to read from")
+	flag.StringVar(&keyringPath, "keyring", config.KeyringPath, "keyring file")
+	flag.StringVar(&from, "from", "", "phone number or address of the account to pay from")
+	flag.StringVar(&progressPath, "progress", "", "progress file, defaults to the csv file with .progress appended")
+	flag.StringVar(&reportPath, "report", "", "report file, defaults to the csv file with .report.csv appended")
+	flag.Float64Var(&rate, "rate", 1, "most transfers per second")
+	flag.BoolVar(&dryRun, "dry-run", false, "validate the rows and the balance without paying")
+	flag.BoolVar(&retryFailed, "retry-failed", false, "retry transfers that failed in an earlier run")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 || from == "" || rate <= 0 {
+		usage()
+		os.Exit(1)
+	}
+	csvPath := flag.Arg(0)
+	if progressPath == "" {
+		progressPath = csvPath + ".progress"
+	}
+	if reportPath == "" {
+		reportPath = csvPath + ".report.csv"
+	}
+
+	f, err := os.Open(csvPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	rows, err := disburse.ReadRows(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", csvPath, err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, "Database", database)
+
+	keyring, err := encryption.LoadKeyring(keyringPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	menuStorageService := storage.NewMenuStorageService(dbDir, "")
+	userdataStore, err := menuStorageService.GetUserdataDb(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	defer userdataStore.Close()
+
+	var store common.DataStore = &common.UserDataStore{Db: userdataStore}
+	if keyring != nil {
+		store = encryption.NewDataStore(store, keyring)
+	}
+
+	progress, err := disburse.OpenProgress(progressPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open progress file: %v\n", err)
+		os.Exit(1)
+	}
+	defer progress.Close()
+
+	d := disburse.NewDisburser(store, &remote.AccountService{}, progress).
+		WithInterval(time.Duration(float64(time.Second) / rate)).
+		WithDryRun(dryRun).
+		WithRetryFailed(retryFailed)
+	results, runErr := d.Run(ctx, from, rows)
+
+	if results != nil {
+		w, err := os.Create(reportPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create report: %v\n", err)
+			os.Exit(1)
+		}
+		err = disburse.WriteReport(w, results)
+		w.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write report: %v\n", err)
+			os.Exit(1)
+		}
+		counts := make(map[string]int)
+		for _, r := range results {
+			counts[r.Status]++
+		}
+		fmt.Printf("%d rows: %d sent, %d failed, %d invalid, %d unknown, %d dry-run, %d not attempted\n", len(results), counts[disburse.StatusSent], counts[disburse.StatusFailed], counts[disburse.StatusInvalid], counts[disburse.StatusUnknown], counts[disburse.StatusDryRun], counts[""])
+		fmt.Printf("report written to %s\n", reportPath)
+	}
+	if runErr != nil {
+		fmt.Fprintf(os.Stderr, "disbursement stopped: %v\n", runErr)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"git.defalsify.org/vise.git/logging"
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/config"
+	"git.grassecon.net/urdt/ussd/initializers"
+	"git.grassecon.net/urdt/ussd/internal/encryption"
+	"git.grassecon.net/urdt/ussd/internal/storage"
+	"git.grassecon.net/urdt/ussd/internal/terms"
+)
+
+var (
+	logg = logging.NewVanilla()
+)
+
+func init() {
+	initializers.LoadEnvVariables()
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] rotate <key id>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [flags] reencrypt\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// readAccounts reads one session id per line from the given file, or from stdin if it is "-".
+func readAccounts(fp string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if fp != "-" {
+		f, err := os.Open(fp)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var sessionIds []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" {
+			continue
+		}
+		sessionIds = append(sessionIds, s)
+	}
+	return sessionIds, scanner.Err()
+}
+
+// Manages the keyring used to encrypt personal data at rest.
+//
+// The rotate command adds a new key to the keyring and makes it the current key. Running services
+// must be restarted to pick up the new key. The reencrypt command then rewrites the personal data and credentials
+// of each account with the current key, after which keys no longer in use may be removed from the
+// keyring file.
+//
+// As the store cannot be iterated, the accounts to re-encrypt are read from the -accounts file. If
+// it is not given, the accounts known to the terms acceptance index are used.
+func main() {
+	config.LoadConfig()
+
+	var dbDir string
+	var database string
+	var keyringPath string
+	var accountsPath string
+	flag.StringVar(&database, "db", "gdbm", "database to be used")
+	flag.StringVar(&dbDir, "dbdir", ".state", "database dir to read from")
+	flag.StringVar(&keyringPath, "keyring", config.KeyringPath, "keyring file")
+	flag.StringVar(&accountsPath, "accounts", "", "file with one session id per line to re-encrypt, - for stdin")
+	flag.Usage = usage
+	flag.Parse()
+
+	if keyringPath == "" {
+		fmt.Fprintf(os.Stderr, "no keyring file given\n")
+		os.Exit(1)
+	}
+
+	switch flag.Arg(0) {
+	case "rotate":
+		if flag.NArg() != 2 {
+			usage()
+			os.Exit(1)
+		}
+		rotate(keyringPath, flag.Arg(1))
+	case "reencrypt":
+		reencrypt(keyringPath, database, dbDir, accountsPath)
+	default:
+		usage()
+		os.Exit(1)
+	}
+}
+
+func rotate(keyringPath string, keyId string) {
+	var keyring *encryption.Keyring
+	_, err := os.Stat(keyringPath)
+	if os.IsNotExist(err) {
+		key, err := encryption.GenerateKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to generate key: %v\n", err)
+			os.Exit(1)
+		}
+		keyring, err = encryption.NewKeyring(keyId, map[string][]byte{keyId: key})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	} else {
+		keyring, err = encryption.LoadKeyring(keyringPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		err = keyring.Rotate(keyId)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to rotate keyring: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	err = keyring.Save(keyringPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write keyring: %v\n", err)
+		os.Exit(1)
+	}
+	logg.Infof("keyring rotated", "path", keyringPath, "current", keyId)
+}
+
+func reencrypt(keyringPath string, database string, dbDir string, accountsPath string) {
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, "Database", database)
+
+	keyring, err := encryption.LoadKeyring(keyringPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	menuStorageService := storage.NewMenuStorageService(dbDir, "")
+	userdataStore, err := menuStorageService.GetUserdataDb(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	defer userdataStore.Close()
+
+	store := &common.UserDataStore{Db: userdataStore}
+	var sessionIds []string
+	if accountsPath != "" {
+		sessionIds, err = readAccounts(accountsPath)
+	} else {
+		sessionIds, err = terms.NewTracker(store).Accounts(ctx)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to list accounts: %v\n", err)
+		os.Exit(1)
+	}
+
+	encryptedStore := encryption.NewDataStore(store, keyring)
+	var total int
+	var failed int
+	for _, sessionId := range sessionIds {
+		n, err := encryptedStore.Reencrypt(ctx, sessionId)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to re-encrypt account", "session", sessionId, "error", err)
+			failed++
+			continue
+		}
+		total += n
+	}
+	logg.Infof("re-encryption done", "current", keyring.Current(), "accounts", len(sessionIds), "entries", total, "failed", failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/config"
+	"git.grassecon.net/urdt/ussd/initializers"
+	"git.grassecon.net/urdt/ussd/internal/storage"
+)
+
+func init() {
+	initializers.LoadEnvVariables()
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] add|remove|list [till]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// Registers the merchants paid through the "Pay merchant" menu.
+//
+// The add command registers a merchant under a till number, or replaces the details of the
+// merchant already using it. The remove command frees a till number, and the list command
+// prints all merchants.
+func main() {
+	config.LoadConfig()
+
+	var dbDir string
+	var database string
+	var publicKey string
+	var name string
+	var phone string
+	var vouchers string
+	flag.StringVar(&database, "db", "gdbm", "database to be used")
+	flag.StringVar(&dbDir, "dbdir", ".state", "database dir to read from")
+	flag.StringVar(&publicKey, "key", "", "public key of the merchant account")
+	flag.StringVar(&name, "name", "", "name of the merchant shown to payers")
+	flag.StringVar(&phone, "phone", "", "phone number the payment receipts are sent to")
+	flag.StringVar(&vouchers, "vouchers", "", "comma separated symbols or contract addresses of the accepted vouchers")
+	flag.Usage = usage
+	flag.Parse()
+
+	cmd := flag.Arg(0)
+	if (cmd == "list" && flag.NArg() != 1) || ((cmd == "add" || cmd == "remove") && flag.NArg() != 2) {
+		usage()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, "Database", database)
+
+	menuStorageService := storage.NewMenuStorageService(dbDir, "")
+	userdataStore, err := menuStorageService.GetUserdataDb(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	defer userdataStore.Close()
+	store := &common.UserDataStore{Db: userdataStore}
+
+	switch cmd {
+	case "add":
+		m := common.Merchant{
+			Till:      flag.Arg(1),
+			PublicKey: publicKey,
+			Name:      name,
+			Phone:     phone,
+		}
+		for _, v := range strings.Split(vouchers, ",") {
+			v = strings.TrimSpace(v)
+			if v != "" {
+				m.Vouchers = append(m.Vouchers, v)
+			}
+		}
+		err = common.RegisterMerchant(ctx, store, m)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to register merchant: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("registered %s under till %s\n", m.Name, m.Till)
+	case "remove":
+		till := flag.Arg(1)
+		err = common.RemoveMerchant(ctx, store, till)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to remove merchant: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("removed merchant of till %s\n", till)
+	case "list":
+		merchants, err := common.Merchants(ctx, store)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to list merchants: %v\n", err)
+			os.Exit(1)
+		}
+		for _, m := range merchants {
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", m.Till, m.Name, m.PublicKey, m.Phone, strings.Join(m.Vouchers, ","))
+		}
+	default:
+		usage()
+		os.Exit(1)
+	}
+}
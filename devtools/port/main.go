@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/config"
+	"git.grassecon.net/urdt/ussd/initializers"
+	"git.grassecon.net/urdt/ussd/internal/encryption"
+	"git.grassecon.net/urdt/ussd/internal/storage"
+)
+
+func init() {
+	initializers.LoadEnvVariables()
+}
+
+// Moves an account to a new number on behalf of a user who has lost access to their number.
+//
+// The identity of the user must be verified before running it. A redirect to the new number is
+// left on the old one.
+func main() {
+	config.LoadConfig()
+
+	var dbDir string
+	var database string
+	var keyringPath string
+	var from string
+	var to string
+	flag.StringVar(&database, "db", "gdbm", "database to be used")
+	flag.StringVar(&dbDir, "dbdir", ".state", "database dir to read from")
+	flag.StringVar(&keyringPath, "keyring", config.KeyringPath, "keyring file")
+	flag.StringVar(&from, "from", "", "current number of the account")
+	flag.StringVar(&to, "to", "", "number to move the account to")
+	flag.Parse()
+
+	if from == "" || to == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, "Database", database)
+
+	keyring, err := encryption.LoadKeyring(keyringPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	menuStorageService := storage.NewMenuStorageService(dbDir, "")
+	userdataStore, err := menuStorageService.GetUserdataDb(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	defer userdataStore.Close()
+	stateStore, err := menuStorageService.GetStateStore(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	defer stateStore.Close()
+
+	var store common.DataStore = &common.UserDataStore{Db: userdataStore}
+	if keyring != nil {
+		store = encryption.NewDataStore(store, keyring)
+	}
+	err = common.PortAccount(ctx, store, stateStore, from, to)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to move account: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("moved account of %s to %s\n", from, to)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/config"
+	"git.grassecon.net/urdt/ussd/initializers"
+	"git.grassecon.net/urdt/ussd/internal/encryption"
+	"git.grassecon.net/urdt/ussd/internal/provision"
+	"git.grassecon.net/urdt/ussd/internal/storage"
+	"git.grassecon.net/urdt/ussd/remote"
+)
+
+func init() {
+	initializers.LoadEnvVariables()
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] <csv file>\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// Pre-registers the members listed in a CSV collected by field agents before launch.
+//
+// A custodial account is created for every member, with their profile details and an initial PIN they
+// have to change the first time they dial in. It is safe to run again on the same CSV. The report holds
+// the initial PINs and must be handled as a secret.
+func main() {
+	config.LoadConfig()
+
+	var dbDir string
+	var database string
+	var keyringPath string
+	var reportPath string
+	var language string
+	flag.StringVar(&database, "db", "gdbm", "database to be used")
+	flag.StringVar(&dbDir, "dbdir", ".state", "database dir to read from")
+	flag.StringVar(&keyringPath, "keyring", config.KeyringPath, "keyring file")
+	flag.StringVar(&reportPath, "report", "", "report file, defaults to the csv file with .report.csv appended")
+	flag.StringVar(&language, "language", "eng", "language of the members without one in the csv")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(1)
+	}
+	csvPath := flag.Arg(0)
+	if reportPath == "" {
+		reportPath = csvPath + ".report.csv"
+	}
+
+	f, err := os.Open(csvPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	members, err := provision.ReadMembers(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", csvPath, err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, "Database", database)
+
+	keyring, err := encryption.LoadKeyring(keyringPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	menuStorageService := storage.NewMenuStorageService(dbDir, "")
+	userdataStore, err := menuStorageService.GetUserdataDb(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	defer userdataStore.Close()
+
+	var store common.DataStore = &common.UserDataStore{Db: userdataStore}
+	if keyring != nil {
+		store = encryption.NewDataStore(store, keyring)
+	}
+	policy := common.NewPinPolicy(config.PinLength, config.PinHistory)
+	p := provision.NewProvisioner(store, &remote.AccountService{}, nil)
+
+	var results []provision.Result
+	var runErr error
+	counts := make(map[string]int)
+	for _, m := range members {
+		r, err := p.Preregister(ctx, m, policy, language)
+		if err != nil {
+			runErr = fmt.Errorf("line %d: %v", m.Line, err)
+			break
+		}
+		results = append(results, r)
+		counts[r.Status]++
+	}
+
+	w, err := os.OpenFile(reportPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create report: %v\n", err)
+		os.Exit(1)
+	}
+	err = provision.WriteResults(w, results)
+	w.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write report: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%d members: %d created, %d resumed, %d already registered, %d invalid, %d failed\n", len(members), counts[provision.ResultCreated], counts[provision.ResultResumed], counts[provision.ResultExists], counts[provision.ResultInvalid], counts[provision.ResultFailed])
+	fmt.Printf("report written to %s\n", reportPath)
+	if runErr != nil {
+		fmt.Fprintf(os.Stderr, "pre-registration stopped: %v\n", runErr)
+		os.Exit(1)
+	}
+}
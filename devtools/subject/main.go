@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/config"
+	"git.grassecon.net/urdt/ussd/initializers"
+	"git.grassecon.net/urdt/ussd/internal/encryption"
+	"git.grassecon.net/urdt/ussd/internal/storage"
+	"git.grassecon.net/urdt/ussd/internal/subject"
+)
+
+func init() {
+	initializers.LoadEnvVariables()
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] export|erase <session id>\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// Handles data subject requests for a session id (phone number).
+//
+// The export command prints everything held about the session id as JSON. The erase command
+// erases or anonymises it, and prints a JSON report of what was erased and what was kept.
+func main() {
+	config.LoadConfig()
+
+	var dbDir string
+	var database string
+	var keyringPath string
+	flag.StringVar(&database, "db", "gdbm", "database to be used")
+	flag.StringVar(&dbDir, "dbdir", ".state", "database dir to read from")
+	flag.StringVar(&keyringPath, "keyring", config.KeyringPath, "keyring file")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		usage()
+		os.Exit(1)
+	}
+	cmd := flag.Arg(0)
+	sessionId := flag.Arg(1)
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, "Database", database)
+
+	keyring, err := encryption.LoadKeyring(keyringPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	menuStorageService := storage.NewMenuStorageService(dbDir, "")
+	userdataStore, err := menuStorageService.GetUserdataDb(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	defer userdataStore.Close()
+	stateStore, err := menuStorageService.GetStateStore(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	defer stateStore.Close()
+
+	var store common.DataStore = &common.UserDataStore{Db: userdataStore}
+	if keyring != nil {
+		store = encryption.NewDataStore(store, keyring)
+	}
+	svc := subject.NewService(store, stateStore)
+
+	var r any
+	switch cmd {
+	case "export":
+		r, err = svc.Export(ctx, sessionId)
+	case "erase":
+		r, err = svc.Erase(ctx, sessionId)
+	default:
+		usage()
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %v\n", cmd, err)
+		os.Exit(1)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"git.defalsify.org/vise.git/logging"
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/config"
+	"git.grassecon.net/urdt/ussd/initializers"
+	"git.grassecon.net/urdt/ussd/internal/storage"
+	"git.grassecon.net/urdt/ussd/internal/terms"
+)
+
+var (
+	logg = logging.NewVanilla()
+)
+
+func init() {
+	initializers.LoadEnvVariables()
+}
+
+// Lists the accounts that have not accepted the current version of the terms and conditions.
+//
+// Only accounts that have accepted some version of the terms, or have dialed in since acceptances
+// were first recorded, are known.
+func main() {
+	config.LoadConfig()
+
+	var dbDir string
+	var database string
+	var termsPath string
+	var version string
+	flag.StringVar(&database, "db", "gdbm", "database to be used")
+	flag.StringVar(&dbDir, "dbdir", ".state", "database dir to read from")
+	flag.StringVar(&termsPath, "terms", config.TermsPath, "terms registry file")
+	flag.StringVar(&version, "version", "", "version to check against, defaults to the current version in the registry")
+	flag.Parse()
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, "Database", database)
+
+	if version == "" {
+		registry, err := terms.LoadRegistry(termsPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		version = registry.Current
+	}
+
+	menuStorageService := storage.NewMenuStorageService(dbDir, "")
+	userdataStore, err := menuStorageService.GetUserdataDb(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	defer userdataStore.Close()
+
+	tracker := terms.NewTracker(&common.UserDataStore{Db: userdataStore})
+	pending, latest, err := tracker.Pending(ctx, version)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to list pending accounts: %v\n", err)
+		os.Exit(1)
+	}
+	logg.Infof("terms acceptance", "version", version, "pending", len(pending))
+
+	for i, sessionId := range pending {
+		a := latest[i]
+		if a.Version == "" {
+			fmt.Printf("%s\t-\t-\t-\n", sessionId)
+			continue
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", sessionId, a.Version, a.Time.UTC().Format(time.RFC3339), a.Channel)
+	}
+}
@@ -0,0 +1,152 @@
+package balance
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/logging"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/config"
+	"git.grassecon.net/urdt/ussd/remote"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("balance")
+)
+
+const (
+	// DefaultTtl is how long a refreshed balance is used when no TTL is configured.
+	DefaultTtl = time.Minute
+)
+
+// Balance is the balance of the active voucher of a user.
+type Balance struct {
+	// Amount is the balance in whole tokens, as kept in DATA_ACTIVE_BAL.
+	Amount string
+	// UpdatedAt is when the balance was last refreshed from the data indexer, and the zero time if it never was.
+	UpdatedAt time.Time
+	// Stale is set when the refresh failed and the cached balance is returned instead.
+	Stale bool
+}
+
+// Service keeps the balance of the active voucher of each user fresh, by refreshing it from the data indexer
+// once it is older than the TTL.
+type Service struct {
+	store          common.DataStore
+	accountService remote.AccountServiceInterface
+	ttl            time.Duration
+}
+
+// NewService creates a new Service refreshing balances older than ttl.
+func NewService(store common.DataStore, accountService remote.AccountServiceInterface, ttl time.Duration) *Service {
+	return &Service{
+		store:          store,
+		accountService: accountService,
+		ttl:            ttl,
+	}
+}
+
+// NewDefaultService creates a new Service with the configured TTL.
+func NewDefaultService(store common.DataStore, accountService remote.AccountServiceInterface) *Service {
+	ttl := DefaultTtl
+	if config.BalanceTtl > 0 {
+		ttl = time.Duration(config.BalanceTtl) * time.Second
+	}
+	return NewService(store, accountService, ttl)
+}
+
+// Get returns the balance of the active voucher of the session id, refreshing it first if it is older than the TTL.
+//
+// If the data indexer cannot be reached, the cached balance is returned and marked as stale.
+func (s *Service) Get(ctx context.Context, sessionId string) (Balance, error) {
+	var b Balance
+
+	v, err := s.store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_BAL)
+	if err != nil {
+		return b, err
+	}
+	b.Amount = string(v)
+	b.UpdatedAt, err = s.readUpdated(ctx, sessionId)
+	if err != nil {
+		return b, err
+	}
+	if !b.UpdatedAt.IsZero() && time.Since(b.UpdatedAt) < s.ttl {
+		return b, nil
+	}
+
+	r, err := s.Refresh(ctx, sessionId)
+	if err != nil {
+		logg.WarnCtxf(ctx, "balance refresh failed, using cached balance", "session", sessionId, "updated", b.UpdatedAt, "error", err)
+		b.Stale = true
+		return b, nil
+	}
+	return r, nil
+}
+
+// Refresh fetches the balance of the active voucher of the session id from the data indexer and stores it.
+//
+// A voucher the indexer no longer lists for the account has a balance of zero.
+func (s *Service) Refresh(ctx context.Context, sessionId string) (Balance, error) {
+	var b Balance
+
+	publicKey, err := s.store.ReadEntry(ctx, sessionId, common.DATA_PUBLIC_KEY)
+	if err != nil {
+		return b, err
+	}
+	activeAddress, err := s.store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_ADDRESS)
+	if err != nil {
+		return b, err
+	}
+	holdings, err := s.accountService.FetchVouchers(ctx, string(publicKey))
+	if err != nil {
+		return b, err
+	}
+
+	b.Amount = "0"
+	for _, h := range holdings {
+		if h.ContractAddress == string(activeAddress) {
+			b.Amount = common.ScaleDownBalance(h.Balance, h.TokenDecimals)
+			break
+		}
+	}
+	b.UpdatedAt = time.Now()
+
+	err = s.store.WriteEntry(ctx, sessionId, common.DATA_ACTIVE_BAL, []byte(b.Amount))
+	if err != nil {
+		return b, err
+	}
+	err = s.store.WriteEntry(ctx, sessionId, common.DATA_ACTIVE_BAL_UPDATED, []byte(strconv.FormatInt(b.UpdatedAt.Unix(), 10)))
+	if err != nil {
+		return b, err
+	}
+	return b, nil
+}
+
+// Invalidate marks the balance of the session id as outdated, so that it is refreshed the next time it is read.
+//
+// It is meant to be called after transfers from the account.
+func (s *Service) Invalidate(ctx context.Context, sessionId string) error {
+	return s.store.WriteEntry(ctx, sessionId, common.DATA_ACTIVE_BAL_UPDATED, []byte{})
+}
+
+func (s *Service) readUpdated(ctx context.Context, sessionId string) (time.Time, error) {
+	v, err := s.store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_BAL_UPDATED)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	if len(v) == 0 {
+		return time.Time{}, nil
+	}
+	ts, err := strconv.ParseInt(string(v), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid balance timestamp: %v", err)
+	}
+	return time.Unix(ts, 0), nil
+}
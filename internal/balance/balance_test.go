@@ -0,0 +1,99 @@
+package balance
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	dataserviceapi "github.com/grassrootseconomics/ussd-data-service/pkg/api"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/testutil/mocks"
+	"git.grassecon.net/urdt/ussd/internal/testutil/teststore"
+)
+
+func writeActiveVoucher(t *testing.T, ctx context.Context, store common.DataStore, sessionId string) {
+	entries := map[common.DataTyp]string{
+		common.DATA_PUBLIC_KEY:     "0X13242618721",
+		common.DATA_ACTIVE_ADDRESS: "0xd4c288865Ce",
+		common.DATA_ACTIVE_BAL:     "10",
+	}
+	for k, v := range entries {
+		err := store.WriteEntry(ctx, sessionId, k, []byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGetRefreshes(t *testing.T) {
+	sessionId := "+254712345678"
+	ctx, store := teststore.InitializeTestStore(t)
+	writeActiveVoucher(t, ctx, store, sessionId)
+
+	mockAccountService := new(mocks.MockAccountService)
+	mockAccountService.On("FetchVouchers", "0X13242618721").Return([]dataserviceapi.TokenHoldings{
+		{ContractAddress: "0x41c188d63Qa", TokenSymbol: "MILO", TokenDecimals: "6", Balance: "100"},
+		{ContractAddress: "0xd4c288865Ce", TokenSymbol: "SRF", TokenDecimals: "6", Balance: "4500000"},
+	}, nil)
+
+	s := NewService(store, mockAccountService, time.Minute)
+	b, err := s.Get(ctx, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, "4.5", b.Amount)
+	assert.False(t, b.Stale)
+	assert.False(t, b.UpdatedAt.IsZero())
+
+	v, err := store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_BAL)
+	assert.NoError(t, err)
+	assert.Equal(t, "4.5", string(v))
+
+	// a fresh balance is not fetched again until it is invalidated
+	_, err = s.Get(ctx, sessionId)
+	assert.NoError(t, err)
+	mockAccountService.AssertNumberOfCalls(t, "FetchVouchers", 1)
+
+	err = s.Invalidate(ctx, sessionId)
+	assert.NoError(t, err)
+	_, err = s.Get(ctx, sessionId)
+	assert.NoError(t, err)
+	mockAccountService.AssertNumberOfCalls(t, "FetchVouchers", 2)
+}
+
+func TestGetMissingVoucher(t *testing.T) {
+	sessionId := "+254712345678"
+	ctx, store := teststore.InitializeTestStore(t)
+	writeActiveVoucher(t, ctx, store, sessionId)
+
+	mockAccountService := new(mocks.MockAccountService)
+	mockAccountService.On("FetchVouchers", "0X13242618721").Return([]dataserviceapi.TokenHoldings{}, nil)
+
+	s := NewService(store, mockAccountService, time.Minute)
+	b, err := s.Get(ctx, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, "0", b.Amount)
+}
+
+func TestGetFallsBackToCache(t *testing.T) {
+	sessionId := "+254712345678"
+	ctx, store := teststore.InitializeTestStore(t)
+	writeActiveVoucher(t, ctx, store, sessionId)
+	updatedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	err := store.WriteEntry(ctx, sessionId, common.DATA_ACTIVE_BAL_UPDATED, []byte(strconv.FormatInt(updatedAt.Unix(), 10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockAccountService := new(mocks.MockAccountService)
+	mockAccountService.On("FetchVouchers", "0X13242618721").Return([]dataserviceapi.TokenHoldings{}, errors.New("indexer unavailable"))
+
+	s := NewService(store, mockAccountService, time.Minute)
+	b, err := s.Get(ctx, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, "10", b.Amount)
+	assert.True(t, b.Stale)
+	assert.True(t, updatedAt.Equal(b.UpdatedAt))
+}
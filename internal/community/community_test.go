@@ -0,0 +1,69 @@
+package community
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	dataserviceapi "github.com/grassrootseconomics/ussd-data-service/pkg/api"
+)
+
+func TestLoadRegistry(t *testing.T) {
+	r, err := LoadRegistry("")
+	assert.NoError(t, err)
+	_, ok := r.ForManager("+254712345678")
+	assert.False(t, ok)
+
+	fp := path.Join(t.TempDir(), "communities.json")
+	err = os.WriteFile(fp, []byte(`{
+		"communities": [
+			{
+				"name": "Mama Mboga",
+				"address": "0xC0mmun1ty",
+				"vouchers": ["0xd4c288865Ce"],
+				"managers": ["+254712345678"]
+			}
+		]
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = LoadRegistry(fp)
+	assert.NoError(t, err)
+	c, ok := r.ForManager("+254712345678")
+	assert.True(t, ok)
+	assert.Equal(t, "0xC0mmun1ty", c.Address)
+	_, ok = r.ForManager("+254700000000")
+	assert.False(t, ok)
+}
+
+func TestLoadRegistryInvalid(t *testing.T) {
+	fp := path.Join(t.TempDir(), "communities.json")
+	err := os.WriteFile(fp, []byte(`{
+		"communities": [
+			{"name": "a", "address": "0xA", "managers": ["+254712345678"]},
+			{"name": "b", "address": "0xB", "managers": ["+254712345678"]}
+		]
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadRegistry(fp)
+	assert.Error(t, err)
+}
+
+func TestPooled(t *testing.T) {
+	c := Community{
+		Address:  "0xC0mmun1ty",
+		Vouchers: []string{"0xD4C288865CE"},
+	}
+	holdings := []dataserviceapi.TokenHoldings{
+		{ContractAddress: "0xd4c288865Ce", TokenSymbol: "SRF"},
+		{ContractAddress: "0x41c188d63Qa", TokenSymbol: "MILO"},
+	}
+	assert.Equal(t, []dataserviceapi.TokenHoldings{holdings[0]}, c.Pooled(holdings))
+
+	c.Vouchers = nil
+	assert.Equal(t, holdings, c.Pooled(holdings))
+}
@@ -0,0 +1,150 @@
+package community
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"git.defalsify.org/vise.git/logging"
+	dataserviceapi "github.com/grassrootseconomics/ussd-data-service/pkg/api"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("community")
+)
+
+// Community is a community account and the vouchers it issues.
+type Community struct {
+	Name string `json:"name"`
+	// Address is the address of the community account holding the pooled balance.
+	Address string `json:"address"`
+	// Vouchers are the contract addresses of the vouchers issued by the community.
+	Vouchers []string `json:"vouchers"`
+	// Managers are the phone numbers of the members allowed to see the community balance.
+	Managers []string `json:"managers"`
+}
+
+// Issues checks whether the voucher with the contract address is issued by the community.
+func (c Community) Issues(address string) bool {
+	for _, v := range c.Vouchers {
+		if strings.EqualFold(v, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pooled returns the holdings of the community account in the vouchers issued by the community.
+//
+// All holdings are returned if the community lists no vouchers.
+func (c Community) Pooled(holdings []dataserviceapi.TokenHoldings) []dataserviceapi.TokenHoldings {
+	if len(c.Vouchers) == 0 {
+		return holdings
+	}
+	var r []dataserviceapi.TokenHoldings
+	for _, h := range holdings {
+		if c.Issues(h.ContractAddress) {
+			r = append(r, h)
+		}
+	}
+	return r
+}
+
+// Recent returns up to n transfers of the community account in the vouchers issued by the community.
+func (c Community) Recent(transfers []dataserviceapi.Last10TxResponse, n int) []dataserviceapi.Last10TxResponse {
+	var r []dataserviceapi.Last10TxResponse
+	for _, t := range transfers {
+		if len(r) == n {
+			break
+		}
+		if len(c.Vouchers) == 0 || c.Issues(t.ContractAddress) {
+			r = append(r, t)
+		}
+	}
+	return r
+}
+
+// Outgoing checks whether the transfer was sent from the community account.
+func (c Community) Outgoing(t dataserviceapi.Last10TxResponse) bool {
+	return strings.EqualFold(t.Sender, c.Address)
+}
+
+// Registry holds the known community accounts.
+type Registry struct {
+	Communities []Community `json:"communities"`
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// LoadRegistry reads the registry from a JSON file, or from the data service if src is an http(s) URL.
+//
+// If src is empty, the registry returned by NewRegistry is used.
+func LoadRegistry(src string) (*Registry, error) {
+	if src == "" {
+		return NewRegistry(), nil
+	}
+	b, err := read(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read community registry: %v", err)
+	}
+	var r Registry
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse community registry %s: %v", src, err)
+	}
+	err = r.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid community registry %s: %v", src, err)
+	}
+	logg.Infof("loaded community registry", "source", src, "communities", len(r.Communities))
+	return &r, nil
+}
+
+func read(src string) ([]byte, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return os.ReadFile(src)
+	}
+	rs, err := http.Get(src)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Body.Close()
+	if rs.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", rs.Status)
+	}
+	return io.ReadAll(rs.Body)
+}
+
+func (r *Registry) validate() error {
+	managers := make(map[string]string)
+	for _, c := range r.Communities {
+		if c.Address == "" {
+			return fmt.Errorf("community %s without address", c.Name)
+		}
+		for _, m := range c.Managers {
+			if v, ok := managers[m]; ok && v != c.Address {
+				return fmt.Errorf("%s manages more than one community", m)
+			}
+			managers[m] = c.Address
+		}
+	}
+	return nil
+}
+
+// ForManager returns the community managed by the phone number, if any.
+func (r *Registry) ForManager(msisdn string) (Community, bool) {
+	for _, c := range r.Communities {
+		for _, m := range c.Managers {
+			if m == msisdn {
+				return c, true
+			}
+		}
+	}
+	return Community{}, false
+}
@@ -0,0 +1,338 @@
+package disburse
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/logging"
+	dataserviceapi "github.com/grassrootseconomics/ussd-data-service/pkg/api"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/remote"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("disburse")
+)
+
+const (
+	// DefaultInterval is the time waited between transfers when no rate is given.
+	DefaultInterval = time.Second
+)
+
+const (
+	// StatusSent is set when the API accepted the transfer, in this run or an earlier one.
+	StatusSent = "sent"
+	// StatusFailed is set when the API rejected the transfer.
+	StatusFailed = "failed"
+	// StatusInvalid is set when the row cannot be paid, like an unregistered phone number.
+	StatusInvalid = "invalid"
+	// StatusUnknown is set when an earlier run stopped after requesting the transfer but before recording the answer.
+	// The transfer is not repeated; whether it was made has to be checked by hand.
+	StatusUnknown = "unknown"
+	// StatusDryRun is set in a dry run for the rows that would be paid.
+	StatusDryRun = "dry-run"
+)
+
+var (
+	ErrInsufficientBalance = errors.New("insufficient balance")
+)
+
+// Row is a payment read from the disbursement CSV.
+type Row struct {
+	// Line is the line of the row in the CSV.
+	Line  int
+	Phone string
+	// Amount is the amount in whole tokens.
+	Amount string
+	// Voucher is the symbol or contract address of the voucher to pay with.
+	Voucher string
+}
+
+// Result is the outcome of paying a row.
+type Result struct {
+	Row
+	Status     string
+	TrackingId string
+	// Detail explains a status other than sent.
+	Detail string
+}
+
+// ReadRows reads the rows of a disbursement CSV with the columns phone, amount and voucher.
+//
+// A header row starting with "phone" is skipped.
+func ReadRows(r io.Reader) ([]Row, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 3
+	cr.TrimLeadingSpace = true
+	var rows []Row
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		if len(rows) == 0 && line == 1 && strings.EqualFold(strings.TrimSpace(rec[0]), "phone") {
+			continue
+		}
+		rows = append(rows, Row{
+			Line:    line,
+			Phone:   strings.TrimSpace(rec[0]),
+			Amount:  strings.TrimSpace(rec[1]),
+			Voucher: strings.TrimSpace(rec[2]),
+		})
+	}
+	return rows, nil
+}
+
+// WriteReport writes the results as CSV.
+func WriteReport(w io.Writer, results []Result) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"line", "phone", "amount", "voucher", "status", "tracking_id", "detail"})
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		err = cw.Write([]string{strconv.Itoa(r.Line), r.Phone, r.Amount, r.Voucher, r.Status, r.TrackingId, r.Detail})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// payment is a row that is ready to be paid.
+type payment struct {
+	index   int
+	key     string
+	to      string
+	amount  common.Amount
+	voucher dataserviceapi.TokenHoldings
+}
+
+// Disburser pays the rows of a disbursement from a single sender account.
+type Disburser struct {
+	store          common.DataStore
+	accountService remote.AccountServiceInterface
+	progress       *Progress
+	interval       time.Duration
+	dryRun         bool
+	retryFailed    bool
+}
+
+// NewDisburser creates a new Disburser recording its transfers in progress.
+func NewDisburser(store common.DataStore, accountService remote.AccountServiceInterface, progress *Progress) *Disburser {
+	return &Disburser{
+		store:          store,
+		accountService: accountService,
+		progress:       progress,
+		interval:       DefaultInterval,
+	}
+}
+
+// WithInterval sets the time waited between transfers, to keep within the rate limit of the API.
+func (d *Disburser) WithInterval(interval time.Duration) *Disburser {
+	d.interval = interval
+	return d
+}
+
+// WithDryRun makes Run validate the rows and the balance without making any transfer.
+func (d *Disburser) WithDryRun(dryRun bool) *Disburser {
+	d.dryRun = dryRun
+	return d
+}
+
+// WithRetryFailed makes Run retry the transfers the API rejected in an earlier run.
+//
+// It is off by default, as the API may have made a transfer it reported as failed.
+func (d *Disburser) WithRetryFailed(retryFailed bool) *Disburser {
+	d.retryFailed = retryFailed
+	return d
+}
+
+// Run pays the rows from the account of the sender, which is a phone number or an address.
+//
+// All rows are validated and the balance of the sender is checked before any transfer is made. If the balance
+// does not cover the rows still to be paid, nothing is paid and ErrInsufficientBalance is returned.
+// Rows paid in an earlier run with the same progress are not paid again.
+func (d *Disburser) Run(ctx context.Context, sender string, rows []Row) ([]Result, error) {
+	from := sender
+	if !strings.HasPrefix(sender, "0x") {
+		var reason string
+		var err error
+		from, reason, err = d.resolve(ctx, sender)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			return nil, fmt.Errorf("sender %s: %s", sender, reason)
+		}
+	}
+	holdings, err := d.accountService.FetchVouchers(ctx, from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the vouchers of the sender: %v", err)
+	}
+
+	results := make([]Result, len(rows))
+	var payments []payment
+	seen := make(map[string]int)
+	totals := make(map[string]common.Amount)
+	for i, row := range rows {
+		results[i].Row = row
+
+		// the same payment may be listed more than once, each is paid
+		k := strings.Join([]string{row.Phone, strings.ToLower(row.Voucher), row.Amount}, "|")
+		seen[k]++
+		key := fmt.Sprintf("%s|%d", k, seen[k])
+
+		e, ok := d.progress.get(key)
+		if ok {
+			switch e.state {
+			case progressSent:
+				results[i].Status = StatusSent
+				results[i].TrackingId = e.detail
+				continue
+			case progressPending:
+				results[i].Status = StatusUnknown
+				results[i].Detail = "an earlier run stopped before the transfer was confirmed, check it before paying again"
+				continue
+			case progressFailed:
+				if !d.retryFailed {
+					results[i].Status = StatusFailed
+					results[i].Detail = e.detail
+					continue
+				}
+			}
+		}
+
+		voucher, ok := findVoucher(holdings, row.Voucher)
+		if !ok {
+			results[i].Status = StatusInvalid
+			results[i].Detail = fmt.Sprintf("the sender does not hold %s", row.Voucher)
+			continue
+		}
+		amount, err := common.ParseTransferAmount(row.Amount, voucher.TokenDecimals)
+		if err != nil {
+			results[i].Status = StatusInvalid
+			results[i].Detail = err.Error()
+			continue
+		}
+		to, reason, err := d.resolve(ctx, row.Phone)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			results[i].Status = StatusInvalid
+			results[i].Detail = reason
+			continue
+		}
+		if strings.EqualFold(to, from) {
+			results[i].Status = StatusInvalid
+			results[i].Detail = "the recipient is the sender"
+			continue
+		}
+
+		payments = append(payments, payment{
+			index:   i,
+			key:     key,
+			to:      to,
+			amount:  amount,
+			voucher: voucher,
+		})
+		totals[voucher.ContractAddress] = totals[voucher.ContractAddress].Add(amount)
+	}
+
+	for _, h := range holdings {
+		total, ok := totals[h.ContractAddress]
+		if !ok {
+			continue
+		}
+		balance, err := common.NewAmount(h.Balance, h.TokenDecimals)
+		if err != nil {
+			return nil, err
+		}
+		if total.Cmp(balance) > 0 {
+			return results, fmt.Errorf("%w: %s %s is needed but the sender holds %s %s", ErrInsufficientBalance, total, h.TokenSymbol, balance, h.TokenSymbol)
+		}
+	}
+
+	for n, p := range payments {
+		if d.dryRun {
+			results[p.index].Status = StatusDryRun
+			continue
+		}
+		if n > 0 {
+			select {
+			case <-ctx.Done():
+				return results, ctx.Err()
+			case <-time.After(d.interval):
+			}
+		}
+
+		err = d.progress.record(p.key, progressPending, "")
+		if err != nil {
+			return results, fmt.Errorf("failed to record progress: %v", err)
+		}
+		r, err := d.accountService.TokenTransfer(ctx, p.amount.Raw(), from, p.to, p.voucher.ContractAddress)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed on TokenTransfer", "line", rows[p.index].Line, "to", p.to, "error", err)
+			results[p.index].Status = StatusFailed
+			results[p.index].Detail = err.Error()
+			err = d.progress.record(p.key, progressFailed, err.Error())
+		} else {
+			logg.InfoCtxf(ctx, "TokenTransfer", "line", rows[p.index].Line, "to", p.to, "trackingId", r.TrackingId)
+			results[p.index].Status = StatusSent
+			results[p.index].TrackingId = r.TrackingId
+			err = d.progress.record(p.key, progressSent, r.TrackingId)
+		}
+		if err != nil {
+			return results, fmt.Errorf("failed to record progress: %v", err)
+		}
+	}
+	return results, nil
+}
+
+// resolve returns the address of the account held by the phone number.
+// If the account cannot be paid, the returned string explains why.
+func (d *Disburser) resolve(ctx context.Context, phone string) (string, string, error) {
+	holder, err := common.ResolvePorted(ctx, d.store, phone)
+	if err != nil {
+		return "", "", err
+	}
+	_, closed, err := common.ReadClosure(ctx, d.store, holder)
+	if err != nil {
+		return "", "", err
+	}
+	if closed {
+		return "", "the account is closed", nil
+	}
+	publicKey, err := d.store.ReadEntry(ctx, holder, common.DATA_PUBLIC_KEY)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return "", "the number is not registered", nil
+		}
+		return "", "", err
+	}
+	return string(publicKey), "", nil
+}
+
+// findVoucher returns the holding of the voucher with the symbol or contract address.
+func findVoucher(holdings []dataserviceapi.TokenHoldings, voucher string) (dataserviceapi.TokenHoldings, bool) {
+	for _, h := range holdings {
+		if strings.EqualFold(h.TokenSymbol, voucher) || strings.EqualFold(h.ContractAddress, voucher) {
+			return h, true
+		}
+	}
+	return dataserviceapi.TokenHoldings{}, false
+}
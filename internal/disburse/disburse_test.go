@@ -0,0 +1,162 @@
+package disburse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	dataserviceapi "github.com/grassrootseconomics/ussd-data-service/pkg/api"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/testutil/mocks"
+	"git.grassecon.net/urdt/ussd/internal/testutil/teststore"
+	"git.grassecon.net/urdt/ussd/models"
+)
+
+const (
+	senderKey = "0x1000000000000000000000000000000000000001"
+)
+
+var (
+	holdings = []dataserviceapi.TokenHoldings{
+		{ContractAddress: "0xd4c288865Ce", TokenSymbol: "SRF", TokenDecimals: "6", Balance: "10000000"},
+	}
+)
+
+func openProgress(t *testing.T, p string) *Progress {
+	progress, err := OpenProgress(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		progress.Close()
+	})
+	return progress
+}
+
+func writeAccounts(t *testing.T, ctx context.Context, store common.DataStore) {
+	accounts := map[string]string{
+		"+254700000000": senderKey,
+		"+254711111111": "0x2000000000000000000000000000000000000002",
+		"+254722222222": "0x3000000000000000000000000000000000000003",
+	}
+	for k, v := range accounts {
+		err := store.WriteEntry(ctx, k, common.DATA_PUBLIC_KEY, []byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadRows(t *testing.T) {
+	rows, err := ReadRows(strings.NewReader("phone,amount,voucher\n+254711111111, 2.5,SRF\n\n+254722222222,1,0xd4c288865Ce\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Row{
+		{Line: 2, Phone: "+254711111111", Amount: "2.5", Voucher: "SRF"},
+		{Line: 4, Phone: "+254722222222", Amount: "1", Voucher: "0xd4c288865Ce"},
+	}, rows)
+
+	_, err = ReadRows(strings.NewReader("+254711111111,2.5\n"))
+	assert.Error(t, err)
+}
+
+func TestRun(t *testing.T) {
+	ctx, store := teststore.InitializeTestStore(t)
+	writeAccounts(t, ctx, store)
+	progressPath := path.Join(t.TempDir(), "progress")
+	rows := []Row{
+		{Line: 1, Phone: "+254711111111", Amount: "2", Voucher: "SRF"},
+		{Line: 2, Phone: "+254733333333", Amount: "1", Voucher: "SRF"},
+		{Line: 3, Phone: "+254722222222", Amount: "1.5", Voucher: "srf"},
+		{Line: 4, Phone: "+254711111111", Amount: "1", Voucher: "MILO"},
+		{Line: 5, Phone: "+254711111111", Amount: "2", Voucher: "SRF"},
+	}
+
+	mockAccountService := new(mocks.MockAccountService)
+	mockAccountService.On("FetchVouchers", senderKey).Return(holdings, nil)
+	mockAccountService.On("TokenTransfer").Return(&models.TokenTransferResponse{TrackingId: "1"}, nil).Once()
+	mockAccountService.On("TokenTransfer").Return((*models.TokenTransferResponse)(nil), errors.New("transfer failed")).Once()
+	mockAccountService.On("TokenTransfer").Return(&models.TokenTransferResponse{TrackingId: "3"}, nil).Once()
+
+	d := NewDisburser(store, mockAccountService, openProgress(t, progressPath)).WithInterval(0)
+	results, err := d.Run(ctx, "+254700000000", rows)
+	assert.NoError(t, err)
+	var statuses []string
+	for _, r := range results {
+		statuses = append(statuses, r.Status)
+	}
+	assert.Equal(t, []string{StatusSent, StatusInvalid, StatusFailed, StatusInvalid, StatusSent}, statuses)
+	assert.Equal(t, "3", results[4].TrackingId)
+
+	var b bytes.Buffer
+	err = WriteReport(&b, results)
+	assert.NoError(t, err)
+	assert.Contains(t, b.String(), "2,+254733333333,1,SRF,invalid,,the number is not registered\n")
+
+	// a rerun pays nothing twice, and does not retry failures unless asked to
+	rerunService := new(mocks.MockAccountService)
+	rerunService.On("FetchVouchers", senderKey).Return(holdings, nil)
+	rerunService.On("TokenTransfer").Return(&models.TokenTransferResponse{TrackingId: "4"}, nil)
+	d = NewDisburser(store, rerunService, openProgress(t, progressPath)).WithInterval(0)
+	results, err = d.Run(ctx, senderKey, rows)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusFailed, results[2].Status)
+	assert.Equal(t, "1", results[0].TrackingId)
+	rerunService.AssertNumberOfCalls(t, "TokenTransfer", 0)
+
+	d = NewDisburser(store, rerunService, openProgress(t, progressPath)).WithInterval(0).WithRetryFailed(true)
+	results, err = d.Run(ctx, senderKey, rows)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusSent, results[2].Status)
+	assert.Equal(t, "4", results[2].TrackingId)
+	rerunService.AssertNumberOfCalls(t, "TokenTransfer", 1)
+}
+
+func TestRunInterrupted(t *testing.T) {
+	ctx, store := teststore.InitializeTestStore(t)
+	writeAccounts(t, ctx, store)
+	progressPath := path.Join(t.TempDir(), "progress")
+	rows := []Row{
+		{Line: 1, Phone: "+254711111111", Amount: "2", Voucher: "SRF"},
+	}
+
+	// the transfer was requested, but the run stopped before the answer was recorded
+	progress := openProgress(t, progressPath)
+	err := progress.record("+254711111111|srf|2|1", progressPending, "")
+	assert.NoError(t, err)
+
+	mockAccountService := new(mocks.MockAccountService)
+	mockAccountService.On("FetchVouchers", senderKey).Return(holdings, nil)
+	d := NewDisburser(store, mockAccountService, openProgress(t, progressPath)).WithInterval(0).WithRetryFailed(true)
+	results, err := d.Run(ctx, senderKey, rows)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusUnknown, results[0].Status)
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 0)
+}
+
+func TestRunInsufficientBalance(t *testing.T) {
+	ctx, store := teststore.InitializeTestStore(t)
+	writeAccounts(t, ctx, store)
+	rows := []Row{
+		{Line: 1, Phone: "+254711111111", Amount: "6", Voucher: "SRF"},
+		{Line: 2, Phone: "+254722222222", Amount: "4.000001", Voucher: "SRF"},
+	}
+
+	mockAccountService := new(mocks.MockAccountService)
+	mockAccountService.On("FetchVouchers", senderKey).Return(holdings, nil)
+	d := NewDisburser(store, mockAccountService, openProgress(t, path.Join(t.TempDir(), "progress"))).WithInterval(0)
+	_, err := d.Run(ctx, senderKey, rows)
+	assert.True(t, errors.Is(err, ErrInsufficientBalance))
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 0)
+
+	rows[1].Amount = "4"
+	results, err := d.WithDryRun(true).Run(ctx, senderKey, rows)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusDryRun, results[0].Status)
+	assert.Equal(t, StatusDryRun, results[1].Status)
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 0)
+}
@@ -0,0 +1,92 @@
+package disburse
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// progressPending is recorded before a transfer is requested.
+	progressPending = "pending"
+	// progressSent is recorded once the API has accepted a transfer.
+	progressSent = "sent"
+	// progressFailed is recorded when the API rejected a transfer.
+	progressFailed = "failed"
+)
+
+type progressEntry struct {
+	state  string
+	detail string
+}
+
+// Progress is the append-only record of the transfers of a disbursement, used to resume it safely.
+//
+// A transfer is recorded as pending before it is requested, and as sent or failed once the API has answered.
+// Every record is synced to disk before the next step, so a transfer left pending by a crash is never repeated blindly.
+type Progress struct {
+	mu      sync.Mutex
+	f       *os.File
+	entries map[string]progressEntry
+}
+
+// OpenProgress reads the progress file at path, creating it if it does not exist.
+func OpenProgress(path string) (*Progress, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	p := &Progress{
+		f:       f,
+		entries: make(map[string]progressEntry),
+	}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, "\t", 3)
+		if len(parts) != 3 {
+			f.Close()
+			return nil, fmt.Errorf("invalid progress entry: %q", line)
+		}
+		p.entries[parts[1]] = progressEntry{state: parts[0], detail: parts[2]}
+	}
+	err = sc.Err()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Close closes the progress file.
+func (p *Progress) Close() error {
+	return p.f.Close()
+}
+
+func (p *Progress) get(key string) (progressEntry, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.entries[key]
+	return e, ok
+}
+
+func (p *Progress) record(key string, state string, detail string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	detail = strings.NewReplacer("\t", " ", "\n", " ").Replace(detail)
+	_, err := fmt.Fprintf(p.f, "%s\t%s\t%s\n", state, key, detail)
+	if err != nil {
+		return err
+	}
+	err = p.f.Sync()
+	if err != nil {
+		return err
+	}
+	p.entries[key] = progressEntry{state: state, detail: detail}
+	return nil
+}
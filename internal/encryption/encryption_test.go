@@ -0,0 +1,150 @@
+package encryption
+
+import (
+	"bytes"
+	"path"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/testutil/teststore"
+)
+
+func newTestKeyring(t *testing.T, id string) *Keyring {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := NewKeyring(id, map[string][]byte{id: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestLoadKeyring(t *testing.T) {
+	k, err := LoadKeyring("")
+	assert.NoError(t, err)
+	assert.Zero(t, k)
+
+	k = newTestKeyring(t, "k1")
+	err = k.Rotate("k2")
+	assert.NoError(t, err)
+	err = k.Rotate("k1")
+	assert.Error(t, err)
+
+	fp := path.Join(t.TempDir(), "keyring.json")
+	err = k.Save(fp)
+	assert.NoError(t, err)
+	loaded, err := LoadKeyring(fp)
+	assert.NoError(t, err)
+	assert.Equal(t, "k2", loaded.Current())
+	for _, id := range []string{"k1", "k2"} {
+		want, _ := k.Get(id)
+		got, err := loaded.Get(id)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	_, err = NewKeyring("k1", map[string][]byte{"k1": []byte("short")})
+	assert.Error(t, err)
+	_, err = NewKeyring("k3", map[string][]byte{})
+	assert.Error(t, err)
+}
+
+func TestDataStore(t *testing.T) {
+	ctx, store := teststore.InitializeTestStore(t)
+	sessionId := "+254700000000"
+	s := NewDataStore(store, newTestKeyring(t, "k1"))
+
+	err := store.WriteEntry(ctx, sessionId, common.DATA_FAMILY_NAME, []byte("Doe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.WriteEntry(ctx, sessionId, common.DATA_FIRST_NAME, []byte("Jane"))
+	assert.NoError(t, err)
+	err = s.WriteEntry(ctx, sessionId, common.DATA_ACCOUNT_CREATED, []byte("1"))
+	assert.NoError(t, err)
+
+	raw, err := store.ReadEntry(ctx, sessionId, common.DATA_FIRST_NAME)
+	assert.NoError(t, err)
+	assert.False(t, bytes.Contains(raw, []byte("Jane")))
+	v, err := s.ReadEntry(ctx, sessionId, common.DATA_FIRST_NAME)
+	assert.NoError(t, err)
+	assert.Equal(t, "Jane", string(v))
+
+	// entries that are not personal data and values written before encryption are passed through
+	raw, err = store.ReadEntry(ctx, sessionId, common.DATA_ACCOUNT_CREATED)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(raw))
+	v, err = s.ReadEntry(ctx, sessionId, common.DATA_FAMILY_NAME)
+	assert.NoError(t, err)
+	assert.Equal(t, "Doe", string(v))
+
+	// values are bound to their entry
+	raw, _ = store.ReadEntry(ctx, sessionId, common.DATA_FIRST_NAME)
+	err = store.WriteEntry(ctx, "+254711111111", common.DATA_FIRST_NAME, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.ReadEntry(ctx, "+254711111111", common.DATA_FIRST_NAME)
+	assert.Error(t, err)
+}
+
+func TestReencrypt(t *testing.T) {
+	ctx, store := teststore.InitializeTestStore(t)
+	sessionId := "+254700000000"
+	keyring := newTestKeyring(t, "k1")
+	s := NewDataStore(store, keyring)
+
+	err := store.WriteEntry(ctx, sessionId, common.DATA_FAMILY_NAME, []byte("Doe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.WriteEntry(ctx, sessionId, common.DATA_FIRST_NAME, []byte("Jane"))
+	assert.NoError(t, err)
+	// credentials written before they were encrypted
+	err = common.NewPinPolicy(4, 0).Record(ctx, store, sessionId, "4831")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := common.IssueAuthToken(ctx, store, sessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = keyring.Rotate("k2")
+	assert.NoError(t, err)
+	n, err := s.Reencrypt(ctx, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	n, err = s.Reencrypt(ctx, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// values are readable with only the current key
+	key, _ := keyring.Get("k2")
+	current, err := NewKeyring("k2", map[string][]byte{"k2": key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = NewDataStore(store, current)
+	v, err := s.ReadEntry(ctx, sessionId, common.DATA_FIRST_NAME)
+	assert.NoError(t, err)
+	assert.Equal(t, "Jane", string(v))
+	v, err = s.ReadEntry(ctx, sessionId, common.DATA_FAMILY_NAME)
+	assert.NoError(t, err)
+	assert.Equal(t, "Doe", string(v))
+	read, err := common.ReadAuthToken(ctx, s, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, token.String(), read.String())
+	assert.Equal(t, common.ErrPinReused, common.NewPinPolicy(4, 0).Check(ctx, s, sessionId, "4831"))
+
+	// the stored credentials are encrypted
+	for _, typ := range []common.DataTyp{common.DATA_PIN_HISTORY, common.DATA_AUTH_TOKEN} {
+		v, err = store.ReadEntry(ctx, sessionId, typ)
+		assert.NoError(t, err)
+		assert.Equal(t, byte(magic), v[0])
+	}
+}
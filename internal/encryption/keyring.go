@@ -0,0 +1,166 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"git.defalsify.org/vise.git/logging"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("encryption")
+)
+
+const (
+	// KeySize is the size in bytes of the AES-256 keys in the keyring.
+	KeySize = 32
+	// maxKeyIdLength is the longest key id that fits in the value header.
+	maxKeyIdLength = 255
+)
+
+var (
+	ErrUnknownKey = errors.New("unknown encryption key")
+)
+
+// keyringFile is the JSON representation of the keyring file.
+type keyringFile struct {
+	Current string `json:"current"`
+	// Keys holds the base64 encoded keys by key id.
+	Keys map[string]string `json:"keys"`
+}
+
+// Keyring holds the keys used to encrypt userdata values.
+//
+// Values are encrypted with the current key. Older keys are kept to decrypt values that have not been
+// re-encrypted since the keys were rotated.
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyring creates a Keyring from raw keys by key id.
+func NewKeyring(current string, keys map[string][]byte) (*Keyring, error) {
+	k := &Keyring{
+		current: current,
+		keys:    make(map[string][]byte),
+	}
+	for id, key := range keys {
+		if id == "" || len(id) > maxKeyIdLength {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("key %s must be %d bytes, got %d", id, KeySize, len(key))
+		}
+		k.keys[id] = key
+	}
+	if _, ok := k.keys[current]; !ok {
+		return nil, fmt.Errorf("current key %q is not in the keyring", current)
+	}
+	return k, nil
+}
+
+// LoadKeyring reads the keyring from the JSON file at the given path.
+//
+// If the path is empty, nil is returned and values are stored unencrypted.
+func LoadKeyring(fp string) (*Keyring, error) {
+	if fp == "" {
+		logg.Warnf("no keyring configured, personal data is stored unencrypted")
+		return nil, nil
+	}
+	fi, err := os.Stat(fp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring file: %v", err)
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		logg.Warnf("keyring file is readable by other users", "path", fp, "mode", fi.Mode().Perm())
+	}
+	b, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring file: %v", err)
+	}
+	var f keyringFile
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse keyring file %s: %v", fp, err)
+	}
+	keys := make(map[string][]byte)
+	for id, v := range f.Keys {
+		key, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key %s: %v", id, err)
+		}
+		keys[id] = key
+	}
+	k, err := NewKeyring(f.Current, keys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyring file %s: %v", fp, err)
+	}
+	logg.Infof("loaded keyring", "path", fp, "current", f.Current, "keys", len(keys))
+	return k, nil
+}
+
+// Save writes the keyring to the JSON file at the given path, readable only by the owner.
+func (k *Keyring) Save(fp string) error {
+	f := keyringFile{
+		Current: k.current,
+		Keys:    make(map[string]string),
+	}
+	for id, key := range k.keys {
+		f.Keys[id] = base64.StdEncoding.EncodeToString(key)
+	}
+	b, err := json.MarshalIndent(f, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := fp + ".tmp"
+	err = os.WriteFile(tmp, b, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, fp)
+}
+
+// Current returns the id of the key new values are encrypted with.
+func (k *Keyring) Current() string {
+	return k.current
+}
+
+// Get returns the key with the given id.
+func (k *Keyring) Get(id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+// Rotate adds a new random key with the given id and makes it the current key.
+func (k *Keyring) Rotate(id string) error {
+	if id == "" || len(id) > maxKeyIdLength {
+		return fmt.Errorf("invalid key id %q", id)
+	}
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("key %s already exists", id)
+	}
+	key, err := GenerateKey()
+	if err != nil {
+		return err
+	}
+	k.keys[id] = key
+	k.current = id
+	return nil
+}
+
+// GenerateKey returns a new random key.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
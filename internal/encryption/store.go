@@ -0,0 +1,181 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+
+	"git.defalsify.org/vise.git/db"
+
+	"git.grassecon.net/urdt/ussd/common"
+)
+
+const (
+	magic   = 0xe5
+	version = 0x01
+)
+
+var (
+	// DefaultTypes are the personal data and credential entries that are encrypted.
+	DefaultTypes = []common.DataTyp{
+		common.DATA_FIRST_NAME,
+		common.DATA_FAMILY_NAME,
+		common.DATA_YOB,
+		common.DATA_LOCATION,
+		common.DATA_GENDER,
+		common.DATA_OFFERINGS,
+		common.DATA_ACCOUNT_PIN,
+		common.DATA_TEMPORARY_VALUE,
+		common.DATA_PIN_HISTORY,
+		common.DATA_AUTH_TOKEN,
+	}
+)
+
+// DataStore is a common.DataStore that encrypts the values of personal data entries.
+//
+// Each value is sealed with AES-256-GCM under the current key of the keyring. The value is prefixed with
+// a header holding the key id, and is bound to its entry key, so that values cannot be moved between
+// entries or accounts. Values written before encryption was enabled are returned as they are.
+type DataStore struct {
+	common.DataStore
+	keyring *Keyring
+	types   map[common.DataTyp]bool
+}
+
+// NewDataStore creates a DataStore encrypting the DefaultTypes entries of store with keys from keyring.
+func NewDataStore(store common.DataStore, keyring *Keyring) *DataStore {
+	return NewDataStoreWithTypes(store, keyring, DefaultTypes)
+}
+
+// NewDataStoreWithTypes creates a DataStore encrypting the given entries of store with keys from keyring.
+func NewDataStoreWithTypes(store common.DataStore, keyring *Keyring, types []common.DataTyp) *DataStore {
+	s := &DataStore{
+		DataStore: store,
+		keyring:   keyring,
+		types:     make(map[common.DataTyp]bool),
+	}
+	for _, typ := range types {
+		s.types[typ] = true
+	}
+	return s
+}
+
+// ReadEntry implements common.DataStore, decrypting the value if it is encrypted.
+func (s *DataStore) ReadEntry(ctx context.Context, sessionId string, typ common.DataTyp) ([]byte, error) {
+	v, err := s.DataStore.ReadEntry(ctx, sessionId, typ)
+	if err != nil || !s.types[typ] {
+		return v, err
+	}
+	r, _, err := s.open(sessionId, typ, v)
+	return r, err
+}
+
+// WriteEntry implements common.DataStore, encrypting the value if the entry holds personal data.
+func (s *DataStore) WriteEntry(ctx context.Context, sessionId string, typ common.DataTyp, value []byte) error {
+	if !s.types[typ] {
+		return s.DataStore.WriteEntry(ctx, sessionId, typ, value)
+	}
+	v, err := s.seal(sessionId, typ, value)
+	if err != nil {
+		return err
+	}
+	return s.DataStore.WriteEntry(ctx, sessionId, typ, v)
+}
+
+// Reencrypt encrypts all personal data entries of the session id with the current key.
+//
+// It returns the number of entries that were rewritten. Entries already encrypted with the current key are left as they are.
+func (s *DataStore) Reencrypt(ctx context.Context, sessionId string) (int, error) {
+	var count int
+	for typ := range s.types {
+		v, err := s.DataStore.ReadEntry(ctx, sessionId, typ)
+		if err != nil {
+			if db.IsNotFound(err) {
+				continue
+			}
+			return count, err
+		}
+		r, keyId, err := s.open(sessionId, typ, v)
+		if err != nil {
+			return count, err
+		}
+		if keyId == s.keyring.Current() {
+			continue
+		}
+		err = s.WriteEntry(ctx, sessionId, typ, r)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// header returns the value header for the given key id, which is also authenticated as additional data.
+func header(keyId string) []byte {
+	return append([]byte{magic, version, byte(len(keyId))}, []byte(keyId)...)
+}
+
+func newAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *DataStore) seal(sessionId string, typ common.DataTyp, value []byte) ([]byte, error) {
+	keyId := s.keyring.Current()
+	key, err := s.keyring.Get(keyId)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAead(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	h := header(keyId)
+	ad := append(bytes.Clone(h), common.PackKey(typ, []byte(sessionId))...)
+	r := append(h, nonce...)
+	return aead.Seal(r, nonce, value, ad), nil
+}
+
+// open decrypts the value and returns it together with the id of the key it was encrypted with.
+//
+// Values without a header are returned unchanged with an empty key id.
+func (s *DataStore) open(sessionId string, typ common.DataTyp, v []byte) ([]byte, string, error) {
+	if len(v) < 3 || v[0] != magic || v[1] != version {
+		return v, "", nil
+	}
+	l := int(v[2])
+	if len(v) < 3+l {
+		return nil, "", fmt.Errorf("truncated encrypted value")
+	}
+	keyId := string(v[3 : 3+l])
+	key, err := s.keyring.Get(keyId)
+	if err != nil {
+		return nil, keyId, err
+	}
+	aead, err := newAead(key)
+	if err != nil {
+		return nil, keyId, err
+	}
+	rest := v[3+l:]
+	if len(rest) < aead.NonceSize() {
+		return nil, keyId, fmt.Errorf("truncated encrypted value")
+	}
+	ad := append(header(keyId), common.PackKey(typ, []byte(sessionId))...)
+	r, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], ad)
+	if err != nil {
+		return nil, keyId, fmt.Errorf("failed to decrypt value: %v", err)
+	}
+	return r, keyId, nil
+}
@@ -0,0 +1,280 @@
+package escrow
+
+import (
+	"context"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/logging"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/config"
+	"git.grassecon.net/urdt/ussd/internal/balance"
+	"git.grassecon.net/urdt/ussd/internal/notify"
+	"git.grassecon.net/urdt/ussd/remote"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("escrow")
+)
+
+// Service holds transfers sent to unregistered numbers in the escrow account, releases them to the numbers once
+// they register, and returns them to the senders once they expire.
+type Service struct {
+	store          common.DataStore
+	accountService remote.AccountServiceInterface
+	notifier       notify.Notifier
+	address        string
+	ttl            time.Duration
+	wake           chan struct{}
+}
+
+// NewService creates a new Service using the escrow account at address, returning transfers unclaimed after ttl.
+// If notifier is nil, notifications are only logged.
+func NewService(store common.DataStore, accountService remote.AccountServiceInterface, notifier notify.Notifier, address string, ttl time.Duration) *Service {
+	if notifier == nil {
+		notifier = &notify.LogNotifier{}
+	}
+	return &Service{
+		store:          store,
+		accountService: accountService,
+		notifier:       notifier,
+		address:        address,
+		ttl:            ttl,
+		wake:           make(chan struct{}, 1),
+	}
+}
+
+// WithStore returns a Service using another store, such as another handle to the same database, which is
+// woken by the same calls to Wake as s.
+func (s *Service) WithStore(store common.DataStore) *Service {
+	r := *s
+	r.store = store
+	return &r
+}
+
+// Wake makes Run release the escrowed transfers without waiting for the next interval, as when a number has
+// just registered. It does not block.
+func (s *Service) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// NewDefaultService creates a new Service with the configured escrow account and expiry.
+func NewDefaultService(store common.DataStore, accountService remote.AccountServiceInterface, notifier notify.Notifier) *Service {
+	ttl := common.DefaultClaimTTL
+	if config.EscrowTtl > 0 {
+		ttl = time.Duration(config.EscrowTtl) * time.Second
+	}
+	return NewService(store, accountService, notifier, config.EscrowAddress, ttl)
+}
+
+// Address returns the address of the escrow account, or an empty string if transfers to unregistered numbers
+// are not available.
+func (s *Service) Address() string {
+	return s.address
+}
+
+// RecipientKey returns the recipient a transfer escrowed for the number is known by to the transfer risk rules.
+//
+// All escrowed transfers are sent to the same escrow account, so they are told apart by the number they are for.
+func RecipientKey(number string) string {
+	return "escrow:" + number
+}
+
+// Ttl returns how long an escrowed transfer waits to be claimed before it is returned to the sender.
+func (s *Service) Ttl() time.Duration {
+	return s.ttl
+}
+
+// Release sends the escrowed transfers waiting for the number of the session id to its account, once it has one.
+//
+// A claim is marked as releasing before its transfer is requested, and removed once the transfer is made. A
+// transfer the API rejects stays in escrow, to be released on the next run.
+func (s *Service) Release(ctx context.Context, sessionId string) error {
+	number, err := common.NormalizePhoneNumber(sessionId)
+	if err != nil {
+		return nil
+	}
+	claims, err := common.ReadClaims(ctx, s.store, number)
+	if err != nil || len(claims) == 0 {
+		return err
+	}
+	publicKey, err := s.store.ReadEntry(ctx, sessionId, common.DATA_PUBLIC_KEY)
+	if err != nil {
+		if db.IsNotFound(err) {
+			logg.InfoCtxf(ctx, "escrowed transfers held until the account is created", "session", sessionId)
+			return nil
+		}
+		return err
+	}
+
+	for _, c := range claims {
+		if c.Status != common.ClaimHeld {
+			continue
+		}
+		c, err = s.mark(ctx, c, common.ClaimHeld, common.ClaimReleasing)
+		if err != nil {
+			continue
+		}
+		err = s.send(ctx, c, string(publicKey))
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to release escrowed transfer", "number", number, "claim", c.Id, "error", err)
+			s.mark(ctx, c, common.ClaimReleasing, common.ClaimHeld)
+			continue
+		}
+		s.remove(ctx, c)
+		err = balance.NewDefaultService(s.store, s.accountService).Invalidate(ctx, sessionId)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to write active balance timestamp entry with", "key", common.DATA_ACTIVE_BAL_UPDATED, "error", err)
+		}
+
+		s.notify(ctx, sessionId, "You have received %s %s from %s, sent to you before you joined Sarafu Network.", c.Amount, c.Symbol, c.Sender)
+		s.notify(ctx, s.sender(ctx, c), "%s has joined Sarafu Network and received the %s %s you sent.", number, c.Amount, c.Symbol)
+	}
+	return nil
+}
+
+// RunDue releases the escrowed transfers of numbers that have registered, and returns the transfers that
+// have expired at now to their senders.
+//
+// A transfer the API rejects stays in escrow, to be returned on the next run. Claims left pending, releasing
+// or refunding are not touched, and are logged to be resolved by hand.
+func (s *Service) RunDue(ctx context.Context, now time.Time) error {
+	numbers, err := common.ClaimNumbers(ctx, s.store)
+	if err != nil {
+		return err
+	}
+	for _, number := range numbers {
+		claims, err := common.ReadClaims(ctx, s.store, number)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to read escrowed transfers", "number", number, "error", err)
+			continue
+		}
+		for _, c := range claims {
+			if c.Status != common.ClaimHeld {
+				logg.WarnCtxf(ctx, "escrowed transfer outcome unknown, check it by hand", "number", number, "claim", c.Id, "status", c.Status, "trackingId", c.TrackingId)
+			}
+		}
+
+		if s.isRegistered(ctx, number) {
+			err = s.Release(ctx, number)
+			if err != nil {
+				logg.ErrorCtxf(ctx, "failed to release escrowed transfers", "number", number, "error", err)
+			}
+			continue
+		}
+		for _, c := range claims {
+			if c.Status == common.ClaimHeld && c.IsExpired(now, s.ttl) {
+				s.refund(ctx, c)
+			}
+		}
+	}
+	return nil
+}
+
+// Run releases and returns the escrowed transfers at the given interval, or when woken, until the context is
+// done.
+func (s *Service) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+		err := s.RunDue(ctx, time.Now())
+		if err != nil {
+			logg.ErrorCtxf(ctx, "escrow run failed", "error", err)
+		}
+	}
+}
+
+// isRegistered checks whether the number has an account with a PIN set.
+func (s *Service) isRegistered(ctx context.Context, number string) bool {
+	for _, key := range []common.DataTyp{common.DATA_PUBLIC_KEY, common.DATA_ACCOUNT_PIN} {
+		v, err := s.store.ReadEntry(ctx, number, key)
+		if err != nil || len(v) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// refund returns the expired escrowed transfer to its sender.
+func (s *Service) refund(ctx context.Context, c common.Claim) {
+	c, err := s.mark(ctx, c, common.ClaimHeld, common.ClaimRefunding)
+	if err != nil {
+		return
+	}
+	err = s.send(ctx, c, c.SenderAddress)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to return escrowed transfer", "number", c.Recipient, "claim", c.Id, "error", err)
+		s.mark(ctx, c, common.ClaimRefunding, common.ClaimHeld)
+		return
+	}
+	s.remove(ctx, c)
+
+	sender := s.sender(ctx, c)
+	err = balance.NewDefaultService(s.store, s.accountService).Invalidate(ctx, sender)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write active balance timestamp entry with", "key", common.DATA_ACTIVE_BAL_UPDATED, "error", err)
+	}
+	s.notify(ctx, sender, "Your transfer of %s %s to %s was not claimed and has been returned to you.", c.Amount, c.Symbol, c.Recipient)
+}
+
+// send makes the transfer of the escrowed amount from the escrow account to the address.
+func (s *Service) send(ctx context.Context, c common.Claim, to string) error {
+	amount, err := common.ParseAmount(c.Amount, c.Decimals)
+	if err != nil {
+		return err
+	}
+	r, err := s.accountService.TokenTransfer(ctx, amount.Raw(), s.address, to, c.Address)
+	if err != nil {
+		return err
+	}
+	logg.InfoCtxf(ctx, "escrowed transfer sent", "number", c.Recipient, "claim", c.Id, "to", to, "trackingId", r.TrackingId)
+	return nil
+}
+
+// mark changes the status of the escrowed transfer from one to another. A claim that has been taken by another
+// run, or has gone, is skipped silently.
+func (s *Service) mark(ctx context.Context, c common.Claim, from string, to string) (common.Claim, error) {
+	r, err := common.MarkClaim(ctx, s.store, c.Recipient, c.Id, from, to)
+	if err != nil && err != common.ErrNoClaim && err != common.ErrClaimStatus {
+		logg.ErrorCtxf(ctx, "failed to update escrowed transfer", "claim", c.String(), "status", to, "error", err)
+	}
+	return r, err
+}
+
+// remove takes the escrowed transfer once it has been sent. If it cannot be removed, it is left releasing or
+// refunding, to be resolved by hand.
+func (s *Service) remove(ctx context.Context, c common.Claim) {
+	_, err := common.RemoveClaim(ctx, s.store, c.Recipient, c.Id)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to remove sent escrowed transfer", "claim", c.String(), "error", err)
+	}
+}
+
+// sender returns the number the sender of the escrowed transfer can be reached at, as they may have moved
+// their account to a new number since.
+func (s *Service) sender(ctx context.Context, c common.Claim) string {
+	holder, err := common.ResolvePorted(ctx, s.store, c.Sender)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to resolve ported number", "session", c.Sender, "error", err)
+		return c.Sender
+	}
+	return holder
+}
+
+// notify sends the message to the session id, translated to its language.
+func (s *Service) notify(ctx context.Context, sessionId string, format string, args ...interface{}) {
+	err := s.notifier.Notify(ctx, sessionId, notify.Locale(ctx, s.store, sessionId).Get(format, args...))
+	if err != nil {
+		logg.WarnCtxf(ctx, "escrow notification failed", "session", sessionId, "error", err)
+	}
+}
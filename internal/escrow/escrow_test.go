@@ -0,0 +1,203 @@
+package escrow
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/testutil/mocks"
+	"git.grassecon.net/urdt/ussd/internal/testutil/teststore"
+	"git.grassecon.net/urdt/ussd/models"
+)
+
+type testNotifier struct {
+	sent map[string][]string
+}
+
+func (n *testNotifier) Notify(ctx context.Context, sessionId string, message string) error {
+	n.sent[sessionId] = append(n.sent[sessionId], message)
+	return nil
+}
+
+type wakeNotifier struct {
+	sent chan string
+}
+
+func (n *wakeNotifier) Notify(ctx context.Context, sessionId string, message string) error {
+	n.sent <- sessionId
+	return nil
+}
+
+func TestRunDue(t *testing.T) {
+	sender := "+254787654321"
+	registered := "+254712345678"
+	unregistered := "+254711111111"
+	publicKey := "0x8a6ad4e22c1e5d4be5ee8e1d7f57d7c5c4e6ef21"
+	ctx, store := teststore.InitializeTestStore(t)
+	now := time.Now()
+	ttl := 24 * time.Hour
+
+	c := common.Claim{
+		Sender:        sender,
+		SenderAddress: "0x5523058cdFfe5F3c1EaDADD5015E55C6E00fb439",
+		Recipient:     registered,
+		Amount:        "2.5",
+		Symbol:        "SRF",
+		Address:       "0xd4c288865Ce",
+		Decimals:      "6",
+		CreatedAt:     now.Add(-time.Hour),
+	}
+	_, err := common.AddClaim(ctx, store, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Recipient = unregistered
+	_, err = common.AddClaim(ctx, store, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.CreatedAt = now.Add(-2 * ttl)
+	_, err = common.AddClaim(ctx, store, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockAccountService := new(mocks.MockAccountService)
+	mockAccountService.On("TokenTransfer").Return(&models.TokenTransferResponse{TrackingId: "1234567890"}, nil)
+	notifier := &testNotifier{sent: make(map[string][]string)}
+	s := NewService(store, mockAccountService, notifier, "0x2a8d4e22c1e5d4be5ee8e1d7f57d7c5c4e6ef21a", ttl)
+
+	// nothing is released before the account has a PIN set
+	err = store.WriteEntry(ctx, registered, common.DATA_PUBLIC_KEY, []byte(publicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.RunDue(ctx, now)
+	assert.NoError(t, err)
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 1)
+	assert.Equal(t, []string{"Your transfer of 2.5 SRF to +254711111111 was not claimed and has been returned to you."}, notifier.sent[sender])
+
+	err = store.WriteEntry(ctx, registered, common.DATA_ACCOUNT_PIN, []byte("1234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.RunDue(ctx, now)
+	assert.NoError(t, err)
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 2)
+	assert.Equal(t, []string{"You have received 2.5 SRF from +254787654321, sent to you before you joined Sarafu Network."}, notifier.sent[registered])
+	assert.Equal(t, "+254712345678 has joined Sarafu Network and received the 2.5 SRF you sent.", notifier.sent[sender][1])
+
+	// only the claim of the unregistered number that has not expired is left
+	claims, err := common.ReadClaims(ctx, store, registered)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(claims))
+	claims, err = common.ReadClaims(ctx, store, unregistered)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(claims))
+	numbers, err := common.ClaimNumbers(ctx, store)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{unregistered}, numbers)
+}
+
+func TestRunDueUnresolved(t *testing.T) {
+	sender := "+254787654321"
+	number := "+254711111111"
+	ctx, store := teststore.InitializeTestStore(t)
+	now := time.Unix(time.Now().Unix(), 0)
+	ttl := 24 * time.Hour
+
+	c := common.Claim{
+		Sender:        sender,
+		SenderAddress: "0x5523058cdFfe5F3c1EaDADD5015E55C6E00fb439",
+		Recipient:     number,
+		Amount:        "2.5",
+		Symbol:        "SRF",
+		Address:       "0xd4c288865Ce",
+		Decimals:      "6",
+		CreatedAt:     now.Add(-2 * ttl),
+	}
+	held, err := common.AddClaim(ctx, store, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an earlier run stopped before the outcome of the transfer was known
+	unresolved, err := common.AddClaim(ctx, store, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unresolved, err = common.MarkClaim(ctx, store, number, unresolved.Id, common.ClaimHeld, common.ClaimRefunding)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockAccountService := new(mocks.MockAccountService)
+	mockAccountService.On("TokenTransfer").Return((*models.TokenTransferResponse)(nil), errors.New("service unavailable"))
+	notifier := &testNotifier{sent: make(map[string][]string)}
+	s := NewService(store, mockAccountService, notifier, "0x2a8d4e22c1e5d4be5ee8e1d7f57d7c5c4e6ef21a", ttl)
+
+	// the rejected transfer stays in escrow, the unresolved one is left to be checked by hand
+	err = s.RunDue(ctx, now)
+	assert.NoError(t, err)
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 1)
+	assert.Equal(t, 0, len(notifier.sent[sender]))
+	claims, err := common.ReadClaims(ctx, store, number)
+	assert.NoError(t, err)
+	assert.Equal(t, []common.Claim{held, unresolved}, claims)
+}
+
+func TestRunWake(t *testing.T) {
+	number := "+254712345678"
+	ctx, store := teststore.InitializeTestStore(t)
+	_, err := common.AddClaim(ctx, store, common.Claim{
+		Sender:        "+254787654321",
+		SenderAddress: "0x5523058cdFfe5F3c1EaDADD5015E55C6E00fb439",
+		Recipient:     number,
+		Amount:        "2.5",
+		Symbol:        "SRF",
+		Address:       "0xd4c288865Ce",
+		Decimals:      "6",
+		CreatedAt:     time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[common.DataTyp]string{
+		common.DATA_PUBLIC_KEY:  "0x8a6ad4e22c1e5d4be5ee8e1d7f57d7c5c4e6ef21",
+		common.DATA_ACCOUNT_PIN: "1234",
+	} {
+		err = store.WriteEntry(ctx, number, k, []byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mockAccountService := new(mocks.MockAccountService)
+	mockAccountService.On("TokenTransfer").Return(&models.TokenTransferResponse{TrackingId: "1234567890"}, nil)
+	notifier := &wakeNotifier{sent: make(chan string, 2)}
+	s := NewService(store, mockAccountService, notifier, "0x2a8d4e22c1e5d4be5ee8e1d7f57d7c5c4e6ef21a", time.Hour)
+
+	// waking the service the handlers use runs the worker well before its interval
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		s.WithStore(store).Run(runCtx, time.Hour)
+		close(done)
+	}()
+	s.Wake()
+	s.Wake()
+	select {
+	case <-notifier.sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("escrowed transfer not released after wake")
+	}
+	cancel()
+	<-done
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 1)
+	numbers, err := common.ClaimNumbers(ctx, store)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(numbers))
+}
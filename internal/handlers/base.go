@@ -48,7 +48,15 @@ func(f *BaseSessionHandler) Process(rqs RequestSession) (RequestSession, error)
 	
 	logg.InfoCtxf(rqs.Ctx, "new request", "data", rqs)
 
-	rqs.Storage, err = f.provider.Get(rqs.Config.SessionId)
+	if rqs.UserId == "" {
+		rqs.UserId = rqs.Config.SessionId
+	}
+	rqs.Config.SessionId = rqs.UserId
+	if rqs.TransportSessionId != "" {
+		rqs.Storage, err = f.provider.GetTransport(rqs.UserId, rqs.TransportSessionId)
+	} else {
+		rqs.Storage, err = f.provider.Get(rqs.UserId)
+	}
 	if err != nil {
 		logg.ErrorCtxf(rqs.Ctx, "", "storage get error", err)
 		return rqs, ErrStorage
@@ -71,7 +79,11 @@ func(f *BaseSessionHandler) Process(rqs RequestSession) (RequestSession, error)
 	}
 	rqs.Engine = en
 
-	r, err = rqs.Engine.Exec(rqs.Ctx, rqs.Input)
+	if rqs.TransportSessionId != "" && rqs.InputHistory != nil {
+		r, err = f.execHistory(rqs)
+	} else {
+		r, err = rqs.Engine.Exec(rqs.Ctx, rqs.Input)
+	}
 	if err != nil {
 		perr := f.provider.Put(rqs.Config.SessionId, rqs.Storage)
 		rqs.Storage = nil
@@ -85,6 +97,41 @@ func(f *BaseSessionHandler) Process(rqs RequestSession) (RequestSession, error)
 	return rqs, nil
 }
 
+// execHistory feeds the input of the transport session that the engine has not seen yet to the engine, in order.
+//
+// A dial string like *384*96*1*0712345678*50# can then drive the menu in a single request.
+func(f *BaseSessionHandler) execHistory(rqs RequestSession) (bool, error) {
+	stateDb := rqs.Storage.StateDb
+	consumed, seen, err := storage.ReadInputCount(rqs.Ctx, stateDb, rqs.Config.SessionId)
+	if err != nil {
+		return false, err
+	}
+	r := true
+	if !seen {
+		// the first request of the transport session starts the menu
+		r, err = rqs.Engine.Exec(rqs.Ctx, []byte{})
+		if err != nil {
+			return r, err
+		}
+	} else if consumed >= len(rqs.InputHistory) {
+		r, err = rqs.Engine.Exec(rqs.Ctx, rqs.Input)
+		if err != nil {
+			return r, err
+		}
+	}
+	for ; r && consumed < len(rqs.InputHistory); consumed++ {
+		r, err = rqs.Engine.Exec(rqs.Ctx, rqs.InputHistory[consumed])
+		if err != nil {
+			return r, err
+		}
+	}
+	err = storage.WriteInputCount(rqs.Ctx, stateDb, rqs.Config.SessionId, consumed)
+	if err != nil {
+		logg.ErrorCtxf(rqs.Ctx, "failed to write input count", "session", rqs.Config.SessionId, "transport", rqs.TransportSessionId, "error", err)
+	}
+	return r, nil
+}
+
 func(f *BaseSessionHandler) Output(rqs RequestSession) (RequestSession,  error) {
 	var err error
 	_, err = rqs.Engine.Flush(rqs.Ctx, rqs.Writer)
@@ -93,7 +140,25 @@ func(f *BaseSessionHandler) Output(rqs RequestSession) (RequestSession,  error)
 
 func(f *BaseSessionHandler) Reset(rqs RequestSession) (RequestSession, error) {
 	defer f.provider.Put(rqs.Config.SessionId, rqs.Storage)
-	return rqs, rqs.Engine.Finish()
+	err := rqs.Engine.Finish()
+	if err != nil {
+		return rqs, err
+	}
+	if rqs.Continue || rqs.TransportSessionId == "" {
+		return rqs, nil
+	}
+	// the transport session has ended, and its state will not be used again
+	rqs.Storage.StateDb.SetPrefix(db.DATATYPE_STATE)
+	rqs.Storage.StateDb.SetSession("")
+	err = rqs.Storage.StateDb.Put(rqs.Ctx, []byte(rqs.Config.SessionId), []byte{})
+	if err != nil {
+		logg.ErrorCtxf(rqs.Ctx, "failed to clear transport session state", "session", rqs.Config.SessionId, "transport", rqs.TransportSessionId, "error", err)
+	}
+	err = storage.WriteInputCount(rqs.Ctx, rqs.Storage.StateDb, rqs.Config.SessionId, -1)
+	if err != nil {
+		logg.ErrorCtxf(rqs.Ctx, "failed to clear input count", "session", rqs.Config.SessionId, "transport", rqs.TransportSessionId, "error", err)
+	}
+	return rqs, nil
 }
 
 func(f *BaseSessionHandler) GetConfig() engine.Config {
@@ -9,7 +9,16 @@ import (
 	"git.defalsify.org/vise.git/persist"
 	"git.defalsify.org/vise.git/resource"
 
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/community"
+	"git.grassecon.net/urdt/ussd/internal/encryption"
+	"git.grassecon.net/urdt/ussd/internal/escrow"
 	"git.grassecon.net/urdt/ussd/internal/handlers/ussd"
+	"git.grassecon.net/urdt/ussd/internal/notify"
+	"git.grassecon.net/urdt/ussd/internal/operator"
+	"git.grassecon.net/urdt/ussd/internal/provision"
+	"git.grassecon.net/urdt/ussd/internal/risk"
+	"git.grassecon.net/urdt/ussd/internal/terms"
 	"git.grassecon.net/urdt/ussd/internal/utils"
 	"git.grassecon.net/urdt/ussd/remote"
 )
@@ -28,13 +37,22 @@ func getParser(fp string, debug bool) (*asm.FlagParser, error) {
 }
 
 type LocalHandlerService struct {
-	Parser        *asm.FlagParser
-	DbRs          *resource.DbResource
-	Pe            *persist.Persister
-	UserdataStore *db.Db
-	AdminStore    *utils.AdminStore
-	Cfg           engine.Config
-	Rs            resource.Resource
+	Parser            *asm.FlagParser
+	DbRs              *resource.DbResource
+	Pe                *persist.Persister
+	UserdataStore     *db.Db
+	AdminStore        *utils.AdminStore
+	Cfg               engine.Config
+	Rs                resource.Resource
+	Provisioner       *provision.Provisioner
+	RiskEngine        *risk.Engine
+	Notifier          notify.Notifier
+	TermsRegistry     *terms.Registry
+	CommunityRegistry *community.Registry
+	Keyring           *encryption.Keyring
+	SimChecker        operator.SimChecker
+	StateStore        db.Db
+	EscrowService     *escrow.Service
 }
 
 func NewLocalHandlerService(ctx context.Context, fp string, debug bool, dbResource *resource.DbResource, cfg engine.Config, rs resource.Resource) (*LocalHandlerService, error) {
@@ -63,12 +81,90 @@ func (ls *LocalHandlerService) SetDataStore(db *db.Db) {
 	ls.UserdataStore = db
 }
 
+func (ls *LocalHandlerService) SetProvisioner(provisioner *provision.Provisioner) {
+	ls.Provisioner = provisioner
+}
+
+func (ls *LocalHandlerService) SetRiskEngine(engine *risk.Engine) {
+	ls.RiskEngine = engine
+}
+
+func (ls *LocalHandlerService) SetNotifier(notifier notify.Notifier) {
+	ls.Notifier = notifier
+}
+
+func (ls *LocalHandlerService) SetTermsRegistry(registry *terms.Registry) {
+	ls.TermsRegistry = registry
+}
+
+func (ls *LocalHandlerService) SetCommunityRegistry(registry *community.Registry) {
+	ls.CommunityRegistry = registry
+}
+
+func (ls *LocalHandlerService) SetSimChecker(checker operator.SimChecker) {
+	ls.SimChecker = checker
+}
+
+func (ls *LocalHandlerService) SetStateStore(store db.Db) {
+	ls.StateStore = store
+}
+
+func (ls *LocalHandlerService) SetEscrowService(service *escrow.Service) {
+	ls.EscrowService = service
+}
+
+func (ls *LocalHandlerService) SetKeyring(keyring *encryption.Keyring) {
+	ls.Keyring = keyring
+}
+
+// GetUserdataStore returns the user data store, encrypting personal data if a keyring is set.
+func (ls *LocalHandlerService) GetUserdataStore() common.DataStore {
+	return ls.NewUserdataStore(*ls.UserdataStore)
+}
+
+// NewUserdataStore returns a user data store over another handle to the user data database, encrypting
+// personal data if a keyring is set.
+func (ls *LocalHandlerService) NewUserdataStore(userdataDb db.Db) common.DataStore {
+	var store common.DataStore = &common.UserDataStore{Db: userdataDb}
+	if ls.Keyring != nil {
+		store = encryption.NewDataStore(store, ls.Keyring)
+	}
+	return store
+}
+
 func (ls *LocalHandlerService) GetHandler(accountService remote.AccountServiceInterface) (*ussd.Handlers, error) {
 	ussdHandlers, err := ussd.NewHandlers(ls.Parser, *ls.UserdataStore, ls.AdminStore, accountService)
 	if err != nil {
 		return nil, err
 	}
 	ussdHandlers = ussdHandlers.WithPersister(ls.Pe)
+	if ls.Keyring != nil {
+		ussdHandlers = ussdHandlers.WithUserdataStore(ls.GetUserdataStore())
+	}
+	if ls.Provisioner != nil {
+		ussdHandlers = ussdHandlers.WithProvisioner(ls.Provisioner)
+	}
+	if ls.EscrowService != nil {
+		ussdHandlers = ussdHandlers.WithEscrowService(ls.EscrowService)
+	}
+	if ls.RiskEngine != nil {
+		ussdHandlers = ussdHandlers.WithRiskEngine(ls.RiskEngine)
+	}
+	if ls.Notifier != nil {
+		ussdHandlers = ussdHandlers.WithNotifier(ls.Notifier)
+	}
+	if ls.TermsRegistry != nil {
+		ussdHandlers = ussdHandlers.WithTermsRegistry(ls.TermsRegistry)
+	}
+	if ls.CommunityRegistry != nil {
+		ussdHandlers = ussdHandlers.WithCommunityRegistry(ls.CommunityRegistry)
+	}
+	if ls.SimChecker != nil {
+		ussdHandlers = ussdHandlers.WithSimChecker(ls.SimChecker)
+	}
+	if ls.StateStore != nil {
+		ussdHandlers = ussdHandlers.WithStateStore(ls.StateStore)
+	}
 	ls.DbRs.AddLocalFunc("set_language", ussdHandlers.SetLanguage)
 	ls.DbRs.AddLocalFunc("create_account", ussdHandlers.CreateAccount)
 	ls.DbRs.AddLocalFunc("save_temporary_pin", ussdHandlers.SaveTemporaryPin)
@@ -95,13 +191,42 @@ func (ls *LocalHandlerService) GetHandler(accountService remote.AccountServiceIn
 	ls.DbRs.AddLocalFunc("save_yob", ussdHandlers.SaveYob)
 	ls.DbRs.AddLocalFunc("save_offerings", ussdHandlers.SaveOfferings)
 	ls.DbRs.AddLocalFunc("reset_account_authorized", ussdHandlers.ResetAccountAuthorized)
+	ls.DbRs.AddLocalFunc("check_authorization", ussdHandlers.CheckAuthorization)
+	ls.DbRs.AddLocalFunc("check_transfer_risk", ussdHandlers.CheckTransferRisk)
+	ls.DbRs.AddLocalFunc("confirm_transfer", ussdHandlers.ConfirmTransfer)
+	ls.DbRs.AddLocalFunc("check_freeze", ussdHandlers.CheckFreeze)
+	ls.DbRs.AddLocalFunc("check_sim_swap", ussdHandlers.CheckSimSwap)
+	ls.DbRs.AddLocalFunc("check_ported", ussdHandlers.CheckPorted)
+	ls.DbRs.AddLocalFunc("request_number_change", ussdHandlers.RequestNumberChange)
+	ls.DbRs.AddLocalFunc("confirm_number_change", ussdHandlers.ConfirmNumberChange)
+	ls.DbRs.AddLocalFunc("check_closed", ussdHandlers.CheckClosed)
+	ls.DbRs.AddLocalFunc("check_closure_balances", ussdHandlers.CheckClosureBalances)
+	ls.DbRs.AddLocalFunc("set_closure_recipient", ussdHandlers.SetClosureRecipient)
+	ls.DbRs.AddLocalFunc("check_closure_risk", ussdHandlers.CheckClosureRisk)
+	ls.DbRs.AddLocalFunc("transfer_closure_balances", ussdHandlers.TransferClosureBalances)
+	ls.DbRs.AddLocalFunc("close_account", ussdHandlers.CloseAccount)
+	ls.DbRs.AddLocalFunc("restore_user_state", ussdHandlers.RestoreUserState)
+	ls.DbRs.AddLocalFunc("freeze_account", ussdHandlers.FreezeAccount)
+	ls.DbRs.AddLocalFunc("unfreeze_account", ussdHandlers.UnfreezeAccount)
+	ls.DbRs.AddLocalFunc("unfreeze_others_account", ussdHandlers.UnfreezeOthersAccount)
+	ls.DbRs.AddLocalFunc("get_guardians", ussdHandlers.GetGuardians)
+	ls.DbRs.AddLocalFunc("add_guardian", ussdHandlers.AddGuardian)
+	ls.DbRs.AddLocalFunc("remove_guardian", ussdHandlers.RemoveGuardian)
+	ls.DbRs.AddLocalFunc("request_recovery", ussdHandlers.RequestRecovery)
+	ls.DbRs.AddLocalFunc("approve_recovery", ussdHandlers.ApproveRecovery)
+	ls.DbRs.AddLocalFunc("get_terms", ussdHandlers.GetTerms)
+	ls.DbRs.AddLocalFunc("accept_terms", ussdHandlers.AcceptTerms)
+	ls.DbRs.AddLocalFunc("check_terms", ussdHandlers.CheckTerms)
 	ls.DbRs.AddLocalFunc("reset_allow_update", ussdHandlers.ResetAllowUpdate)
 	ls.DbRs.AddLocalFunc("get_profile_info", ussdHandlers.GetProfileInfo)
 	ls.DbRs.AddLocalFunc("verify_yob", ussdHandlers.VerifyYob)
 	ls.DbRs.AddLocalFunc("reset_incorrect_date_format", ussdHandlers.ResetIncorrectYob)
 	ls.DbRs.AddLocalFunc("initiate_transaction", ussdHandlers.InitiateTransaction)
 	ls.DbRs.AddLocalFunc("verify_new_pin", ussdHandlers.VerifyNewPin)
+	ls.DbRs.AddLocalFunc("verify_others_new_pin", ussdHandlers.VerifyOthersNewPin)
+	ls.DbRs.AddLocalFunc("get_pin_length", ussdHandlers.GetPinLength)
 	ls.DbRs.AddLocalFunc("confirm_pin_change", ussdHandlers.ConfirmPinChange)
+	ls.DbRs.AddLocalFunc("check_pin_change", ussdHandlers.CheckPinChange)
 	ls.DbRs.AddLocalFunc("quit_with_help", ussdHandlers.QuitWithHelp)
 	ls.DbRs.AddLocalFunc("fetch_community_balance", ussdHandlers.FetchCommunityBalance)
 	ls.DbRs.AddLocalFunc("set_default_voucher", ussdHandlers.SetDefaultVoucher)
@@ -121,6 +246,38 @@ func (ls *LocalHandlerService) GetHandler(accountService remote.AccountServiceIn
 	ls.DbRs.AddLocalFunc("check_transactions", ussdHandlers.CheckTransactions)
 	ls.DbRs.AddLocalFunc("get_transactions", ussdHandlers.GetTransactionsList)
 	ls.DbRs.AddLocalFunc("view_statement", ussdHandlers.ViewTransactionStatement)
+	ls.DbRs.AddLocalFunc("get_resolved_recipient", ussdHandlers.GetResolvedRecipient)
+	ls.DbRs.AddLocalFunc("get_current_alias", ussdHandlers.GetCurrentAlias)
+	ls.DbRs.AddLocalFunc("claim_alias", ussdHandlers.ClaimAlias)
+	ls.DbRs.AddLocalFunc("reset_batch", ussdHandlers.ResetBatch)
+	ls.DbRs.AddLocalFunc("add_batch_recipient", ussdHandlers.AddBatchRecipient)
+	ls.DbRs.AddLocalFunc("add_batch_amount", ussdHandlers.AddBatchAmount)
+	ls.DbRs.AddLocalFunc("check_batch", ussdHandlers.CheckBatch)
+	ls.DbRs.AddLocalFunc("transfer_batch", ussdHandlers.TransferBatch)
+	ls.DbRs.AddLocalFunc("reset_payment_request", ussdHandlers.ResetPaymentRequest)
+	ls.DbRs.AddLocalFunc("set_payment_request_payer", ussdHandlers.SetPaymentRequestPayer)
+	ls.DbRs.AddLocalFunc("set_payment_request_voucher", ussdHandlers.SetPaymentRequestVoucher)
+	ls.DbRs.AddLocalFunc("create_payment_request", ussdHandlers.CreatePaymentRequest)
+	ls.DbRs.AddLocalFunc("check_payment_requests", ussdHandlers.CheckPaymentRequests)
+	ls.DbRs.AddLocalFunc("get_payment_requests", ussdHandlers.GetPaymentRequests)
+	ls.DbRs.AddLocalFunc("view_payment_request", ussdHandlers.ViewPaymentRequest)
+	ls.DbRs.AddLocalFunc("approve_payment_request", ussdHandlers.ApprovePaymentRequest)
+	ls.DbRs.AddLocalFunc("decline_payment_request", ussdHandlers.DeclinePaymentRequest)
+	ls.DbRs.AddLocalFunc("validate_till", ussdHandlers.ValidateTill)
+	ls.DbRs.AddLocalFunc("get_merchant", ussdHandlers.GetMerchant)
+	ls.DbRs.AddLocalFunc("get_merchant_payment", ussdHandlers.GetMerchantPayment)
+	ls.DbRs.AddLocalFunc("initiate_merchant_payment", ussdHandlers.InitiateMerchantPayment)
+	ls.DbRs.AddLocalFunc("set_schedule_frequency", ussdHandlers.SetScheduleFrequency)
+	ls.DbRs.AddLocalFunc("set_schedule_date", ussdHandlers.SetScheduleDate)
+	ls.DbRs.AddLocalFunc("check_schedule_risk", ussdHandlers.CheckScheduleRisk)
+	ls.DbRs.AddLocalFunc("get_schedule", ussdHandlers.GetSchedule)
+	ls.DbRs.AddLocalFunc("create_schedule", ussdHandlers.CreateSchedule)
+	ls.DbRs.AddLocalFunc("get_schedules", ussdHandlers.GetSchedules)
+	ls.DbRs.AddLocalFunc("view_schedule", ussdHandlers.ViewSchedule)
+	ls.DbRs.AddLocalFunc("cancel_schedule", ussdHandlers.CancelSchedule)
+	ls.DbRs.AddLocalFunc("set_escrow_recipient", ussdHandlers.SetEscrowRecipient)
+	ls.DbRs.AddLocalFunc("get_escrow_transfer", ussdHandlers.GetEscrowTransfer)
+	ls.DbRs.AddLocalFunc("initiate_escrow_transfer", ussdHandlers.InitiateEscrowTransfer)
 
 	return ussdHandlers, nil
 }
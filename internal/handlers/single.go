@@ -35,6 +35,15 @@ type RequestSession struct {
 	Storage *storage.Storage
 	Writer io.Writer
 	Continue bool
+	// UserId identifies the user by phone number (MSISDN). Userdata is kept per user, and the engine
+	// is given it as the session id. It defaults to the session id of the engine config.
+	UserId string
+	// TransportSessionId is the id the gateway gives the session, if it has one.
+	// When it is set, the menu state is kept per transport session.
+	TransportSessionId string
+	// InputHistory is all the input of the transport session so far, for gateways that send it with
+	// every request. It is nil if the gateway does not.
+	InputHistory [][]byte
 }
 
 // TODO: seems like can remove this.
@@ -43,6 +52,17 @@ type RequestParser interface {
 	GetInput(rq any) ([]byte, error)
 }
 
+// TransportSessionParser is implemented by request parsers for gateways that have sessions of their own.
+type TransportSessionParser interface {
+	GetTransportSessionId(rq any) (string, error)
+}
+
+// InputHistoryParser is implemented by request parsers for gateways that send all the input of the session
+// with every request.
+type InputHistoryParser interface {
+	GetInputHistory(rq any) ([][]byte, error)
+}
+
 type RequestHandler interface {
 	GetConfig() engine.Config
 	GetRequestParser() RequestParser
@@ -3,11 +3,13 @@ package ussd
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"git.defalsify.org/vise.git/asm"
 	"github.com/grassrootseconomics/eth-custodial/pkg/api"
@@ -20,8 +22,18 @@ import (
 	"git.defalsify.org/vise.git/resource"
 	"git.defalsify.org/vise.git/state"
 	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/config"
+	"git.grassecon.net/urdt/ussd/internal/balance"
+	"git.grassecon.net/urdt/ussd/internal/community"
+	"git.grassecon.net/urdt/ussd/internal/escrow"
+	"git.grassecon.net/urdt/ussd/internal/notify"
+	"git.grassecon.net/urdt/ussd/internal/operator"
+	"git.grassecon.net/urdt/ussd/internal/provision"
+	"git.grassecon.net/urdt/ussd/internal/risk"
+	"git.grassecon.net/urdt/ussd/internal/terms"
 	"git.grassecon.net/urdt/ussd/internal/utils"
 	"git.grassecon.net/urdt/ussd/remote"
+	dataserviceapi "github.com/grassrootseconomics/ussd-data-service/pkg/api"
 	"gopkg.in/leonelquinteros/gotext.v1"
 
 	"git.grassecon.net/urdt/ussd/internal/storage"
@@ -38,7 +50,15 @@ var (
 // Define the regex patterns as  constants
 const (
 	phoneRegex = `(\(\d{3}\)\s?|\d{3}[-.\s]?)?\d{3}[-.\s]?\d{4}`
-	pinPattern = `^\d{4}$`
+)
+
+const (
+	// communityTransferCount is how many recent community transfers fit on the community balance screen.
+	communityTransferCount = 3
+	// batchFee is the fee shown for a batch transfer, as transfers are not charged a fee.
+	batchFee = "0"
+	// scheduleHour is the hour of the day scheduled transfers are made at.
+	scheduleHour = 8
 )
 
 // FlagManager handles centralized flag management
@@ -65,14 +85,25 @@ func (fm *FlagManager) GetFlag(label string) (uint32, error) {
 }
 
 type Handlers struct {
-	pe             *persist.Persister
-	st             *state.State
-	ca             cache.Memory
-	userdataStore  common.DataStore
-	adminstore     *utils.AdminStore
-	flagManager    *asm.FlagParser
-	accountService remote.AccountServiceInterface
-	prefixDb       storage.PrefixDb
+	pe                *persist.Persister
+	st                *state.State
+	ca                cache.Memory
+	userdataStore     common.DataStore
+	adminstore        *utils.AdminStore
+	flagManager       *asm.FlagParser
+	accountService    remote.AccountServiceInterface
+	prefixDb          storage.PrefixDb
+	provisioner       *provision.Provisioner
+	pinPolicy         *common.PinPolicy
+	riskEngine        *risk.Engine
+	notifier          notify.Notifier
+	termsRegistry     *terms.Registry
+	termsTracker      *terms.Tracker
+	simChecker        operator.SimChecker
+	stateStore        db.Db
+	balanceService    *balance.Service
+	communityRegistry *community.Registry
+	escrowService     *escrow.Service
 }
 
 func NewHandlers(appFlags *asm.FlagParser, userdataStore db.Db, adminstore *utils.AdminStore, accountService remote.AccountServiceInterface) (*Handlers, error) {
@@ -95,12 +126,6 @@ func NewHandlers(appFlags *asm.FlagParser, userdataStore db.Db, adminstore *util
 	return h, nil
 }
 
-// isValidPIN checks whether the given input is a 4 digit number
-func isValidPIN(pin string) bool {
-	match, _ := regexp.MatchString(pinPattern, pin)
-	return match
-}
-
 func isValidPhoneNumber(phonenumber string) bool {
 	match, _ := regexp.MatchString(phoneRegex, phonenumber)
 	return match
@@ -114,6 +139,339 @@ func (h *Handlers) WithPersister(pe *persist.Persister) *Handlers {
 	return h
 }
 
+// WithUserdataStore replaces the store user data is read from and written to, such as with an encrypting store.
+func (h *Handlers) WithUserdataStore(store common.DataStore) *Handlers {
+	h.userdataStore = store
+	return h
+}
+
+// WithProvisioner sets the provisioner used to create and track custodial accounts.
+func (h *Handlers) WithProvisioner(p *provision.Provisioner) *Handlers {
+	h.provisioner = p
+	return h
+}
+
+func (h *Handlers) getProvisioner() *provision.Provisioner {
+	if h.provisioner == nil {
+		h.provisioner = provision.NewProvisioner(h.userdataStore, h.accountService, nil)
+	}
+	return h.provisioner
+}
+
+// WithEscrowService sets the service holding transfers to unregistered numbers until they register.
+func (h *Handlers) WithEscrowService(s *escrow.Service) *Handlers {
+	h.escrowService = s
+	return h
+}
+
+func (h *Handlers) getEscrowService() *escrow.Service {
+	if h.escrowService == nil {
+		h.escrowService = escrow.NewDefaultService(h.userdataStore, h.accountService, h.getNotifier())
+	}
+	return h.escrowService
+}
+
+// isAuthorizationFresh checks whether the PIN was entered recently enough for sensitive operations.
+func (h *Handlers) isAuthorizationFresh(ctx context.Context, sessionId string) bool {
+	t, err := common.ReadAuthToken(ctx, h.userdataStore, sessionId)
+	if err != nil {
+		if !db.IsNotFound(err) {
+			logg.ErrorCtxf(ctx, "failed to read auth token entry with", "key", common.DATA_AUTH_TOKEN, "error", err)
+		}
+		return false
+	}
+	maxAge := common.DefaultAuthMaxAge
+	if config.AuthMaxAge > 0 {
+		maxAge = time.Duration(config.AuthMaxAge) * time.Second
+	}
+	if !t.IsFresh(maxAge, time.Now()) {
+		logg.InfoCtxf(ctx, "authorization expired", "token", t.Id, "issued", t.IssuedAt)
+		return false
+	}
+	return true
+}
+
+// expireAuthorization resets the authorization flags so that the PIN is asked for again.
+func (h *Handlers) expireAuthorization(ctx context.Context, res resource.Result) (resource.Result, error) {
+	flag_account_authorized, _ := h.flagManager.GetFlag("flag_account_authorized")
+	flag_allow_update, _ := h.flagManager.GetFlag("flag_allow_update")
+	res.FlagReset = append(res.FlagReset, flag_account_authorized, flag_allow_update)
+	return res, nil
+}
+
+// WithPinPolicy sets the policy new PINs are checked against.
+func (h *Handlers) WithPinPolicy(p *common.PinPolicy) *Handlers {
+	h.pinPolicy = p
+	return h
+}
+
+func (h *Handlers) getPinPolicy() *common.PinPolicy {
+	if h.pinPolicy == nil {
+		h.pinPolicy = common.NewPinPolicy(config.PinLength, config.PinHistory)
+	}
+	return h.pinPolicy
+}
+
+// checkPinPolicy checks the new PIN for the given session id against the PIN policy.
+// If the PIN is rejected, the returned string explains why in the user's language.
+func (h *Handlers) checkPinPolicy(ctx context.Context, sessionId string, pin string) (string, error) {
+	pinPolicy := h.getPinPolicy()
+	err := pinPolicy.Check(ctx, h.userdataStore, sessionId, pin)
+	if err == nil {
+		return "", nil
+	}
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	switch err {
+	case common.ErrPinLength:
+		return l.Get("The PIN must be %d digits.", pinPolicy.Length()), nil
+	case common.ErrPinWeak:
+		return l.Get("The PIN is too easy to guess. Do not use repeated or consecutive digits."), nil
+	case common.ErrPinYob:
+		return l.Get("The PIN must not contain your year of birth."), nil
+	case common.ErrPinReused:
+		return l.Get("The PIN must be different from your recent PINs."), nil
+	}
+	return "", err
+}
+
+// recordPin adds the PIN that has just been set to the PIN history of the session id.
+func (h *Handlers) recordPin(ctx context.Context, sessionId string, pin []byte) {
+	err := h.getPinPolicy().Record(ctx, h.userdataStore, sessionId, string(pin))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write pin history entry with", "key", common.DATA_PIN_HISTORY, "error", err)
+	}
+}
+
+// WithRiskEngine sets the engine transfers are evaluated against before they are initiated.
+func (h *Handlers) WithRiskEngine(e *risk.Engine) *Handlers {
+	h.riskEngine = e
+	return h
+}
+
+func (h *Handlers) getRiskEngine() *risk.Engine {
+	if h.riskEngine == nil {
+		h.riskEngine = risk.NewEngine()
+	}
+	return h.riskEngine
+}
+
+// evaluateTransfer evaluates the pending transfer of the session id against the transfer risk rules.
+func (h *Handlers) evaluateTransfer(ctx context.Context, sessionId string) (risk.Transfer, risk.Decision, error) {
+	var t risk.Transfer
+	var d risk.Decision
+	store := h.userdataStore
+
+	amount, err := store.ReadEntry(ctx, sessionId, common.DATA_AMOUNT)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read amount entry with", "key", common.DATA_AMOUNT, "error", err)
+		return t, d, err
+	}
+	activeSym, err := store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_SYM)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read activeSym entry with", "key", common.DATA_ACTIVE_SYM, "error", err)
+		return t, d, err
+	}
+	recipient, err := store.ReadEntry(ctx, sessionId, common.DATA_RECIPIENT)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read recipient entry with", "key", common.DATA_RECIPIENT, "error", err)
+		return t, d, err
+	}
+	temporaryValue, _ := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
+	return h.evaluate(ctx, sessionId, string(amount), string(activeSym), h.riskRecipient(string(recipient), string(temporaryValue)))
+}
+
+// riskRecipient returns the recipient a transfer to the recipient address is known by to the transfer risk rules.
+//
+// A transfer to the escrow account is known by the number it is held for, given as the temporary value.
+func (h *Handlers) riskRecipient(recipient string, temporaryValue string) string {
+	address := h.getEscrowService().Address()
+	if address == "" || !strings.EqualFold(recipient, address) {
+		return recipient
+	}
+	number, err := common.NormalizePhoneNumber(temporaryValue)
+	if err != nil {
+		return recipient
+	}
+	return escrow.RecipientKey(number)
+}
+
+// evaluate evaluates a transfer of amount whole tokens of voucher to the recipient address
+// from the session id against the transfer risk rules.
+func (h *Handlers) evaluate(ctx context.Context, sessionId string, amount string, voucher string, recipient string) (risk.Transfer, risk.Decision, error) {
+	var t risk.Transfer
+	var d risk.Decision
+	var err error
+
+	t.Amount, err = common.ParseDecimal(amount)
+	if err != nil {
+		return t, d, err
+	}
+	t.Voucher = voucher
+	t.Recipient = recipient
+	t.Time = time.Now()
+
+	history, err := risk.NewLedger(h.userdataStore).History(ctx, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read transfer log entry with", "key", common.DATA_TRANSFER_LOG, "error", err)
+		return t, d, err
+	}
+	d = h.getRiskEngine().Evaluate(ctx, sessionId, t, history)
+	return t, d, nil
+}
+
+// riskReason explains a transfer risk decision in the user's language.
+func riskReason(l *gotext.Locale, d risk.Decision, voucher string) string {
+	switch d.Rule {
+	case risk.RuleMaxPerTransaction:
+		return l.Get("The amount is above the limit of %s %s per transaction.", strconv.FormatFloat(d.Limit, 'f', -1, 64), voucher)
+	case risk.RuleMaxDaily:
+		return l.Get("This transfer would exceed your daily limit of %s %s.", strconv.FormatFloat(d.Limit, 'f', -1, 64), voucher)
+	case risk.RuleMaxWeekly:
+		return l.Get("This transfer would exceed your weekly limit of %s %s.", strconv.FormatFloat(d.Limit, 'f', -1, 64), voucher)
+	case risk.RuleVelocity:
+		return l.Get("You have made too many transfers. Please try again later.")
+	case risk.RuleNewRecipient:
+		return l.Get("You have not sent to this recipient before.")
+	case risk.RuleLargeAmount:
+		return l.Get("This is a large transfer.")
+	}
+	return l.Get("This transfer is not allowed.")
+}
+
+// WithNotifier sets the notifier used to send SMS outside of the session.
+func (h *Handlers) WithNotifier(n notify.Notifier) *Handlers {
+	h.notifier = n
+	return h
+}
+
+func (h *Handlers) getNotifier() notify.Notifier {
+	if h.notifier == nil {
+		h.notifier = notify.NewNotifier()
+	}
+	return h.notifier
+}
+
+// WithSimChecker sets the operator check used to detect recent SIM changes.
+func (h *Handlers) WithSimChecker(c operator.SimChecker) *Handlers {
+	h.simChecker = c
+	return h
+}
+
+// WithCommunityRegistry sets the registry of community accounts and their managers.
+func (h *Handlers) WithCommunityRegistry(r *community.Registry) *Handlers {
+	h.communityRegistry = r
+	return h
+}
+
+func (h *Handlers) getCommunityRegistry() *community.Registry {
+	if h.communityRegistry == nil {
+		h.communityRegistry = community.NewRegistry()
+	}
+	return h.communityRegistry
+}
+
+func (h *Handlers) getSimChecker() operator.SimChecker {
+	if h.simChecker == nil {
+		h.simChecker = operator.NewSimChecker()
+	}
+	return h.simChecker
+}
+
+// WithBalanceService sets the service keeping the balance of the active voucher fresh.
+func (h *Handlers) WithBalanceService(s *balance.Service) *Handlers {
+	h.balanceService = s
+	return h
+}
+
+func (h *Handlers) getBalanceService() *balance.Service {
+	if h.balanceService == nil {
+		h.balanceService = balance.NewDefaultService(h.userdataStore, h.accountService)
+	}
+	return h.balanceService
+}
+
+// invalidateBalance makes the balance of the active voucher be refreshed the next time it is shown,
+// as it has changed with a transfer from the account.
+func (h *Handlers) invalidateBalance(ctx context.Context, sessionId string) {
+	err := h.getBalanceService().Invalidate(ctx, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write active balance timestamp entry with", "key", common.DATA_ACTIVE_BAL_UPDATED, "error", err)
+	}
+}
+
+// WithStateStore sets the store of the menu state, which is moved along with the account when the number changes.
+func (h *Handlers) WithStateStore(store db.Db) *Handlers {
+	h.stateStore = store
+	return h
+}
+
+// WithTermsRegistry sets the registry of the terms and conditions users must accept.
+func (h *Handlers) WithTermsRegistry(r *terms.Registry) *Handlers {
+	h.termsRegistry = r
+	return h
+}
+
+func (h *Handlers) getTermsRegistry() *terms.Registry {
+	if h.termsRegistry == nil {
+		h.termsRegistry = terms.NewRegistry()
+	}
+	return h.termsRegistry
+}
+
+func (h *Handlers) getTermsTracker() *terms.Tracker {
+	if h.termsTracker == nil {
+		h.termsTracker = terms.NewTracker(h.userdataStore)
+	}
+	return h.termsTracker
+}
+
+func recoveryQuorum() int {
+	if config.RecoveryQuorum > 0 {
+		return int(config.RecoveryQuorum)
+	}
+	return common.DefaultRecoveryQuorum
+}
+
+func freezeCoolingOff() time.Duration {
+	if config.FreezeCoolingOff > 0 {
+		return time.Duration(config.FreezeCoolingOff) * time.Second
+	}
+	return common.DefaultFreezeCoolingOff
+}
+
+func simSwapWindow() time.Duration {
+	return operator.SwapWindow()
+}
+
+func paymentRequestTtl() time.Duration {
+	if config.PaymentRequestTtl > 0 {
+		return time.Duration(config.PaymentRequestTtl) * time.Second
+	}
+	return common.DefaultPaymentRequestTTL
+}
+
+// checkSimSwap returns the message to show when transfers are blocked because of a recent SIM change,
+// or an empty string if they are not.
+//
+// Transfers are also blocked when the SIM change cannot be checked.
+func (h *Handlers) checkSimSwap(ctx context.Context, sessionId string, l *gotext.Locale) string {
+	window := simSwapWindow()
+	changed, changedAt, err := operator.RecentSimChange(ctx, h.getSimChecker(), sessionId, window)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to check SIM change", "session", sessionId, "error", err)
+		return l.Get("We could not verify your SIM card. Please try again later.")
+	}
+	if changed {
+		logg.WarnCtxf(ctx, "transfer blocked after SIM change", "session", sessionId, "changed", changedAt)
+		return l.Get("Your SIM card was recently changed. For your security, transfers are blocked until %s.", changedAt.Add(window).Format("2006-01-02 15:04"))
+	}
+	return ""
+}
+
 func (h *Handlers) Init(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var r resource.Result
 	if h.pe == nil {
@@ -147,9 +505,15 @@ func (h *Handlers) Init(ctx context.Context, sym string, input []byte) (resource
 }
 
 // SetLanguage sets the language across the menu
+// The language is also kept in the userdata, so that it can be restored in the next sessions of the user.
 func (h *Handlers) SetLanguage(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+
 	symbol, _ := h.st.Where()
 	code := strings.Split(symbol, "_")[1]
 
@@ -159,6 +523,12 @@ func (h *Handlers) SetLanguage(ctx context.Context, sym string, input []byte) (r
 	res.FlagSet = append(res.FlagSet, state.FLAG_LANG)
 	res.Content = code
 
+	err := h.userdataStore.WriteEntry(ctx, sessionId, common.DATA_LANGUAGE_CODE, []byte(code))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write language code entry with", "key", common.DATA_LANGUAGE_CODE, "value", code, "error", err)
+		return res, err
+	}
+
 	languageSetFlag, err := h.flagManager.GetFlag("flag_language_set")
 	if err != nil {
 		logg.ErrorCtxf(ctx, "Error setting the languageSetFlag", "error", err)
@@ -169,61 +539,90 @@ func (h *Handlers) SetLanguage(ctx context.Context, sym string, input []byte) (r
 	return res, nil
 }
 
-func (h *Handlers) createAccountNoExist(ctx context.Context, sessionId string, res *resource.Result) error {
-	flag_account_created, _ := h.flagManager.GetFlag("flag_account_created")
-	r, err := h.accountService.CreateAccount(ctx)
-	if err != nil {
-		return err
-	}
-	trackingId := r.TrackingId
-	publicKey := r.PublicKey
+// RestoreUserState sets the flags kept for the user across sessions, when the menu state is new.
+//
+// The menu state is kept per transport session, so a new dial starts without the language, account
+// and PIN flags of earlier sessions. They are restored from the userdata.
+func (h *Handlers) RestoreUserState(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
 
-	data := map[common.DataTyp]string{
-		common.DATA_TRACKING_ID: trackingId,
-		common.DATA_PUBLIC_KEY:  publicKey,
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
 	}
+	flag_language_set, _ := h.flagManager.GetFlag("flag_language_set")
+	flag_account_created, _ := h.flagManager.GetFlag("flag_account_created")
+	flag_pin_set, _ := h.flagManager.GetFlag("flag_pin_set")
 	store := h.userdataStore
-	for key, value := range data {
-		err = store.WriteEntry(ctx, sessionId, key, []byte(value))
-		if err != nil {
-			return err
-		}
+
+	if h.st != nil && h.st.MatchFlag(flag_language_set, true) {
+		return res, nil
 	}
-	publicKeyNormalized, err := common.NormalizeHex(publicKey)
+
+	code, err := store.ReadEntry(ctx, sessionId, common.DATA_LANGUAGE_CODE)
 	if err != nil {
-		return err
+		if db.IsNotFound(err) {
+			return res, nil
+		}
+		logg.ErrorCtxf(ctx, "failed to read language code entry with", "key", common.DATA_LANGUAGE_CODE, "error", err)
+		return res, err
+	}
+	if len(code) == 0 {
+		return res, nil
 	}
-	err = store.WriteEntry(ctx, publicKeyNormalized, common.DATA_PUBLIC_KEY_REVERSE, []byte(sessionId))
+	res.FlagSet = append(res.FlagSet, state.FLAG_LANG, flag_language_set)
+	res.Content = string(code)
+
+	st, err := h.getProvisioner().GetState(ctx, sessionId)
 	if err != nil {
-		return err
+		logg.ErrorCtxf(ctx, "failed to read account status entry with", "key", common.DATA_ACCOUNT_STATUS, "error", err)
+		return res, err
+	}
+	if st == provision.StateNone || st == provision.StateFailed {
+		return res, nil
 	}
 	res.FlagSet = append(res.FlagSet, flag_account_created)
-	return nil
+
+	pin, err := store.ReadEntry(ctx, sessionId, common.DATA_ACCOUNT_PIN)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return res, nil
+		}
+		logg.ErrorCtxf(ctx, "failed to read AccountPin entry with", "key", common.DATA_ACCOUNT_PIN, "error", err)
+		return res, err
+	}
+	if len(pin) > 0 {
+		res.FlagSet = append(res.FlagSet, flag_pin_set)
+	}
+	logg.DebugCtxf(ctx, "restored user state", "session", sessionId, "language", string(code), "account", st)
+	return res, nil
 }
 
-// CreateAccount checks if any account exists on the JSON data file, and if not
-// creates an account on the API,
-// sets the default values and flags
+// CreateAccount requests a custodial account on the API through the provisioner, unless one has already been requested,
+// and sets the account creation flags.
 func (h *Handlers) CreateAccount(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
-	var err error
 	sessionId, ok := ctx.Value("SessionId").(string)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
-	store := h.userdataStore
-	_, err = store.ReadEntry(ctx, sessionId, common.DATA_ACCOUNT_CREATED)
+
+	flag_account_created, _ := h.flagManager.GetFlag("flag_account_created")
+	flag_account_creation_failed, _ := h.flagManager.GetFlag("flag_account_creation_failed")
+
+	st, err := h.getProvisioner().Request(ctx, sessionId)
 	if err != nil {
-		if db.IsNotFound(err) {
-			logg.InfoCtxf(ctx, "Creating an account because it doesn't exist")
-			err = h.createAccountNoExist(ctx, sessionId, &res)
-			if err != nil {
-				logg.ErrorCtxf(ctx, "failed on createAccountNoExist", "error", err)
-				return res, err
-			}
+		if err == provision.ErrInProgress {
+			logg.InfoCtxf(ctx, "account creation already in progress", "session", sessionId)
+			res.FlagSet = append(res.FlagSet, flag_account_created)
+			return res, nil
 		}
+		logg.ErrorCtxf(ctx, "failed to request account", "state", st, "error", err)
+		res.FlagSet = append(res.FlagSet, flag_account_creation_failed)
+		return res, nil
 	}
 
+	res.FlagSet = append(res.FlagSet, flag_account_created)
 	return res, nil
 }
 
@@ -253,25 +652,71 @@ func (h *Handlers) CheckPinMisMatch(ctx context.Context, sym string, input []byt
 	return res, nil
 }
 
+// VerifyNewPin checks the new PIN of the user against the PIN policy
+// and sets the reason for a rejection as content
 func (h *Handlers) VerifyNewPin(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	res := resource.Result{}
-	_, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	return h.verifyNewPin(ctx, sessionId, input)
+}
+
+// VerifyOthersNewPin checks the new PIN for the blocked number against the PIN policy
+// and sets the reason for a rejection as content
+func (h *Handlers) VerifyOthersNewPin(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	res := resource.Result{}
+	sessionId, ok := ctx.Value("SessionId").(string)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
+	blockedNumber, err := h.userdataStore.ReadEntry(ctx, sessionId, common.DATA_BLOCKED_NUMBER)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read blockedNumber entry with", "key", common.DATA_BLOCKED_NUMBER, "error", err)
+		return res, err
+	}
+	return h.verifyNewPin(ctx, string(blockedNumber), input)
+}
+
+func (h *Handlers) verifyNewPin(ctx context.Context, sessionId string, input []byte) (resource.Result, error) {
+	res := resource.Result{}
 	flag_valid_pin, _ := h.flagManager.GetFlag("flag_valid_pin")
-	pinInput := string(input)
-	// Validate that the PIN is a 4-digit number
-	if isValidPIN(pinInput) {
+	reason, err := h.checkPinPolicy(ctx, sessionId, string(input))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to check pin policy", "error", err)
+		return res, err
+	}
+	if reason == "" {
 		res.FlagSet = append(res.FlagSet, flag_valid_pin)
 	} else {
 		res.FlagReset = append(res.FlagReset, flag_valid_pin)
+		res.Content = reason
 	}
 
 	return res, nil
 }
 
-// SaveTemporaryPin saves the valid PIN input to the DATA_TEMPORARY_VALUE
+// GetPinLength returns the number of digits of a PIN in words, for use in the PIN prompts
+func (h *Handlers) GetPinLength(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	switch h.getPinPolicy().Length() {
+	case 5:
+		res.Content = l.Get("five")
+	case 6:
+		res.Content = l.Get("six")
+	default:
+		res.Content = l.Get("four")
+	}
+	return res, nil
+}
+
+// SaveTemporaryPin saves the PIN input to the DATA_TEMPORARY_VALUE if it satisfies the PIN policy
 // during the account creation process
 // and during the change PIN process
 func (h *Handlers) SaveTemporaryPin(ctx context.Context, sym string, input []byte) (resource.Result, error) {
@@ -286,9 +731,14 @@ func (h *Handlers) SaveTemporaryPin(ctx context.Context, sym string, input []byt
 	flag_incorrect_pin, _ := h.flagManager.GetFlag("flag_incorrect_pin")
 	accountPIN := string(input)
 
-	// Validate that the PIN is a 4-digit number
-	if !isValidPIN(accountPIN) {
+	reason, err := h.checkPinPolicy(ctx, sessionId, accountPIN)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to check pin policy", "error", err)
+		return res, err
+	}
+	if reason != "" {
 		res.FlagSet = append(res.FlagSet, flag_incorrect_pin)
+		res.Content = reason
 		return res, nil
 	}
 	res.FlagReset = append(res.FlagReset, flag_incorrect_pin)
@@ -317,6 +767,15 @@ func (h *Handlers) SaveOthersTemporaryPin(ctx context.Context, sym string, input
 		logg.ErrorCtxf(ctx, "failed to read blockedNumber entry with", "key", common.DATA_BLOCKED_NUMBER, "error", err)
 		return res, err
 	}
+	reason, err := h.checkPinPolicy(ctx, string(blockedNumber), temporaryPin)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to check pin policy", "error", err)
+		return res, err
+	}
+	if reason != "" {
+		logg.WarnCtxf(ctx, "not saving pin rejected by pin policy", "reason", reason)
+		return res, nil
+	}
 
 	err = store.WriteEntry(ctx, string(blockedNumber), common.DATA_TEMPORARY_VALUE, []byte(temporaryPin))
 	if err != nil {
@@ -335,6 +794,10 @@ func (h *Handlers) ConfirmPinChange(ctx context.Context, sym string, input []byt
 	}
 	flag_pin_mismatch, _ := h.flagManager.GetFlag("flag_pin_mismatch")
 
+	if !h.isAuthorizationFresh(ctx, sessionId) {
+		return h.expireAuthorization(ctx, res)
+	}
+
 	store := h.userdataStore
 	temporaryPin, err := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
 	if err != nil {
@@ -351,6 +814,37 @@ func (h *Handlers) ConfirmPinChange(ctx context.Context, sym string, input []byt
 		logg.ErrorCtxf(ctx, "failed to write temporaryPin entry with", "key", common.DATA_ACCOUNT_PIN, "value", temporaryPin, "error", err)
 		return res, err
 	}
+	if bytes.Equal(temporaryPin, input) {
+		h.recordPin(ctx, sessionId, temporaryPin)
+		err = common.ClearPinChange(ctx, store, sessionId)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to write pin change entry with", "key", common.DATA_PIN_CHANGE_REQUIRED, "error", err)
+		}
+	}
+	return res, nil
+}
+
+// CheckPinChange sets the flag_pin_change_required flag if the PIN has to be changed before the account can be used,
+// like the initial PIN of a pre-registered account.
+func (h *Handlers) CheckPinChange(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_pin_change_required, _ := h.flagManager.GetFlag("flag_pin_change_required")
+
+	required, err := common.IsPinChangeRequired(ctx, h.userdataStore, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read pin change entry with", "key", common.DATA_PIN_CHANGE_REQUIRED, "error", err)
+		return res, err
+	}
+	if required {
+		res.FlagSet = append(res.FlagSet, flag_pin_change_required)
+	} else {
+		res.FlagReset = append(res.FlagReset, flag_pin_change_required)
+	}
 	return res, nil
 }
 
@@ -387,6 +881,11 @@ func (h *Handlers) VerifyCreatePin(ctx context.Context, sym string, input []byte
 		logg.ErrorCtxf(ctx, "failed to write temporaryPin entry with", "key", common.DATA_ACCOUNT_PIN, "value", temporaryPin, "error", err)
 		return res, err
 	}
+	if bytes.Equal(input, temporaryPin) {
+		h.recordPin(ctx, sessionId, temporaryPin)
+		// transfers sent to the number before it registered are released to the new account by the escrow worker
+		h.getEscrowService().Wake()
+	}
 
 	return res, nil
 }
@@ -413,6 +912,9 @@ func (h *Handlers) SaveFirstname(ctx context.Context, sym string, input []byte)
 	store := h.userdataStore
 	flag_allow_update, _ := h.flagManager.GetFlag("flag_allow_update")
 	allowUpdate := h.st.MatchFlag(flag_allow_update, true)
+	if allowUpdate && !h.isAuthorizationFresh(ctx, sessionId) {
+		return h.expireAuthorization(ctx, res)
+	}
 	if allowUpdate {
 		temporaryFirstName, _ := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
 		err = store.WriteEntry(ctx, sessionId, common.DATA_FIRST_NAME, []byte(temporaryFirstName))
@@ -445,6 +947,9 @@ func (h *Handlers) SaveFamilyname(ctx context.Context, sym string, input []byte)
 
 	flag_allow_update, _ := h.flagManager.GetFlag("flag_allow_update")
 	allowUpdate := h.st.MatchFlag(flag_allow_update, true)
+	if allowUpdate && !h.isAuthorizationFresh(ctx, sessionId) {
+		return h.expireAuthorization(ctx, res)
+	}
 
 	if allowUpdate {
 		temporaryFamilyName, _ := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
@@ -476,6 +981,9 @@ func (h *Handlers) SaveYob(ctx context.Context, sym string, input []byte) (resou
 	store := h.userdataStore
 	flag_allow_update, _ := h.flagManager.GetFlag("flag_allow_update")
 	allowUpdate := h.st.MatchFlag(flag_allow_update, true)
+	if allowUpdate && !h.isAuthorizationFresh(ctx, sessionId) {
+		return h.expireAuthorization(ctx, res)
+	}
 
 	if allowUpdate {
 		temporaryYob, _ := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
@@ -508,6 +1016,9 @@ func (h *Handlers) SaveLocation(ctx context.Context, sym string, input []byte) (
 
 	flag_allow_update, _ := h.flagManager.GetFlag("flag_allow_update")
 	allowUpdate := h.st.MatchFlag(flag_allow_update, true)
+	if allowUpdate && !h.isAuthorizationFresh(ctx, sessionId) {
+		return h.expireAuthorization(ctx, res)
+	}
 
 	if allowUpdate {
 		temporaryLocation, _ := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
@@ -540,6 +1051,9 @@ func (h *Handlers) SaveGender(ctx context.Context, sym string, input []byte) (re
 	store := h.userdataStore
 	flag_allow_update, _ := h.flagManager.GetFlag("flag_allow_update")
 	allowUpdate := h.st.MatchFlag(flag_allow_update, true)
+	if allowUpdate && !h.isAuthorizationFresh(ctx, sessionId) {
+		return h.expireAuthorization(ctx, res)
+	}
 
 	if allowUpdate {
 		temporaryGender, _ := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
@@ -573,6 +1087,9 @@ func (h *Handlers) SaveOfferings(ctx context.Context, sym string, input []byte)
 
 	flag_allow_update, _ := h.flagManager.GetFlag("flag_allow_update")
 	allowUpdate := h.st.MatchFlag(flag_allow_update, true)
+	if allowUpdate && !h.isAuthorizationFresh(ctx, sessionId) {
+		return h.expireAuthorization(ctx, res)
+	}
 
 	if allowUpdate {
 		temporaryOfferings, _ := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
@@ -613,9 +1130,30 @@ func (h *Handlers) ResetAccountAuthorized(ctx context.Context, sym string, input
 	var res resource.Result
 	flag_account_authorized, _ := h.flagManager.GetFlag("flag_account_authorized")
 	res.FlagReset = append(res.FlagReset, flag_account_authorized)
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if ok && h.userdataStore != nil {
+		err := common.RevokeAuthToken(ctx, h.userdataStore, sessionId)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to write auth token entry with", "key", common.DATA_AUTH_TOKEN, "error", err)
+		}
+	}
 	return res, nil
 }
 
+// CheckAuthorization resets the authorization flags if the last PIN entry is older than the configured max age,
+// so that guarded nodes ask for the PIN again.
+func (h *Handlers) CheckAuthorization(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	if h.isAuthorizationFresh(ctx, sessionId) {
+		return res, nil
+	}
+	return h.expireAuthorization(ctx, res)
+}
+
 // CheckIdentifier retrieves the PublicKey from the JSON data file.
 func (h *Handlers) CheckIdentifier(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
@@ -650,8 +1188,14 @@ func (h *Handlers) Authorize(ctx context.Context, sym string, input []byte) (res
 		logg.ErrorCtxf(ctx, "failed to read AccountPin entry with", "key", common.DATA_ACCOUNT_PIN, "error", err)
 		return res, err
 	}
-	if len(input) == 4 {
+	if len(input) >= common.MinPinLength && len(input) <= common.MaxPinLength {
 		if bytes.Equal(input, AccountPin) {
+			t, err := common.IssueAuthToken(ctx, store, sessionId)
+			if err != nil {
+				logg.ErrorCtxf(ctx, "failed to write auth token entry with", "key", common.DATA_AUTH_TOKEN, "error", err)
+				return res, err
+			}
+			logg.DebugCtxf(ctx, "issued auth token", "token", t.Id)
 			if h.st.MatchFlag(flag_account_authorized, false) {
 				res.FlagReset = append(res.FlagReset, flag_incorrect_pin)
 				res.FlagSet = append(res.FlagSet, flag_allow_update, flag_account_authorized)
@@ -678,7 +1222,7 @@ func (h *Handlers) ResetIncorrectPin(ctx context.Context, sym string, input []by
 	return res, nil
 }
 
-// CheckAccountStatus queries the API using the TrackingId and sets flags
+// CheckAccountStatus queries the API through the provisioner and sets flags
 // based on the account status
 func (h *Handlers) CheckAccountStatus(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
@@ -692,15 +1236,12 @@ func (h *Handlers) CheckAccountStatus(ctx context.Context, sym string, input []b
 		return res, fmt.Errorf("missing session")
 	}
 
-	store := h.userdataStore
-	publicKey, err := store.ReadEntry(ctx, sessionId, common.DATA_PUBLIC_KEY)
-	if err != nil {
-		logg.ErrorCtxf(ctx, "failed to read publicKey entry with", "key", common.DATA_PUBLIC_KEY, "error", err)
-		return res, err
-	}
-
-	r, err := h.accountService.TrackAccountStatus(ctx, string(publicKey))
+	st, err := h.getProvisioner().Check(ctx, sessionId)
 	if err != nil {
+		if st == provision.StateNone {
+			logg.ErrorCtxf(ctx, "failed to read publicKey entry with", "key", common.DATA_PUBLIC_KEY, "error", err)
+			return res, err
+		}
 		res.FlagSet = append(res.FlagSet, flag_api_error)
 		logg.ErrorCtxf(ctx, "failed on TrackAccountStatus", "error", err)
 		return res, err
@@ -708,7 +1249,7 @@ func (h *Handlers) CheckAccountStatus(ctx context.Context, sym string, input []b
 
 	res.FlagReset = append(res.FlagReset, flag_api_error)
 
-	if r.Active {
+	if st == provision.StateActive {
 		res.FlagSet = append(res.FlagSet, flag_account_success)
 		res.FlagReset = append(res.FlagReset, flag_account_pending)
 	} else {
@@ -782,7 +1323,10 @@ func (h *Handlers) ResetIncorrectYob(ctx context.Context, sym string, input []by
 }
 
 // CheckBalance retrieves the balance of the active voucher and sets
-// the balance as the result content
+// the balance as the result content.
+//
+// The balance is refreshed if it is outdated. If it cannot be refreshed, the last known balance is shown
+// with the time it was last updated.
 func (h *Handlers) CheckBalance(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 	var err error
@@ -811,25 +1355,77 @@ func (h *Handlers) CheckBalance(ctx context.Context, sym string, input []byte) (
 		return res, err
 	}
 
-	activeBal, err := store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_BAL)
+	b, err := h.getBalanceService().Get(ctx, sessionId)
 	if err != nil {
 		logg.ErrorCtxf(ctx, "failed to read activeBal entry with", "key", common.DATA_ACTIVE_BAL, "error", err)
 		return res, err
 	}
 
-	res.Content = l.Get("Balance: %s\n", fmt.Sprintf("%s %s", activeBal, activeSym))
+	res.Content = l.Get("Balance: %s\n", fmt.Sprintf("%s %s", b.Amount, activeSym))
+	if b.Stale && !b.UpdatedAt.IsZero() {
+		res.Content += l.Get("Last updated: %s\n", b.UpdatedAt.Format("2006-01-02 15:04"))
+	}
 
 	return res, nil
 }
 
+// FetchCommunityBalance sets the pooled balance and the recent transfers of the community managed by the user
+// as the result content.
+//
+// Users who do not manage a community are told that only managers can see it.
 func (h *Handlers) FetchCommunityBalance(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_api_error, _ := h.flagManager.GetFlag("flag_api_call_error")
+
 	code := codeFromCtx(ctx)
 	l := gotext.NewLocale(translationDir, code)
 	l.AddDomain("default")
-	//TODO:
-	//Check if the address is a community account,if then,get the actual balance
-	res.Content = l.Get("Community Balance: 0.00")
+
+	c, ok := h.getCommunityRegistry().ForManager(sessionId)
+	if !ok {
+		res.Content = l.Get("Only community managers can see the community balance.")
+		return res, nil
+	}
+
+	holdings, err := h.accountService.FetchVouchers(ctx, c.Address)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed on FetchVouchers", "community", c.Address, "error", err)
+		res.FlagSet = append(res.FlagSet, flag_api_error)
+		return res, nil
+	}
+	transfers, err := h.accountService.FetchTransactions(ctx, c.Address)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed on FetchTransactions", "community", c.Address, "error", err)
+		res.FlagSet = append(res.FlagSet, flag_api_error)
+		return res, nil
+	}
+
+	lines := []string{l.Get("%s balance:", c.Name)}
+	pooled := c.Pooled(holdings)
+	for _, v := range pooled {
+		lines = append(lines, fmt.Sprintf("%s %s", common.ScaleDownBalance(v.Balance, v.TokenDecimals), v.TokenSymbol))
+	}
+	if len(pooled) == 0 {
+		lines = append(lines, "0")
+	}
+	recent := c.Recent(transfers, communityTransferCount)
+	if len(recent) > 0 {
+		lines = append(lines, l.Get("Recent transfers:"))
+	}
+	for _, t := range recent {
+		sign := "+"
+		if c.Outgoing(t) {
+			sign = "-"
+		}
+		lines = append(lines, fmt.Sprintf("%s%s %s %s", sign, common.ScaleDownBalance(t.TransferValue, t.TokenDecimals), t.TokenSymbol, t.DateBlock.Format("2006-01-02")))
+	}
+	res.Content = strings.Join(lines, "\n")
+	res.FlagReset = append(res.FlagReset, flag_api_error)
 	return res, nil
 }
 
@@ -854,6 +1450,7 @@ func (h *Handlers) ResetOthersPin(ctx context.Context, sym string, input []byte)
 	if err != nil {
 		return res, nil
 	}
+	h.recordPin(ctx, string(blockedPhonenumber), temporaryPin)
 
 	return res, nil
 }
@@ -898,95 +1495,2822 @@ func (h *Handlers) ValidateBlockedNumber(ctx context.Context, sym string, input
 	return res, nil
 }
 
-// ValidateRecipient validates that the given input is a valid phone number.
-func (h *Handlers) ValidateRecipient(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+// CheckFreeze sets the flag_account_frozen flag if outgoing transfers are blocked for the account,
+// with the freeze status as the result content.
+func (h *Handlers) CheckFreeze(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
-	var err error
-	store := h.userdataStore
 
 	sessionId, ok := ctx.Value("SessionId").(string)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
+	flag_account_frozen, _ := h.flagManager.GetFlag("flag_account_frozen")
 
-	recipient := string(input)
+	frozenAt, frozen, err := common.ReadFreeze(ctx, h.userdataStore, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read freeze entry with", "key", common.DATA_ACCOUNT_FROZEN, "error", err)
+		return res, err
+	}
+	if !frozen {
+		res.FlagReset = append(res.FlagReset, flag_account_frozen)
+		return res, nil
+	}
 
-	flag_invalid_recipient, _ := h.flagManager.GetFlag("flag_invalid_recipient")
-	flag_invalid_recipient_with_invite, _ := h.flagManager.GetFlag("flag_invalid_recipient_with_invite")
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
 
-	if recipient != "0" {
-		if !isValidPhoneNumber(recipient) {
-			res.FlagSet = append(res.FlagSet, flag_invalid_recipient)
-			res.Content = recipient
+	res.FlagSet = append(res.FlagSet, flag_account_frozen)
+	allowedAt := common.UnfreezeAllowedAt(frozenAt, freezeCoolingOff())
+	if time.Now().Before(allowedAt) {
+		res.Content = l.Get("Your account is frozen. You can unfreeze it with your PIN after %s.", allowedAt.Format("2006-01-02 15:04"))
+	} else {
+		res.Content = l.Get("Your account is frozen. You can unfreeze it with your PIN.")
+	}
+	return res, nil
+}
 
-			return res, nil
-		}
+// CheckSimSwap sets flag_sim_swapped if the SIM of the number was changed recently, blocking transfers.
+func (h *Handlers) CheckSimSwap(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
 
-		// save the recipient as the temporaryRecipient
-		err = store.WriteEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE, []byte(recipient))
-		if err != nil {
-			logg.ErrorCtxf(ctx, "failed to write temporaryRecipient entry with", "key", common.DATA_TEMPORARY_VALUE, "value", recipient, "error", err)
-			return res, err
-		}
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_sim_swapped, _ := h.flagManager.GetFlag("flag_sim_swapped")
 
-		publicKey, err := store.ReadEntry(ctx, recipient, common.DATA_PUBLIC_KEY)
-		if err != nil {
-			if db.IsNotFound(err) {
-				logg.InfoCtxf(ctx, "Unregistered number")
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
 
-				res.FlagSet = append(res.FlagSet, flag_invalid_recipient_with_invite)
-				res.Content = recipient
+	res.Content = h.checkSimSwap(ctx, sessionId, l)
+	if res.Content == "" {
+		res.FlagReset = append(res.FlagReset, flag_sim_swapped)
+		return res, nil
+	}
+	res.FlagSet = append(res.FlagSet, flag_sim_swapped)
+	return res, nil
+}
 
-				return res, nil
-			}
+// FreezeAccount freezes the account when the user confirms, blocking outgoing transfers.
+// The PIN authorization is revoked, so that the PIN has to be entered again.
+func (h *Handlers) FreezeAccount(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
 
-			logg.ErrorCtxf(ctx, "failed to read publicKey entry with", "key", common.DATA_PUBLIC_KEY, "error", err)
-			return res, err
-		}
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	if string(input) != "1" {
+		return res, nil
+	}
+	flag_account_frozen, _ := h.flagManager.GetFlag("flag_account_frozen")
+	flag_account_authorized, _ := h.flagManager.GetFlag("flag_account_authorized")
+	flag_allow_update, _ := h.flagManager.GetFlag("flag_allow_update")
 
-		err = store.WriteEntry(ctx, sessionId, common.DATA_RECIPIENT, publicKey)
-		if err != nil {
-			logg.ErrorCtxf(ctx, "failed to write recipient entry with", "key", common.DATA_RECIPIENT, "value", string(publicKey), "error", err)
-			return res, nil
-		}
+	frozenAt, err := common.FreezeAccount(ctx, h.userdataStore, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write freeze entry with", "key", common.DATA_ACCOUNT_FROZEN, "error", err)
+		return res, err
+	}
+	logg.InfoCtxf(ctx, "account frozen", "session", sessionId, "frozenAt", frozenAt)
+
+	err = common.RevokeAuthToken(ctx, h.userdataStore, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write auth token entry with", "key", common.DATA_AUTH_TOKEN, "error", err)
 	}
 
+	res.FlagSet = append(res.FlagSet, flag_account_frozen)
+	res.FlagReset = append(res.FlagReset, flag_account_authorized, flag_allow_update)
 	return res, nil
 }
 
-// TransactionReset resets the previous transaction data (Recipient and Amount)
-// as well as the invalid flags
-func (h *Handlers) TransactionReset(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+// UnfreezeAccount lifts the freeze of the account with the PIN, once the cooling-off period has passed.
+func (h *Handlers) UnfreezeAccount(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
-	var err error
 
 	sessionId, ok := ctx.Value("SessionId").(string)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
+	flag_account_frozen, _ := h.flagManager.GetFlag("flag_account_frozen")
+	flag_freeze_cooling_off, _ := h.flagManager.GetFlag("flag_freeze_cooling_off")
+	flag_incorrect_pin, _ := h.flagManager.GetFlag("flag_incorrect_pin")
+	store := h.userdataStore
 
-	flag_invalid_recipient, _ := h.flagManager.GetFlag("flag_invalid_recipient")
-	flag_invalid_recipient_with_invite, _ := h.flagManager.GetFlag("flag_invalid_recipient_with_invite")
+	frozenAt, frozen, err := common.ReadFreeze(ctx, store, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read freeze entry with", "key", common.DATA_ACCOUNT_FROZEN, "error", err)
+		return res, err
+	}
+	if !frozen {
+		res.FlagReset = append(res.FlagReset, flag_account_frozen, flag_freeze_cooling_off)
+		return res, nil
+	}
+
+	allowedAt := common.UnfreezeAllowedAt(frozenAt, freezeCoolingOff())
+	if time.Now().Before(allowedAt) {
+		code := codeFromCtx(ctx)
+		l := gotext.NewLocale(translationDir, code)
+		l.AddDomain("default")
+
+		res.FlagSet = append(res.FlagSet, flag_freeze_cooling_off)
+		res.Content = l.Get("You can unfreeze your account after %s. Contact support to unfreeze it sooner.", allowedAt.Format("2006-01-02 15:04"))
+		return res, nil
+	}
+
+	AccountPin, err := store.ReadEntry(ctx, sessionId, common.DATA_ACCOUNT_PIN)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read AccountPin entry with", "key", common.DATA_ACCOUNT_PIN, "error", err)
+		return res, err
+	}
+	if !bytes.Equal(input, AccountPin) {
+		res.FlagSet = append(res.FlagSet, flag_incorrect_pin)
+		return res, nil
+	}
+
+	err = common.UnfreezeAccount(ctx, store, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write freeze entry with", "key", common.DATA_ACCOUNT_FROZEN, "error", err)
+		return res, err
+	}
+	logg.InfoCtxf(ctx, "account unfrozen", "session", sessionId, "frozenAt", frozenAt)
+
+	res.FlagReset = append(res.FlagReset, flag_account_frozen, flag_freeze_cooling_off, flag_incorrect_pin)
+	return res, nil
+}
+
+// UnfreezeOthersAccount lifts the freeze of the account whose number was entered by an admin.
+func (h *Handlers) UnfreezeOthersAccount(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
 	store := h.userdataStore
-	err = store.WriteEntry(ctx, sessionId, common.DATA_AMOUNT, []byte(""))
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	if string(input) != "1" {
+		return res, nil
+	}
+	isAdmin, err := h.adminstore.IsAdmin(sessionId)
+	if err != nil {
+		return res, err
+	}
+	if !isAdmin {
+		flag_admin_privilege, _ := h.flagManager.GetFlag("flag_admin_privilege")
+		res.FlagReset = append(res.FlagReset, flag_admin_privilege)
+		return res, nil
+	}
+	frozenNumber, err := store.ReadEntry(ctx, sessionId, common.DATA_BLOCKED_NUMBER)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read frozenNumber entry with", "key", common.DATA_BLOCKED_NUMBER, "error", err)
+		return res, err
+	}
+	err = common.UnfreezeAccount(ctx, store, string(frozenNumber))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write freeze entry with", "key", common.DATA_ACCOUNT_FROZEN, "error", err)
+		return res, err
+	}
+	logg.InfoCtxf(ctx, "account unfrozen by admin", "session", string(frozenNumber), "admin", sessionId)
+	return res, nil
+}
+
+// GetGuardians returns the numbered list of the guardians of the account.
+func (h *Handlers) GetGuardians(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	guardians, err := common.ReadGuardians(ctx, h.userdataStore, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read guardians entry with", "key", common.DATA_GUARDIANS, "error", err)
+		return res, err
+	}
+	if len(guardians) == 0 {
+		code := codeFromCtx(ctx)
+		l := gotext.NewLocale(translationDir, code)
+		l.AddDomain("default")
+		res.Content = l.Get("You have no guardians.")
+		return res, nil
+	}
+	var lines []string
+	for i, g := range guardians {
+		lines = append(lines, fmt.Sprintf("%d:%s", i+1, g))
+	}
+	res.Content = strings.Join(lines, "\n")
+	return res, nil
+}
+
+// AddGuardian adds the registered user with the given phone number as a guardian of the account.
+// If the guardian is rejected, the flag_invalid_guardian flag is set with the reason as the result content.
+func (h *Handlers) AddGuardian(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_invalid_guardian, _ := h.flagManager.GetFlag("flag_invalid_guardian")
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	guardian := strings.TrimSpace(string(input))
+	if !isValidPhoneNumber(guardian) {
+		res.FlagSet = append(res.FlagSet, flag_invalid_guardian)
+		res.Content = l.Get("%s is not a valid phone number.", guardian)
+		return res, nil
+	}
+	err := common.AddGuardian(ctx, h.userdataStore, sessionId, guardian)
+	switch err {
+	case nil:
+	case common.ErrGuardianSelf:
+		res.Content = l.Get("You cannot be your own guardian.")
+	case common.ErrGuardianUnregistered:
+		res.Content = l.Get("%s is not registered with Sarafu.", guardian)
+	case common.ErrGuardianExists:
+		res.Content = l.Get("%s is already your guardian.", guardian)
+	case common.ErrGuardianLimit:
+		res.Content = l.Get("You can have at most %d guardians.", common.MaxGuardians)
+	default:
+		logg.ErrorCtxf(ctx, "failed to write guardians entry with", "key", common.DATA_GUARDIANS, "error", err)
+		return res, err
+	}
+	if err != nil {
+		res.FlagSet = append(res.FlagSet, flag_invalid_guardian)
+		return res, nil
+	}
+	logg.InfoCtxf(ctx, "guardian added", "session", sessionId, "guardian", guardian)
+
+	err = h.getNotifier().Notify(ctx, guardian, notify.Locale(ctx, h.userdataStore, guardian).Get("%s has added you as a guardian on Sarafu. You may be asked to approve a PIN reset for them.", sessionId))
+	if err != nil {
+		logg.WarnCtxf(ctx, "guardian notification failed", "guardian", guardian, "error", err)
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_invalid_guardian)
+	res.Content = guardian
+	return res, nil
+}
+
+// RemoveGuardian removes the guardian selected by its position in the list returned by GetGuardians.
+func (h *Handlers) RemoveGuardian(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_invalid_guardian, _ := h.flagManager.GetFlag("flag_invalid_guardian")
+	store := h.userdataStore
+
+	guardians, err := common.ReadGuardians(ctx, store, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read guardians entry with", "key", common.DATA_GUARDIANS, "error", err)
+		return res, err
+	}
+	i, err := strconv.Atoi(string(input))
+	if err != nil || i < 1 || i > len(guardians) {
+		res.FlagSet = append(res.FlagSet, flag_invalid_guardian)
+		return res, nil
+	}
+	guardian := guardians[i-1]
+	err = common.RemoveGuardian(ctx, store, sessionId, guardian)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write guardians entry with", "key", common.DATA_GUARDIANS, "error", err)
+		return res, err
+	}
+	logg.InfoCtxf(ctx, "guardian removed", "session", sessionId, "guardian", guardian)
+
+	res.FlagReset = append(res.FlagReset, flag_invalid_guardian)
+	res.Content = guardian
+	return res, nil
+}
+
+// RequestRecovery asks the guardians of the account to approve a PIN reset, when the user confirms.
+// If recovery is not possible, the flag_recovery_rejected flag is set with the reason as the result content.
+func (h *Handlers) RequestRecovery(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_recovery_rejected, _ := h.flagManager.GetFlag("flag_recovery_rejected")
+	if string(input) != "1" {
+		res.FlagReset = append(res.FlagReset, flag_recovery_rejected)
+		return res, nil
+	}
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	quorum := recoveryQuorum()
+	_, created, err := common.RequestRecovery(ctx, h.userdataStore, sessionId, quorum)
+	if err != nil {
+		if err == common.ErrTooFewGuardians {
+			res.FlagSet = append(res.FlagSet, flag_recovery_rejected)
+			res.Content = l.Get("You need at least %d guardians to recover your account. Please contact support.", quorum)
+			return res, nil
+		}
+		logg.ErrorCtxf(ctx, "failed to write recovery request entry with", "key", common.DATA_RECOVERY_REQUEST, "error", err)
+		return res, err
+	}
+
+	if created {
+		logg.InfoCtxf(ctx, "account recovery requested", "session", sessionId)
+		guardians, err := common.ReadGuardians(ctx, h.userdataStore, sessionId)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to read guardians entry with", "key", common.DATA_GUARDIANS, "error", err)
+			return res, err
+		}
+		for _, g := range guardians {
+			err = h.getNotifier().Notify(ctx, g, notify.Locale(ctx, h.userdataStore, g).Get("%s has asked you to approve a PIN reset. Dial in and choose PIN options > Guardians > Approve recovery.", sessionId))
+			if err != nil {
+				logg.WarnCtxf(ctx, "recovery notification failed", "guardian", g, "error", err)
+			}
+		}
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_recovery_rejected)
+	res.Content = l.Get("Your guardians have been asked to approve your PIN reset. You will get a temporary PIN by SMS once %d of them approve.", quorum)
+	return res, nil
+}
+
+// ApproveRecovery records the approval of the recovery request of the account with the given phone number,
+// by the guardian in the current session. When enough guardians have approved, a temporary PIN is issued
+// and sent to the account by SMS, which has to be changed the next time the user dials in.
+func (h *Handlers) ApproveRecovery(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+	store := h.userdataStore
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_recovery_rejected, _ := h.flagManager.GetFlag("flag_recovery_rejected")
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	requester := strings.TrimSpace(string(input))
+	r, err := common.ApproveRecovery(ctx, store, requester, sessionId)
+	switch err {
+	case nil:
+	case common.ErrNotGuardian:
+		res.Content = l.Get("You are not a guardian of %s.", requester)
+	case common.ErrNoRecoveryRequest:
+		res.Content = l.Get("%s has not asked for account recovery.", requester)
+	default:
+		logg.ErrorCtxf(ctx, "failed to write recovery request entry with", "key", common.DATA_RECOVERY_REQUEST, "error", err)
+		return res, err
+	}
+	if err != nil {
+		res.FlagSet = append(res.FlagSet, flag_recovery_rejected)
+		return res, nil
+	}
+	logg.InfoCtxf(ctx, "account recovery approved", "session", requester, "guardian", sessionId, "approvals", len(r.Approvals))
+	res.FlagReset = append(res.FlagReset, flag_recovery_rejected)
+
+	if len(r.Approvals) < recoveryQuorum() {
+		res.Content = l.Get("Thank you. Your approval for %s has been recorded.", requester)
+		return res, nil
+	}
+
+	temporaryPin, err := h.getPinPolicy().Generate()
+	if err != nil {
+		return res, err
+	}
+	err = store.WriteEntry(ctx, requester, common.DATA_ACCOUNT_PIN, []byte(temporaryPin))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write AccountPin entry with", "key", common.DATA_ACCOUNT_PIN, "error", err)
+		return res, err
+	}
+	h.recordPin(ctx, requester, []byte(temporaryPin))
+	// the temporary PIN has been sent by SMS, so it must be replaced the next time the user dials in
+	err = common.RequirePinChange(ctx, store, requester)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write pin change entry with", "key", common.DATA_PIN_CHANGE_REQUIRED, "error", err)
+		return res, err
+	}
+	err = common.ClearRecoveryRequest(ctx, store, requester)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write recovery request entry with", "key", common.DATA_RECOVERY_REQUEST, "error", err)
+	}
+	logg.InfoCtxf(ctx, "account recovered by guardians", "session", requester, "guardians", r.Approvals)
+
+	err = h.getNotifier().Notify(ctx, requester, notify.Locale(ctx, h.userdataStore, requester).Get("Your guardians have approved your PIN reset. Your temporary PIN is %s. You will be asked to choose a new PIN when you log in.", temporaryPin))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "temporary pin notification failed", "session", requester, "error", err)
+	}
+
+	res.Content = l.Get("Thank you. The PIN of %s has been reset.", requester)
+	return res, nil
+}
+
+// CheckPorted sets flag_account_ported if the account of the number has moved to a new number.
+func (h *Handlers) CheckPorted(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_account_ported, _ := h.flagManager.GetFlag("flag_account_ported")
+
+	to, err := common.ReadPortedTo(ctx, h.userdataStore, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read ported entry with", "key", common.DATA_PORTED_TO, "error", err)
+		return res, err
+	}
+	if to == "" {
+		res.FlagReset = append(res.FlagReset, flag_account_ported)
+		return res, nil
+	}
+	res.FlagSet = append(res.FlagSet, flag_account_ported)
+	return res, nil
+}
+
+// numberChangeReason explains in the user's language why the number change was rejected.
+func numberChangeReason(l *gotext.Locale, err error) string {
+	switch err {
+	case common.ErrPortSameNumber:
+		return l.Get("This is already your number.")
+	case common.ErrPortTargetInUse:
+		return l.Get("The new number already has an account.")
+	case common.ErrNoNumberChange:
+		return l.Get("The code has expired. Please start again.")
+	case common.ErrNumberChangeCode:
+		return l.Get("The code is not correct.")
+	}
+	return l.Get("Your account cannot be moved. Please contact support.")
+}
+
+// RequestNumberChange sends a code to the new number the user wants to move the account to.
+// If the number is rejected, the flag_number_change_rejected flag is set with the reason as the result content.
+func (h *Handlers) RequestNumberChange(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_number_change_rejected, _ := h.flagManager.GetFlag("flag_number_change_rejected")
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	if !h.isAuthorizationFresh(ctx, sessionId) {
+		res.Content = l.Get("Your session has expired. Please enter your PIN again.")
+		res.FlagSet = append(res.FlagSet, flag_number_change_rejected)
+		return h.expireAuthorization(ctx, res)
+	}
+
+	to := string(input)
+	if !isValidPhoneNumber(to) {
+		res.FlagSet = append(res.FlagSet, flag_number_change_rejected)
+		res.Content = l.Get("%s is not a valid phone number.", to)
+		return res, nil
+	}
+
+	changeCode, err := common.RequestNumberChange(ctx, h.userdataStore, sessionId, to)
+	if err != nil {
+		if err == common.ErrPortSameNumber || err == common.ErrPortTargetInUse || err == common.ErrPortNoAccount {
+			res.FlagSet = append(res.FlagSet, flag_number_change_rejected)
+			res.Content = numberChangeReason(l, err)
+			return res, nil
+		}
+		logg.ErrorCtxf(ctx, "failed to write number change entry with", "key", common.DATA_NUMBER_CHANGE, "error", err)
+		return res, err
+	}
+
+	// the new number has not chosen a language, so the notification is in the language of the sender
+	err = h.getNotifier().Notify(ctx, to, l.Get("Your code to move the account of %s to this number is %s. Do not share it with anyone.", sessionId, changeCode))
+	if err != nil {
+		logg.WarnCtxf(ctx, "number change notification failed", "to", to, "error", err)
+	}
+	logg.InfoCtxf(ctx, "number change requested", "session", sessionId, "to", to)
+
+	res.FlagReset = append(res.FlagReset, flag_number_change_rejected)
+	return res, nil
+}
+
+// ConfirmNumberChange moves the account to the new number when the code sent to it is entered.
+// If the code is rejected, the flag_number_change_rejected flag is set with the reason as the result content.
+func (h *Handlers) ConfirmNumberChange(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_number_change_rejected, _ := h.flagManager.GetFlag("flag_number_change_rejected")
+	flag_account_authorized, _ := h.flagManager.GetFlag("flag_account_authorized")
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	if !h.isAuthorizationFresh(ctx, sessionId) {
+		res.Content = l.Get("Your session has expired. Please enter your PIN again.")
+		res.FlagSet = append(res.FlagSet, flag_number_change_rejected)
+		return h.expireAuthorization(ctx, res)
+	}
+
+	to, err := common.ConfirmNumberChange(ctx, h.userdataStore, sessionId, string(input))
+	if err != nil {
+		if err == common.ErrNoNumberChange || err == common.ErrNumberChangeCode {
+			logg.WarnCtxf(ctx, "number change code rejected", "session", sessionId, "error", err)
+			res.FlagSet = append(res.FlagSet, flag_number_change_rejected)
+			res.Content = numberChangeReason(l, err)
+			return res, nil
+		}
+		logg.ErrorCtxf(ctx, "failed to read number change entry with", "key", common.DATA_NUMBER_CHANGE, "error", err)
+		return res, err
+	}
+
+	err = common.PortAccount(ctx, h.userdataStore, h.stateStore, sessionId, to)
+	if err != nil {
+		if err == common.ErrPortTargetInUse || err == common.ErrPortNoAccount {
+			res.FlagSet = append(res.FlagSet, flag_number_change_rejected)
+			res.Content = numberChangeReason(l, err)
+			return res, nil
+		}
+		logg.ErrorCtxf(ctx, "failed to move account", "session", sessionId, "to", to, "error", err)
+		return res, err
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_number_change_rejected, flag_account_authorized)
+	res.Content = l.Get("Your account has moved to %s. Please dial in from your new number.", to)
+	return res, nil
+}
+
+// CheckClosed sets the flag_account_closed flag when the account of the number has been closed.
+func (h *Handlers) CheckClosed(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_account_closed, _ := h.flagManager.GetFlag("flag_account_closed")
+
+	_, closed, err := common.ReadClosure(ctx, h.userdataStore, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read closure entry with", "key", common.DATA_ACCOUNT_CLOSED, "error", err)
+		return res, err
+	}
+	if !closed {
+		res.FlagReset = append(res.FlagReset, flag_account_closed)
+		return res, nil
+	}
+	res.FlagSet = append(res.FlagSet, flag_account_closed)
+	return res, nil
+}
+
+// CheckClosureBalances fetches the vouchers held by the account before it is closed.
+// If any voucher has a balance, the flag_closure_balance flag is set with the balances as the result content.
+func (h *Handlers) CheckClosureBalances(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_closure_balance, _ := h.flagManager.GetFlag("flag_closure_balance")
+	flag_api_call_error, _ := h.flagManager.GetFlag("flag_api_call_error")
+
+	publicKey, err := h.userdataStore.ReadEntry(ctx, sessionId, common.DATA_PUBLIC_KEY)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read publicKey entry with", "key", common.DATA_PUBLIC_KEY, "error", err)
+		return res, err
+	}
+	holdings, err := h.accountService.FetchVouchers(ctx, string(publicKey))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed on FetchVouchers", "error", err)
+		res.FlagSet = append(res.FlagSet, flag_api_call_error)
+		return res, nil
+	}
+	res.FlagReset = append(res.FlagReset, flag_api_call_error)
+
+	held := common.HeldVouchers(holdings)
+	if len(held) == 0 {
+		res.FlagReset = append(res.FlagReset, flag_closure_balance)
+		return res, nil
+	}
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	var lines []string
+	for _, v := range held {
+		lines = append(lines, fmt.Sprintf("%s %s", common.ScaleDownBalance(v.Balance, v.TokenDecimals), v.TokenSymbol))
+	}
+	res.Content = l.Get("You still hold:\n%s\nSend it to another account before closing.", strings.Join(lines, "\n"))
+	res.FlagSet = append(res.FlagSet, flag_closure_balance)
+	return res, nil
+}
+
+// resolveTransferRecipient returns the address of the phone number, alias or address a transfer is sent to.
+// If the recipient is rejected, the returned string explains why in the user's language.
+func (h *Handlers) resolveTransferRecipient(ctx context.Context, sessionId string, recipient string, l *gotext.Locale) (string, string, error) {
+	store := h.userdataStore
+
+	publicKey, err := store.ReadEntry(ctx, sessionId, common.DATA_PUBLIC_KEY)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read publicKey entry with", "key", common.DATA_PUBLIC_KEY, "error", err)
+		return "", "", err
+	}
+
+	if strings.HasPrefix(recipient, "0x") {
+		if !common.IsValidAddress(recipient) {
+			return "", l.Get("%s is not a valid recipient.", recipient), nil
+		}
+		address, err := common.ToChecksumAddress(recipient)
+		if err != nil {
+			return "", l.Get("%s is not a valid recipient.", recipient), nil
+		}
+		if strings.EqualFold(address, string(publicKey)) {
+			return "", l.Get("You cannot send to your own account."), nil
+		}
+		return address, "", nil
+	}
+
+	var holder string
+	alias := common.NormalizeAlias(recipient)
+	if common.IsValidAlias(alias) {
+		holder, err = common.ResolveAlias(ctx, store, alias)
+		if err != nil {
+			if db.IsNotFound(err) {
+				return "", l.Get("%s is not registered with Sarafu.", recipient), nil
+			}
+			logg.ErrorCtxf(ctx, "failed to resolve alias", "alias", alias, "error", err)
+			return "", "", err
+		}
+	} else {
+		if !isValidPhoneNumber(recipient) {
+			return "", l.Get("%s is not a valid recipient.", recipient), nil
+		}
+		holder, err = common.ResolvePorted(ctx, store, recipient)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to resolve ported number", "recipient", recipient, "error", err)
+			return "", "", err
+		}
+	}
+	if holder == sessionId {
+		return "", l.Get("You cannot send to your own account."), nil
+	}
+	_, closed, err := common.ReadClosure(ctx, store, holder)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read closure entry with", "key", common.DATA_ACCOUNT_CLOSED, "error", err)
+		return "", "", err
+	}
+	if closed {
+		return "", l.Get("The account of %s is closed.", recipient), nil
+	}
+	recipientKey, err := store.ReadEntry(ctx, holder, common.DATA_PUBLIC_KEY)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return "", l.Get("%s is not registered with Sarafu.", recipient), nil
+		}
+		logg.ErrorCtxf(ctx, "failed to read publicKey entry with", "key", common.DATA_PUBLIC_KEY, "error", err)
+		return "", "", err
+	}
+	return string(recipientKey), "", nil
+}
+
+// SetClosureRecipient saves the phone number, alias or address the balances are sent to before the account is closed.
+// If the recipient is rejected, the flag_invalid_recipient flag is set with the reason as the result content.
+func (h *Handlers) SetClosureRecipient(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_invalid_recipient, _ := h.flagManager.GetFlag("flag_invalid_recipient")
+	store := h.userdataStore
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	recipient := string(input)
+	address, reason, err := h.resolveTransferRecipient(ctx, sessionId, recipient, l)
+	if err != nil {
+		return res, err
+	}
+	if reason != "" {
+		res.FlagSet = append(res.FlagSet, flag_invalid_recipient)
+		res.Content = reason
+		return res, nil
+	}
+
+	err = store.WriteEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE, []byte(recipient))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write temporaryRecipient entry with", "key", common.DATA_TEMPORARY_VALUE, "value", recipient, "error", err)
+		return res, err
+	}
+	err = store.WriteEntry(ctx, sessionId, common.DATA_RECIPIENT, []byte(address))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write recipient entry with", "key", common.DATA_RECIPIENT, "value", address, "error", err)
+		return res, err
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_invalid_recipient)
+	res.Content = recipient
+	return res, nil
+}
+
+// TransferClosureBalances sends the whole balance of every voucher held by the account to the saved recipient, when the user confirms.
+// If the transfers are not allowed, the flag_closure_balance flag is set with the reason as the result content.
+//
+// Every transfer is checked against the transfer rules before any of them is made. If the rules ask for a confirmation,
+// the transfers are only made once the user has confirmed them.
+func (h *Handlers) TransferClosureBalances(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	if string(input) != "1" {
+		return res, nil
+	}
+	flag_closure_balance, _ := h.flagManager.GetFlag("flag_closure_balance")
+	flag_api_call_error, _ := h.flagManager.GetFlag("flag_api_call_error")
+	flag_transfer_confirmed, _ := h.flagManager.GetFlag("flag_transfer_confirmed")
+	store := h.userdataStore
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	res, err := h.checkTransferAccount(ctx, sessionId, l)
+	if err != nil {
+		return res, err
+	}
+	if res.Content != "" {
+		res.FlagSet = append(res.FlagSet, flag_closure_balance)
+		return res, nil
+	}
+
+	publicKey, err := store.ReadEntry(ctx, sessionId, common.DATA_PUBLIC_KEY)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read publicKey entry with", "key", common.DATA_PUBLIC_KEY, "error", err)
+		return res, err
+	}
+	recipient, err := store.ReadEntry(ctx, sessionId, common.DATA_RECIPIENT)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read recipient entry with", "key", common.DATA_RECIPIENT, "error", err)
+		return res, err
+	}
+	temporaryValue, err := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read temporaryValue entry with", "key", common.DATA_TEMPORARY_VALUE, "error", err)
+		return res, err
+	}
+
+	holdings, err := h.accountService.FetchVouchers(ctx, string(publicKey))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed on FetchVouchers", "error", err)
+		res.FlagSet = append(res.FlagSet, flag_api_call_error)
+		return res, nil
+	}
+	held := common.HeldVouchers(holdings)
+
+	transfers, d, voucher, err := h.evaluateClosure(ctx, sessionId, held, string(recipient))
+	if err != nil {
+		return res, err
+	}
+	confirmed := h.st != nil && h.st.MatchFlag(flag_transfer_confirmed, true)
+	if d.Action == risk.Deny || (d.Action == risk.Confirm && !confirmed) {
+		logg.WarnCtxf(ctx, "closure transfer blocked", "session", sessionId, "voucher", voucher, "rule", d.Rule, "action", d.Action)
+		res.Content = riskReason(l, d, voucher)
+		res.FlagSet = append(res.FlagSet, flag_closure_balance)
+		return res, nil
+	}
+
+	ledger := risk.NewLedger(store)
+	for i, v := range held {
+		r, err := h.accountService.TokenTransfer(ctx, v.Balance, string(publicKey), string(recipient), v.ContractAddress)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed on TokenTransfer", "voucher", v.TokenSymbol, "error", err)
+			res.FlagSet = append(res.FlagSet, flag_api_call_error)
+			res.Content = l.Get("Your request failed. Please try again later.")
+			return res, nil
+		}
+		logg.InfoCtxf(ctx, "TokenTransfer", "trackingId", r.TrackingId, "voucher", v.TokenSymbol)
+		err = ledger.Record(ctx, sessionId, transfers[i])
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to write transfer log entry with", "key", common.DATA_TRANSFER_LOG, "error", err)
+		}
+		h.invalidateBalance(ctx, sessionId)
+	}
+
+	res.Content = l.Get("Your balances have been sent to %s.", string(temporaryValue))
+	res.FlagReset = append(res.FlagReset, flag_closure_balance, flag_transfer_confirmed)
+	return res, nil
+}
+
+// evaluateClosure evaluates the transfers of the whole balance of each held voucher to the recipient address against
+// the transfer risk rules. It returns the transfers, and the most severe decision with the voucher it was made for.
+//
+// The transfers sweep the balances of an account that is being closed, so the amount limits do not apply to them.
+func (h *Handlers) evaluateClosure(ctx context.Context, sessionId string, held []dataserviceapi.TokenHoldings, recipient string) ([]risk.Transfer, risk.Decision, string, error) {
+	var r risk.Decision
+	var voucher string
+
+	history, err := risk.NewLedger(h.userdataStore).History(ctx, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read transfer log entry with", "key", common.DATA_TRANSFER_LOG, "error", err)
+		return nil, r, "", err
+	}
+	var transfers []risk.Transfer
+	for _, v := range held {
+		amount, err := common.NewAmount(v.Balance, v.TokenDecimals)
+		if err != nil {
+			return nil, r, "", err
+		}
+		t := risk.Transfer{
+			Time:      time.Now(),
+			Voucher:   v.TokenSymbol,
+			Amount:    amount,
+			Recipient: recipient,
+			Sweep:     true,
+		}
+		d := h.getRiskEngine().Evaluate(ctx, sessionId, t, history)
+		if d.Action > r.Action {
+			r = d
+			voucher = v.TokenSymbol
+		}
+		transfers = append(transfers, t)
+	}
+	return transfers, r, voucher, nil
+}
+
+// CheckClosureRisk evaluates the transfers of the balances to the saved recipient before the account is closed.
+// If the transfer rules ask for a confirmation, the flag_transfer_confirm flag is set with the reason as the result content.
+func (h *Handlers) CheckClosureRisk(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_transfer_confirm, _ := h.flagManager.GetFlag("flag_transfer_confirm")
+	flag_transfer_confirmed, _ := h.flagManager.GetFlag("flag_transfer_confirmed")
+	flag_api_call_error, _ := h.flagManager.GetFlag("flag_api_call_error")
+	store := h.userdataStore
+
+	publicKey, err := store.ReadEntry(ctx, sessionId, common.DATA_PUBLIC_KEY)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read publicKey entry with", "key", common.DATA_PUBLIC_KEY, "error", err)
+		return res, err
+	}
+	recipient, err := store.ReadEntry(ctx, sessionId, common.DATA_RECIPIENT)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read recipient entry with", "key", common.DATA_RECIPIENT, "error", err)
+		return res, err
+	}
+	holdings, err := h.accountService.FetchVouchers(ctx, string(publicKey))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed on FetchVouchers", "error", err)
+		res.FlagSet = append(res.FlagSet, flag_api_call_error)
+		return res, nil
+	}
+
+	_, d, voucher, err := h.evaluateClosure(ctx, sessionId, common.HeldVouchers(holdings), string(recipient))
+	if err != nil {
+		return res, err
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_api_call_error, flag_transfer_confirmed)
+	if d.Action != risk.Confirm {
+		// a denied transfer is reported when the balances are sent
+		res.FlagReset = append(res.FlagReset, flag_transfer_confirm)
+		return res, nil
+	}
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	res.FlagSet = append(res.FlagSet, flag_transfer_confirm)
+	res.Content = riskReason(l, d, voucher)
+	return res, nil
+}
+
+// CloseAccount closes the account when the user confirms, so that it can only be used again once an admin reactivates it.
+// The PIN authorization is revoked.
+func (h *Handlers) CloseAccount(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	if string(input) != "1" {
+		return res, nil
+	}
+	flag_account_closed, _ := h.flagManager.GetFlag("flag_account_closed")
+	flag_account_authorized, _ := h.flagManager.GetFlag("flag_account_authorized")
+	flag_allow_update, _ := h.flagManager.GetFlag("flag_allow_update")
+
+	if !h.isAuthorizationFresh(ctx, sessionId) {
+		return h.expireAuthorization(ctx, res)
+	}
+
+	closedAt, err := common.CloseAccount(ctx, h.userdataStore, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write closure entry with", "key", common.DATA_ACCOUNT_CLOSED, "error", err)
+		return res, err
+	}
+	logg.InfoCtxf(ctx, "account closed", "session", sessionId, "closedAt", closedAt)
+
+	err = common.RevokeAuthToken(ctx, h.userdataStore, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write auth token entry with", "key", common.DATA_AUTH_TOKEN, "error", err)
+	}
+
+	res.FlagSet = append(res.FlagSet, flag_account_closed)
+	res.FlagReset = append(res.FlagReset, flag_account_authorized, flag_allow_update)
+	return res, nil
+}
+
+// GetTerms returns the text of the current version of the terms and conditions in the user's language.
+func (h *Handlers) GetTerms(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	code := codeFromCtx(ctx)
+	if code == "" {
+		code = "eng"
+	}
+	text, ok := h.getTermsRegistry().CurrentVersion().Text(code)
+	if !ok {
+		l := gotext.NewLocale(translationDir, code)
+		l.AddDomain("default")
+		text = l.Get("Do you agree to terms and conditions?")
+	}
+	res.Content = text
+	return res, nil
+}
+
+// AcceptTerms records the acceptance of the current version of the terms and conditions, when the user agrees.
+func (h *Handlers) AcceptTerms(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	// the terms menus use 0 for yes
+	if string(input) != "0" {
+		return res, nil
+	}
+	flag_terms_outdated, _ := h.flagManager.GetFlag("flag_terms_outdated")
+
+	version := h.getTermsRegistry().Current
+	_, err := h.getTermsTracker().Accept(ctx, sessionId, version, terms.ChannelUssd)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write terms acceptance entry with", "key", common.DATA_TERMS_ACCEPTANCE, "error", err)
+		return res, err
+	}
+	res.FlagReset = append(res.FlagReset, flag_terms_outdated)
+	return res, nil
+}
+
+// CheckTerms sets the flag_terms_outdated flag if the user has not accepted the current version of the terms and conditions.
+func (h *Handlers) CheckTerms(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_terms_outdated, _ := h.flagManager.GetFlag("flag_terms_outdated")
+	tracker := h.getTermsTracker()
+
+	latest, accepted, err := tracker.Latest(ctx, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read terms acceptance entry with", "key", common.DATA_TERMS_ACCEPTANCE, "error", err)
+		return res, err
+	}
+	if !accepted {
+		// accounts created before acceptances were recorded are not in the index yet
+		err = tracker.Track(ctx, sessionId)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to add account to terms index", "error", err)
+		}
+	}
+	current := h.getTermsRegistry().Current
+	if latest.Version != current {
+		logg.InfoCtxf(ctx, "terms not accepted", "session", sessionId, "accepted", latest.Version, "current", current)
+		res.FlagSet = append(res.FlagSet, flag_terms_outdated)
+		return res, nil
+	}
+	res.FlagReset = append(res.FlagReset, flag_terms_outdated)
+	return res, nil
+}
+
+// ValidateRecipient validates that the given input is a valid phone number,
+// a voucher address or a registered alias, and resolves it to the recipient's public key.
+func (h *Handlers) ValidateRecipient(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+	var err error
+	store := h.userdataStore
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+
+	recipient := string(input)
+
+	flag_invalid_recipient, _ := h.flagManager.GetFlag("flag_invalid_recipient")
+	flag_invalid_recipient_with_invite, _ := h.flagManager.GetFlag("flag_invalid_recipient_with_invite")
+	flag_confirm_recipient, _ := h.flagManager.GetFlag("flag_confirm_recipient")
+
+	if recipient != "0" {
+		if strings.HasPrefix(recipient, "0x") {
+			address, err := common.ToChecksumAddress(recipient)
+			if err != nil || !common.IsValidAddress(recipient) {
+				res.FlagSet = append(res.FlagSet, flag_invalid_recipient)
+				res.Content = recipient
+
+				return res, nil
+			}
+
+			err = store.WriteEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE, []byte(address))
+			if err != nil {
+				logg.ErrorCtxf(ctx, "failed to write temporaryRecipient entry with", "key", common.DATA_TEMPORARY_VALUE, "value", address, "error", err)
+				return res, err
+			}
+			err = store.WriteEntry(ctx, sessionId, common.DATA_RECIPIENT, []byte(address))
+			if err != nil {
+				logg.ErrorCtxf(ctx, "failed to write recipient entry with", "key", common.DATA_RECIPIENT, "value", address, "error", err)
+				return res, err
+			}
+
+			res.FlagSet = append(res.FlagSet, flag_confirm_recipient)
+			return res, nil
+		}
+
+		alias := common.NormalizeAlias(recipient)
+		if common.IsValidAlias(alias) {
+			aliasOwner, err := common.ResolveAlias(ctx, store, alias)
+			if err != nil {
+				if db.IsNotFound(err) {
+					logg.InfoCtxf(ctx, "Unregistered alias", "alias", alias)
+					res.FlagSet = append(res.FlagSet, flag_invalid_recipient)
+					res.Content = recipient
+
+					return res, nil
+				}
+
+				logg.ErrorCtxf(ctx, "failed to resolve alias", "alias", alias, "error", err)
+				return res, err
+			}
+
+			_, closed, err := common.ReadClosure(ctx, store, aliasOwner)
+			if err != nil {
+				logg.ErrorCtxf(ctx, "failed to read closure entry with", "key", common.DATA_ACCOUNT_CLOSED, "error", err)
+				return res, err
+			}
+			if closed {
+				logg.InfoCtxf(ctx, "recipient account is closed", "alias", alias)
+				res.FlagSet = append(res.FlagSet, flag_invalid_recipient)
+				res.Content = recipient
+
+				return res, nil
+			}
+
+			publicKey, err := store.ReadEntry(ctx, aliasOwner, common.DATA_PUBLIC_KEY)
+			if err != nil {
+				logg.ErrorCtxf(ctx, "failed to read publicKey entry with", "key", common.DATA_PUBLIC_KEY, "error", err)
+				return res, err
+			}
+
+			err = store.WriteEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE, []byte(alias))
+			if err != nil {
+				logg.ErrorCtxf(ctx, "failed to write temporaryRecipient entry with", "key", common.DATA_TEMPORARY_VALUE, "value", alias, "error", err)
+				return res, err
+			}
+			err = store.WriteEntry(ctx, sessionId, common.DATA_RECIPIENT, publicKey)
+			if err != nil {
+				logg.ErrorCtxf(ctx, "failed to write recipient entry with", "key", common.DATA_RECIPIENT, "value", string(publicKey), "error", err)
+				return res, err
+			}
+
+			res.FlagSet = append(res.FlagSet, flag_confirm_recipient)
+			return res, nil
+		}
+
+		if !isValidPhoneNumber(recipient) {
+			res.FlagSet = append(res.FlagSet, flag_invalid_recipient)
+			res.Content = recipient
+
+			return res, nil
+		}
+
+		// save the recipient as the temporaryRecipient
+		err = store.WriteEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE, []byte(recipient))
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to write temporaryRecipient entry with", "key", common.DATA_TEMPORARY_VALUE, "value", recipient, "error", err)
+			return res, err
+		}
+
+		holder, err := common.ResolvePorted(ctx, store, recipient)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to resolve ported number", "recipient", recipient, "error", err)
+			return res, err
+		}
+		if holder != recipient {
+			logg.InfoCtxf(ctx, "recipient has moved to a new number", "recipient", recipient, "holder", holder)
+		}
+		_, closed, err := common.ReadClosure(ctx, store, holder)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to read closure entry with", "key", common.DATA_ACCOUNT_CLOSED, "error", err)
+			return res, err
+		}
+		if closed {
+			logg.InfoCtxf(ctx, "recipient account is closed", "recipient", recipient)
+			res.FlagSet = append(res.FlagSet, flag_invalid_recipient)
+			res.Content = recipient
+
+			return res, nil
+		}
+
+		publicKey, err := store.ReadEntry(ctx, holder, common.DATA_PUBLIC_KEY)
+		if err != nil {
+			if db.IsNotFound(err) {
+				logg.InfoCtxf(ctx, "Unregistered number")
+
+				res.FlagSet = append(res.FlagSet, flag_invalid_recipient_with_invite)
+				res.Content = recipient
+
+				return res, nil
+			}
+
+			logg.ErrorCtxf(ctx, "failed to read publicKey entry with", "key", common.DATA_PUBLIC_KEY, "error", err)
+			return res, err
+		}
+
+		err = store.WriteEntry(ctx, sessionId, common.DATA_RECIPIENT, publicKey)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to write recipient entry with", "key", common.DATA_RECIPIENT, "value", string(publicKey), "error", err)
+			return res, nil
+		}
+	}
+
+	return res, nil
+}
+
+// GetResolvedRecipient returns the recipient as entered by the user together with the
+// address it was resolved to, so that it can be confirmed before the amount is entered.
+func (h *Handlers) GetResolvedRecipient(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	store := h.userdataStore
+	target, err := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read temporaryRecipient entry with", "key", common.DATA_TEMPORARY_VALUE, "error", err)
+		return res, err
+	}
+	recipient, err := store.ReadEntry(ctx, sessionId, common.DATA_RECIPIENT)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read recipient entry with", "key", common.DATA_RECIPIENT, "error", err)
+		return res, err
+	}
+
+	if bytes.Equal(target, recipient) {
+		res.Content = l.Get("Send to address:\n%s", string(recipient))
+	} else {
+		res.Content = l.Get("Send to %s\nAddress: %s", string(target), string(recipient))
+	}
+
+	return res, nil
+}
+
+// TransactionReset resets the previous transaction data (Recipient and Amount)
+// as well as the invalid flags
+func (h *Handlers) TransactionReset(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+	var err error
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+
+	flag_invalid_recipient, _ := h.flagManager.GetFlag("flag_invalid_recipient")
+	flag_invalid_recipient_with_invite, _ := h.flagManager.GetFlag("flag_invalid_recipient_with_invite")
+	flag_confirm_recipient, _ := h.flagManager.GetFlag("flag_confirm_recipient")
+	flag_transfer_denied, _ := h.flagManager.GetFlag("flag_transfer_denied")
+	flag_transfer_confirm, _ := h.flagManager.GetFlag("flag_transfer_confirm")
+	flag_transfer_confirmed, _ := h.flagManager.GetFlag("flag_transfer_confirmed")
+	store := h.userdataStore
+	err = store.WriteEntry(ctx, sessionId, common.DATA_AMOUNT, []byte(""))
+	if err != nil {
+		return res, nil
+	}
+
+	err = store.WriteEntry(ctx, sessionId, common.DATA_RECIPIENT, []byte(""))
+	if err != nil {
+		return res, nil
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_invalid_recipient, flag_invalid_recipient_with_invite, flag_confirm_recipient, flag_transfer_denied, flag_transfer_confirm, flag_transfer_confirmed)
+
+	return res, nil
+}
+
+// InviteValidRecipient sends an invitation to the valid phone number.
+func (h *Handlers) InviteValidRecipient(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+	store := h.userdataStore
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	recipient, _ := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
+
+	// TODO
+	// send an invitation SMS
+	// if successful
+	// res.Content = l.Get("Your invitation to %s to join Sarafu Network has been sent.",  string(recipient))
+
+	res.Content = l.Get("Your invite request for %s to Sarafu Network failed. Please try again later.", string(recipient))
+	return res, nil
+}
+
+// ResetTransactionAmount resets the transaction amount and invalid flag
+func (h *Handlers) ResetTransactionAmount(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+	var err error
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+
+	flag_invalid_amount, _ := h.flagManager.GetFlag("flag_invalid_amount")
+	store := h.userdataStore
+	err = store.WriteEntry(ctx, sessionId, common.DATA_AMOUNT, []byte(""))
+	if err != nil {
+		return res, nil
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_invalid_amount)
+
+	return res, nil
+}
+
+// MaxAmount gets the current balance from the API and sets it as
+// the result content.
+//
+// The last known balance is used if it cannot be refreshed.
+func (h *Handlers) MaxAmount(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+	var err error
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+
+	b, err := h.getBalanceService().Get(ctx, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read activeBal entry with", "key", common.DATA_ACTIVE_BAL, "error", err)
+		return res, err
+	}
+
+	res.Content = b.Amount
+
+	return res, nil
+}
+
+// ValidateAmount ensures that the given input is a valid amount for the active voucher and that
+// it is not more than the current balance.
+func (h *Handlers) ValidateAmount(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_invalid_amount, _ := h.flagManager.GetFlag("flag_invalid_amount")
+	store := h.userdataStore
+
+	// retrieve the active balance
+	activeBal, err := store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_BAL)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read activeBal entry with", "key", common.DATA_ACTIVE_BAL, "error", err)
+		return res, err
+	}
+	activeDecimal, err := store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_DECIMAL)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read activeDecimal entry with", "key", common.DATA_ACTIVE_DECIMAL, "error", err)
+		return res, err
+	}
+	balance, err := common.ParseAmount(string(activeBal), string(activeDecimal))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to parse the active balance", "balance", string(activeBal), "error", err)
+		return res, err
+	}
+
+	amountStr := strings.TrimSpace(string(input))
+	inputAmount, err := common.ParseTransferAmount(amountStr, string(activeDecimal))
+	if err != nil {
+		res.FlagSet = append(res.FlagSet, flag_invalid_amount)
+		res.Content = amountStr
+		return res, nil
+	}
+
+	if inputAmount.Cmp(balance) > 0 {
+		res.FlagSet = append(res.FlagSet, flag_invalid_amount)
+		res.Content = amountStr
+		return res, nil
+	}
+
+	formattedAmount := inputAmount.String()
+	err = store.WriteEntry(ctx, sessionId, common.DATA_AMOUNT, []byte(formattedAmount))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write amount entry with", "key", common.DATA_AMOUNT, "value", formattedAmount, "error", err)
+		return res, err
+	}
+
+	res.Content = formattedAmount
+	return res, nil
+}
+
+// CheckTransferRisk evaluates the pending transfer against the transfer risk rules.
+// It sets a flag if the transfer is denied or has to be confirmed, with the reason as the result content.
+func (h *Handlers) CheckTransferRisk(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_transfer_denied, _ := h.flagManager.GetFlag("flag_transfer_denied")
+	flag_transfer_confirm, _ := h.flagManager.GetFlag("flag_transfer_confirm")
+	flag_transfer_confirmed, _ := h.flagManager.GetFlag("flag_transfer_confirmed")
+
+	t, d, err := h.evaluateTransfer(ctx, sessionId)
+	if err != nil {
+		return res, err
+	}
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	res.FlagReset = append(res.FlagReset, flag_transfer_confirmed)
+	switch d.Action {
+	case risk.Deny:
+		res.FlagSet = append(res.FlagSet, flag_transfer_denied)
+		res.FlagReset = append(res.FlagReset, flag_transfer_confirm)
+		res.Content = riskReason(l, d, t.Voucher)
+	case risk.Confirm:
+		res.FlagSet = append(res.FlagSet, flag_transfer_confirm)
+		res.FlagReset = append(res.FlagReset, flag_transfer_denied)
+		res.Content = riskReason(l, d, t.Voucher)
+	default:
+		res.FlagReset = append(res.FlagReset, flag_transfer_denied, flag_transfer_confirm)
+	}
+	return res, nil
+}
+
+// ConfirmTransfer records that the user has confirmed a transfer the risk rules asked confirmation for.
+func (h *Handlers) ConfirmTransfer(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	flag_transfer_confirmed, _ := h.flagManager.GetFlag("flag_transfer_confirmed")
+	if string(input) == "1" {
+		res.FlagSet = append(res.FlagSet, flag_transfer_confirmed)
+	}
+	return res, nil
+}
+
+// GetRecipient returns the transaction recipient phone number from the gdbm.
+func (h *Handlers) GetRecipient(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	store := h.userdataStore
+	recipient, _ := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
+
+	res.Content = string(recipient)
+
+	return res, nil
+}
+
+// RetrieveBlockedNumber gets the current number during the pin reset for other's is in progress.
+func (h *Handlers) RetrieveBlockedNumber(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	store := h.userdataStore
+	blockedNumber, _ := store.ReadEntry(ctx, sessionId, common.DATA_BLOCKED_NUMBER)
+
+	res.Content = string(blockedNumber)
+
+	return res, nil
+}
+
+// GetSender returns the sessionId (phoneNumber)
+func (h *Handlers) GetSender(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+
+	res.Content = sessionId
+
+	return res, nil
+}
+
+// GetAmount retrieves the amount from teh Gdbm Db
+func (h *Handlers) GetAmount(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	store := h.userdataStore
+
+	// retrieve the active symbol
+	activeSym, err := store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_SYM)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read activeSym entry with", "key", common.DATA_ACTIVE_SYM, "error", err)
+		return res, err
+	}
+
+	amount, _ := store.ReadEntry(ctx, sessionId, common.DATA_AMOUNT)
+
+	res.Content = fmt.Sprintf("%s %s", string(amount), string(activeSym))
+
+	return res, nil
+}
+
+// InitiateTransaction calls the TokenTransfer and returns a confirmation based on the result
+func (h *Handlers) InitiateTransaction(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+
+	flag_account_authorized, _ := h.flagManager.GetFlag("flag_account_authorized")
+	flag_transfer_confirmed, _ := h.flagManager.GetFlag("flag_transfer_confirmed")
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	res, err := h.checkTransferAccount(ctx, sessionId, l)
+	if err != nil || res.Content != "" {
+		return res, err
+	}
+
+	data, err := common.ReadTransactionData(ctx, h.userdataStore, sessionId)
+	if err != nil {
+		return res, err
+	}
+
+	confirmed := h.st != nil && h.st.MatchFlag(flag_transfer_confirmed, true)
+	res, _, err = h.transfer(ctx, sessionId, data, confirmed, l)
+	if err != nil || res.Content != "" {
+		return res, err
+	}
+
+	res.Content = l.Get(
+		"Your request has been sent. %s will receive %s %s from %s.",
+		data.TemporaryValue,
+		data.Amount,
+		data.ActiveSym,
+		sessionId,
+	)
+
+	res.FlagReset = append(res.FlagReset, flag_account_authorized, flag_transfer_confirmed)
+	return res, nil
+}
+
+// checkTransferAccount checks that the PIN was entered recently, and that the account is not frozen
+// or its SIM recently changed. If transfers cannot be made, the result content holds the reason.
+func (h *Handlers) checkTransferAccount(ctx context.Context, sessionId string, l *gotext.Locale) (resource.Result, error) {
+	var res resource.Result
+
+	flag_account_authorized, _ := h.flagManager.GetFlag("flag_account_authorized")
+
+	if !h.isAuthorizationFresh(ctx, sessionId) {
+		res.Content = l.Get("Your session has expired. Please enter your PIN again.")
+		return h.expireAuthorization(ctx, res)
+	}
+
+	_, frozen, err := common.ReadFreeze(ctx, h.userdataStore, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read freeze entry with", "key", common.DATA_ACCOUNT_FROZEN, "error", err)
+		return res, err
+	}
+	if frozen {
+		logg.WarnCtxf(ctx, "transfer blocked on frozen account", "session", sessionId)
+		res.Content = l.Get("Your account is frozen. Transfers cannot be made until it is unfrozen.")
+		res.FlagReset = append(res.FlagReset, flag_account_authorized)
+		return res, nil
+	}
+
+	msg := h.checkSimSwap(ctx, sessionId, l)
+	if msg != "" {
+		res.Content = msg
+		res.FlagReset = append(res.FlagReset, flag_account_authorized)
+	}
+	return res, nil
+}
+
+// transfer makes the transfer described by data from the session id, once checkTransferAccount has passed,
+// if the transfer rules allow it. A transfer the rules ask confirmation for is only made if confirmed is set.
+//
+// If the transfer is not made, the result content holds the reason. Otherwise the content is empty, and
+// the tracking id of the transfer is returned.
+func (h *Handlers) transfer(ctx context.Context, sessionId string, data common.TransactionData, confirmed bool, l *gotext.Locale) (resource.Result, string, error) {
+	var res resource.Result
+
+	flag_account_authorized, _ := h.flagManager.GetFlag("flag_account_authorized")
+
+	// the rules are evaluated again, as the transfer history may have changed since the amount was entered
+	t, d, err := h.evaluate(ctx, sessionId, data.Amount, data.ActiveSym, h.riskRecipient(data.Recipient, data.TemporaryValue))
+	if err != nil {
+		return res, "", err
+	}
+	if d.Action == risk.Deny {
+		res.Content = riskReason(l, d, t.Voucher)
+		res.FlagReset = append(res.FlagReset, flag_account_authorized)
+		return res, "", nil
+	}
+	if d.Action == risk.Confirm && !confirmed {
+		logg.WarnCtxf(ctx, "transfer not confirmed", "session", sessionId, "rule", d.Rule)
+		res.Content = riskReason(l, d, t.Voucher)
+		res.FlagReset = append(res.FlagReset, flag_account_authorized)
+		return res, "", nil
+	}
+
+	amount, err := common.ParseAmount(data.Amount, data.ActiveDecimal)
+	if err != nil {
+		return res, "", err
+	}
+
+	// Call TokenTransfer
+	r, err := h.accountService.TokenTransfer(ctx, amount.Raw(), data.PublicKey, data.Recipient, data.ActiveAddress)
+	if err != nil {
+		flag_api_error, _ := h.flagManager.GetFlag("flag_api_call_error")
+		res.FlagSet = append(res.FlagSet, flag_api_error)
+		res.Content = l.Get("Your request failed. Please try again later.")
+		logg.ErrorCtxf(ctx, "failed on TokenTransfer", "error", err)
+		return res, "", nil
+	}
+
+	trackingId := r.TrackingId
+	logg.InfoCtxf(ctx, "TokenTransfer", "trackingId", trackingId)
+
+	err = risk.NewLedger(h.userdataStore).Record(ctx, sessionId, t)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write transfer log entry with", "key", common.DATA_TRANSFER_LOG, "error", err)
+	}
+	h.invalidateBalance(ctx, sessionId)
+
+	return res, trackingId, nil
+}
+
+// ResetBatch clears the recipients of the batch transfer before a new one is started.
+func (h *Handlers) ResetBatch(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_batch_full, _ := h.flagManager.GetFlag("flag_batch_full")
+	flag_batch_failed, _ := h.flagManager.GetFlag("flag_batch_failed")
+	flag_invalid_recipient, _ := h.flagManager.GetFlag("flag_invalid_recipient")
+	flag_invalid_amount, _ := h.flagManager.GetFlag("flag_invalid_amount")
+
+	err := common.WriteBatch(ctx, h.userdataStore, sessionId, nil)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write batch entry with", "key", common.DATA_BATCH, "error", err)
+		return res, err
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_batch_full, flag_batch_failed, flag_invalid_recipient, flag_invalid_amount)
+	return res, nil
+}
+
+// AddBatchRecipient saves the phone number, alias or address of the next recipient of the batch transfer,
+// until its amount is entered.
+// If the recipient is rejected, the flag_invalid_recipient flag is set with the reason as the result content.
+func (h *Handlers) AddBatchRecipient(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_invalid_recipient, _ := h.flagManager.GetFlag("flag_invalid_recipient")
+	store := h.userdataStore
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	recipient := strings.TrimSpace(string(input))
+	address, reason, err := h.resolveTransferRecipient(ctx, sessionId, recipient, l)
+	if err != nil {
+		return res, err
+	}
+	if reason == "" {
+		entries, err := common.ReadBatch(ctx, store, sessionId)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to read batch entry with", "key", common.DATA_BATCH, "error", err)
+			return res, err
+		}
+		for _, e := range entries {
+			if strings.EqualFold(e.Address, address) {
+				reason = l.Get("%s is already in this payment.", recipient)
+				break
+			}
+		}
+	}
+	if reason != "" {
+		res.FlagSet = append(res.FlagSet, flag_invalid_recipient)
+		res.Content = reason
+		return res, nil
+	}
+
+	err = store.WriteEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE, []byte(recipient))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write temporaryRecipient entry with", "key", common.DATA_TEMPORARY_VALUE, "value", recipient, "error", err)
+		return res, err
+	}
+	err = store.WriteEntry(ctx, sessionId, common.DATA_RECIPIENT, []byte(address))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write recipient entry with", "key", common.DATA_RECIPIENT, "value", address, "error", err)
+		return res, err
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_invalid_recipient)
+	res.Content = recipient
+	return res, nil
+}
+
+// AddBatchAmount adds the saved recipient to the batch transfer with the given amount of the active voucher.
+// If the amount is invalid or the total would be more than the balance, the flag_invalid_amount flag is set
+// with the reason as the result content.
+//
+// The flag_batch_full flag is set once the batch has the most recipients allowed.
+func (h *Handlers) AddBatchAmount(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_invalid_amount, _ := h.flagManager.GetFlag("flag_invalid_amount")
+	flag_batch_full, _ := h.flagManager.GetFlag("flag_batch_full")
+	store := h.userdataStore
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	activeSym, err := store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_SYM)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read activeSym entry with", "key", common.DATA_ACTIVE_SYM, "error", err)
+		return res, err
+	}
+	activeDecimal, err := store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_DECIMAL)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read activeDecimal entry with", "key", common.DATA_ACTIVE_DECIMAL, "error", err)
+		return res, err
+	}
+	recipient, err := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read temporaryValue entry with", "key", common.DATA_TEMPORARY_VALUE, "error", err)
+		return res, err
+	}
+	address, err := store.ReadEntry(ctx, sessionId, common.DATA_RECIPIENT)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read recipient entry with", "key", common.DATA_RECIPIENT, "error", err)
+		return res, err
+	}
+
+	amountStr := strings.TrimSpace(string(input))
+	amount, err := common.ParseTransferAmount(amountStr, string(activeDecimal))
+	if err != nil {
+		res.FlagSet = append(res.FlagSet, flag_invalid_amount)
+		res.Content = l.Get("Amount %s is invalid.", amountStr)
+		return res, nil
+	}
+
+	entries, err := common.ReadBatch(ctx, store, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read batch entry with", "key", common.DATA_BATCH, "error", err)
+		return res, err
+	}
+	total, err := common.BatchTotal(entries, string(activeDecimal))
+	if err != nil {
+		return res, err
+	}
+	total = total.Add(amount)
+	b, err := h.getBalanceService().Get(ctx, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read activeBal entry with", "key", common.DATA_ACTIVE_BAL, "error", err)
+		return res, err
+	}
+	balance, err := common.ParseAmount(b.Amount, string(activeDecimal))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to parse the active balance", "balance", b.Amount, "error", err)
+		return res, err
+	}
+	if total.Cmp(balance) > 0 {
+		res.FlagSet = append(res.FlagSet, flag_invalid_amount)
+		res.Content = l.Get("The total of %s %s is more than your balance of %s %s.", total.String(), string(activeSym), b.Amount, string(activeSym))
+		return res, nil
+	}
+
+	entries, err = common.AddBatchEntry(ctx, store, sessionId, common.BatchEntry{
+		Recipient: string(recipient),
+		Address:   string(address),
+		Amount:    amount.String(),
+	})
+	if err != nil && !errors.Is(err, common.ErrBatchFull) {
+		logg.ErrorCtxf(ctx, "failed to write batch entry with", "key", common.DATA_BATCH, "error", err)
+		return res, err
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_invalid_amount)
+	if len(entries) >= common.MaxBatchRecipients {
+		res.FlagSet = append(res.FlagSet, flag_batch_full)
+	} else {
+		res.FlagReset = append(res.FlagReset, flag_batch_full)
+	}
+	res.Content = l.Get("%d of %d recipients added. Total: %s %s", len(entries), common.MaxBatchRecipients, total.String(), string(activeSym))
+	return res, nil
+}
+
+// CheckBatch summarizes the batch transfer before the user confirms it with the PIN.
+// If the batch is empty, its total is more than the balance or the transfer rules deny a payment, the flag_batch_failed
+// flag is set with the reason as the result content. If the rules ask confirmation for any payment, the flag_transfer_confirm
+// flag is set and the reasons are added to the summary by recipient.
+func (h *Handlers) CheckBatch(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_batch_failed, _ := h.flagManager.GetFlag("flag_batch_failed")
+	flag_transfer_confirm, _ := h.flagManager.GetFlag("flag_transfer_confirm")
+	flag_transfer_confirmed, _ := h.flagManager.GetFlag("flag_transfer_confirmed")
+	store := h.userdataStore
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	activeSym, err := store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_SYM)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read activeSym entry with", "key", common.DATA_ACTIVE_SYM, "error", err)
+		return res, err
+	}
+	activeDecimal, err := store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_DECIMAL)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read activeDecimal entry with", "key", common.DATA_ACTIVE_DECIMAL, "error", err)
+		return res, err
+	}
+	entries, err := common.ReadBatch(ctx, store, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read batch entry with", "key", common.DATA_BATCH, "error", err)
+		return res, err
+	}
+	if len(entries) == 0 {
+		res.FlagSet = append(res.FlagSet, flag_batch_failed)
+		res.Content = l.Get("No recipients have been added.")
+		return res, nil
+	}
+	total, err := common.BatchTotal(entries, string(activeDecimal))
+	if err != nil {
+		return res, err
+	}
+
+	// the balance may have changed since the amounts were entered
+	b, err := h.getBalanceService().Get(ctx, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read activeBal entry with", "key", common.DATA_ACTIVE_BAL, "error", err)
+		return res, err
+	}
+	balance, err := common.ParseAmount(b.Amount, string(activeDecimal))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to parse the active balance", "balance", b.Amount, "error", err)
+		return res, err
+	}
+	if total.Cmp(balance) > 0 {
+		res.FlagSet = append(res.FlagSet, flag_batch_failed)
+		res.Content = l.Get("The total of %s %s is more than your balance of %s %s.", total.String(), string(activeSym), b.Amount, string(activeSym))
+		return res, nil
+	}
+
+	_, decisions, err := h.evaluateBatch(ctx, sessionId, entries, string(activeSym), string(activeDecimal))
+	if err != nil {
+		return res, err
+	}
+	var reasons []string
+	for i, d := range decisions {
+		switch d.Action {
+		case risk.Deny:
+			res.FlagSet = append(res.FlagSet, flag_batch_failed)
+			res.Content = l.Get("The payment to %s is not allowed: %s", entries[i].Recipient, riskReason(l, d, string(activeSym)))
+			return res, nil
+		case risk.Confirm:
+			reasons = append(reasons, fmt.Sprintf("%s: %s", entries[i].Recipient, riskReason(l, d, string(activeSym))))
+		}
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_batch_failed, flag_transfer_confirmed)
+	res.Content = l.Get("%d recipients will receive %s %s in total.\nFee: %s %s", len(entries), total.String(), string(activeSym), batchFee, string(activeSym))
+	if len(reasons) > 0 {
+		res.FlagSet = append(res.FlagSet, flag_transfer_confirm)
+		res.Content += "\n" + strings.Join(reasons, "\n")
+	} else {
+		res.FlagReset = append(res.FlagReset, flag_transfer_confirm)
+	}
+	return res, nil
+}
+
+// evaluateBatch evaluates the transfer to each recipient of the batch that has not been sent yet against the transfer
+// risk rules. It returns the transfers and the decisions by entry.
+func (h *Handlers) evaluateBatch(ctx context.Context, sessionId string, entries []common.BatchEntry, voucher string, decimals string) ([]risk.Transfer, []risk.Decision, error) {
+	history, err := risk.NewLedger(h.userdataStore).History(ctx, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read transfer log entry with", "key", common.DATA_TRANSFER_LOG, "error", err)
+		return nil, nil, err
+	}
+	transfers := make([]risk.Transfer, len(entries))
+	decisions := make([]risk.Decision, len(entries))
+	for i, e := range entries {
+		if e.Status == common.BatchSent {
+			continue
+		}
+		amount, err := common.ParseAmount(e.Amount, decimals)
+		if err != nil {
+			return nil, nil, err
+		}
+		transfers[i] = risk.Transfer{
+			Time:      time.Now(),
+			Voucher:   voucher,
+			Amount:    amount,
+			Recipient: e.Address,
+		}
+		decisions[i] = h.getRiskEngine().Evaluate(ctx, sessionId, transfers[i], history)
+		// the payments of the batch count towards the limits of the ones after them
+		history.Add(transfers[i])
+	}
+	return transfers, decisions, nil
+}
+
+// TransferBatch sends one transfer of the active voucher to each recipient of the batch transfer, once the PIN is entered.
+// The status and tracking id of each transfer are kept with the batch, and the user is told by SMS if any of them failed.
+//
+// Every transfer is checked against the transfer rules before any of them is made. If the rules ask confirmation
+// for any of them, the batch is only sent once the user has confirmed it.
+func (h *Handlers) TransferBatch(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_account_authorized, _ := h.flagManager.GetFlag("flag_account_authorized")
+	flag_transfer_confirmed, _ := h.flagManager.GetFlag("flag_transfer_confirmed")
+	store := h.userdataStore
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	res, err := h.checkTransferAccount(ctx, sessionId, l)
+	if err != nil || res.Content != "" {
+		return res, err
+	}
+
+	publicKey, err := store.ReadEntry(ctx, sessionId, common.DATA_PUBLIC_KEY)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read publicKey entry with", "key", common.DATA_PUBLIC_KEY, "error", err)
+		return res, err
+	}
+	activeSym, err := store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_SYM)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read activeSym entry with", "key", common.DATA_ACTIVE_SYM, "error", err)
+		return res, err
+	}
+	activeAddress, err := store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_ADDRESS)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read activeAddress entry with", "key", common.DATA_ACTIVE_ADDRESS, "error", err)
+		return res, err
+	}
+	activeDecimal, err := store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_DECIMAL)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read activeDecimal entry with", "key", common.DATA_ACTIVE_DECIMAL, "error", err)
+		return res, err
+	}
+	entries, err := common.ReadBatch(ctx, store, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read batch entry with", "key", common.DATA_BATCH, "error", err)
+		return res, err
+	}
+
+	transfers, decisions, err := h.evaluateBatch(ctx, sessionId, entries, string(activeSym), string(activeDecimal))
+	if err != nil {
+		return res, err
+	}
+	confirmed := h.st != nil && h.st.MatchFlag(flag_transfer_confirmed, true)
+	for i, d := range decisions {
+		if d.Action == risk.Deny || (d.Action == risk.Confirm && !confirmed) {
+			logg.WarnCtxf(ctx, "batch transfer blocked", "session", sessionId, "recipient", entries[i].Address, "rule", d.Rule, "action", d.Action)
+			res.Content = l.Get("Nothing was sent. The payment to %s is not allowed: %s", entries[i].Recipient, riskReason(l, d, string(activeSym)))
+			res.FlagReset = append(res.FlagReset, flag_account_authorized, flag_transfer_confirmed)
+			return res, nil
+		}
+	}
+
+	ledger := risk.NewLedger(store)
+	var sent int
+	var failed []string
+	var total common.Amount
+	for i, e := range entries {
+		if e.Status == common.BatchSent {
+			continue
+		}
+		r, err := h.accountService.TokenTransfer(ctx, transfers[i].Amount.Raw(), string(publicKey), e.Address, string(activeAddress))
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed on TokenTransfer", "recipient", e.Address, "error", err)
+			entries[i].Status = common.BatchFailed
+			failed = append(failed, e.Recipient)
+		} else {
+			logg.InfoCtxf(ctx, "TokenTransfer", "trackingId", r.TrackingId, "recipient", e.Address)
+			entries[i].Status = common.BatchSent
+			entries[i].TrackingId = r.TrackingId
+			sent++
+			total = total.Add(transfers[i].Amount)
+			err = ledger.Record(ctx, sessionId, transfers[i])
+			if err != nil {
+				logg.ErrorCtxf(ctx, "failed to write transfer log entry with", "key", common.DATA_TRANSFER_LOG, "error", err)
+			}
+			h.invalidateBalance(ctx, sessionId)
+		}
+
+		// the status is saved after each transfer, so that a recipient is never paid twice if the batch is sent again
+		err = common.WriteBatch(ctx, store, sessionId, entries)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to write batch entry with", "key", common.DATA_BATCH, "error", err)
+			return res, err
+		}
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_account_authorized, flag_transfer_confirmed)
+	if len(failed) == 0 {
+		res.Content = l.Get("Your request has been sent. %d recipients will receive %s %s in total.", sent, total.String(), string(activeSym))
+		return res, nil
+	}
+
+	res.Content = l.Get("%d of %d payments were sent. The payments to %s failed. Please try again later.", sent, sent+len(failed), strings.Join(failed, ", "))
+	err = h.getNotifier().Notify(ctx, sessionId, notify.Locale(ctx, h.userdataStore, sessionId).Get("%d of %d payments of %s from your Sarafu account were sent. The payments to %s failed.", sent, sent+len(failed), string(activeSym), strings.Join(failed, ", ")))
+	if err != nil {
+		logg.WarnCtxf(ctx, "batch transfer notification failed", "session", sessionId, "error", err)
+	}
+	return res, nil
+}
+
+// ResetPaymentRequest starts a new payment request by the session, and clears the flags of the previous one.
+func (h *Handlers) ResetPaymentRequest(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_invalid_recipient, _ := h.flagManager.GetFlag("flag_invalid_recipient")
+	flag_incorrect_voucher, _ := h.flagManager.GetFlag("flag_incorrect_voucher")
+	flag_invalid_amount, _ := h.flagManager.GetFlag("flag_invalid_amount")
+	flag_payment_request_rejected, _ := h.flagManager.GetFlag("flag_payment_request_rejected")
+
+	err := common.WritePaymentRequestDraft(ctx, h.userdataStore, sessionId, common.PaymentRequest{Requester: sessionId})
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write payment request draft entry with", "key", common.DATA_PAYMENT_REQUEST_DRAFT, "error", err)
+		return res, err
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_invalid_recipient, flag_incorrect_voucher, flag_invalid_amount, flag_payment_request_rejected)
+	return res, nil
+}
+
+// SetPaymentRequestPayer saves the phone number of the registered user asked to pay the payment request.
+// If the number is rejected, the flag_invalid_recipient flag is set with the reason as the result content.
+func (h *Handlers) SetPaymentRequestPayer(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_invalid_recipient, _ := h.flagManager.GetFlag("flag_invalid_recipient")
+	store := h.userdataStore
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	payer := strings.TrimSpace(string(input))
+	if payer == "0" {
+		return res, nil
+	}
+	if !isValidPhoneNumber(payer) {
+		res.FlagSet = append(res.FlagSet, flag_invalid_recipient)
+		res.Content = l.Get("%s is not a valid phone number.", payer)
+		return res, nil
+	}
+	holder, err := common.ResolvePorted(ctx, store, payer)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to resolve ported number", "payer", payer, "error", err)
+		return res, err
+	}
+	if holder == sessionId {
+		res.FlagSet = append(res.FlagSet, flag_invalid_recipient)
+		res.Content = l.Get("You cannot request money from yourself.")
+		return res, nil
+	}
+	_, closed, err := common.ReadClosure(ctx, store, holder)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read closure entry with", "key", common.DATA_ACCOUNT_CLOSED, "error", err)
+		return res, err
+	}
+	if closed {
+		res.FlagSet = append(res.FlagSet, flag_invalid_recipient)
+		res.Content = l.Get("The account of %s is closed.", payer)
+		return res, nil
+	}
+	_, err = store.ReadEntry(ctx, holder, common.DATA_PUBLIC_KEY)
+	if err != nil {
+		if db.IsNotFound(err) {
+			res.FlagSet = append(res.FlagSet, flag_invalid_recipient)
+			res.Content = l.Get("%s is not registered with Sarafu.", payer)
+			return res, nil
+		}
+		logg.ErrorCtxf(ctx, "failed to read publicKey entry with", "key", common.DATA_PUBLIC_KEY, "error", err)
+		return res, err
+	}
+
+	err = common.WritePaymentRequestDraft(ctx, store, sessionId, common.PaymentRequest{Requester: sessionId, Payer: holder})
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write payment request draft entry with", "key", common.DATA_PAYMENT_REQUEST_DRAFT, "error", err)
+		return res, err
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_invalid_recipient)
+	res.Content = payer
+	return res, nil
+}
+
+// SetPaymentRequestVoucher saves the voucher of the payment request, selected by number or symbol from the vouchers of the session.
+// If the voucher is not found, the flag_incorrect_voucher flag is set.
+func (h *Handlers) SetPaymentRequestVoucher(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_incorrect_voucher, _ := h.flagManager.GetFlag("flag_incorrect_voucher")
+	store := h.userdataStore
+
+	inputStr := string(input)
+	if inputStr == "0" || inputStr == "99" {
+		res.FlagReset = append(res.FlagReset, flag_incorrect_voucher)
+		return res, nil
+	}
+
+	metadata, err := common.GetVoucherData(ctx, h.prefixDb, inputStr)
+	if err != nil {
+		return res, fmt.Errorf("failed to retrieve voucher data: %v", err)
+	}
+	if metadata == nil {
+		res.FlagSet = append(res.FlagSet, flag_incorrect_voucher)
+		return res, nil
+	}
+
+	draft, err := common.ReadPaymentRequestDraft(ctx, store, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read payment request draft entry with", "key", common.DATA_PAYMENT_REQUEST_DRAFT, "error", err)
+		return res, err
+	}
+	draft.Symbol = metadata.TokenSymbol
+	draft.Address = metadata.ContractAddress
+	draft.Decimals = metadata.TokenDecimals
+	err = common.WritePaymentRequestDraft(ctx, store, sessionId, draft)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write payment request draft entry with", "key", common.DATA_PAYMENT_REQUEST_DRAFT, "error", err)
+		return res, err
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_incorrect_voucher)
+	res.Content = metadata.TokenSymbol
+	return res, nil
+}
+
+// CreatePaymentRequest sends the payment request for the given amount to the payer, who is told about it by SMS.
+// If the amount is invalid, the flag_invalid_amount flag is set. If the payer cannot take another request from
+// the session, the flag_payment_request_rejected flag is set. In both cases the reason is the result content.
+func (h *Handlers) CreatePaymentRequest(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_invalid_amount, _ := h.flagManager.GetFlag("flag_invalid_amount")
+	flag_payment_request_rejected, _ := h.flagManager.GetFlag("flag_payment_request_rejected")
+	store := h.userdataStore
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	draft, err := common.ReadPaymentRequestDraft(ctx, store, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read payment request draft entry with", "key", common.DATA_PAYMENT_REQUEST_DRAFT, "error", err)
+		return res, err
+	}
+
+	amountStr := strings.TrimSpace(string(input))
+	amount, err := common.ParseTransferAmount(amountStr, draft.Decimals)
+	if err != nil {
+		res.FlagSet = append(res.FlagSet, flag_invalid_amount)
+		res.Content = l.Get("Amount %s is invalid.", amountStr)
+		return res, nil
+	}
+	draft.Amount = amount.String()
+	draft.CreatedAt = time.Now()
+
+	err = common.AddPaymentRequest(ctx, store, draft, paymentRequestTtl())
+	switch err {
+	case nil:
+	case common.ErrPaymentRequestSelf:
+		res.Content = l.Get("You cannot request money from yourself.")
+	case common.ErrPaymentRequestExists:
+		res.Content = l.Get("%s has not answered your last request yet.", draft.Payer)
+	case common.ErrPaymentRequestsFull:
+		res.Content = l.Get("%s cannot receive more payment requests right now.", draft.Payer)
+	default:
+		logg.ErrorCtxf(ctx, "failed to write payment requests entry with", "key", common.DATA_PAYMENT_REQUESTS, "error", err)
+		return res, err
+	}
+	if err != nil {
+		res.FlagSet = append(res.FlagSet, flag_payment_request_rejected)
+		res.FlagReset = append(res.FlagReset, flag_invalid_amount)
+		return res, nil
+	}
+	logg.InfoCtxf(ctx, "payment requested", "session", sessionId, "payer", draft.Payer, "amount", draft.Amount, "voucher", draft.Symbol)
+
+	err = h.getNotifier().Notify(ctx, draft.Payer, notify.Locale(ctx, h.userdataStore, draft.Payer).Get("%s has requested %s %s from you on Sarafu. Dial in and choose Requests to approve or decline it.", sessionId, draft.Amount, draft.Symbol))
+	if err != nil {
+		logg.WarnCtxf(ctx, "payment request notification failed", "payer", draft.Payer, "error", err)
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_invalid_amount, flag_payment_request_rejected)
+	res.Content = l.Get("Your request for %s %s has been sent to %s.", draft.Amount, draft.Symbol, draft.Payer)
+	return res, nil
+}
+
+// CheckPaymentRequests sets the flag_payment_request_new flag if payment requests have been received since the user
+// last dialed in. The requests are marked as seen, so that the user is only told about them once.
+func (h *Handlers) CheckPaymentRequests(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_payment_request_new, _ := h.flagManager.GetFlag("flag_payment_request_new")
+
+	unseen, err := common.MarkPaymentRequestsSeen(ctx, h.userdataStore, sessionId, paymentRequestTtl())
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write payment requests entry with", "key", common.DATA_PAYMENT_REQUESTS, "error", err)
+		return res, err
+	}
+	if unseen > 0 {
+		res.FlagSet = append(res.FlagSet, flag_payment_request_new)
+	} else {
+		res.FlagReset = append(res.FlagReset, flag_payment_request_new)
+	}
+	return res, nil
+}
+
+// GetPaymentRequests returns the numbered list of the pending payment requests to the account.
+func (h *Handlers) GetPaymentRequests(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	requests, err := common.ReadPaymentRequests(ctx, h.userdataStore, sessionId, paymentRequestTtl())
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read payment requests entry with", "key", common.DATA_PAYMENT_REQUESTS, "error", err)
+		return res, err
+	}
+	if len(requests) == 0 {
+		res.Content = l.Get("You have no pending payment requests.")
+		return res, nil
+	}
+	var lines []string
+	for i, r := range requests {
+		lines = append(lines, fmt.Sprintf("%d:%s %s %s", i+1, r.Requester, r.Amount, r.Symbol))
+	}
+	res.Content = strings.Join(lines, "\n")
+	return res, nil
+}
+
+// ViewPaymentRequest selects the payment request at the given position in the list returned by GetPaymentRequests,
+// and returns its details. If there is no such request, the flag_payment_request_rejected flag is set.
+//
+// If the transfer rules ask a confirmation for paying the request, the reason is added to the details and the
+// flag_transfer_confirm flag is set, so that approving the request confirms it.
+func (h *Handlers) ViewPaymentRequest(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_payment_request_rejected, _ := h.flagManager.GetFlag("flag_payment_request_rejected")
+	flag_transfer_confirm, _ := h.flagManager.GetFlag("flag_transfer_confirm")
+	flag_transfer_confirmed, _ := h.flagManager.GetFlag("flag_transfer_confirmed")
+	store := h.userdataStore
+
+	inputStr := string(input)
+	if inputStr == "0" {
+		res.FlagReset = append(res.FlagReset, flag_payment_request_rejected)
+		return res, nil
+	}
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	requests, err := common.ReadPaymentRequests(ctx, store, sessionId, paymentRequestTtl())
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read payment requests entry with", "key", common.DATA_PAYMENT_REQUESTS, "error", err)
+		return res, err
+	}
+	i, err := strconv.Atoi(inputStr)
+	if err != nil || i < 1 || i > len(requests) {
+		res.FlagSet = append(res.FlagSet, flag_payment_request_rejected)
+		return res, nil
+	}
+	r := requests[i-1]
+
+	err = store.WriteEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE, []byte(r.Requester))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write temporaryValue entry with", "key", common.DATA_TEMPORARY_VALUE, "value", r.Requester, "error", err)
+		return res, err
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_payment_request_rejected, flag_transfer_confirmed)
+	res.Content = l.Get("%s requests %s %s from you.", r.Requester, r.Amount, r.Symbol)
+
+	// a requester that cannot be paid is reported when the request is approved
+	address, reason, err := h.resolveTransferRecipient(ctx, sessionId, r.Requester, l)
+	if err != nil || reason != "" {
+		res.FlagReset = append(res.FlagReset, flag_transfer_confirm)
+		return res, err
+	}
+	t, d, err := h.evaluate(ctx, sessionId, r.Amount, r.Symbol, h.riskRecipient(address, r.Requester))
+	if err != nil {
+		return res, err
+	}
+	if d.Action != risk.Confirm {
+		res.FlagReset = append(res.FlagReset, flag_transfer_confirm)
+		return res, nil
+	}
+	res.FlagSet = append(res.FlagSet, flag_transfer_confirm)
+	res.Content += "\n" + riskReason(l, d, t.Voucher)
+	return res, nil
+}
+
+// ApprovePaymentRequest pays the selected payment request once the PIN is entered, through the same checks
+// as InitiateTransaction. The request is removed once paid, and the requester is told by SMS.
+//
+// A request the transfer rules ask confirmation for is only paid if it was approved with the reason shown by
+// ViewPaymentRequest.
+func (h *Handlers) ApprovePaymentRequest(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_account_authorized, _ := h.flagManager.GetFlag("flag_account_authorized")
+	flag_transfer_confirmed, _ := h.flagManager.GetFlag("flag_transfer_confirmed")
+	store := h.userdataStore
+	ttl := paymentRequestTtl()
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	requester, err := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read temporaryValue entry with", "key", common.DATA_TEMPORARY_VALUE, "error", err)
+		return res, err
+	}
+	requests, err := common.ReadPaymentRequests(ctx, store, sessionId, ttl)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read payment requests entry with", "key", common.DATA_PAYMENT_REQUESTS, "error", err)
+		return res, err
+	}
+	var r *common.PaymentRequest
+	for i := range requests {
+		if requests[i].Requester == string(requester) {
+			r = &requests[i]
+			break
+		}
+	}
+	res, err = h.checkTransferAccount(ctx, sessionId, l)
+	if err != nil || res.Content != "" {
+		return res, err
+	}
+	confirmed := h.st != nil && h.st.MatchFlag(flag_transfer_confirmed, true)
+	res.FlagReset = append(res.FlagReset, flag_account_authorized, flag_transfer_confirmed)
+	if r == nil {
+		res.Content = l.Get("The payment request has expired.")
+		return res, nil
+	}
+
+	address, reason, err := h.resolveTransferRecipient(ctx, sessionId, r.Requester, l)
+	if err != nil {
+		return res, err
+	}
+	if reason != "" {
+		_, err = common.RemovePaymentRequest(ctx, store, sessionId, r.Requester, ttl)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to write payment requests entry with", "key", common.DATA_PAYMENT_REQUESTS, "error", err)
+		}
+		res.Content = reason
+		return res, nil
+	}
+
+	publicKey, err := store.ReadEntry(ctx, sessionId, common.DATA_PUBLIC_KEY)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read publicKey entry with", "key", common.DATA_PUBLIC_KEY, "error", err)
+		return res, err
+	}
+	amount, err := common.ParseAmount(r.Amount, r.Decimals)
+	if err != nil {
+		return res, err
+	}
+	holdings, err := h.accountService.FetchVouchers(ctx, string(publicKey))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed on FetchVouchers", "error", err)
+		res.Content = l.Get("Your request failed. Please try again later.")
+		return res, nil
+	}
+	var balance common.Amount
+	for _, v := range holdings {
+		if strings.EqualFold(v.ContractAddress, r.Address) {
+			balance, err = common.NewAmount(v.Balance, v.TokenDecimals)
+			if err != nil {
+				return res, err
+			}
+			break
+		}
+	}
+	if amount.Cmp(balance) > 0 {
+		res.Content = l.Get("You do not have enough %s to pay this request.", r.Symbol)
+		return res, nil
+	}
+
+	data := common.TransactionData{
+		TemporaryValue: r.Requester,
+		ActiveSym:      r.Symbol,
+		Amount:         r.Amount,
+		PublicKey:      string(publicKey),
+		Recipient:      address,
+		ActiveDecimal:  r.Decimals,
+		ActiveAddress:  r.Address,
+	}
+	res, trackingId, err := h.transfer(ctx, sessionId, data, confirmed, l)
+	if err != nil || res.Content != "" {
+		res.FlagReset = append(res.FlagReset, flag_transfer_confirmed)
+		return res, err
+	}
+	logg.InfoCtxf(ctx, "payment request paid", "session", sessionId, "requester", r.Requester, "trackingId", trackingId)
+
+	_, err = common.RemovePaymentRequest(ctx, store, sessionId, r.Requester, ttl)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write payment requests entry with", "key", common.DATA_PAYMENT_REQUESTS, "error", err)
+	}
+	err = h.getNotifier().Notify(ctx, r.Requester, notify.Locale(ctx, h.userdataStore, r.Requester).Get("%s has paid your request of %s %s.", sessionId, r.Amount, r.Symbol))
+	if err != nil {
+		logg.WarnCtxf(ctx, "payment request notification failed", "requester", r.Requester, "error", err)
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_account_authorized, flag_transfer_confirmed)
+	res.Content = l.Get("Your request has been sent. %s will receive %s %s from %s.", r.Requester, r.Amount, r.Symbol, sessionId)
+	return res, nil
+}
+
+// DeclinePaymentRequest removes the selected payment request without paying it, and tells the requester by SMS.
+func (h *Handlers) DeclinePaymentRequest(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	store := h.userdataStore
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	requester, err := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read temporaryValue entry with", "key", common.DATA_TEMPORARY_VALUE, "error", err)
+		return res, err
+	}
+	r, err := common.RemovePaymentRequest(ctx, store, sessionId, string(requester), paymentRequestTtl())
+	if err != nil {
+		if err == common.ErrNoPaymentRequest {
+			res.Content = l.Get("The payment request has expired.")
+			return res, nil
+		}
+		logg.ErrorCtxf(ctx, "failed to write payment requests entry with", "key", common.DATA_PAYMENT_REQUESTS, "error", err)
+		return res, err
+	}
+	logg.InfoCtxf(ctx, "payment request declined", "session", sessionId, "requester", r.Requester)
+
+	err = h.getNotifier().Notify(ctx, r.Requester, notify.Locale(ctx, h.userdataStore, r.Requester).Get("%s has declined your request of %s %s.", sessionId, r.Amount, r.Symbol))
+	if err != nil {
+		logg.WarnCtxf(ctx, "payment request notification failed", "requester", r.Requester, "error", err)
+	}
+
+	res.Content = l.Get("You have declined the request of %s for %s %s.", r.Requester, r.Amount, r.Symbol)
+	return res, nil
+}
+
+// ValidateTill resolves the till number entered to the merchant using it, and checks that the merchant accepts
+// the active voucher. If not, the flag_invalid_till flag is set with the reason as the result content.
+func (h *Handlers) ValidateTill(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_invalid_till, _ := h.flagManager.GetFlag("flag_invalid_till")
+	store := h.userdataStore
+
+	till := strings.TrimSpace(string(input))
+	if till == "0" {
+		res.FlagReset = append(res.FlagReset, flag_invalid_till)
+		return res, nil
+	}
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	m, err := common.ReadMerchant(ctx, store, till)
+	if err != nil {
+		if err == common.ErrMerchantNotFound {
+			res.FlagSet = append(res.FlagSet, flag_invalid_till)
+			res.Content = l.Get("No merchant uses till %s.", till)
+			return res, nil
+		}
+		logg.ErrorCtxf(ctx, "failed to read merchant", "till", till, "error", err)
+		return res, err
+	}
+
+	activeSym, err := store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_SYM)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read activeSym entry with", "key", common.DATA_ACTIVE_SYM, "error", err)
+		return res, err
+	}
+	activeAddress, err := store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_ADDRESS)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read activeAddress entry with", "key", common.DATA_ACTIVE_ADDRESS, "error", err)
+		return res, err
+	}
+	if !m.Accepts(string(activeSym), string(activeAddress)) {
+		res.FlagSet = append(res.FlagSet, flag_invalid_till)
+		res.Content = l.Get("%s does not accept %s.", m.Name, string(activeSym))
+		return res, nil
+	}
+
+	err = store.WriteEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE, []byte(m.Till))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write temporaryValue entry with", "key", common.DATA_TEMPORARY_VALUE, "value", m.Till, "error", err)
+		return res, err
+	}
+	err = store.WriteEntry(ctx, sessionId, common.DATA_RECIPIENT, []byte(m.PublicKey))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write recipient entry with", "key", common.DATA_RECIPIENT, "value", m.PublicKey, "error", err)
+		return res, err
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_invalid_till)
+	res.Content = till
+	return res, nil
+}
+
+// readTillMerchant returns the merchant of the till number saved by ValidateTill.
+func (h *Handlers) readTillMerchant(ctx context.Context, sessionId string) (common.Merchant, error) {
+	till, err := h.userdataStore.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read temporaryValue entry with", "key", common.DATA_TEMPORARY_VALUE, "error", err)
+		return common.Merchant{}, err
+	}
+	return common.ReadMerchant(ctx, h.userdataStore, string(till))
+}
+
+// GetMerchant returns the name of the merchant of the till number, so that it can be confirmed before the amount is entered.
+func (h *Handlers) GetMerchant(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	m, err := h.readTillMerchant(ctx, sessionId)
+	if err != nil {
+		return res, err
+	}
+
+	res.Content = l.Get("Pay %s\nTill: %s", m.Name, m.Till)
+	return res, nil
+}
+
+// GetMerchantPayment returns the amount and the merchant of the pending merchant payment.
+func (h *Handlers) GetMerchantPayment(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	store := h.userdataStore
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	m, err := h.readTillMerchant(ctx, sessionId)
+	if err != nil {
+		return res, err
+	}
+	activeSym, err := store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_SYM)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read activeSym entry with", "key", common.DATA_ACTIVE_SYM, "error", err)
+		return res, err
+	}
+	amount, _ := store.ReadEntry(ctx, sessionId, common.DATA_AMOUNT)
+
+	res.Content = l.Get("Pay %s %s to %s (till %s)", string(amount), string(activeSym), m.Name, m.Till)
+	return res, nil
+}
+
+// InitiateMerchantPayment pays the merchant of the till number once the PIN is entered, through the same checks
+// as InitiateTransaction. The merchant is sent a receipt by SMS.
+func (h *Handlers) InitiateMerchantPayment(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_account_authorized, _ := h.flagManager.GetFlag("flag_account_authorized")
+	flag_transfer_confirmed, _ := h.flagManager.GetFlag("flag_transfer_confirmed")
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	res, err := h.checkTransferAccount(ctx, sessionId, l)
+	if err != nil || res.Content != "" {
+		return res, err
+	}
+
+	data, err := common.ReadTransactionData(ctx, h.userdataStore, sessionId)
+	if err != nil {
+		return res, err
+	}
+
+	// the merchant is read again, as its details may have changed since the till number was entered
+	m, err := common.ReadMerchant(ctx, h.userdataStore, data.TemporaryValue)
+	if err != nil && err != common.ErrMerchantNotFound {
+		logg.ErrorCtxf(ctx, "failed to read merchant", "till", data.TemporaryValue, "error", err)
+		return res, err
+	}
+	if err == common.ErrMerchantNotFound {
+		res.Content = l.Get("No merchant uses till %s.", data.TemporaryValue)
+	} else if !m.Accepts(data.ActiveSym, data.ActiveAddress) {
+		res.Content = l.Get("%s does not accept %s.", m.Name, data.ActiveSym)
+	}
+	if res.Content != "" {
+		res.FlagReset = append(res.FlagReset, flag_account_authorized, flag_transfer_confirmed)
+		return res, nil
+	}
+	data.Recipient = m.PublicKey
+
+	confirmed := h.st != nil && h.st.MatchFlag(flag_transfer_confirmed, true)
+	res, trackingId, err := h.transfer(ctx, sessionId, data, confirmed, l)
+	if err != nil || res.Content != "" {
+		return res, err
+	}
+	logg.InfoCtxf(ctx, "merchant paid", "session", sessionId, "till", m.Till, "trackingId", trackingId)
+
+	err = h.getNotifier().Notify(ctx, m.Phone, notify.Locale(ctx, h.userdataStore, m.Phone).Get("%s received %s %s at till %s from %s on Sarafu.", m.Name, data.Amount, data.ActiveSym, m.Till, sessionId))
+	if err != nil {
+		logg.WarnCtxf(ctx, "merchant receipt failed", "till", m.Till, "error", err)
+	}
+
+	res.Content = l.Get("Your payment of %s %s to %s (till %s) has been sent.", data.Amount, data.ActiveSym, m.Name, m.Till)
+	res.FlagReset = append(res.FlagReset, flag_account_authorized, flag_transfer_confirmed)
+	return res, nil
+}
+
+// scheduleWhen describes when the transfers of a schedule are made, from its next date.
+func scheduleWhen(l *gotext.Locale, s common.Schedule) string {
+	date := s.Next.Format("02/01/2006")
+	switch s.Frequency {
+	case common.FrequencyWeekly:
+		return l.Get("every week from %s", date)
+	case common.FrequencyMonthly:
+		return l.Get("every month from %s", date)
+	}
+	return l.Get("on %s", date)
+}
+
+// SetScheduleFrequency starts a scheduled transfer of the pending transfer, made once, weekly or monthly
+// as selected by the input.
+func (h *Handlers) SetScheduleFrequency(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_invalid_schedule_date, _ := h.flagManager.GetFlag("flag_invalid_schedule_date")
+
+	var frequency common.Frequency
+	switch string(input) {
+	case "1":
+		frequency = common.FrequencyOnce
+	case "2":
+		frequency = common.FrequencyWeekly
+	case "3":
+		frequency = common.FrequencyMonthly
+	default:
+		return res, nil
+	}
+
+	data, err := common.ReadTransactionData(ctx, h.userdataStore, sessionId)
+	if err != nil {
+		return res, err
+	}
+	draft := common.Schedule{
+		Recipient:        data.TemporaryValue,
+		RecipientAddress: data.Recipient,
+		Amount:           data.Amount,
+		Symbol:           data.ActiveSym,
+		Address:          data.ActiveAddress,
+		Decimals:         data.ActiveDecimal,
+		Frequency:        frequency,
+	}
+	err = common.WriteScheduleDraft(ctx, h.userdataStore, sessionId, draft)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write schedule draft entry with", "key", common.DATA_SCHEDULE_DRAFT, "error", err)
+		return res, err
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_invalid_schedule_date)
+	return res, nil
+}
+
+// SetScheduleDate sets the date of the first transfer of the scheduled transfer, entered as DDMMYYYY.
+// The date must be after today and within a year. If not, the flag_invalid_schedule_date flag is set
+// with the reason as the result content.
+func (h *Handlers) SetScheduleDate(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_invalid_schedule_date, _ := h.flagManager.GetFlag("flag_invalid_schedule_date")
+	store := h.userdataStore
+
+	inputStr := strings.TrimSpace(string(input))
+	if inputStr == "0" {
+		res.FlagReset = append(res.FlagReset, flag_invalid_schedule_date)
+		return res, nil
+	}
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	date, err := time.ParseInLocation("02012006", inputStr, time.Local)
 	if err != nil {
+		res.FlagSet = append(res.FlagSet, flag_invalid_schedule_date)
+		res.Content = l.Get("%s is not a valid date. Enter it as DDMMYYYY.", inputStr)
 		return res, nil
 	}
-
-	err = store.WriteEntry(ctx, sessionId, common.DATA_RECIPIENT, []byte(""))
-	if err != nil {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	if !date.After(today) {
+		res.FlagSet = append(res.FlagSet, flag_invalid_schedule_date)
+		res.Content = l.Get("The date must be after today.")
+		return res, nil
+	}
+	if date.After(today.AddDate(1, 0, 0)) {
+		res.FlagSet = append(res.FlagSet, flag_invalid_schedule_date)
+		res.Content = l.Get("The date must be within a year.")
 		return res, nil
 	}
 
-	res.FlagReset = append(res.FlagReset, flag_invalid_recipient, flag_invalid_recipient_with_invite)
+	draft, err := common.ReadScheduleDraft(ctx, store, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read schedule draft entry with", "key", common.DATA_SCHEDULE_DRAFT, "error", err)
+		return res, err
+	}
+	draft.Start = date.Add(scheduleHour * time.Hour)
+	draft.Next = draft.Start
+	err = common.WriteScheduleDraft(ctx, store, sessionId, draft)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write schedule draft entry with", "key", common.DATA_SCHEDULE_DRAFT, "error", err)
+		return res, err
+	}
 
+	res.FlagReset = append(res.FlagReset, flag_invalid_schedule_date)
+	res.Content = inputStr
 	return res, nil
 }
 
-// InviteValidRecipient sends an invitation to the valid phone number.
-func (h *Handlers) InviteValidRecipient(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+// GetSchedule returns the details of the scheduled transfer being entered, so that it can be confirmed with the PIN.
+func (h *Handlers) GetSchedule(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
-	store := h.userdataStore
 
 	sessionId, ok := ctx.Value("SessionId").(string)
 	if !ok {
@@ -997,133 +4321,187 @@ func (h *Handlers) InviteValidRecipient(ctx context.Context, sym string, input [
 	l := gotext.NewLocale(translationDir, code)
 	l.AddDomain("default")
 
-	recipient, _ := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
-
-	// TODO
-	// send an invitation SMS
-	// if successful
-	// res.Content = l.Get("Your invitation to %s to join Sarafu Network has been sent.",  string(recipient))
+	draft, err := common.ReadScheduleDraft(ctx, h.userdataStore, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read schedule draft entry with", "key", common.DATA_SCHEDULE_DRAFT, "error", err)
+		return res, err
+	}
 
-	res.Content = l.Get("Your invite request for %s to Sarafu Network failed. Please try again later.", string(recipient))
+	res.Content = l.Get("Send %s %s to %s %s", draft.Amount, draft.Symbol, draft.Recipient, scheduleWhen(l, draft))
 	return res, nil
 }
 
-// ResetTransactionAmount resets the transaction amount and invalid flag
-func (h *Handlers) ResetTransactionAmount(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+// CheckScheduleRisk evaluates the scheduled transfer being entered against the transfer risk rules, setting
+// flag_transfer_confirm with the reason as content when the rules ask for a confirmation.
+//
+// A denied schedule is reported by CreateSchedule.
+func (h *Handlers) CheckScheduleRisk(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
-	var err error
 
 	sessionId, ok := ctx.Value("SessionId").(string)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
+	flag_transfer_confirm, _ := h.flagManager.GetFlag("flag_transfer_confirm")
+	flag_transfer_confirmed, _ := h.flagManager.GetFlag("flag_transfer_confirmed")
 
-	flag_invalid_amount, _ := h.flagManager.GetFlag("flag_invalid_amount")
-	store := h.userdataStore
-	err = store.WriteEntry(ctx, sessionId, common.DATA_AMOUNT, []byte(""))
+	draft, err := common.ReadScheduleDraft(ctx, h.userdataStore, sessionId)
 	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read schedule draft entry with", "key", common.DATA_SCHEDULE_DRAFT, "error", err)
+		return res, err
+	}
+	t, d, err := h.evaluate(ctx, sessionId, draft.Amount, draft.Symbol, draft.RecipientAddress)
+	if err != nil {
+		return res, err
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_transfer_confirmed)
+	if d.Action != risk.Confirm {
+		res.FlagReset = append(res.FlagReset, flag_transfer_confirm)
 		return res, nil
 	}
 
-	res.FlagReset = append(res.FlagReset, flag_invalid_amount)
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
 
+	res.FlagSet = append(res.FlagSet, flag_transfer_confirm)
+	res.Content = riskReason(l, d, t.Voucher)
 	return res, nil
 }
 
-// MaxAmount gets the current balance from the API and sets it as
-// the result content.
-func (h *Handlers) MaxAmount(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+// CreateSchedule saves the scheduled transfer being entered once the PIN is entered, after the same account
+// checks as InitiateTransaction. Its transfers are made by the scheduler.
+func (h *Handlers) CreateSchedule(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
-	var err error
 
 	sessionId, ok := ctx.Value("SessionId").(string)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
+	flag_account_authorized, _ := h.flagManager.GetFlag("flag_account_authorized")
+	flag_transfer_confirmed, _ := h.flagManager.GetFlag("flag_transfer_confirmed")
 	store := h.userdataStore
 
-	activeBal, err := store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_BAL)
-	if err != nil {
-		logg.ErrorCtxf(ctx, "failed to read activeBal entry with", "key", common.DATA_ACTIVE_BAL, "error", err)
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	res, err := h.checkTransferAccount(ctx, sessionId, l)
+	if err != nil || res.Content != "" {
 		return res, err
 	}
+	res.FlagReset = append(res.FlagReset, flag_account_authorized, flag_transfer_confirmed)
 
-	res.Content = string(activeBal)
+	draft, err := common.ReadScheduleDraft(ctx, store, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read schedule draft entry with", "key", common.DATA_SCHEDULE_DRAFT, "error", err)
+		return res, err
+	}
+	// each transfer is evaluated again when it is made; a confirmation the rules ask for is given once, for
+	// all the transfers of the schedule
+	t, d, err := h.evaluate(ctx, sessionId, draft.Amount, draft.Symbol, draft.RecipientAddress)
+	if err != nil {
+		return res, err
+	}
+	confirmed := h.st != nil && h.st.MatchFlag(flag_transfer_confirmed, true)
+	if d.Action == risk.Deny || (d.Action == risk.Confirm && !confirmed) {
+		logg.WarnCtxf(ctx, "schedule blocked by risk rules", "session", sessionId, "rule", d.Rule, "action", d.Action)
+		res.Content = riskReason(l, d, t.Voucher)
+		return res, nil
+	}
+	s, err := common.AddSchedule(ctx, store, sessionId, draft)
+	if err != nil {
+		if err == common.ErrSchedulesFull {
+			res.Content = l.Get("You already have %d scheduled transfers. Cancel one to schedule another.", common.MaxSchedules)
+			return res, nil
+		}
+		logg.ErrorCtxf(ctx, "failed to write schedules entry with", "key", common.DATA_SCHEDULES, "error", err)
+		return res, err
+	}
+	logg.InfoCtxf(ctx, "transfer scheduled", "session", sessionId, "schedule", s.Id, "frequency", s.Frequency, "start", s.Start)
 
+	res.Content = l.Get("Your transfer of %s %s to %s %s has been scheduled.", s.Amount, s.Symbol, s.Recipient, scheduleWhen(l, s))
 	return res, nil
 }
 
-// ValidateAmount ensures that the given input is a valid amount and that
-// it is not more than the current balance.
-func (h *Handlers) ValidateAmount(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+// GetSchedules returns the numbered list of the scheduled transfers of the account, with the date of their next transfer.
+func (h *Handlers) GetSchedules(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 
 	sessionId, ok := ctx.Value("SessionId").(string)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
-	flag_invalid_amount, _ := h.flagManager.GetFlag("flag_invalid_amount")
-	store := h.userdataStore
 
-	var balanceValue float64
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
 
-	// retrieve the active balance
-	activeBal, err := store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_BAL)
-	if err != nil {
-		logg.ErrorCtxf(ctx, "failed to read activeBal entry with", "key", common.DATA_ACTIVE_BAL, "error", err)
-		return res, err
-	}
-	balanceValue, err = strconv.ParseFloat(string(activeBal), 64)
+	schedules, err := common.ReadSchedules(ctx, h.userdataStore, sessionId)
 	if err != nil {
-		logg.ErrorCtxf(ctx, "Failed to convert the activeBal to a float", "error", err)
+		logg.ErrorCtxf(ctx, "failed to read schedules entry with", "key", common.DATA_SCHEDULES, "error", err)
 		return res, err
 	}
-
-	// Extract numeric part from the input amount
-	amountStr := strings.TrimSpace(string(input))
-	inputAmount, err := strconv.ParseFloat(amountStr, 64)
-	if err != nil {
-		res.FlagSet = append(res.FlagSet, flag_invalid_amount)
-		res.Content = amountStr
-		return res, nil
-	}
-
-	if inputAmount > balanceValue {
-		res.FlagSet = append(res.FlagSet, flag_invalid_amount)
-		res.Content = amountStr
+	if len(schedules) == 0 {
+		res.Content = l.Get("You have no scheduled transfers.")
 		return res, nil
 	}
-
-	// Format the amount with 2 decimal places before saving
-	formattedAmount := fmt.Sprintf("%.2f", inputAmount)
-	err = store.WriteEntry(ctx, sessionId, common.DATA_AMOUNT, []byte(formattedAmount))
-	if err != nil {
-		logg.ErrorCtxf(ctx, "failed to write amount entry with", "key", common.DATA_AMOUNT, "value", formattedAmount, "error", err)
-		return res, err
+	var lines []string
+	for i, s := range schedules {
+		lines = append(lines, fmt.Sprintf("%d:%s %s %s %s", i+1, s.Amount, s.Symbol, s.Recipient, s.Next.Format("02/01/2006")))
 	}
-
-	res.Content = formattedAmount
+	res.Content = strings.Join(lines, "\n")
 	return res, nil
 }
 
-// GetRecipient returns the transaction recipient phone number from the gdbm.
-func (h *Handlers) GetRecipient(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+// ViewSchedule selects the scheduled transfer at the given position in the list returned by GetSchedules,
+// and returns its details. If there is no such schedule, the flag_schedule_rejected flag is set.
+func (h *Handlers) ViewSchedule(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 
 	sessionId, ok := ctx.Value("SessionId").(string)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
+	flag_schedule_rejected, _ := h.flagManager.GetFlag("flag_schedule_rejected")
 	store := h.userdataStore
-	recipient, _ := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
 
-	res.Content = string(recipient)
+	inputStr := string(input)
+	if inputStr == "0" {
+		res.FlagReset = append(res.FlagReset, flag_schedule_rejected)
+		return res, nil
+	}
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	schedules, err := common.ReadSchedules(ctx, store, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read schedules entry with", "key", common.DATA_SCHEDULES, "error", err)
+		return res, err
+	}
+	i, err := strconv.Atoi(inputStr)
+	if err != nil || i < 1 || i > len(schedules) {
+		res.FlagSet = append(res.FlagSet, flag_schedule_rejected)
+		return res, nil
+	}
+	s := schedules[i-1]
+
+	err = store.WriteEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE, []byte(s.Id))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write temporaryValue entry with", "key", common.DATA_TEMPORARY_VALUE, "value", s.Id, "error", err)
+		return res, err
+	}
 
+	res.FlagReset = append(res.FlagReset, flag_schedule_rejected)
+	res.Content = l.Get("Send %s %s to %s %s", s.Amount, s.Symbol, s.Recipient, scheduleWhen(l, s))
 	return res, nil
 }
 
-// RetrieveBlockedNumber gets the current number during the pin reset for other's is in progress.
-func (h *Handlers) RetrieveBlockedNumber(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+// CancelSchedule cancels the selected scheduled transfer, so that no more of its transfers are made.
+func (h *Handlers) CancelSchedule(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 
 	sessionId, ok := ctx.Value("SessionId").(string)
@@ -1131,29 +4509,88 @@ func (h *Handlers) RetrieveBlockedNumber(ctx context.Context, sym string, input
 		return res, fmt.Errorf("missing session")
 	}
 	store := h.userdataStore
-	blockedNumber, _ := store.ReadEntry(ctx, sessionId, common.DATA_BLOCKED_NUMBER)
 
-	res.Content = string(blockedNumber)
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	id, err := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read temporaryValue entry with", "key", common.DATA_TEMPORARY_VALUE, "error", err)
+		return res, err
+	}
+	s, err := common.RemoveSchedule(ctx, store, sessionId, string(id))
+	if err != nil {
+		if err == common.ErrNoSchedule {
+			res.Content = l.Get("The scheduled transfer has already ended.")
+			return res, nil
+		}
+		logg.ErrorCtxf(ctx, "failed to write schedules entry with", "key", common.DATA_SCHEDULES, "error", err)
+		return res, err
+	}
+	logg.InfoCtxf(ctx, "scheduled transfer cancelled", "session", sessionId, "schedule", s.Id)
 
+	res.Content = l.Get("Your scheduled transfer of %s %s to %s has been cancelled.", s.Amount, s.Symbol, s.Recipient)
 	return res, nil
 }
 
-// GetSender returns the sessionId (phoneNumber)
-func (h *Handlers) GetSender(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+// SetEscrowRecipient makes the escrow account the recipient of the pending transfer to an unregistered number,
+// where it is held until the number registers.
+// If the number cannot be sent to, the flag_escrow_unavailable flag is set with the reason as the result content.
+func (h *Handlers) SetEscrowRecipient(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 
 	sessionId, ok := ctx.Value("SessionId").(string)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
+	flag_escrow_unavailable, _ := h.flagManager.GetFlag("flag_escrow_unavailable")
+	store := h.userdataStore
 
-	res.Content = sessionId
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	recipient, err := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read temporaryRecipient entry with", "key", common.DATA_TEMPORARY_VALUE, "error", err)
+		return res, err
+	}
+	address := h.getEscrowService().Address()
+	if address == "" {
+		res.Content = l.Get("Sending to numbers not on Sarafu Network is not available.")
+		res.FlagSet = append(res.FlagSet, flag_escrow_unavailable)
+		return res, nil
+	}
+	number, err := common.NormalizePhoneNumber(string(recipient))
+	if err != nil {
+		res.Content = l.Get("%s is not a valid phone number.", string(recipient))
+		res.FlagSet = append(res.FlagSet, flag_escrow_unavailable)
+		return res, nil
+	}
+	claims, err := common.ReadClaims(ctx, store, number)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read escrowed transfers", "number", number, "error", err)
+		return res, err
+	}
+	if len(claims) >= common.MaxClaims {
+		res.Content = l.Get("%s cannot receive more transfers until they join Sarafu Network.", string(recipient))
+		res.FlagSet = append(res.FlagSet, flag_escrow_unavailable)
+		return res, nil
+	}
 
+	err = store.WriteEntry(ctx, sessionId, common.DATA_RECIPIENT, []byte(address))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write recipient entry with", "key", common.DATA_RECIPIENT, "value", address, "error", err)
+		return res, err
+	}
+	res.FlagReset = append(res.FlagReset, flag_escrow_unavailable)
 	return res, nil
 }
 
-// GetAmount retrieves the amount from teh Gdbm Db
-func (h *Handlers) GetAmount(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+// GetEscrowTransfer returns the amount and the recipient of the pending transfer to an unregistered number,
+// and when it is returned if not claimed.
+func (h *Handlers) GetEscrowTransfer(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 
 	sessionId, ok := ctx.Value("SessionId").(string)
@@ -1162,67 +4599,112 @@ func (h *Handlers) GetAmount(ctx context.Context, sym string, input []byte) (res
 	}
 	store := h.userdataStore
 
-	// retrieve the active symbol
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	recipient, _ := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
+	amount, _ := store.ReadEntry(ctx, sessionId, common.DATA_AMOUNT)
 	activeSym, err := store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_SYM)
 	if err != nil {
 		logg.ErrorCtxf(ctx, "failed to read activeSym entry with", "key", common.DATA_ACTIVE_SYM, "error", err)
 		return res, err
 	}
+	expires := time.Now().Add(h.getEscrowService().Ttl()).Format("02/01/2006")
 
-	amount, _ := store.ReadEntry(ctx, sessionId, common.DATA_AMOUNT)
-
-	res.Content = fmt.Sprintf("%s %s", string(amount), string(activeSym))
-
+	res.Content = l.Get("Send %s %s to %s\nIt is returned to you if not claimed by %s.", string(amount), string(activeSym), string(recipient), expires)
 	return res, nil
 }
 
-// InitiateTransaction calls the TokenTransfer and returns a confirmation based on the result
-func (h *Handlers) InitiateTransaction(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+// InitiateEscrowTransfer sends the pending transfer to an unregistered number to the escrow account once the PIN
+// is entered, through the same checks as InitiateTransaction. The number is told of the transfer by SMS.
+func (h *Handlers) InitiateEscrowTransfer(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
-	var err error
+
 	sessionId, ok := ctx.Value("SessionId").(string)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
-
 	flag_account_authorized, _ := h.flagManager.GetFlag("flag_account_authorized")
+	flag_transfer_confirmed, _ := h.flagManager.GetFlag("flag_transfer_confirmed")
+	store := h.userdataStore
 
 	code := codeFromCtx(ctx)
 	l := gotext.NewLocale(translationDir, code)
 	l.AddDomain("default")
 
-	data, err := common.ReadTransactionData(ctx, h.userdataStore, sessionId)
-	if err != nil {
+	res, err := h.checkTransferAccount(ctx, sessionId, l)
+	if err != nil || res.Content != "" {
 		return res, err
 	}
 
-	finalAmountStr, err := common.ParseAndScaleAmount(data.Amount, data.ActiveDecimal)
+	data, err := common.ReadTransactionData(ctx, store, sessionId)
 	if err != nil {
 		return res, err
 	}
 
-	// Call TokenTransfer
-	r, err := h.accountService.TokenTransfer(ctx, finalAmountStr, data.PublicKey, data.Recipient, data.ActiveAddress)
+	es := h.getEscrowService()
+	if es.Address() == "" {
+		res.Content = l.Get("Sending to numbers not on Sarafu Network is not available.")
+	}
+	number, err := common.NormalizePhoneNumber(data.TemporaryValue)
 	if err != nil {
-		flag_api_error, _ := h.flagManager.GetFlag("flag_api_call_error")
-		res.FlagSet = append(res.FlagSet, flag_api_error)
-		res.Content = l.Get("Your request failed. Please try again later.")
-		logg.ErrorCtxf(ctx, "failed on TokenTransfer", "error", err)
+		res.Content = l.Get("%s is not a valid phone number.", data.TemporaryValue)
+	}
+	if res.Content != "" {
+		res.FlagReset = append(res.FlagReset, flag_account_authorized, flag_transfer_confirmed)
 		return res, nil
 	}
 
-	trackingId := r.TrackingId
-	logg.InfoCtxf(ctx, "TokenTransfer", "trackingId", trackingId)
+	// the claim is written before the transfer, so that a transfer made is never left without one; the number is
+	// checked again, as other transfers to it may have been made since it was entered
+	c, err := common.AddClaim(ctx, store, common.Claim{
+		Sender:        sessionId,
+		SenderAddress: data.PublicKey,
+		Recipient:     number,
+		Amount:        data.Amount,
+		Symbol:        data.ActiveSym,
+		Address:       data.ActiveAddress,
+		Decimals:      data.ActiveDecimal,
+		Status:        common.ClaimPending,
+	})
+	if err != nil {
+		if err == common.ErrClaimsFull {
+			res.Content = l.Get("%s cannot receive more transfers until they join Sarafu Network.", data.TemporaryValue)
+			res.FlagReset = append(res.FlagReset, flag_account_authorized, flag_transfer_confirmed)
+			return res, nil
+		}
+		logg.ErrorCtxf(ctx, "failed to add escrowed transfer", "number", number, "error", err)
+		return res, err
+	}
+	data.Recipient = es.Address()
 
-	res.Content = l.Get(
-		"Your request has been sent. %s will receive %s %s from %s.",
-		data.TemporaryValue,
-		data.Amount,
-		data.ActiveSym,
-		sessionId,
-	)
+	confirmed := h.st != nil && h.st.MatchFlag(flag_transfer_confirmed, true)
+	res, trackingId, err := h.transfer(ctx, sessionId, data, confirmed, l)
+	if err != nil || res.Content != "" {
+		// the transfer was not made
+		_, rerr := common.RemoveClaim(ctx, store, number, c.Id)
+		if rerr != nil {
+			logg.ErrorCtxf(ctx, "failed to remove escrowed transfer that was not sent", "number", number, "claim", c.Id, "error", rerr)
+		}
+		return res, err
+	}
+	_, err = common.HoldClaim(ctx, store, number, c.Id, trackingId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to hold escrowed transfer", "number", number, "claim", c.Id, "trackingId", trackingId, "error", err)
+		return res, err
+	}
+	logg.InfoCtxf(ctx, "transfer escrowed", "session", sessionId, "number", number, "claim", c.Id, "trackingId", trackingId)
+	expires := c.ExpiresAt(es.Ttl()).Format("02/01/2006")
 
-	res.FlagReset = append(res.FlagReset, flag_account_authorized)
+	// the number is not registered, so the notification is in the language of the sender
+	err = h.getNotifier().Notify(ctx, number, l.Get("%s has sent you %s %s on Sarafu Network. Dial in and register by %s to receive it.", sessionId, data.Amount, data.ActiveSym, expires))
+	if err != nil {
+		logg.WarnCtxf(ctx, "escrowed transfer notification failed", "number", number, "error", err)
+	}
+
+	res.Content = l.Get("Your transfer of %s %s to %s will be sent once they join Sarafu Network. It is returned to you if not claimed by %s.", data.Amount, data.ActiveSym, data.TemporaryValue, expires)
+	res.FlagReset = append(res.FlagReset, flag_account_authorized, flag_transfer_confirmed)
 	return res, nil
 }
 
@@ -1395,6 +4877,78 @@ func (h *Handlers) GetProfileInfo(ctx context.Context, sym string, input []byte)
 	return res, nil
 }
 
+// GetCurrentAlias returns the alias currently claimed by the user.
+func (h *Handlers) GetCurrentAlias(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	store := h.userdataStore
+	alias, err := store.ReadEntry(ctx, sessionId, common.DATA_ALIAS)
+	if err != nil {
+		if db.IsNotFound(err) {
+			res.Content = l.Get("Not provided")
+			return res, nil
+		}
+		logg.ErrorCtxf(ctx, "failed to read alias entry with", "key", common.DATA_ALIAS, "error", err)
+		return res, err
+	}
+	if len(alias) == 0 {
+		res.Content = l.Get("Not provided")
+		return res, nil
+	}
+
+	res.Content = string(alias)
+	return res, nil
+}
+
+// ClaimAlias registers the given input as the user's alias, which others can use
+// instead of the phone number when sending.
+func (h *Handlers) ClaimAlias(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+	sessionId, ok := ctx.Value("SessionId").(string)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+
+	flag_invalid_alias, _ := h.flagManager.GetFlag("flag_invalid_alias")
+
+	code := codeFromCtx(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	alias := common.NormalizeAlias(string(input))
+	if alias == "0" {
+		return res, nil
+	}
+
+	err := common.ClaimAlias(ctx, h.userdataStore, sessionId, alias)
+	switch err {
+	case nil:
+	case common.ErrInvalidAlias:
+		res.FlagSet = append(res.FlagSet, flag_invalid_alias)
+		res.Content = l.Get("%s is not a valid username. Use 3 to 16 letters or numbers, starting with a letter.", alias)
+		return res, nil
+	case common.ErrAliasTaken:
+		res.FlagSet = append(res.FlagSet, flag_invalid_alias)
+		res.Content = l.Get("The username %s is already taken.", alias)
+		return res, nil
+	default:
+		logg.ErrorCtxf(ctx, "failed on ClaimAlias", "alias", alias, "error", err)
+		return res, err
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_invalid_alias)
+	res.Content = alias
+	return res, nil
+}
+
 // SetDefaultVoucher retrieves the current vouchers
 // and sets the first as the default voucher, if no active voucher is set
 func (h *Handlers) SetDefaultVoucher(ctx context.Context, sym string, input []byte) (resource.Result, error) {
@@ -2,18 +2,28 @@ package ussd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"path"
+	"strconv"
 	"testing"
+	"time"
 
 	"git.defalsify.org/vise.git/lang"
 	"git.defalsify.org/vise.git/persist"
 	"git.defalsify.org/vise.git/resource"
 	"git.defalsify.org/vise.git/state"
+	"git.grassecon.net/urdt/ussd/internal/community"
+	"git.grassecon.net/urdt/ussd/internal/escrow"
+	"git.grassecon.net/urdt/ussd/internal/operator"
+	"git.grassecon.net/urdt/ussd/internal/provision"
+	"git.grassecon.net/urdt/ussd/internal/risk"
 	"git.grassecon.net/urdt/ussd/internal/storage"
+	"git.grassecon.net/urdt/ussd/internal/terms"
 	"git.grassecon.net/urdt/ussd/internal/testutil/mocks"
 	"git.grassecon.net/urdt/ussd/internal/testutil/testservice"
+	"git.grassecon.net/urdt/ussd/internal/testutil/teststore"
 	"git.grassecon.net/urdt/ussd/models"
 
 	"git.grassecon.net/urdt/ussd/common"
@@ -31,25 +41,6 @@ var (
 	flagsPath = path.Join(baseDir, "services", "registration", "pp.csv")
 )
 
-// InitializeTestStore sets up and returns an in-memory database and store.
-func InitializeTestStore(t *testing.T) (context.Context, *common.UserDataStore) {
-	ctx := context.Background()
-
-	// Initialize memDb
-	db := memdb.NewMemDb()
-	err := db.Connect(ctx, "")
-	require.NoError(t, err, "Failed to connect to memDb")
-
-	// Create UserDataStore with memDb
-	store := &common.UserDataStore{Db: db}
-
-	t.Cleanup(func() {
-		db.Close() // Ensure the DB is closed after each test
-	})
-
-	return ctx, store
-}
-
 func InitializeTestSubPrefixDb(t *testing.T, ctx context.Context) *storage.SubPrefixDb {
 	db := memdb.NewMemDb()
 	err := db.Connect(ctx, "")
@@ -62,7 +53,7 @@ func InitializeTestSubPrefixDb(t *testing.T, ctx context.Context) *storage.SubPr
 }
 
 func TestNewHandlers(t *testing.T) {
-	_, store := InitializeTestStore(t)
+	_, store := teststore.InitializeTestStore(t)
 
 	fm, err := NewFlagManager(flagsPath)
 	accountService := testservice.TestAccountService{}
@@ -99,7 +90,7 @@ func TestNewHandlers(t *testing.T) {
 
 func TestCreateAccount(t *testing.T) {
 	sessionId := "session123"
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
 	fm, err := NewFlagManager(flagsPath)
@@ -111,13 +102,26 @@ func TestCreateAccount(t *testing.T) {
 	if err != nil {
 		t.Logf(err.Error())
 	}
+	flag_account_creation_failed, err := fm.GetFlag("flag_account_creation_failed")
+	if err != nil {
+		t.Logf(err.Error())
+	}
 
 	tests := []struct {
 		name           string
 		serverResponse *models.AccountResult
+		serverErr      error
 		expectedResult resource.Result
 	}{
 		{
+			name:      "Test account creation failure",
+			serverErr: errors.New("service unavailable"),
+			expectedResult: resource.Result{
+				FlagSet: []uint32{flag_account_creation_failed},
+			},
+		},
+		{
+			// the failed request is retried
 			name: "Test account creation success",
 			serverResponse: &models.AccountResult{
 				TrackingId: "1234567890",
@@ -138,7 +142,7 @@ func TestCreateAccount(t *testing.T) {
 				flagManager:    fm.parser,
 			}
 
-			mockAccountService.On("CreateAccount").Return(tt.serverResponse, nil)
+			mockAccountService.On("CreateAccount").Return(tt.serverResponse, tt.serverErr)
 
 			// Call the method you want to test
 			res, err := h.CreateAccount(ctx, "create_account", []byte(""))
@@ -173,9 +177,14 @@ func TestWithPersister_PanicWhenAlreadySet(t *testing.T) {
 
 func TestSaveFirstname(t *testing.T) {
 	sessionId := "session123"
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
+	// The PIN has just been entered
+	if _, err := common.IssueAuthToken(ctx, store, sessionId); err != nil {
+		t.Fatal(err)
+	}
+
 	fm, _ := NewFlagManager(flagsPath)
 
 	flag_allow_update, _ := fm.GetFlag("flag_allow_update")
@@ -212,9 +221,14 @@ func TestSaveFirstname(t *testing.T) {
 
 func TestSaveFamilyname(t *testing.T) {
 	sessionId := "session123"
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
+	// The PIN has just been entered
+	if _, err := common.IssueAuthToken(ctx, store, sessionId); err != nil {
+		t.Fatal(err)
+	}
+
 	fm, _ := NewFlagManager(flagsPath)
 
 	flag_allow_update, _ := fm.GetFlag("flag_allow_update")
@@ -251,9 +265,14 @@ func TestSaveFamilyname(t *testing.T) {
 
 func TestSaveYoB(t *testing.T) {
 	sessionId := "session123"
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
+	// The PIN has just been entered
+	if _, err := common.IssueAuthToken(ctx, store, sessionId); err != nil {
+		t.Fatal(err)
+	}
+
 	fm, _ := NewFlagManager(flagsPath)
 
 	flag_allow_update, _ := fm.GetFlag("flag_allow_update")
@@ -290,9 +309,14 @@ func TestSaveYoB(t *testing.T) {
 
 func TestSaveLocation(t *testing.T) {
 	sessionId := "session123"
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
+	// The PIN has just been entered
+	if _, err := common.IssueAuthToken(ctx, store, sessionId); err != nil {
+		t.Fatal(err)
+	}
+
 	fm, _ := NewFlagManager(flagsPath)
 
 	flag_allow_update, _ := fm.GetFlag("flag_allow_update")
@@ -329,9 +353,14 @@ func TestSaveLocation(t *testing.T) {
 
 func TestSaveOfferings(t *testing.T) {
 	sessionId := "session123"
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
+	// The PIN has just been entered
+	if _, err := common.IssueAuthToken(ctx, store, sessionId); err != nil {
+		t.Fatal(err)
+	}
+
 	fm, _ := NewFlagManager(flagsPath)
 
 	flag_allow_update, _ := fm.GetFlag("flag_allow_update")
@@ -368,9 +397,14 @@ func TestSaveOfferings(t *testing.T) {
 
 func TestSaveGender(t *testing.T) {
 	sessionId := "session123"
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
+	// The PIN has just been entered
+	if _, err := common.IssueAuthToken(ctx, store, sessionId); err != nil {
+		t.Fatal(err)
+	}
+
 	fm, _ := NewFlagManager(flagsPath)
 
 	flag_allow_update, _ := fm.GetFlag("flag_allow_update")
@@ -436,7 +470,7 @@ func TestSaveGender(t *testing.T) {
 
 func TestSaveTemporaryPin(t *testing.T) {
 	sessionId := "session123"
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
 	fm, err := NewFlagManager(flagsPath)
@@ -460,7 +494,7 @@ func TestSaveTemporaryPin(t *testing.T) {
 	}{
 		{
 			name:  "Valid Pin entry",
-			input: []byte("1234"),
+			input: []byte("4831"),
 			expectedResult: resource.Result{
 				FlagReset: []uint32{flag_incorrect_pin},
 			},
@@ -470,6 +504,15 @@ func TestSaveTemporaryPin(t *testing.T) {
 			input: []byte("12343"),
 			expectedResult: resource.Result{
 				FlagSet: []uint32{flag_incorrect_pin},
+				Content: "The PIN must be 4 digits.",
+			},
+		},
+		{
+			name:  "Sequential Pin entry",
+			input: []byte("1234"),
+			expectedResult: resource.Result{
+				FlagSet: []uint32{flag_incorrect_pin},
+				Content: "The PIN is too easy to guess. Do not use repeated or consecutive digits.",
 			},
 		},
 	}
@@ -490,7 +533,7 @@ func TestSaveTemporaryPin(t *testing.T) {
 
 func TestCheckIdentifier(t *testing.T) {
 	sessionId := "session123"
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
 	// Define test cases
@@ -534,7 +577,7 @@ func TestCheckIdentifier(t *testing.T) {
 
 func TestGetSender(t *testing.T) {
 	sessionId := "session123"
-	ctx, _ := InitializeTestStore(t)
+	ctx, _ := teststore.InitializeTestStore(t)
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
 	// Create the Handlers instance
@@ -549,7 +592,7 @@ func TestGetSender(t *testing.T) {
 
 func TestGetAmount(t *testing.T) {
 	sessionId := "session123"
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
 	// Define test data
@@ -582,7 +625,7 @@ func TestGetAmount(t *testing.T) {
 
 func TestGetRecipient(t *testing.T) {
 	sessionId := "session123"
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
 	recepient := "0712345678"
@@ -648,6 +691,10 @@ func TestSetLanguage(t *testing.T) {
 		},
 	}
 
+	sessionId := "session123"
+	ctx, store := teststore.InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockState := state.NewState(16)
@@ -656,22 +703,115 @@ func TestSetLanguage(t *testing.T) {
 
 			// Create the Handlers instance with the mock flag manager
 			h := &Handlers{
-				flagManager: fm.parser,
-				st:          mockState,
+				userdataStore: store,
+				flagManager:   fm.parser,
+				st:            mockState,
 			}
 
 			// Call the method
-			res, err := h.SetLanguage(context.Background(), "set_language", nil)
+			res, err := h.SetLanguage(ctx, "set_language", nil)
 			if err != nil {
 				t.Error(err)
 			}
 
 			// Assert that the Result FlagSet has the required flags after language switch
 			assert.Equal(t, res, tt.expectedResult, "Result should match expected result")
+
+			// The language is remembered for the next sessions of the user
+			code, err := store.ReadEntry(ctx, sessionId, common.DATA_LANGUAGE_CODE)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedResult.Content, string(code))
 		})
 	}
 }
 
+func TestRestoreUserState(t *testing.T) {
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	flag_language_set, _ := fm.parser.GetFlag("flag_language_set")
+	flag_account_created, _ := fm.parser.GetFlag("flag_account_created")
+	flag_pin_set, _ := fm.parser.GetFlag("flag_pin_set")
+
+	sessionId := "session123"
+	ctx, store := teststore.InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
+
+	h := &Handlers{
+		userdataStore: store,
+		flagManager:   fm.parser,
+		st:            state.NewState(128),
+	}
+
+	// Nothing to restore for a new user
+	res, err := h.RestoreUserState(ctx, "restore_user_state", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{}, res)
+
+	err = store.WriteEntry(ctx, sessionId, common.DATA_LANGUAGE_CODE, []byte("swa"))
+	assert.NoError(t, err)
+	err = store.WriteEntry(ctx, sessionId, common.DATA_ACCOUNT_STATUS, []byte(provision.StateActive))
+	assert.NoError(t, err)
+	err = store.WriteEntry(ctx, sessionId, common.DATA_ACCOUNT_PIN, []byte("hashedpin"))
+	assert.NoError(t, err)
+
+	res, err = h.RestoreUserState(ctx, "restore_user_state", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagSet: []uint32{state.FLAG_LANG, flag_language_set, flag_account_created, flag_pin_set},
+		Content: "swa",
+	}, res)
+
+	// The state of an ongoing session is left alone
+	h.st.SetFlag(flag_language_set)
+	res, err = h.RestoreUserState(ctx, "restore_user_state", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{}, res)
+}
+
+func TestCheckPinChange(t *testing.T) {
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	flag_pin_change_required, _ := fm.parser.GetFlag("flag_pin_change_required")
+
+	sessionId := "session123"
+	ctx, store := teststore.InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
+
+	h := &Handlers{
+		userdataStore: store,
+		flagManager:   fm.parser,
+		pinPolicy:     common.NewPinPolicy(4, 0),
+	}
+
+	res, err := h.CheckPinChange(ctx, "check_pin_change", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagReset: []uint32{flag_pin_change_required}}, res)
+
+	// A pre-registered account with its initial PIN
+	err = store.WriteEntry(ctx, sessionId, common.DATA_ACCOUNT_PIN, []byte("4829"))
+	assert.NoError(t, err)
+	err = common.RequirePinChange(ctx, store, sessionId)
+	assert.NoError(t, err)
+	res, err = h.CheckPinChange(ctx, "check_pin_change", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagSet: []uint32{flag_pin_change_required}}, res)
+
+	// The requirement is lifted once the new PIN is confirmed
+	_, err = common.IssueAuthToken(ctx, store, sessionId)
+	assert.NoError(t, err)
+	err = store.WriteEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE, []byte("5173"))
+	assert.NoError(t, err)
+	_, err = h.ConfirmPinChange(ctx, "confirm_pin_change", []byte("5173"))
+	assert.NoError(t, err)
+	res, err = h.CheckPinChange(ctx, "check_pin_change", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagReset: []uint32{flag_pin_change_required}}, res)
+}
+
 func TestResetAllowUpdate(t *testing.T) {
 	fm, err := NewFlagManager(flagsPath)
 	if err != nil {
@@ -840,164 +980,536 @@ func TestResetIncorrectYob(t *testing.T) {
 	}
 }
 
-func TestAuthorize(t *testing.T) {
+func TestCheckAuthorization(t *testing.T) {
 	sessionId := "session123"
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
 	fm, err := NewFlagManager(flagsPath)
 	if err != nil {
-		t.Logf(err.Error())
+		t.Fatal(err)
 	}
-
-	// Create required mocks
-	mockAccountService := new(mocks.MockAccountService)
-	mockState := state.NewState(16)
-	flag_incorrect_pin, _ := fm.GetFlag("flag_incorrect_pin")
 	flag_account_authorized, _ := fm.GetFlag("flag_account_authorized")
 	flag_allow_update, _ := fm.GetFlag("flag_allow_update")
 
-	// Set 1234 is the correct account pin
-	accountPIN := "1234"
-
 	h := &Handlers{
-		userdataStore:  store,
-		accountService: mockAccountService,
-		flagManager:    fm.parser,
-		st:             mockState,
+		userdataStore: store,
+		flagManager:   fm.parser,
 	}
 
 	tests := []struct {
 		name           string
-		input          []byte
+		token          string
 		expectedResult resource.Result
 	}{
 		{
-			name:  "Test with correct pin",
-			input: []byte("1234"),
-			expectedResult: resource.Result{
-				FlagReset: []uint32{flag_incorrect_pin},
-				FlagSet:   []uint32{flag_allow_update, flag_account_authorized},
-			},
+			name:  "Test with fresh token",
+			token: common.AuthToken{Id: "a1", IssuedAt: time.Now()}.String(),
 		},
 		{
-			name:  "Test with incorrect pin",
-			input: []byte("1235"),
+			name:  "Test with stale token",
+			token: common.AuthToken{Id: "a2", IssuedAt: time.Now().Add(-common.DefaultAuthMaxAge)}.String(),
 			expectedResult: resource.Result{
-				FlagReset: []uint32{flag_account_authorized},
-				FlagSet:   []uint32{flag_incorrect_pin},
+				FlagReset: []uint32{flag_account_authorized, flag_allow_update},
 			},
 		},
 		{
-			name:           "Test with pin that is not a 4 digit",
-			input:          []byte("1235aqds"),
-			expectedResult: resource.Result{},
+			name:  "Test with revoked token",
+			token: "",
+			expectedResult: resource.Result{
+				FlagReset: []uint32{flag_account_authorized, flag_allow_update},
+			},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err = store.WriteEntry(ctx, sessionId, common.DATA_ACCOUNT_PIN, []byte(accountPIN))
+			err := store.WriteEntry(ctx, sessionId, common.DATA_AUTH_TOKEN, []byte(tt.token))
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			// Call the method under test
-			res, err := h.Authorize(ctx, "authorize", []byte(tt.input))
+			res, err := h.CheckAuthorization(ctx, "check_authorization", []byte(""))
 
-			// Assert that no errors occurred
 			assert.NoError(t, err)
-
-			//Assert that the account created flag has been set to the result
-			assert.Equal(t, res, tt.expectedResult, "Expected result should be equal to the actual result")
+			assert.Equal(t, tt.expectedResult, res)
 		})
 	}
 }
 
-func TestVerifyYob(t *testing.T) {
+func TestFreezeAccount(t *testing.T) {
+	sessionId := "session123"
+	ctx, store := teststore.InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
+
 	fm, err := NewFlagManager(flagsPath)
 	if err != nil {
-		t.Logf(err.Error())
+		t.Fatal(err)
+	}
+	flag_account_frozen, _ := fm.GetFlag("flag_account_frozen")
+	flag_account_authorized, _ := fm.GetFlag("flag_account_authorized")
+	flag_allow_update, _ := fm.GetFlag("flag_allow_update")
+
+	h := &Handlers{
+		userdataStore: store,
+		flagManager:   fm.parser,
+	}
+
+	if _, err := common.IssueAuthToken(ctx, store, sessionId); err != nil {
+		t.Fatal(err)
+	}
+
+	// going back does not freeze the account
+	res, err := h.FreezeAccount(ctx, "freeze_account", []byte("0"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{}, res)
+	res, err = h.CheckFreeze(ctx, "check_freeze", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagReset: []uint32{flag_account_frozen}}, res)
+
+	res, err = h.FreezeAccount(ctx, "freeze_account", []byte("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagSet:   []uint32{flag_account_frozen},
+		FlagReset: []uint32{flag_account_authorized, flag_allow_update},
+	}, res)
+	assert.False(t, h.isAuthorizationFresh(ctx, sessionId))
+
+	res, err = h.CheckFreeze(ctx, "check_freeze", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{flag_account_frozen}, res.FlagSet)
+
+	// transfers are blocked while the account is frozen
+	if _, err := common.IssueAuthToken(ctx, store, sessionId); err != nil {
+		t.Fatal(err)
 	}
+	res, err = h.InitiateTransaction(ctx, "transaction_initiated", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagReset: []uint32{flag_account_authorized},
+		Content:   "Your account is frozen. Transfers cannot be made until it is unfrozen.",
+	}, res)
+}
 
+func TestCheckSimSwap(t *testing.T) {
 	sessionId := "session123"
-	// Create required mocks
-	mockAccountService := new(mocks.MockAccountService)
-	mockState := state.NewState(16)
-	flag_incorrect_date_format, _ := fm.parser.GetFlag("flag_incorrect_date_format")
-	ctx := context.WithValue(context.Background(), "SessionId", sessionId)
+	ctx, store := teststore.InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flag_sim_swapped, _ := fm.GetFlag("flag_sim_swapped")
+	flag_account_authorized, _ := fm.GetFlag("flag_account_authorized")
+
+	checker := operator.NewStubChecker().WithSimChange(sessionId, time.Now().Add(-2*simSwapWindow()))
 	h := &Handlers{
-		accountService: mockAccountService,
-		flagManager:    fm.parser,
-		st:             mockState,
+		userdataStore: store,
+		flagManager:   fm.parser,
+		simChecker:    checker,
+	}
+
+	res, err := h.CheckSimSwap(ctx, "check_sim_swap", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagReset: []uint32{flag_sim_swapped}}, res)
+
+	changedAt := time.Now().Add(-time.Hour)
+	checker.WithSimChange(sessionId, changedAt)
+	content := fmt.Sprintf("Your SIM card was recently changed. For your security, transfers are blocked until %s.", changedAt.Add(simSwapWindow()).Format("2006-01-02 15:04"))
+	res, err = h.CheckSimSwap(ctx, "check_sim_swap", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagSet: []uint32{flag_sim_swapped}, Content: content}, res)
+
+	// transfers are blocked after a recent SIM change
+	if _, err := common.IssueAuthToken(ctx, store, sessionId); err != nil {
+		t.Fatal(err)
+	}
+	res, err = h.InitiateTransaction(ctx, "transaction_initiated", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagReset: []uint32{flag_account_authorized},
+		Content:   content,
+	}, res)
+}
+
+func TestUnfreezeAccount(t *testing.T) {
+	sessionId := "session123"
+	ctx, store := teststore.InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
+
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flag_account_frozen, _ := fm.GetFlag("flag_account_frozen")
+	flag_freeze_cooling_off, _ := fm.GetFlag("flag_freeze_cooling_off")
+	flag_incorrect_pin, _ := fm.GetFlag("flag_incorrect_pin")
+
+	h := &Handlers{
+		userdataStore: store,
+		flagManager:   fm.parser,
+	}
+
+	err = store.WriteEntry(ctx, sessionId, common.DATA_ACCOUNT_PIN, []byte("4831"))
+	if err != nil {
+		t.Fatal(err)
 	}
 
 	tests := []struct {
 		name           string
+		frozenAt       time.Time
 		input          []byte
+		expectedFrozen bool
 		expectedResult resource.Result
 	}{
 		{
-			name:  "Test with correct yob",
-			input: []byte("1980"),
+			name:           "Test unfreeze during cooling-off period",
+			frozenAt:       time.Now().Add(-time.Hour),
+			input:          []byte("4831"),
+			expectedFrozen: true,
 			expectedResult: resource.Result{
-				FlagReset: []uint32{flag_incorrect_date_format},
+				FlagSet: []uint32{flag_freeze_cooling_off},
+				Content: fmt.Sprintf("You can unfreeze your account after %s. Contact support to unfreeze it sooner.", time.Unix(time.Now().Add(-time.Hour).Unix(), 0).Add(common.DefaultFreezeCoolingOff).Format("2006-01-02 15:04")),
 			},
 		},
 		{
-			name:  "Test with incorrect yob",
-			input: []byte("sgahaha"),
+			name:           "Test unfreeze with incorrect PIN",
+			frozenAt:       time.Now().Add(-2 * common.DefaultFreezeCoolingOff),
+			input:          []byte("1111"),
+			expectedFrozen: true,
 			expectedResult: resource.Result{
-				FlagSet: []uint32{flag_incorrect_date_format},
+				FlagSet: []uint32{flag_incorrect_pin},
 			},
 		},
 		{
-			name:  "Test with numeric but less 4 digits",
-			input: []byte("123"),
+			name:           "Test unfreeze with correct PIN",
+			frozenAt:       time.Now().Add(-2 * common.DefaultFreezeCoolingOff),
+			input:          []byte("4831"),
+			expectedFrozen: false,
 			expectedResult: resource.Result{
-				FlagSet: []uint32{flag_incorrect_date_format},
+				FlagReset: []uint32{flag_account_frozen, flag_freeze_cooling_off, flag_incorrect_pin},
 			},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Call the method under test
-			res, err := h.VerifyYob(ctx, "verify_yob", []byte(tt.input))
+			err := store.WriteEntry(ctx, sessionId, common.DATA_ACCOUNT_FROZEN, []byte(strconv.FormatInt(tt.frozenAt.Unix(), 10)))
+			if err != nil {
+				t.Fatal(err)
+			}
 
-			// Assert that no errors occurred
-			assert.NoError(t, err)
+			res, err := h.UnfreezeAccount(ctx, "unfreeze_account", tt.input)
 
-			//Assert that the account created flag has been set to the result
-			assert.Equal(t, res, tt.expectedResult, "Expected result should be equal to the actual result")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedResult, res)
+			_, frozen, err := common.ReadFreeze(ctx, store, sessionId)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedFrozen, frozen)
 		})
 	}
 }
 
-func TestVerifyCreatePin(t *testing.T) {
-	sessionId := "session123"
-	ctx, store := InitializeTestStore(t)
-	ctx = context.WithValue(ctx, "SessionId", sessionId)
+type testNotifier struct {
+	sent map[string]string
+}
+
+func (n *testNotifier) Notify(ctx context.Context, sessionId string, message string) error {
+	n.sent[sessionId] = message
+	return nil
+}
+
+func TestApproveRecovery(t *testing.T) {
+	requester := "+254712345678"
+	guardians := []string{"+254700000001", "+254700000002"}
+	ctx, store := teststore.InitializeTestStore(t)
 
 	fm, err := NewFlagManager(flagsPath)
 	if err != nil {
-		t.Logf(err.Error())
+		t.Fatal(err)
 	}
+	flag_recovery_rejected, _ := fm.GetFlag("flag_recovery_rejected")
 
-	// Create required mocks
-	mockAccountService := new(mocks.MockAccountService)
-	mockState := state.NewState(16)
-
-	flag_valid_pin, _ := fm.parser.GetFlag("flag_valid_pin")
-	flag_pin_mismatch, _ := fm.parser.GetFlag("flag_pin_mismatch")
-	flag_pin_set, _ := fm.parser.GetFlag("flag_pin_set")
-
+	notifier := &testNotifier{sent: make(map[string]string)}
 	h := &Handlers{
-		userdataStore:  store,
-		accountService: mockAccountService,
-		flagManager:    fm.parser,
-		st:             mockState,
+		userdataStore: store,
+		flagManager:   fm.parser,
+		notifier:      notifier,
+		pinPolicy:     common.NewPinPolicy(4, 0),
+	}
+
+	err = store.WriteEntry(ctx, requester, common.DATA_ACCOUNT_PIN, []byte("4831"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range guardians {
+		err = store.WriteEntry(ctx, g, common.DATA_PUBLIC_KEY, []byte("0x"+g))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = common.AddGuardian(ctx, store, requester, g)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the locked out user asks for recovery
+	res, err := h.RequestRecovery(context.WithValue(ctx, "SessionId", requester), "request_recovery", []byte("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{flag_recovery_rejected}, res.FlagReset)
+	assert.Equal(t, 2, len(notifier.sent))
+
+	// someone who is not a guardian cannot approve
+	res, err = h.ApproveRecovery(context.WithValue(ctx, "SessionId", "+254700000009"), "approve_recovery", []byte(requester))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagSet: []uint32{flag_recovery_rejected},
+		Content: "You are not a guardian of +254712345678.",
+	}, res)
+
+	res, err = h.ApproveRecovery(context.WithValue(ctx, "SessionId", guardians[0]), "approve_recovery", []byte(requester))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagReset: []uint32{flag_recovery_rejected},
+		Content:   "Thank you. Your approval for +254712345678 has been recorded.",
+	}, res)
+	pin, err := store.ReadEntry(ctx, requester, common.DATA_ACCOUNT_PIN)
+	assert.NoError(t, err)
+	assert.Equal(t, "4831", string(pin))
+
+	res, err = h.ApproveRecovery(context.WithValue(ctx, "SessionId", guardians[1]), "approve_recovery", []byte(requester))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagReset: []uint32{flag_recovery_rejected},
+		Content:   "Thank you. The PIN of +254712345678 has been reset.",
+	}, res)
+	pin, err = store.ReadEntry(ctx, requester, common.DATA_ACCOUNT_PIN)
+	assert.NoError(t, err)
+	assert.NotEqual(t, "4831", string(pin))
+	assert.Contains(t, notifier.sent[requester], string(pin))
+	// the temporary PIN has to be changed the next time the user dials in
+	required, err := common.IsPinChangeRequired(ctx, store, requester)
+	assert.NoError(t, err)
+	assert.True(t, required)
+
+	// the request is closed once the PIN has been reset
+	_, err = common.ReadRecoveryRequest(ctx, store, requester)
+	assert.Equal(t, common.ErrNoRecoveryRequest, err)
+}
+
+func TestCheckTerms(t *testing.T) {
+	sessionId := "session123"
+	ctx, store := teststore.InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
+
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flag_terms_outdated, _ := fm.GetFlag("flag_terms_outdated")
+
+	h := &Handlers{
+		userdataStore: store,
+		flagManager:   fm.parser,
+		termsRegistry: &terms.Registry{
+			Current: "1",
+			Versions: []terms.Version{
+				{Id: "1"},
+				{Id: "2", Texts: map[string]string{"eng": "Do you accept the new terms?"}},
+			},
+		},
+	}
+
+	// accounts that never accepted any terms are asked to
+	res, err := h.CheckTerms(ctx, "check_terms", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{flag_terms_outdated}, res.FlagSet)
+
+	res, err = h.GetTerms(ctx, "get_terms", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, "Do you agree to terms and conditions?", res.Content)
+
+	res, err = h.AcceptTerms(ctx, "accept_terms", []byte("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{}, res)
+	res, err = h.AcceptTerms(ctx, "accept_terms", []byte("0"))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{flag_terms_outdated}, res.FlagReset)
+
+	res, err = h.CheckTerms(ctx, "check_terms", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagReset: []uint32{flag_terms_outdated}}, res)
+
+	// publishing a new version asks for acceptance again
+	h.termsRegistry.Current = "2"
+	res, err = h.CheckTerms(ctx, "check_terms", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{flag_terms_outdated}, res.FlagSet)
+
+	res, err = h.GetTerms(ctx, "get_terms", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, "Do you accept the new terms?", res.Content)
+}
+
+func TestAuthorize(t *testing.T) {
+	sessionId := "session123"
+	ctx, store := teststore.InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
+
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		t.Logf(err.Error())
+	}
+
+	// Create required mocks
+	mockAccountService := new(mocks.MockAccountService)
+	mockState := state.NewState(16)
+	flag_incorrect_pin, _ := fm.GetFlag("flag_incorrect_pin")
+	flag_account_authorized, _ := fm.GetFlag("flag_account_authorized")
+	flag_allow_update, _ := fm.GetFlag("flag_allow_update")
+
+	// Set 1234 is the correct account pin
+	accountPIN := "1234"
+
+	h := &Handlers{
+		userdataStore:  store,
+		accountService: mockAccountService,
+		flagManager:    fm.parser,
+		st:             mockState,
+	}
+
+	tests := []struct {
+		name           string
+		input          []byte
+		expectedResult resource.Result
+	}{
+		{
+			name:  "Test with correct pin",
+			input: []byte("1234"),
+			expectedResult: resource.Result{
+				FlagReset: []uint32{flag_incorrect_pin},
+				FlagSet:   []uint32{flag_allow_update, flag_account_authorized},
+			},
+		},
+		{
+			name:  "Test with incorrect pin",
+			input: []byte("1235"),
+			expectedResult: resource.Result{
+				FlagReset: []uint32{flag_account_authorized},
+				FlagSet:   []uint32{flag_incorrect_pin},
+			},
+		},
+		{
+			name:           "Test with pin that is not a 4 digit",
+			input:          []byte("1235aqds"),
+			expectedResult: resource.Result{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err = store.WriteEntry(ctx, sessionId, common.DATA_ACCOUNT_PIN, []byte(accountPIN))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Call the method under test
+			res, err := h.Authorize(ctx, "authorize", []byte(tt.input))
+
+			// Assert that no errors occurred
+			assert.NoError(t, err)
+
+			//Assert that the account created flag has been set to the result
+			assert.Equal(t, res, tt.expectedResult, "Expected result should be equal to the actual result")
+		})
+	}
+}
+
+func TestVerifyYob(t *testing.T) {
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		t.Logf(err.Error())
+	}
+
+	sessionId := "session123"
+	// Create required mocks
+	mockAccountService := new(mocks.MockAccountService)
+	mockState := state.NewState(16)
+	flag_incorrect_date_format, _ := fm.parser.GetFlag("flag_incorrect_date_format")
+	ctx := context.WithValue(context.Background(), "SessionId", sessionId)
+
+	h := &Handlers{
+		accountService: mockAccountService,
+		flagManager:    fm.parser,
+		st:             mockState,
+	}
+
+	tests := []struct {
+		name           string
+		input          []byte
+		expectedResult resource.Result
+	}{
+		{
+			name:  "Test with correct yob",
+			input: []byte("1980"),
+			expectedResult: resource.Result{
+				FlagReset: []uint32{flag_incorrect_date_format},
+			},
+		},
+		{
+			name:  "Test with incorrect yob",
+			input: []byte("sgahaha"),
+			expectedResult: resource.Result{
+				FlagSet: []uint32{flag_incorrect_date_format},
+			},
+		},
+		{
+			name:  "Test with numeric but less 4 digits",
+			input: []byte("123"),
+			expectedResult: resource.Result{
+				FlagSet: []uint32{flag_incorrect_date_format},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Call the method under test
+			res, err := h.VerifyYob(ctx, "verify_yob", []byte(tt.input))
+
+			// Assert that no errors occurred
+			assert.NoError(t, err)
+
+			//Assert that the account created flag has been set to the result
+			assert.Equal(t, res, tt.expectedResult, "Expected result should be equal to the actual result")
+		})
+	}
+}
+
+func TestVerifyCreatePin(t *testing.T) {
+	sessionId := "session123"
+	ctx, store := teststore.InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
+
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		t.Logf(err.Error())
+	}
+
+	// Create required mocks
+	mockAccountService := new(mocks.MockAccountService)
+	mockState := state.NewState(16)
+
+	flag_valid_pin, _ := fm.parser.GetFlag("flag_valid_pin")
+	flag_pin_mismatch, _ := fm.parser.GetFlag("flag_pin_mismatch")
+	flag_pin_set, _ := fm.parser.GetFlag("flag_pin_set")
+
+	h := &Handlers{
+		userdataStore:  store,
+		accountService: mockAccountService,
+		flagManager:    fm.parser,
+		st:             mockState,
 	}
 
 	tests := []struct {
@@ -1043,7 +1555,7 @@ func TestVerifyCreatePin(t *testing.T) {
 
 func TestCheckAccountStatus(t *testing.T) {
 	sessionId := "session123"
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
 	fm, err := NewFlagManager(flagsPath)
@@ -1114,7 +1626,7 @@ func TestCheckAccountStatus(t *testing.T) {
 
 func TestTransactionReset(t *testing.T) {
 	sessionId := "session123"
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
 	fm, err := NewFlagManager(flagsPath)
@@ -1124,6 +1636,10 @@ func TestTransactionReset(t *testing.T) {
 
 	flag_invalid_recipient, _ := fm.GetFlag("flag_invalid_recipient")
 	flag_invalid_recipient_with_invite, _ := fm.GetFlag("flag_invalid_recipient_with_invite")
+	flag_confirm_recipient, _ := fm.GetFlag("flag_confirm_recipient")
+	flag_transfer_denied, _ := fm.GetFlag("flag_transfer_denied")
+	flag_transfer_confirm, _ := fm.GetFlag("flag_transfer_confirm")
+	flag_transfer_confirmed, _ := fm.GetFlag("flag_transfer_confirmed")
 
 	mockAccountService := new(mocks.MockAccountService)
 
@@ -1141,7 +1657,7 @@ func TestTransactionReset(t *testing.T) {
 		{
 			name: "Test transaction reset for amount and recipient",
 			expectedResult: resource.Result{
-				FlagReset: []uint32{flag_invalid_recipient, flag_invalid_recipient_with_invite},
+				FlagReset: []uint32{flag_invalid_recipient, flag_invalid_recipient_with_invite, flag_confirm_recipient, flag_transfer_denied, flag_transfer_confirm, flag_transfer_confirmed},
 			},
 		},
 	}
@@ -1162,7 +1678,7 @@ func TestTransactionReset(t *testing.T) {
 
 func TestResetTransactionAmount(t *testing.T) {
 	sessionId := "session123"
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
 	fm, err := NewFlagManager(flagsPath)
@@ -1207,14 +1723,20 @@ func TestResetTransactionAmount(t *testing.T) {
 
 func TestInitiateTransaction(t *testing.T) {
 	sessionId := "254712345678"
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
+	// The PIN has just been entered
+	if _, err := common.IssueAuthToken(ctx, store, sessionId); err != nil {
+		t.Fatal(err)
+	}
+
 	fm, err := NewFlagManager(flagsPath)
 	if err != nil {
 		t.Logf(err.Error())
 	}
 	account_authorized_flag, _ := fm.parser.GetFlag("flag_account_authorized")
+	flag_transfer_confirmed, _ := fm.parser.GetFlag("flag_transfer_confirmed")
 
 	mockAccountService := new(mocks.MockAccountService)
 
@@ -1251,7 +1773,7 @@ func TestInitiateTransaction(t *testing.T) {
 				TrackingId: "1234567890",
 			},
 			expectedResult: resource.Result{
-				FlagReset: []uint32{account_authorized_flag},
+				FlagReset: []uint32{account_authorized_flag, flag_transfer_confirmed},
 				Content:   "Your request has been sent. 0711223344 will receive 1.00 SRF from 254712345678.",
 			},
 		},
@@ -1302,102 +1824,721 @@ func TestInitiateTransaction(t *testing.T) {
 	}
 }
 
-func TestQuit(t *testing.T) {
+func TestBatchTransfer(t *testing.T) {
+	sessionId := "+254712345678"
+	recipients := []string{"0x5523058cdFfe5F3c1EaDADD5015E55C6E00fb439", "0x8a6ad4e22c1e5d4be5ee8e1d7f57d7c5c4e6ef21"}
+	ctx, store := teststore.InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
+
+	entries := map[common.DataTyp]string{
+		common.DATA_PUBLIC_KEY:         "0X13242618721",
+		common.DATA_ACTIVE_SYM:         "SRF",
+		common.DATA_ACTIVE_DECIMAL:     "6",
+		common.DATA_ACTIVE_ADDRESS:     "0xd4c288865Ce",
+		common.DATA_ACTIVE_BAL:         "10",
+		common.DATA_ACTIVE_BAL_UPDATED: strconv.FormatInt(time.Now().Unix(), 10),
+	}
+	for k, v := range entries {
+		err := store.WriteEntry(ctx, sessionId, k, []byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
 	fm, err := NewFlagManager(flagsPath)
 	if err != nil {
-		t.Logf(err.Error())
+		t.Fatal(err)
 	}
-	flag_account_authorized, _ := fm.parser.GetFlag("flag_account_authorized")
+	flag_invalid_recipient, _ := fm.GetFlag("flag_invalid_recipient")
+	flag_invalid_amount, _ := fm.GetFlag("flag_invalid_amount")
+	flag_batch_full, _ := fm.GetFlag("flag_batch_full")
+	flag_batch_failed, _ := fm.GetFlag("flag_batch_failed")
+	flag_account_authorized, _ := fm.GetFlag("flag_account_authorized")
+	flag_transfer_confirm, _ := fm.GetFlag("flag_transfer_confirm")
+	flag_transfer_confirmed, _ := fm.GetFlag("flag_transfer_confirmed")
 
 	mockAccountService := new(mocks.MockAccountService)
-
-	sessionId := "session123"
-
-	ctx := context.WithValue(context.Background(), "SessionId", sessionId)
+	mockAccountService.On("TokenTransfer").Return(&models.TokenTransferResponse{TrackingId: "1234567890"}, nil).Once()
+	mockAccountService.On("TokenTransfer").Return((*models.TokenTransferResponse)(nil), fmt.Errorf("transfer failed")).Once()
+	notifier := &testNotifier{sent: make(map[string]string)}
 
 	h := &Handlers{
+		userdataStore:  store,
 		accountService: mockAccountService,
 		flagManager:    fm.parser,
-	}
-	tests := []struct {
-		name           string
-		input          []byte
-		status         string
-		expectedResult resource.Result
-	}{
-		{
-			name: "Test quit message",
-			expectedResult: resource.Result{
-				FlagReset: []uint32{flag_account_authorized},
-				Content:   "Thank you for using Sarafu. Goodbye!",
-			},
-		},
+		notifier:       notifier,
+		riskEngine:     risk.NewEngineFromConfig(&risk.Config{ConfirmNewRecipient: true}),
+		st:             state.NewState(128),
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
+	_, err = h.ResetBatch(ctx, "reset_batch", []byte(""))
+	assert.NoError(t, err)
 
-			// Call the method under test
-			res, _ := h.Quit(ctx, "test_quit", tt.input)
+	res, err := h.AddBatchRecipient(ctx, "add_batch_recipient", []byte(recipients[0]))
+	assert.NoError(t, err)
+	assert.Equal(t, recipients[0], res.Content)
 
-			// Assert that no errors occurred
-			assert.NoError(t, err)
+	res, err = h.AddBatchAmount(ctx, "add_batch_amount", []byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagSet: []uint32{flag_invalid_amount}, Content: "Amount abc is invalid."}, res)
 
-			//Assert that the account created flag has been set to the result
-			assert.Equal(t, res, tt.expectedResult, "Expected result should be equal to the actual result")
-		})
+	res, err = h.AddBatchAmount(ctx, "add_batch_amount", []byte("2"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagReset: []uint32{flag_invalid_amount, flag_batch_full},
+		Content:   "1 of 5 recipients added. Total: 2 SRF",
+	}, res)
+
+	res, err = h.AddBatchRecipient(ctx, "add_batch_recipient", []byte(recipients[0]))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagSet: []uint32{flag_invalid_recipient},
+		Content: recipients[0] + " is already in this payment.",
+	}, res)
+
+	res, err = h.AddBatchRecipient(ctx, "add_batch_recipient", []byte(recipients[1]))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagReset: []uint32{flag_invalid_recipient}, Content: recipients[1]}, res)
+	res, err = h.AddBatchAmount(ctx, "add_batch_amount", []byte("9"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagSet: []uint32{flag_invalid_amount},
+		Content: "The total of 11 SRF is more than your balance of 10 SRF.",
+	}, res)
+	_, err = h.AddBatchAmount(ctx, "add_batch_amount", []byte("3.5"))
+	assert.NoError(t, err)
+
+	// a payment the rules deny fails the batch
+	h.riskEngine = risk.NewEngineFromConfig(&risk.Config{Default: risk.Limits{MaxPerTransaction: 3}})
+	res, err = h.CheckBatch(ctx, "check_batch", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagSet: []uint32{flag_batch_failed},
+		Content: fmt.Sprintf("The payment to %s is not allowed: The amount is above the limit of 3 SRF per transaction.", recipients[1]),
+	}, res)
+
+	// payments to new recipients are listed for the user to confirm
+	h.riskEngine = risk.NewEngineFromConfig(&risk.Config{ConfirmNewRecipient: true})
+	res, err = h.CheckBatch(ctx, "check_batch", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagSet:   []uint32{flag_transfer_confirm},
+		FlagReset: []uint32{flag_batch_failed, flag_transfer_confirmed},
+		Content: fmt.Sprintf("2 recipients will receive 5.5 SRF in total.\nFee: 0 SRF\n%s: You have not sent to this recipient before.\n%s: You have not sent to this recipient before.",
+			recipients[0], recipients[1]),
+	}, res)
+
+	// The PIN has just been entered
+	_, err = common.IssueAuthToken(ctx, store, sessionId)
+	if err != nil {
+		t.Fatal(err)
 	}
+	res, err = h.TransferBatch(ctx, "transfer_batch", []byte("1234"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagReset: []uint32{flag_account_authorized, flag_transfer_confirmed},
+		Content:   fmt.Sprintf("Nothing was sent. The payment to %s is not allowed: You have not sent to this recipient before.", recipients[0]),
+	}, res)
+	mockAccountService.AssertNotCalled(t, "TokenTransfer")
+
+	h.st.SetFlag(flag_transfer_confirmed)
+	res, err = h.TransferBatch(ctx, "transfer_batch", []byte("1234"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagReset: []uint32{flag_account_authorized, flag_transfer_confirmed},
+		Content:   fmt.Sprintf("1 of 2 payments were sent. The payments to %s failed. Please try again later.", recipients[1]),
+	}, res)
+	assert.Contains(t, notifier.sent[sessionId], recipients[1])
+
+	batch, err := common.ReadBatch(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, common.BatchSent, batch[0].Status)
+	assert.Equal(t, "1234567890", batch[0].TrackingId)
+	assert.Equal(t, common.BatchFailed, batch[1].Status)
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 2)
+
+	// sending the batch again only pays the recipient whose payment failed
+	mockAccountService.On("TokenTransfer").Return(&models.TokenTransferResponse{TrackingId: "0987654321"}, nil).Once()
+	_, err = h.TransferBatch(ctx, "transfer_batch", []byte("1234"))
+	assert.NoError(t, err)
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 3)
+	batch, err = common.ReadBatch(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, "1234567890", batch[0].TrackingId)
+	assert.Equal(t, common.BatchSent, batch[1].Status)
+	assert.Equal(t, "0987654321", batch[1].TrackingId)
 }
 
-func TestIsValidPIN(t *testing.T) {
+func TestPaymentRequest(t *testing.T) {
+	requester := "+254787654321"
+	payer := "+254712345678"
+	requesterKey := "0x5523058cdFfe5F3c1EaDADD5015E55C6E00fb439"
+	payerKey := "0x8a6ad4e22c1e5d4be5ee8e1d7f57d7c5c4e6ef21"
+	ctx, store := teststore.InitializeTestStore(t)
+	requesterCtx := context.WithValue(ctx, "SessionId", requester)
+	payerCtx := context.WithValue(ctx, "SessionId", payer)
+	spdb := InitializeTestSubPrefixDb(t, ctx)
+
+	for k, v := range map[string]string{requester: requesterKey, payer: payerKey} {
+		err := store.WriteEntry(ctx, k, common.DATA_PUBLIC_KEY, []byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flag_invalid_recipient, _ := fm.GetFlag("flag_invalid_recipient")
+	flag_invalid_amount, _ := fm.GetFlag("flag_invalid_amount")
+	flag_payment_request_new, _ := fm.GetFlag("flag_payment_request_new")
+	flag_payment_request_rejected, _ := fm.GetFlag("flag_payment_request_rejected")
+	flag_account_authorized, _ := fm.GetFlag("flag_account_authorized")
+	flag_transfer_confirm, _ := fm.GetFlag("flag_transfer_confirm")
+	flag_transfer_confirmed, _ := fm.GetFlag("flag_transfer_confirmed")
+
+	holdings := []dataserviceapi.TokenHoldings{
+		{ContractAddress: "0xd4c288865Ce", TokenSymbol: "SRF", TokenDecimals: "6", Balance: "3000000"},
+	}
+	mockAccountService := new(mocks.MockAccountService)
+	mockAccountService.On("FetchVouchers", requesterKey).Return(holdings, nil)
+	mockAccountService.On("FetchVouchers", payerKey).Return(holdings, nil)
+	mockAccountService.On("TokenTransfer").Return(&models.TokenTransferResponse{TrackingId: "1234567890"}, nil)
+	notifier := &testNotifier{sent: make(map[string]string)}
+
+	h := &Handlers{
+		userdataStore:  store,
+		accountService: mockAccountService,
+		flagManager:    fm.parser,
+		prefixDb:       spdb,
+		notifier:       notifier,
+		st:             state.NewState(128),
+	}
+
+	_, err = h.ResetPaymentRequest(requesterCtx, "reset_payment_request", []byte(""))
+	assert.NoError(t, err)
+	res, err := h.SetPaymentRequestPayer(requesterCtx, "set_payment_request_payer", []byte(requester))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagSet: []uint32{flag_invalid_recipient}, Content: "You cannot request money from yourself."}, res)
+	res, err = h.SetPaymentRequestPayer(requesterCtx, "set_payment_request_payer", []byte(payer))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagReset: []uint32{flag_invalid_recipient}, Content: payer}, res)
+
+	_, err = h.CheckVouchers(requesterCtx, "check_vouchers", []byte(""))
+	assert.NoError(t, err)
+	res, err = h.SetPaymentRequestVoucher(requesterCtx, "set_payment_request_voucher", []byte("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, "SRF", res.Content)
+
+	res, err = h.CreatePaymentRequest(requesterCtx, "create_payment_request", []byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagSet: []uint32{flag_invalid_amount}, Content: "Amount abc is invalid."}, res)
+	res, err = h.CreatePaymentRequest(requesterCtx, "create_payment_request", []byte("2.5"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagReset: []uint32{flag_invalid_amount, flag_payment_request_rejected},
+		Content:   "Your request for 2.5 SRF has been sent to " + payer + ".",
+	}, res)
+	assert.Contains(t, notifier.sent[payer], "2.5 SRF")
+	res, err = h.CreatePaymentRequest(requesterCtx, "create_payment_request", []byte("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagSet:   []uint32{flag_payment_request_rejected},
+		FlagReset: []uint32{flag_invalid_amount},
+		Content:   payer + " has not answered your last request yet.",
+	}, res)
+
+	// the payer is told about the request once, on the next dial
+	res, err = h.CheckPaymentRequests(payerCtx, "check_payment_requests", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagSet: []uint32{flag_payment_request_new}}, res)
+	res, err = h.CheckPaymentRequests(payerCtx, "check_payment_requests", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagReset: []uint32{flag_payment_request_new}}, res)
+
+	res, err = h.GetPaymentRequests(payerCtx, "get_payment_requests", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, "1:"+requester+" 2.5 SRF", res.Content)
+	res, err = h.ViewPaymentRequest(payerCtx, "view_payment_request", []byte("2"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagSet: []uint32{flag_payment_request_rejected}}, res)
+	res, err = h.ViewPaymentRequest(payerCtx, "view_payment_request", []byte("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, requester+" requests 2.5 SRF from you.", res.Content)
+
+	// The PIN has just been entered
+	_, err = common.IssueAuthToken(payerCtx, store, payer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = h.ApprovePaymentRequest(payerCtx, "approve_payment_request", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagReset: []uint32{flag_account_authorized, flag_transfer_confirmed},
+		Content:   fmt.Sprintf("Your request has been sent. %s will receive 2.5 SRF from %s.", requester, payer),
+	}, res)
+	assert.Contains(t, notifier.sent[requester], "has paid your request")
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 1)
+
+	res, err = h.GetPaymentRequests(payerCtx, "get_payment_requests", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, "You have no pending payment requests.", res.Content)
+
+	// a request for more than the balance is not paid
+	_, err = h.CreatePaymentRequest(requesterCtx, "create_payment_request", []byte("4"))
+	assert.NoError(t, err)
+	_, err = h.ViewPaymentRequest(payerCtx, "view_payment_request", []byte("1"))
+	assert.NoError(t, err)
+	res, err = h.ApprovePaymentRequest(payerCtx, "approve_payment_request", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, "You do not have enough SRF to pay this request.", res.Content)
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 1)
+
+	res, err = h.DeclinePaymentRequest(payerCtx, "decline_payment_request", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("You have declined the request of %s for 4 SRF.", requester), res.Content)
+	assert.Contains(t, notifier.sent[requester], "has declined your request")
+	res, err = h.DeclinePaymentRequest(payerCtx, "decline_payment_request", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, "The payment request has expired.", res.Content)
+
+	// a request the risk rules ask about is only paid once approved with the reason shown
+	h.riskEngine = risk.NewEngineFromConfig(&risk.Config{Default: risk.Limits{ConfirmAbove: 1}})
+	_, err = h.CreatePaymentRequest(requesterCtx, "create_payment_request", []byte("2"))
+	assert.NoError(t, err)
+	res, err = h.ViewPaymentRequest(payerCtx, "view_payment_request", []byte("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagSet:   []uint32{flag_transfer_confirm},
+		FlagReset: []uint32{flag_payment_request_rejected, flag_transfer_confirmed},
+		Content:   requester + " requests 2 SRF from you.\nThis is a large transfer.",
+	}, res)
+	_, err = common.IssueAuthToken(payerCtx, store, payer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = h.ApprovePaymentRequest(payerCtx, "approve_payment_request", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, "This is a large transfer.", res.Content)
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 1)
+
+	res, err = h.ConfirmTransfer(payerCtx, "confirm_transfer", []byte("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{flag_transfer_confirmed}, res.FlagSet)
+	h.st.SetFlag(flag_transfer_confirmed)
+	_, err = common.IssueAuthToken(payerCtx, store, payer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = h.ApprovePaymentRequest(payerCtx, "approve_payment_request", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("Your request has been sent. %s will receive 2 SRF from %s.", requester, payer), res.Content)
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 2)
+}
+
+func TestMerchantPayment(t *testing.T) {
+	sessionId := "+254712345678"
+	publicKey := "0x8a6ad4e22c1e5d4be5ee8e1d7f57d7c5c4e6ef21"
+	ctx, store := teststore.InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
+
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flag_invalid_till, _ := fm.GetFlag("flag_invalid_till")
+	flag_account_authorized, _ := fm.GetFlag("flag_account_authorized")
+	flag_transfer_confirmed, _ := fm.GetFlag("flag_transfer_confirmed")
+
+	m := common.Merchant{
+		Till:      "12345",
+		PublicKey: "0x5523058cdFfe5F3c1EaDADD5015E55C6E00fb439",
+		Name:      "Mama Mboga",
+		Phone:     "+254787654321",
+		Vouchers:  []string{"SRF"},
+	}
+	err = common.RegisterMerchant(ctx, store, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[common.DataTyp]string{
+		common.DATA_PUBLIC_KEY:     publicKey,
+		common.DATA_ACTIVE_SYM:     "SRF",
+		common.DATA_ACTIVE_ADDRESS: "0xd4c288865Ce",
+		common.DATA_ACTIVE_DECIMAL: "6",
+	} {
+		err = store.WriteEntry(ctx, sessionId, k, []byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mockAccountService := new(mocks.MockAccountService)
+	mockAccountService.On("TokenTransfer").Return(&models.TokenTransferResponse{TrackingId: "1234567890"}, nil)
+	notifier := &testNotifier{sent: make(map[string]string)}
+
+	h := &Handlers{
+		userdataStore:  store,
+		accountService: mockAccountService,
+		flagManager:    fm.parser,
+		notifier:       notifier,
+	}
+
+	res, err := h.ValidateTill(ctx, "validate_till", []byte("99999"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagSet: []uint32{flag_invalid_till}, Content: "No merchant uses till 99999."}, res)
+	res, err = h.ValidateTill(ctx, "validate_till", []byte(m.Till))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagReset: []uint32{flag_invalid_till}, Content: m.Till}, res)
+	recipient, err := store.ReadEntry(ctx, sessionId, common.DATA_RECIPIENT)
+	assert.NoError(t, err)
+	assert.Equal(t, m.PublicKey, string(recipient))
+
+	res, err = h.GetMerchant(ctx, "get_merchant", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, "Pay Mama Mboga\nTill: 12345", res.Content)
+
+	err = store.WriteEntry(ctx, sessionId, common.DATA_AMOUNT, []byte("2.5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = h.GetMerchantPayment(ctx, "get_merchant_payment", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, "Pay 2.5 SRF to Mama Mboga (till 12345)", res.Content)
+
+	// The PIN has just been entered
+	_, err = common.IssueAuthToken(ctx, store, sessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = h.InitiateMerchantPayment(ctx, "initiate_merchant_payment", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagReset: []uint32{flag_account_authorized, flag_transfer_confirmed},
+		Content:   "Your payment of 2.5 SRF to Mama Mboga (till 12345) has been sent.",
+	}, res)
+	assert.Contains(t, notifier.sent[m.Phone], "received 2.5 SRF at till 12345")
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 1)
+
+	// the merchant stops accepting the voucher before the PIN is entered
+	m.Vouchers = []string{"MILO"}
+	err = common.RegisterMerchant(ctx, store, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = h.InitiateMerchantPayment(ctx, "initiate_merchant_payment", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, "Mama Mboga does not accept SRF.", res.Content)
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 1)
+	res, err = h.ValidateTill(ctx, "validate_till", []byte(m.Till))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagSet: []uint32{flag_invalid_till}, Content: "Mama Mboga does not accept SRF."}, res)
+}
+
+func TestScheduleTransfer(t *testing.T) {
+	sessionId := "+254712345678"
+	ctx, store := teststore.InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
+
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flag_invalid_schedule_date, _ := fm.GetFlag("flag_invalid_schedule_date")
+	flag_schedule_rejected, _ := fm.GetFlag("flag_schedule_rejected")
+	flag_account_authorized, _ := fm.GetFlag("flag_account_authorized")
+	flag_transfer_confirm, _ := fm.GetFlag("flag_transfer_confirm")
+	flag_transfer_confirmed, _ := fm.GetFlag("flag_transfer_confirmed")
+
+	for k, v := range map[common.DataTyp]string{
+		common.DATA_TEMPORARY_VALUE: "+254787654321",
+		common.DATA_RECIPIENT:       "0x5523058cdFfe5F3c1EaDADD5015E55C6E00fb439",
+		common.DATA_AMOUNT:          "2.5",
+		common.DATA_PUBLIC_KEY:      "0x8a6ad4e22c1e5d4be5ee8e1d7f57d7c5c4e6ef21",
+		common.DATA_ACTIVE_SYM:      "SRF",
+		common.DATA_ACTIVE_ADDRESS:  "0xd4c288865Ce",
+		common.DATA_ACTIVE_DECIMAL:  "6",
+	} {
+		err = store.WriteEntry(ctx, sessionId, k, []byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := &Handlers{
+		userdataStore: store,
+		flagManager:   fm.parser,
+		st:            state.NewState(128),
+	}
+
+	res, err := h.SetScheduleFrequency(ctx, "set_schedule_frequency", []byte("2"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagReset: []uint32{flag_invalid_schedule_date}}, res)
+
+	res, err = h.SetScheduleDate(ctx, "set_schedule_date", []byte("31022026"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagSet: []uint32{flag_invalid_schedule_date},
+		Content: "31022026 is not a valid date. Enter it as DDMMYYYY.",
+	}, res)
+	today := time.Now().Format("02012006")
+	res, err = h.SetScheduleDate(ctx, "set_schedule_date", []byte(today))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagSet: []uint32{flag_invalid_schedule_date}, Content: "The date must be after today."}, res)
+	res, err = h.SetScheduleDate(ctx, "set_schedule_date", []byte(time.Now().AddDate(2, 0, 0).Format("02012006")))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagSet: []uint32{flag_invalid_schedule_date}, Content: "The date must be within a year."}, res)
+	start := time.Now().AddDate(0, 0, 7)
+	res, err = h.SetScheduleDate(ctx, "set_schedule_date", []byte(start.Format("02012006")))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagReset: []uint32{flag_invalid_schedule_date}, Content: start.Format("02012006")}, res)
+
+	expected := "Send 2.5 SRF to +254787654321 every week from " + start.Format("02/01/2006")
+	res, err = h.GetSchedule(ctx, "get_schedule", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, res.Content)
+
+	// The PIN has just been entered
+	_, err = common.IssueAuthToken(ctx, store, sessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a transfer the risk rules deny is not scheduled
+	h.riskEngine = risk.NewEngineFromConfig(&risk.Config{Default: risk.Limits{MaxPerTransaction: 2}})
+	res, err = h.CreateSchedule(ctx, "create_schedule", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagReset: []uint32{flag_account_authorized, flag_transfer_confirmed},
+		Content:   "The amount is above the limit of 2 SRF per transaction.",
+	}, res)
+	schedules, err := common.ReadSchedules(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(schedules))
+
+	res, err = h.CheckScheduleRisk(ctx, "check_schedule_risk", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagReset: []uint32{flag_transfer_confirmed, flag_transfer_confirm}}, res)
+
+	// a transfer the risk rules ask about is only scheduled once confirmed
+	h.riskEngine = risk.NewEngineFromConfig(&risk.Config{ConfirmNewRecipient: true})
+	res, err = h.CheckScheduleRisk(ctx, "check_schedule_risk", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagSet:   []uint32{flag_transfer_confirm},
+		FlagReset: []uint32{flag_transfer_confirmed},
+		Content:   "You have not sent to this recipient before.",
+	}, res)
+	_, err = common.IssueAuthToken(ctx, store, sessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = h.CreateSchedule(ctx, "create_schedule", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagReset: []uint32{flag_account_authorized, flag_transfer_confirmed},
+		Content:   "You have not sent to this recipient before.",
+	}, res)
+	schedules, err = common.ReadSchedules(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(schedules))
+
+	res, err = h.ConfirmTransfer(ctx, "confirm_transfer", []byte("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{flag_transfer_confirmed}, res.FlagSet)
+	h.st.SetFlag(flag_transfer_confirmed)
+	_, err = common.IssueAuthToken(ctx, store, sessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = h.CreateSchedule(ctx, "create_schedule", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagReset: []uint32{flag_account_authorized, flag_transfer_confirmed},
+		Content:   "Your transfer of 2.5 SRF to +254787654321 every week from " + start.Format("02/01/2006") + " has been scheduled.",
+	}, res)
+	schedules, err = common.ReadSchedules(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(schedules))
+	assert.Equal(t, 8, schedules[0].Next.Hour())
+
+	res, err = h.GetSchedules(ctx, "get_schedules", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, "1:2.5 SRF +254787654321 "+start.Format("02/01/2006"), res.Content)
+	res, err = h.ViewSchedule(ctx, "view_schedule", []byte("2"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagSet: []uint32{flag_schedule_rejected}}, res)
+	res, err = h.ViewSchedule(ctx, "view_schedule", []byte("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagReset: []uint32{flag_schedule_rejected}, Content: expected}, res)
+
+	res, err = h.CancelSchedule(ctx, "cancel_schedule", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, "Your scheduled transfer of 2.5 SRF to +254787654321 has been cancelled.", res.Content)
+	res, err = h.CancelSchedule(ctx, "cancel_schedule", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, "The scheduled transfer has already ended.", res.Content)
+	res, err = h.GetSchedules(ctx, "get_schedules", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, "You have no scheduled transfers.", res.Content)
+}
+
+func TestCheckTransferRisk(t *testing.T) {
+	sessionId := "254712345678"
+	ctx, store := teststore.InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
+
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		t.Logf(err.Error())
+	}
+	flag_transfer_denied, _ := fm.parser.GetFlag("flag_transfer_denied")
+	flag_transfer_confirm, _ := fm.parser.GetFlag("flag_transfer_confirm")
+	flag_transfer_confirmed, _ := fm.parser.GetFlag("flag_transfer_confirmed")
+
+	cfg := &risk.Config{
+		Default: risk.Limits{
+			MaxPerTransaction: 100,
+			ConfirmAbove:      50,
+		},
+		ConfirmNewRecipient: true,
+	}
+	h := &Handlers{
+		userdataStore: store,
+		flagManager:   fm.parser,
+		riskEngine:    risk.NewEngineFromConfig(cfg),
+	}
+
+	err = store.WriteEntry(ctx, sessionId, common.DATA_ACTIVE_SYM, []byte("SRF"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.WriteEntry(ctx, sessionId, common.DATA_RECIPIENT, []byte("0x12415ass27192"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	amount, err := common.ParseDecimal("10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = risk.NewLedger(store).Record(ctx, sessionId, risk.Transfer{
+		Time:      time.Now().Add(-time.Hour),
+		Voucher:   "SRF",
+		Amount:    amount,
+		Recipient: "0x12415ass27192",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	tests := []struct {
-		name     string
-		pin      string
-		expected bool
+		name           string
+		amount         string
+		recipient      string
+		expectedResult resource.Result
 	}{
 		{
-			name:     "Valid PIN with 4 digits",
-			pin:      "1234",
-			expected: true,
-		},
-		{
-			name:     "Valid PIN with leading zeros",
-			pin:      "0001",
-			expected: true,
-		},
-		{
-			name:     "Invalid PIN with less than 4 digits",
-			pin:      "123",
-			expected: false,
+			name:      "Test allowed transfer",
+			amount:    "20.00",
+			recipient: "0x12415ass27192",
+			expectedResult: resource.Result{
+				FlagReset: []uint32{flag_transfer_confirmed, flag_transfer_denied, flag_transfer_confirm},
+			},
 		},
 		{
-			name:     "Invalid PIN with more than 4 digits",
-			pin:      "12345",
-			expected: false,
+			name:      "Test transfer to new recipient",
+			amount:    "20.00",
+			recipient: "0x98765ass27192",
+			expectedResult: resource.Result{
+				FlagSet:   []uint32{flag_transfer_confirm},
+				FlagReset: []uint32{flag_transfer_confirmed, flag_transfer_denied},
+				Content:   "You have not sent to this recipient before.",
+			},
 		},
 		{
-			name:     "Invalid PIN with letters",
-			pin:      "abcd",
-			expected: false,
+			name:      "Test large transfer",
+			amount:    "60.00",
+			recipient: "0x12415ass27192",
+			expectedResult: resource.Result{
+				FlagSet:   []uint32{flag_transfer_confirm},
+				FlagReset: []uint32{flag_transfer_confirmed, flag_transfer_denied},
+				Content:   "This is a large transfer.",
+			},
 		},
 		{
-			name:     "Invalid PIN with special characters",
-			pin:      "12@#",
-			expected: false,
+			name:      "Test transfer above limit",
+			amount:    "150.00",
+			recipient: "0x12415ass27192",
+			expectedResult: resource.Result{
+				FlagSet:   []uint32{flag_transfer_denied},
+				FlagReset: []uint32{flag_transfer_confirmed, flag_transfer_confirm},
+				Content:   "The amount is above the limit of 100 SRF per transaction.",
+			},
 		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.WriteEntry(ctx, sessionId, common.DATA_AMOUNT, []byte(tt.amount))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = store.WriteEntry(ctx, sessionId, common.DATA_RECIPIENT, []byte(tt.recipient))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := h.CheckTransferRisk(ctx, "check_transfer_risk", []byte(""))
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedResult, res)
+		})
+	}
+}
+
+func TestQuit(t *testing.T) {
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		t.Logf(err.Error())
+	}
+	flag_account_authorized, _ := fm.parser.GetFlag("flag_account_authorized")
+
+	mockAccountService := new(mocks.MockAccountService)
+
+	sessionId := "session123"
+
+	ctx := context.WithValue(context.Background(), "SessionId", sessionId)
+
+	h := &Handlers{
+		accountService: mockAccountService,
+		flagManager:    fm.parser,
+	}
+	tests := []struct {
+		name           string
+		input          []byte
+		status         string
+		expectedResult resource.Result
+	}{
 		{
-			name:     "Empty PIN",
-			pin:      "",
-			expected: false,
+			name: "Test quit message",
+			expectedResult: resource.Result{
+				FlagReset: []uint32{flag_account_authorized},
+				Content:   "Thank you for using Sarafu. Goodbye!",
+			},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			actual := isValidPIN(tt.pin)
-			if actual != tt.expected {
-				t.Errorf("isValidPIN(%q) = %v; expected %v", tt.pin, actual, tt.expected)
-			}
+
+			// Call the method under test
+			res, _ := h.Quit(ctx, "test_quit", tt.input)
+
+			// Assert that no errors occurred
+			assert.NoError(t, err)
+
+			//Assert that the account created flag has been set to the result
+			assert.Equal(t, res, tt.expectedResult, "Expected result should be equal to the actual result")
 		})
 	}
 }
@@ -1410,7 +2551,7 @@ func TestValidateAmount(t *testing.T) {
 
 	sessionId := "session123"
 
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
 	flag_invalid_amount, _ := fm.parser.GetFlag("flag_invalid_amount")
@@ -1434,7 +2575,42 @@ func TestValidateAmount(t *testing.T) {
 			input:     []byte("4.10"),
 			activeBal: []byte("5"),
 			expectedResult: resource.Result{
-				Content: "4.10",
+				Content: "4.1",
+			},
+		},
+		{
+			name:      "Test with the full active balance",
+			input:     []byte("1,000.000001"),
+			activeBal: []byte("1000.000001"),
+			expectedResult: resource.Result{
+				Content: "1000.000001",
+			},
+		},
+		{
+			name:      "Test with amount just above the active balance",
+			input:     []byte("1000.000002"),
+			activeBal: []byte("1000.000001"),
+			expectedResult: resource.Result{
+				FlagSet: []uint32{flag_invalid_amount},
+				Content: "1000.000002",
+			},
+		},
+		{
+			name:      "Test with more decimals than the voucher",
+			input:     []byte("0.0000001"),
+			activeBal: []byte("5"),
+			expectedResult: resource.Result{
+				FlagSet: []uint32{flag_invalid_amount},
+				Content: "0.0000001",
+			},
+		},
+		{
+			name:      "Test with zero amount",
+			input:     []byte("0"),
+			activeBal: []byte("5"),
+			expectedResult: resource.Result{
+				FlagSet: []uint32{flag_invalid_amount},
+				Content: "0",
 			},
 		},
 		{
@@ -1463,6 +2639,10 @@ func TestValidateAmount(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
+			err = store.WriteEntry(ctx, sessionId, common.DATA_ACTIVE_DECIMAL, []byte("6"))
+			if err != nil {
+				t.Fatal(err)
+			}
 
 			// Call the method under test
 			res, _ := h.ValidateAmount(ctx, "test_validate_amount", tt.input)
@@ -1470,84 +2650,244 @@ func TestValidateAmount(t *testing.T) {
 			// Assert no errors occurred
 			assert.NoError(t, err)
 
-			// Assert the result matches the expected result
-			assert.Equal(t, tt.expectedResult, res, "Expected result should match actual result")
+			// Assert the result matches the expected result
+			assert.Equal(t, tt.expectedResult, res, "Expected result should match actual result")
+		})
+	}
+}
+
+func TestValidateRecipient(t *testing.T) {
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sessionId := "session123"
+	publicKey := "0X13242618721"
+	ctx, store := teststore.InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
+
+	flag_invalid_recipient, _ := fm.parser.GetFlag("flag_invalid_recipient")
+	flag_invalid_recipient_with_invite, _ := fm.parser.GetFlag("flag_invalid_recipient_with_invite")
+
+	// Define test cases
+	tests := []struct {
+		name           string
+		input          []byte
+		expectedResult resource.Result
+	}{
+		{
+			name:  "Test with invalid recepient",
+			input: []byte("9234adf5"),
+			expectedResult: resource.Result{
+				FlagSet: []uint32{flag_invalid_recipient},
+				Content: "9234adf5",
+			},
+		},
+		{
+			name:  "Test with valid unregistered recepient",
+			input: []byte("0712345678"),
+			expectedResult: resource.Result{
+				FlagSet: []uint32{flag_invalid_recipient_with_invite},
+				Content: "0712345678",
+			},
+		},
+		{
+			name:           "Test with valid registered recepient",
+			input:          []byte("0711223344"),
+			expectedResult: resource.Result{},
+		},
+	}
+
+	// store a public key for the valid recipient
+	err = store.WriteEntry(ctx, "0711223344", common.DATA_PUBLIC_KEY, []byte(publicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create the Handlers instance
+			h := &Handlers{
+				flagManager:   fm.parser,
+				userdataStore: store,
+			}
+
+			// Call the method
+			res, err := h.ValidateRecipient(ctx, "validate_recepient", tt.input)
+
+			if err != nil {
+				t.Error(err)
+			}
+
+			// Assert that the Result FlagSet has the required flags after language switch
+			assert.Equal(t, res, tt.expectedResult, "Result should contain flag(s) that have been reset")
+		})
+	}
+}
+
+func TestValidateRecipientAddressAndAlias(t *testing.T) {
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sessionId := "session123"
+	publicKey := "0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb"
+	ctx, store := teststore.InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
+
+	flag_invalid_recipient, _ := fm.parser.GetFlag("flag_invalid_recipient")
+	flag_confirm_recipient, _ := fm.parser.GetFlag("flag_confirm_recipient")
+
+	err = store.WriteEntry(ctx, "0711223344", common.DATA_PUBLIC_KEY, []byte(publicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = common.ClaimAlias(ctx, store, "0711223344", "mamamboga")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name              string
+		input             []byte
+		expectedResult    resource.Result
+		expectedRecipient string
+		expectedTarget    string
+	}{
+		{
+			name:  "Test with checksummed address",
+			input: []byte("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"),
+			expectedResult: resource.Result{
+				FlagSet: []uint32{flag_confirm_recipient},
+			},
+			expectedRecipient: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+			expectedTarget:    "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		},
+		{
+			name:  "Test with lowercase address",
+			input: []byte("0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"),
+			expectedResult: resource.Result{
+				FlagSet: []uint32{flag_confirm_recipient},
+			},
+			expectedRecipient: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+			expectedTarget:    "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		},
+		{
+			name:  "Test with bad address checksum",
+			input: []byte("0x5AAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"),
+			expectedResult: resource.Result{
+				FlagSet: []uint32{flag_invalid_recipient},
+				Content: "0x5AAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+			},
+		},
+		{
+			name:  "Test with registered alias",
+			input: []byte("MamaMboga"),
+			expectedResult: resource.Result{
+				FlagSet: []uint32{flag_confirm_recipient},
+			},
+			expectedRecipient: publicKey,
+			expectedTarget:    "mamamboga",
+		},
+		{
+			name:  "Test with unknown alias",
+			input: []byte("babamboga"),
+			expectedResult: resource.Result{
+				FlagSet: []uint32{flag_invalid_recipient},
+				Content: "babamboga",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handlers{
+				flagManager:   fm.parser,
+				userdataStore: store,
+			}
+
+			res, err := h.ValidateRecipient(ctx, "validate_recepient", tt.input)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedResult, res)
+
+			if tt.expectedRecipient != "" {
+				recipient, err := store.ReadEntry(ctx, sessionId, common.DATA_RECIPIENT)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedRecipient, string(recipient))
+
+				target, err := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedTarget, string(target))
+			}
 		})
 	}
 }
 
-func TestValidateRecipient(t *testing.T) {
+func TestClaimAlias(t *testing.T) {
 	fm, err := NewFlagManager(flagsPath)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	sessionId := "session123"
-	publicKey := "0X13242618721"
-	ctx, store := InitializeTestStore(t)
-	ctx = context.WithValue(ctx, "SessionId", sessionId)
+	ctx, store := teststore.InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", "session123")
 
-	flag_invalid_recipient, _ := fm.parser.GetFlag("flag_invalid_recipient")
-	flag_invalid_recipient_with_invite, _ := fm.parser.GetFlag("flag_invalid_recipient_with_invite")
+	flag_invalid_alias, _ := fm.parser.GetFlag("flag_invalid_alias")
+
+	err = common.ClaimAlias(ctx, store, "0711223344", "taken")
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	// Define test cases
 	tests := []struct {
 		name           string
 		input          []byte
 		expectedResult resource.Result
 	}{
 		{
-			name:  "Test with invalid recepient",
-			input: []byte("9234adf5"),
+			name:  "Test with valid alias",
+			input: []byte("Duka42"),
 			expectedResult: resource.Result{
-				FlagSet: []uint32{flag_invalid_recipient},
-				Content: "9234adf5",
+				FlagReset: []uint32{flag_invalid_alias},
+				Content:   "duka42",
 			},
 		},
 		{
-			name:  "Test with valid unregistered recepient",
-			input: []byte("0712345678"),
+			name:  "Test with invalid alias",
+			input: []byte("42duka"),
 			expectedResult: resource.Result{
-				FlagSet: []uint32{flag_invalid_recipient_with_invite},
-				Content: "0712345678",
+				FlagSet: []uint32{flag_invalid_alias},
+				Content: "42duka is not a valid username. Use 3 to 16 letters or numbers, starting with a letter.",
 			},
 		},
 		{
-			name:           "Test with valid registered recepient",
-			input:          []byte("0711223344"),
-			expectedResult: resource.Result{},
+			name:  "Test with taken alias",
+			input: []byte("taken"),
+			expectedResult: resource.Result{
+				FlagSet: []uint32{flag_invalid_alias},
+				Content: "The username taken is already taken.",
+			},
 		},
 	}
 
-	// store a public key for the valid recipient
-	err = store.WriteEntry(ctx, "0711223344", common.DATA_PUBLIC_KEY, []byte(publicKey))
-	if err != nil {
-		t.Fatal(err)
-	}
-
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Create the Handlers instance
 			h := &Handlers{
 				flagManager:   fm.parser,
 				userdataStore: store,
 			}
 
-			// Call the method
-			res, err := h.ValidateRecipient(ctx, "validate_recepient", tt.input)
-
-			if err != nil {
-				t.Error(err)
-			}
-
-			// Assert that the Result FlagSet has the required flags after language switch
-			assert.Equal(t, res, tt.expectedResult, "Result should contain flag(s) that have been reset")
+			res, err := h.ClaimAlias(ctx, "claim_alias", tt.input)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedResult, res)
 		})
 	}
 }
 
 func TestCheckBalance(t *testing.T) {
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 
 	tests := []struct {
 		name           string
@@ -1602,9 +2942,45 @@ func TestCheckBalance(t *testing.T) {
 	}
 }
 
+func TestCheckBalanceStale(t *testing.T) {
+	sessionId := "session123"
+	publicKey := "0X13242618721"
+	ctx, store := teststore.InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
+
+	updatedAt := time.Now().Add(-time.Hour)
+	entries := map[common.DataTyp]string{
+		common.DATA_PUBLIC_KEY:         publicKey,
+		common.DATA_ACTIVE_SYM:         "SRF",
+		common.DATA_ACTIVE_ADDRESS:     "0xd4c288865Ce",
+		common.DATA_ACTIVE_BAL:         "1.5",
+		common.DATA_ACTIVE_BAL_UPDATED: strconv.FormatInt(updatedAt.Unix(), 10),
+	}
+	for k, v := range entries {
+		err := store.WriteEntry(ctx, sessionId, k, []byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mockAccountService := new(mocks.MockAccountService)
+	mockAccountService.On("FetchVouchers", publicKey).Return([]dataserviceapi.TokenHoldings{}, fmt.Errorf("indexer unavailable"))
+
+	h := &Handlers{
+		userdataStore:  store,
+		accountService: mockAccountService,
+	}
+
+	res, err := h.CheckBalance(ctx, "check_balance", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		Content: fmt.Sprintf("Balance: 1.5 SRF\nLast updated: %s\n", updatedAt.Format("2006-01-02 15:04")),
+	}, res)
+}
+
 func TestGetProfile(t *testing.T) {
 	sessionId := "session123"
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 
 	mockAccountService := new(mocks.MockAccountService)
 	mockState := state.NewState(16)
@@ -1687,11 +3063,22 @@ func TestVerifyNewPin(t *testing.T) {
 
 	flag_valid_pin, _ := fm.parser.GetFlag("flag_valid_pin")
 	mockAccountService := new(mocks.MockAccountService)
+	ctx, store := teststore.InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
 	h := &Handlers{
 		flagManager:    fm.parser,
+		userdataStore:  store,
 		accountService: mockAccountService,
 	}
-	ctx := context.WithValue(context.Background(), "SessionId", sessionId)
+
+	err := store.WriteEntry(ctx, sessionId, common.DATA_YOB, []byte("1984"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.WriteEntry(ctx, sessionId, common.DATA_ACCOUNT_PIN, []byte("4831"))
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	tests := []struct {
 		name           string
@@ -1700,7 +3087,7 @@ func TestVerifyNewPin(t *testing.T) {
 	}{
 		{
 			name:  "Test with valid pin",
-			input: []byte("1234"),
+			input: []byte("5927"),
 			expectedResult: resource.Result{
 				FlagSet: []uint32{flag_valid_pin},
 			},
@@ -1710,6 +3097,23 @@ func TestVerifyNewPin(t *testing.T) {
 			input: []byte("123"),
 			expectedResult: resource.Result{
 				FlagReset: []uint32{flag_valid_pin},
+				Content:   "The PIN must be 4 digits.",
+			},
+		},
+		{
+			name:  "Test with year of birth",
+			input: []byte("1984"),
+			expectedResult: resource.Result{
+				FlagReset: []uint32{flag_valid_pin},
+				Content:   "The PIN must not contain your year of birth.",
+			},
+		},
+		{
+			name:  "Test with current pin",
+			input: []byte("4831"),
+			expectedResult: resource.Result{
+				FlagReset: []uint32{flag_valid_pin},
+				Content:   "The PIN must be different from your recent PINs.",
 			},
 		},
 	}
@@ -1727,9 +3131,14 @@ func TestVerifyNewPin(t *testing.T) {
 func TestConfirmPin(t *testing.T) {
 	sessionId := "session123"
 
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
+	// The PIN has just been entered
+	if _, err := common.IssueAuthToken(ctx, store, sessionId); err != nil {
+		t.Fatal(err)
+	}
+
 	fm, _ := NewFlagManager(flagsPath)
 	flag_pin_mismatch, _ := fm.parser.GetFlag("flag_pin_mismatch")
 	mockAccountService := new(mocks.MockAccountService)
@@ -1773,52 +3182,96 @@ func TestConfirmPin(t *testing.T) {
 }
 
 func TestFetchCommunityBalance(t *testing.T) {
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	flag_api_call_error, _ := fm.parser.GetFlag("flag_api_call_error")
 
-	// Define test data
-	sessionId := "session123"
-	ctx, store := InitializeTestStore(t)
+	communityAddress := "0xC0mmun1ty"
+	registry := &community.Registry{
+		Communities: []community.Community{
+			{
+				Name:     "Mama Mboga",
+				Address:  communityAddress,
+				Vouchers: []string{"0xd4c288865Ce"},
+				Managers: []string{"+254712345678"},
+			},
+		},
+	}
+	holdings := []dataserviceapi.TokenHoldings{
+		{ContractAddress: "0xd4c288865Ce", TokenSymbol: "SRF", TokenDecimals: "6", Balance: "1500000000"},
+		{ContractAddress: "0x41c188d63Qa", TokenSymbol: "MILO", TokenDecimals: "6", Balance: "100"},
+	}
+	date := time.Date(2024, 11, 1, 10, 0, 0, 0, time.UTC)
+	transfers := []dataserviceapi.Last10TxResponse{
+		{Sender: communityAddress, Recipient: "0x13242618721", TransferValue: "50000000", ContractAddress: "0xd4c288865Ce", TokenSymbol: "SRF", TokenDecimals: "6", DateBlock: date},
+		{Sender: "0x41c188d63Qa", Recipient: communityAddress, TransferValue: "10", ContractAddress: "0x41c188d63Qa", TokenSymbol: "MILO", TokenDecimals: "6", DateBlock: date},
+		{Sender: "0x13242618721", Recipient: communityAddress, TransferValue: "200000000", ContractAddress: "0xd4c288865Ce", TokenSymbol: "SRF", TokenDecimals: "6", DateBlock: date},
+	}
 
 	tests := []struct {
 		name           string
+		sessionId      string
 		languageCode   string
+		fetchError     error
 		expectedResult resource.Result
 	}{
 		{
-			name: "Test community balance content when language is english",
+			name:         "Test community balance of a manager in english",
+			sessionId:    "+254712345678",
+			languageCode: "eng",
+			expectedResult: resource.Result{
+				Content:   "Mama Mboga balance:\n1500 SRF\nRecent transfers:\n-50 SRF 2024-11-01\n+200 SRF 2024-11-01",
+				FlagReset: []uint32{flag_api_call_error},
+			},
+		},
+		{
+			name:         "Test community balance of a member who is not a manager",
+			sessionId:    "+254700000000",
+			languageCode: "eng",
 			expectedResult: resource.Result{
-				Content: "Community Balance: 0.00",
+				Content: "Only community managers can see the community balance.",
 			},
+		},
+		{
+			name:         "Test community balance when the data service fails",
+			sessionId:    "+254712345678",
 			languageCode: "eng",
+			fetchError:   fmt.Errorf("data service unavailable"),
+			expectedResult: resource.Result{
+				FlagSet: []uint32{flag_api_call_error},
+			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-
+			ctx, store := teststore.InitializeTestStore(t)
 			mockAccountService := new(mocks.MockAccountService)
-			mockState := state.NewState(16)
+			mockAccountService.On("FetchVouchers", communityAddress).Return(holdings, tt.fetchError)
+			mockAccountService.On("FetchTransactions", communityAddress).Return(transfers, nil)
 
 			h := &Handlers{
-				userdataStore:  store,
-				st:             mockState,
-				accountService: mockAccountService,
+				userdataStore:     store,
+				flagManager:       fm.parser,
+				accountService:    mockAccountService,
+				communityRegistry: registry,
 			}
-			ctx = context.WithValue(ctx, "SessionId", sessionId)
+			ctx = context.WithValue(ctx, "SessionId", tt.sessionId)
 			ctx = context.WithValue(ctx, "Language", lang.Language{
 				Code: tt.languageCode,
 			})
 
-			// Call the method
-			res, _ := h.FetchCommunityBalance(ctx, "fetch_community_balance", []byte(""))
-
-			//Assert that the result set to content is what was expected
-			assert.Equal(t, res, tt.expectedResult, "Result should match expected result")
+			res, err := h.FetchCommunityBalance(ctx, "fetch_community_balance", []byte(""))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedResult, res, "Result should match expected result")
 		})
 	}
 }
 
 func TestSetDefaultVoucher(t *testing.T) {
 	sessionId := "session123"
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
 	fm, err := NewFlagManager(flagsPath)
@@ -1891,7 +3344,7 @@ func TestCheckVouchers(t *testing.T) {
 	sessionId := "session123"
 	publicKey := "0X13242618721"
 
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
 	spdb := InitializeTestSubPrefixDb(t, ctx)
 
@@ -1959,7 +3412,7 @@ func TestViewVoucher(t *testing.T) {
 	if err != nil {
 		t.Logf(err.Error())
 	}
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	sessionId := "session123"
 
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
@@ -1994,7 +3447,7 @@ func TestViewVoucher(t *testing.T) {
 }
 
 func TestSetVoucher(t *testing.T) {
-	ctx, store := InitializeTestStore(t)
+	ctx, store := teststore.InitializeTestStore(t)
 	sessionId := "session123"
 
 	ctx = context.WithValue(ctx, "SessionId", sessionId)
@@ -2024,3 +3477,319 @@ func TestSetVoucher(t *testing.T) {
 
 	assert.Equal(t, string(tempData.TokenSymbol), res.Content)
 }
+
+func TestCloseAccount(t *testing.T) {
+	mockAccountService := new(mocks.MockAccountService)
+	sessionId := "+254712345678"
+	publicKey := "0X13242618721"
+	recipient := "+254711111111"
+	recipientKey := "0x41c188d63Qa"
+
+	ctx, store := teststore.InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
+
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flag_account_closed, _ := fm.GetFlag("flag_account_closed")
+	flag_closure_balance, _ := fm.GetFlag("flag_closure_balance")
+	flag_api_call_error, _ := fm.GetFlag("flag_api_call_error")
+	flag_invalid_recipient, _ := fm.GetFlag("flag_invalid_recipient")
+	flag_account_authorized, _ := fm.GetFlag("flag_account_authorized")
+	flag_allow_update, _ := fm.GetFlag("flag_allow_update")
+	flag_transfer_confirmed, _ := fm.GetFlag("flag_transfer_confirmed")
+
+	h := &Handlers{
+		userdataStore:  store,
+		accountService: mockAccountService,
+		flagManager:    fm.parser,
+	}
+
+	for k, v := range map[string]string{sessionId: publicKey, recipient: recipientKey} {
+		err = store.WriteEntry(ctx, k, common.DATA_PUBLIC_KEY, []byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := common.IssueAuthToken(ctx, store, sessionId); err != nil {
+		t.Fatal(err)
+	}
+
+	mockAccountService.On("FetchVouchers", publicKey).Return([]dataserviceapi.TokenHoldings{
+		{ContractAddress: "0xd4c288865Ce", TokenSymbol: "SRF", TokenDecimals: "6", Balance: "1500000"},
+		{ContractAddress: "0x41c188d63Qa", TokenSymbol: "MILO", TokenDecimals: "4", Balance: "0"},
+	}, nil)
+	mockAccountService.On("TokenTransfer").Return(&models.TokenTransferResponse{TrackingId: "d4c288865Ce"}, nil).Once()
+
+	res, err := h.CheckClosureBalances(ctx, "check_closure_balances", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagSet:   []uint32{flag_closure_balance},
+		FlagReset: []uint32{flag_api_call_error},
+		Content:   "You still hold:\n1.5 SRF\nSend it to another account before closing.",
+	}, res)
+
+	res, err = h.SetClosureRecipient(ctx, "set_closure_recipient", []byte(sessionId))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{flag_invalid_recipient}, res.FlagSet)
+	res, err = h.SetClosureRecipient(ctx, "set_closure_recipient", []byte(recipient))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagReset: []uint32{flag_invalid_recipient}, Content: recipient}, res)
+
+	res, err = h.TransferClosureBalances(ctx, "transfer_closure_balances", []byte("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagReset: []uint32{flag_closure_balance, flag_transfer_confirmed},
+		Content:   "Your balances have been sent to +254711111111.",
+	}, res)
+	history, err := risk.NewLedger(store).History(ctx, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(history.Transfers))
+	assert.Equal(t, recipientKey, history.Transfers[0].Recipient)
+
+	// going back does not close the account
+	res, err = h.CloseAccount(ctx, "close_account", []byte("0"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{}, res)
+	res, err = h.CheckClosed(ctx, "check_closed", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagReset: []uint32{flag_account_closed}}, res)
+
+	res, err = h.CloseAccount(ctx, "close_account", []byte("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagSet:   []uint32{flag_account_closed},
+		FlagReset: []uint32{flag_account_authorized, flag_allow_update},
+	}, res)
+	assert.False(t, h.isAuthorizationFresh(ctx, sessionId))
+	res, err = h.CheckClosed(ctx, "check_closed", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagSet: []uint32{flag_account_closed}}, res)
+
+	// the closed account cannot receive transfers
+	ctx = context.WithValue(ctx, "SessionId", recipient)
+	res, err = h.ValidateRecipient(ctx, "validate_recipient", []byte(sessionId))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{flag_invalid_recipient}, res.FlagSet)
+
+	mockAccountService.AssertExpectations(t)
+}
+
+func TestCloseAccountAboveLimits(t *testing.T) {
+	mockAccountService := new(mocks.MockAccountService)
+	sessionId := "+254712345678"
+	publicKey := "0X13242618721"
+	recipient := "+254711111111"
+	recipientKey := "0x41c188d63Qa"
+
+	ctx, store := teststore.InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
+
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flag_closure_balance, _ := fm.GetFlag("flag_closure_balance")
+	flag_api_call_error, _ := fm.GetFlag("flag_api_call_error")
+	flag_transfer_confirm, _ := fm.GetFlag("flag_transfer_confirm")
+	flag_transfer_confirmed, _ := fm.GetFlag("flag_transfer_confirmed")
+
+	engine, err := risk.LoadEngine(path.Join(baseDir, "config", "risk_rules.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Handlers{
+		userdataStore:  store,
+		accountService: mockAccountService,
+		flagManager:    fm.parser,
+		riskEngine:     engine,
+		st:             state.NewState(128),
+	}
+
+	for k, v := range map[string]string{sessionId: publicKey, recipient: recipientKey} {
+		err = store.WriteEntry(ctx, k, common.DATA_PUBLIC_KEY, []byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := common.IssueAuthToken(ctx, store, sessionId); err != nil {
+		t.Fatal(err)
+	}
+
+	// the balance is above the SRF limits per transaction and per day, and has never been sent to the recipient
+	mockAccountService.On("FetchVouchers", publicKey).Return([]dataserviceapi.TokenHoldings{
+		{ContractAddress: "0xd4c288865Ce", TokenSymbol: "SRF", TokenDecimals: "6", Balance: "7500000000"},
+	}, nil)
+	mockAccountService.On("TokenTransfer").Return(&models.TokenTransferResponse{TrackingId: "d4c288865Ce"}, nil).Once()
+
+	res, err := h.SetClosureRecipient(ctx, "set_closure_recipient", []byte(recipient))
+	assert.NoError(t, err)
+	assert.Equal(t, recipient, res.Content)
+
+	res, err = h.CheckClosureRisk(ctx, "check_closure_risk", []byte(recipient))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagSet:   []uint32{flag_transfer_confirm},
+		FlagReset: []uint32{flag_api_call_error, flag_transfer_confirmed},
+		Content:   "This is a large transfer.",
+	}, res)
+
+	// the balances are not sent without the confirmation
+	res, err = h.TransferClosureBalances(ctx, "transfer_closure_balances", []byte("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagSet: []uint32{flag_closure_balance},
+		Content: "This is a large transfer.",
+	}, res)
+	mockAccountService.AssertNotCalled(t, "TokenTransfer")
+
+	res, err = h.ConfirmTransfer(ctx, "confirm_transfer", []byte("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{flag_transfer_confirmed}, res.FlagSet)
+	h.st.SetFlag(flag_transfer_confirmed)
+
+	res, err = h.TransferClosureBalances(ctx, "transfer_closure_balances", []byte("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagReset: []uint32{flag_closure_balance, flag_transfer_confirmed},
+		Content:   "Your balances have been sent to +254711111111.",
+	}, res)
+	history, err := risk.NewLedger(store).History(ctx, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(history.Transfers))
+	assert.Equal(t, "7500", history.Transfers[0].Amount.String())
+	assert.True(t, history.Known(recipientKey))
+
+	mockAccountService.AssertExpectations(t)
+}
+
+func TestEscrowTransfer(t *testing.T) {
+	sessionId := "+254712345678"
+	recipient := "0787654321"
+	number := "+254787654321"
+	escrowAddress := "0x2a8d4e22c1e5d4be5ee8e1d7f57d7c5c4e6ef21a"
+	ctx, store := teststore.InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
+
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flag_escrow_unavailable, _ := fm.GetFlag("flag_escrow_unavailable")
+	flag_account_authorized, _ := fm.GetFlag("flag_account_authorized")
+	flag_transfer_confirmed, _ := fm.GetFlag("flag_transfer_confirmed")
+	flag_api_call_error, _ := fm.GetFlag("flag_api_call_error")
+
+	for k, v := range map[common.DataTyp]string{
+		common.DATA_TEMPORARY_VALUE: recipient,
+		common.DATA_AMOUNT:          "2.5",
+		common.DATA_PUBLIC_KEY:      "0x8a6ad4e22c1e5d4be5ee8e1d7f57d7c5c4e6ef21",
+		common.DATA_ACTIVE_SYM:      "SRF",
+		common.DATA_ACTIVE_ADDRESS:  "0xd4c288865Ce",
+		common.DATA_ACTIVE_DECIMAL:  "6",
+	} {
+		err = store.WriteEntry(ctx, sessionId, k, []byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mockAccountService := new(mocks.MockAccountService)
+	mockAccountService.On("TokenTransfer").Return(&models.TokenTransferResponse{TrackingId: "1234567890"}, nil)
+	notifier := &testNotifier{sent: make(map[string]string)}
+
+	h := &Handlers{
+		userdataStore:  store,
+		accountService: mockAccountService,
+		flagManager:    fm.parser,
+		notifier:       notifier,
+		escrowService:  escrow.NewService(store, mockAccountService, notifier, "", time.Hour),
+	}
+
+	// no escrow account is configured
+	res, err := h.SetEscrowRecipient(ctx, "set_escrow_recipient", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagSet: []uint32{flag_escrow_unavailable}, Content: "Sending to numbers not on Sarafu Network is not available."}, res)
+
+	h.escrowService = escrow.NewService(store, mockAccountService, notifier, escrowAddress, 24*time.Hour)
+	res, err = h.SetEscrowRecipient(ctx, "set_escrow_recipient", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagReset: []uint32{flag_escrow_unavailable}}, res)
+	address, err := store.ReadEntry(ctx, sessionId, common.DATA_RECIPIENT)
+	assert.NoError(t, err)
+	assert.Equal(t, escrowAddress, string(address))
+
+	expires := time.Now().Add(24 * time.Hour).Format("02/01/2006")
+	res, err = h.GetEscrowTransfer(ctx, "get_escrow_transfer", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, "Send 2.5 SRF to 0787654321\nIt is returned to you if not claimed by "+expires+".", res.Content)
+
+	// The PIN has just been entered
+	_, err = common.IssueAuthToken(ctx, store, sessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the claim is removed when the transfer is rejected
+	failingAccountService := new(mocks.MockAccountService)
+	failingAccountService.On("TokenTransfer").Return((*models.TokenTransferResponse)(nil), errors.New("service unavailable"))
+	h.accountService = failingAccountService
+	res, err = h.InitiateEscrowTransfer(ctx, "initiate_escrow_transfer", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagSet: []uint32{flag_api_call_error},
+		Content: "Your request failed. Please try again later.",
+	}, res)
+	claims, err := common.ReadClaims(ctx, store, number)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(claims))
+
+	h.accountService = mockAccountService
+	_, err = common.IssueAuthToken(ctx, store, sessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = h.InitiateEscrowTransfer(ctx, "initiate_escrow_transfer", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagReset: []uint32{flag_account_authorized, flag_transfer_confirmed},
+		Content:   "Your transfer of 2.5 SRF to 0787654321 will be sent once they join Sarafu Network. It is returned to you if not claimed by " + expires + ".",
+	}, res)
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 1)
+	assert.Contains(t, notifier.sent[number], "+254712345678 has sent you 2.5 SRF on Sarafu Network.")
+	claims, err = common.ReadClaims(ctx, store, number)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(claims))
+	assert.Equal(t, "0x8a6ad4e22c1e5d4be5ee8e1d7f57d7c5c4e6ef21", claims[0].SenderAddress)
+	assert.Equal(t, common.ClaimHeld, claims[0].Status)
+	assert.Equal(t, "1234567890", claims[0].TrackingId)
+	// the transfer is known to the risk rules by the number, not the shared escrow account
+	history, err := risk.NewLedger(store).History(ctx, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(history.Transfers))
+	assert.Equal(t, "escrow:"+number, history.Transfers[0].Recipient)
+	assert.False(t, history.Known(escrowAddress))
+
+	// the recipient registers and sets a PIN
+	ctx = context.WithValue(ctx, "SessionId", number)
+	for k, v := range map[common.DataTyp]string{
+		common.DATA_PUBLIC_KEY:      "0x5523058cdFfe5F3c1EaDADD5015E55C6E00fb439",
+		common.DATA_TEMPORARY_VALUE: "1234",
+	} {
+		err = store.WriteEntry(ctx, number, k, []byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = h.VerifyCreatePin(ctx, "verify_create_pin", []byte("1234"))
+	assert.NoError(t, err)
+	// the transfers are left to the escrow worker
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 1)
+	err = h.getEscrowService().RunDue(ctx, time.Now())
+	assert.NoError(t, err)
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 2)
+	assert.Equal(t, "You have received 2.5 SRF from +254712345678, sent to you before you joined Sarafu Network.", notifier.sent[number])
+	claims, err = common.ReadClaims(ctx, store, number)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(claims))
+}
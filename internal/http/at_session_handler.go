@@ -35,6 +35,19 @@ func (ash *ATSessionHandler) ServeHTTP(w http.ResponseWriter, req *http.Request)
 		return
 	}
 	rqs.Config = cfg
+	rqs.UserId = cfg.SessionId
+	rqs.TransportSessionId, err = getTransportSessionId(rp, req)
+	if err != nil {
+		logg.ErrorCtxf(rqs.Ctx, "", "header processing error", err)
+		ash.writeError(w, 400, err)
+		return
+	}
+	rqs.InputHistory, err = getInputHistory(rp, req)
+	if err != nil {
+		logg.ErrorCtxf(rqs.Ctx, "", "header processing error", err)
+		ash.writeError(w, 400, err)
+		return
+	}
 	rqs.Input, err = rp.GetInput(req)
 	if err != nil {
 		logg.ErrorCtxf(rqs.Ctx, "", "header processing error", err)
@@ -11,8 +11,11 @@ import (
 	"strings"
 	"testing"
 
+	memdb "git.defalsify.org/vise.git/db/mem"
 	"git.defalsify.org/vise.git/engine"
+	"git.grassecon.net/urdt/ussd/common"
 	"git.grassecon.net/urdt/ussd/internal/handlers"
+	"git.grassecon.net/urdt/ussd/internal/subject"
 	"git.grassecon.net/urdt/ussd/internal/testutil/mocks/httpmocks"
 )
 
@@ -78,6 +81,100 @@ func TestATSessionHandler_ServeHTTP(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			expectedBody:   "CON ",
 		},
+		{
+			name: "Transport session id",
+			setupMocks: func(mh *httpmocks.MockRequestHandler, mrp *httpmocks.MockRequestParser, me *httpmocks.MockEngine) {
+				mrp.GetSessionIdFunc = func(rq any) (string, error) {
+					req := rq.(*http.Request)
+					return req.FormValue("phoneNumber"), nil
+				}
+				mrp.GetTransportSessionIdFunc = func(rq any) (string, error) {
+					req := rq.(*http.Request)
+					return req.FormValue("sessionId"), nil
+				}
+				mrp.GetInputFunc = func(rq any) ([]byte, error) {
+					return []byte{}, nil
+				}
+				mh.ProcessFunc = func(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+					if rqs.UserId != "+1234567890" || rqs.TransportSessionId != "ATUid_1" {
+						return rqs, errors.New("unexpected session")
+					}
+					rqs.Continue = true
+					rqs.Engine = me
+					return rqs, nil
+				}
+				mh.GetConfigFunc = func() engine.Config { return engine.Config{} }
+				mh.GetRequestParserFunc = func() handlers.RequestParser { return mrp }
+				mh.OutputFunc = func(rs handlers.RequestSession) (handlers.RequestSession, error) { return rs, nil }
+				mh.ResetFunc = func(rs handlers.RequestSession) (handlers.RequestSession, error) { return rs, nil }
+				me.FlushFunc = func(context.Context, io.Writer) (int, error) { return 0, nil }
+			},
+			formData: url.Values{
+				"phoneNumber": []string{"+1234567890"},
+				"sessionId":   []string{"ATUid_1"},
+				"text":        []string{""},
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "CON ",
+		},
+		{
+			name: "Input history",
+			setupMocks: func(mh *httpmocks.MockRequestHandler, mrp *httpmocks.MockRequestParser, me *httpmocks.MockEngine) {
+				mrp.GetSessionIdFunc = func(rq any) (string, error) {
+					req := rq.(*http.Request)
+					return req.FormValue("phoneNumber"), nil
+				}
+				mrp.GetInputHistoryFunc = func(rq any) ([][]byte, error) {
+					req := rq.(*http.Request)
+					var history [][]byte
+					for _, v := range strings.Split(req.FormValue("text"), "*") {
+						history = append(history, []byte(v))
+					}
+					return history, nil
+				}
+				mrp.GetInputFunc = func(rq any) ([]byte, error) {
+					return []byte("50"), nil
+				}
+				mh.ProcessFunc = func(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+					if len(rqs.InputHistory) != 3 || string(rqs.InputHistory[1]) != "0712345678" {
+						return rqs, errors.New("unexpected input history")
+					}
+					rqs.Continue = true
+					rqs.Engine = me
+					return rqs, nil
+				}
+				mh.GetConfigFunc = func() engine.Config { return engine.Config{} }
+				mh.GetRequestParserFunc = func() handlers.RequestParser { return mrp }
+				mh.OutputFunc = func(rs handlers.RequestSession) (handlers.RequestSession, error) { return rs, nil }
+				mh.ResetFunc = func(rs handlers.RequestSession) (handlers.RequestSession, error) { return rs, nil }
+				me.FlushFunc = func(context.Context, io.Writer) (int, error) { return 0, nil }
+			},
+			formData: url.Values{
+				"phoneNumber": []string{"+1234567890"},
+				"text":        []string{"1*0712345678*50"},
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "CON ",
+		},
+		{
+			name: "GetTransportSessionId error",
+			setupMocks: func(mh *httpmocks.MockRequestHandler, mrp *httpmocks.MockRequestParser, me *httpmocks.MockEngine) {
+				mrp.GetSessionIdFunc = func(rq any) (string, error) {
+					req := rq.(*http.Request)
+					return req.FormValue("phoneNumber"), nil
+				}
+				mrp.GetTransportSessionIdFunc = func(rq any) (string, error) {
+					return "", errors.New("no session id found")
+				}
+				mh.GetConfigFunc = func() engine.Config { return engine.Config{} }
+				mh.GetRequestParserFunc = func() handlers.RequestParser { return mrp }
+			},
+			formData: url.Values{
+				"phoneNumber": []string{"+1234567890"},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "",
+		},
 		{
 			name: "GetSessionId error",
 			setupMocks: func(mh *httpmocks.MockRequestHandler, mrp *httpmocks.MockRequestParser, me *httpmocks.MockEngine) {
@@ -447,3 +544,186 @@ func TestDefaultRequestParser_GetInput(t *testing.T) {
 		})
 	}
 }
+
+func TestSubjectHandler_ServeHTTP(t *testing.T) {
+	ctx := context.Background()
+	store := memdb.NewMemDb()
+	err := store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	userStore := &common.UserDataStore{Db: store}
+	err = userStore.WriteEntry(ctx, "+254700000000", common.DATA_FIRST_NAME, []byte("Jane"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh := NewSubjectHandler(subject.NewService(userStore, nil), "secret")
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		token          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Missing token",
+			method:         http.MethodGet,
+			path:           "/subject/+254700000000",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Wrong token",
+			method:         http.MethodGet,
+			path:           "/subject/+254700000000",
+			token:          "wrong",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Missing session id",
+			method:         http.MethodGet,
+			path:           "/subject/",
+			token:          "secret",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unsupported method",
+			method:         http.MethodPost,
+			path:           "/subject/+254700000000",
+			token:          "secret",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "Export",
+			method:         http.MethodGet,
+			path:           "/subject/+254700000000",
+			token:          "secret",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"name":"first_name","value":"Jane"}`,
+		},
+		{
+			name:           "Erase",
+			method:         http.MethodDelete,
+			path:           "/subject/+254700000000",
+			token:          "secret",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `"erased":["first_name"]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			sh.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if !strings.Contains(w.Body.String(), tt.expectedBody) {
+				t.Errorf("Expected body to contain %s, got %s", tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestPortHandler_ServeHTTP(t *testing.T) {
+	ctx := context.Background()
+	store := memdb.NewMemDb()
+	err := store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	userStore := &common.UserDataStore{Db: store}
+	err = userStore.WriteEntry(ctx, "+254700000000", common.DATA_PUBLIC_KEY, []byte("0x0A1B"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ph := NewPortHandler(userStore, nil, "secret")
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		to             string
+		token          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Missing token",
+			method:         http.MethodPost,
+			path:           "/port/+254700000000",
+			to:             "+254711111111",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Unsupported method",
+			method:         http.MethodGet,
+			path:           "/port/+254700000000",
+			token:          "secret",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "Missing new number",
+			method:         http.MethodPost,
+			path:           "/port/+254700000000",
+			token:          "secret",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "No account",
+			method:         http.MethodPost,
+			path:           "/port/+254722222222",
+			to:             "+254711111111",
+			token:          "secret",
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Move",
+			method:         http.MethodPost,
+			path:           "/port/+254700000000",
+			to:             "+254711111111",
+			token:          "secret",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `"to":"+254711111111"`,
+		},
+		{
+			name:           "Already moved elsewhere",
+			method:         http.MethodPost,
+			path:           "/port/+254700000000",
+			to:             "+254733333333",
+			token:          "secret",
+			expectedStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			if tt.to != "" {
+				form.Set("to", tt.to)
+			}
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			ph.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if !strings.Contains(w.Body.String(), tt.expectedBody) {
+				t.Errorf("Expected body to contain %s, got %s", tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
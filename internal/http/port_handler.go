@@ -0,0 +1,75 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"git.defalsify.org/vise.git/db"
+
+	"git.grassecon.net/urdt/ussd/common"
+)
+
+const (
+	// PortPath is the path the PortHandler is served on, followed by the current number of the account.
+	PortPath = "/port/"
+)
+
+// PortHandler is the admin API for moving an account to a new number, for users who have lost access to their number.
+//
+// POST PortPath + current number with the new number in the "to" form value moves the account. Requests must carry
+// the admin token as a bearer token.
+type PortHandler struct {
+	store      common.DataStore
+	stateStore db.Db
+	token      string
+}
+
+// NewPortHandler creates a new PortHandler.
+func NewPortHandler(store common.DataStore, stateStore db.Db, token string) *PortHandler {
+	return &PortHandler{
+		store:      store,
+		stateStore: stateStore,
+		token:      token,
+	}
+}
+
+func (ph *PortHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !isAdminRequest(req, ph.token) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sessionId := strings.TrimPrefix(req.URL.Path, PortPath)
+	to := req.FormValue("to")
+	if sessionId == "" || strings.Contains(sessionId, "/") || to == "" {
+		http.Error(w, "missing number", http.StatusBadRequest)
+		return
+	}
+
+	err := common.PortAccount(req.Context(), ph.store, ph.stateStore, sessionId, to)
+	switch err {
+	case nil:
+	case common.ErrPortSameNumber, common.ErrPortNoAccount, common.ErrPortTargetInUse, common.ErrAccountPorted:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		logg.ErrorCtxf(req.Context(), "failed to move account", "session", sessionId, "to", to, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	logg.InfoCtxf(req.Context(), "account moved by admin", "session", sessionId, "to", to)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(map[string]string{
+		"from": sessionId,
+		"to":   to,
+	})
+	if err != nil {
+		logg.ErrorCtxf(req.Context(), "failed to write port response", "session", sessionId, "error", err)
+	}
+}
@@ -43,6 +43,24 @@ func(rp *DefaultRequestParser) GetInput(rq any) ([]byte, error) {
 	return v, nil
 }
 
+// getTransportSessionId returns the session id the gateway gave the request, if the parser knows of one.
+func getTransportSessionId(rp handlers.RequestParser, req *http.Request) (string, error) {
+	tp, ok := rp.(handlers.TransportSessionParser)
+	if !ok {
+		return "", nil
+	}
+	return tp.GetTransportSessionId(req)
+}
+
+// getInputHistory returns all the input of the session, if the parser knows of it.
+func getInputHistory(rp handlers.RequestParser, req *http.Request) ([][]byte, error) {
+	hp, ok := rp.(handlers.InputHistoryParser)
+	if !ok {
+		return nil, nil
+	}
+	return hp.GetInputHistory(req)
+}
+
 type SessionHandler struct {
 	handlers.RequestHandler
 }
@@ -83,6 +101,19 @@ func(f *SessionHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		f.writeError(w, 400, err)
 	}
 	rqs.Config = cfg
+	rqs.UserId = cfg.SessionId
+	rqs.TransportSessionId, err = getTransportSessionId(rp, req)
+	if err != nil {
+		logg.ErrorCtxf(rqs.Ctx, "", "header processing error", err)
+		f.writeError(w, 400, err)
+		return
+	}
+	rqs.InputHistory, err = getInputHistory(rp, req)
+	if err != nil {
+		logg.ErrorCtxf(rqs.Ctx, "", "header processing error", err)
+		f.writeError(w, 400, err)
+		return
+	}
 	rqs.Input, err = rp.GetInput(req)
 	if err != nil {
 		logg.ErrorCtxf(rqs.Ctx, "", "header processing error", err)
@@ -0,0 +1,80 @@
+package http
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"git.grassecon.net/urdt/ussd/internal/subject"
+)
+
+const (
+	// SubjectPath is the path the SubjectHandler is served on, followed by the session id.
+	SubjectPath = "/subject/"
+)
+
+// SubjectHandler is the admin API for data subject requests.
+//
+// GET SubjectPath + session id exports the data held about the session id, and DELETE erases it.
+// Requests must carry the admin token as a bearer token.
+type SubjectHandler struct {
+	svc   *subject.Service
+	token string
+}
+
+// NewSubjectHandler creates a new SubjectHandler.
+func NewSubjectHandler(svc *subject.Service, token string) *SubjectHandler {
+	return &SubjectHandler{
+		svc:   svc,
+		token: token,
+	}
+}
+
+// isAdminRequest checks whether the request carries the admin token as a bearer token.
+func isAdminRequest(req *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	v, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(v), []byte(token)) == 1
+}
+
+func (sh *SubjectHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !isAdminRequest(req, sh.token) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	sessionId := strings.TrimPrefix(req.URL.Path, SubjectPath)
+	if sessionId == "" || strings.Contains(sessionId, "/") {
+		http.Error(w, "missing session id", http.StatusBadRequest)
+		return
+	}
+
+	var r any
+	var err error
+	switch req.Method {
+	case http.MethodGet:
+		r, err = sh.svc.Export(req.Context(), sessionId)
+	case http.MethodDelete:
+		r, err = sh.svc.Erase(req.Context(), sessionId)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		logg.ErrorCtxf(req.Context(), "data subject request failed", "session", sessionId, "method", req.Method, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(r)
+	if err != nil {
+		logg.ErrorCtxf(req.Context(), "failed to write data subject response", "session", sessionId, "error", err)
+	}
+}
@@ -0,0 +1,29 @@
+package notify
+
+import (
+	"context"
+	"path"
+
+	"git.defalsify.org/vise.git/db"
+	"gopkg.in/leonelquinteros/gotext.v1"
+
+	"git.grassecon.net/urdt/ussd/common"
+)
+
+var (
+	// TranslationDir is the directory of the translations of the notifications.
+	TranslationDir = path.Join("services", "registration", "locale")
+)
+
+// Locale returns the translations in the language chosen by the session id the notification is sent to.
+//
+// Notifications to a session id that has not chosen a language are not translated.
+func Locale(ctx context.Context, store common.DataStore, sessionId string) *gotext.Locale {
+	code, err := store.ReadEntry(ctx, sessionId, common.DATA_LANGUAGE_CODE)
+	if err != nil && !db.IsNotFound(err) {
+		logg.WarnCtxf(ctx, "failed to read language code entry with", "key", common.DATA_LANGUAGE_CODE, "session", sessionId, "error", err)
+	}
+	l := gotext.NewLocale(TranslationDir, string(code))
+	l.AddDomain("default")
+	return l
+}
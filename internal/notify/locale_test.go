@@ -0,0 +1,28 @@
+package notify
+
+import (
+	"path"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	testdataloader "github.com/peteole/testdata-loader"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/testutil/teststore"
+)
+
+func TestLocale(t *testing.T) {
+	ctx, store := teststore.InitializeTestStore(t)
+	TranslationDir = path.Join(testdataloader.GetBasePath(), "services", "registration", "locale")
+	msg := "Your Sarafu account is now active. Dial again to start using it."
+
+	// no language chosen
+	assert.Equal(t, msg, Locale(ctx, store, "+254712345678").Get(msg))
+
+	err := store.WriteEntry(ctx, "+254712345678", common.DATA_LANGUAGE_CODE, []byte("swa"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Akaunti yako ya Sarafu sasa iko hai. Piga tena ili uanze kuitumia.", Locale(ctx, store, "+254712345678").Get(msg))
+	assert.Equal(t, "+254787654321 amelipa ombi lako la 2.5 SRF.", Locale(ctx, store, "+254712345678").Get("%s has paid your request of %s %s.", "+254787654321", "2.5", "SRF"))
+}
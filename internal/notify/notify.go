@@ -0,0 +1,91 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"git.defalsify.org/vise.git/logging"
+
+	"git.grassecon.net/urdt/ussd/config"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("notify")
+)
+
+const (
+	// smsTimeout bounds a request to the SMS gateway, which is made while a session or worker waits on it.
+	smsTimeout = 10 * time.Second
+)
+
+// Notifier delivers out-of-session messages, such as SMS, to a phone number.
+type Notifier interface {
+	Notify(ctx context.Context, sessionId string, message string) error
+}
+
+// NewNotifier returns an SMS notifier if an SMS endpoint is configured, and a notifier that only logs the message otherwise.
+func NewNotifier() Notifier {
+	if config.SmsURL == "" {
+		return &LogNotifier{}
+	}
+	return NewHttpNotifier(config.SmsURL, config.SmsToken)
+}
+
+// LogNotifier writes the notification to the log.
+type LogNotifier struct {
+}
+
+func (n *LogNotifier) Notify(ctx context.Context, sessionId string, message string) error {
+	logg.InfoCtxf(ctx, "notification", "recipient", sessionId, "message", message)
+	return nil
+}
+
+// HttpNotifier posts the notification as JSON to an SMS gateway endpoint.
+type HttpNotifier struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewHttpNotifier creates a new HttpNotifier posting to url, authorized with the bearer token if it is set.
+func NewHttpNotifier(url string, token string) *HttpNotifier {
+	return &HttpNotifier{
+		url:    url,
+		token:  token,
+		client: &http.Client{Timeout: smsTimeout},
+	}
+}
+
+func (n *HttpNotifier) Notify(ctx context.Context, sessionId string, message string) error {
+	payload := map[string]string{
+		"recipient": sessionId,
+		"message":   message,
+	}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", n.url, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return err
+	}
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("sms gateway returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"git.grassecon.net/urdt/ussd/config"
+)
+
+func TestHttpNotifier(t *testing.T) {
+	config.BearerToken = "custodial-token"
+	var authorization string
+	var payload map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		authorization = req.Header.Get("Authorization")
+		err := json.NewDecoder(req.Body).Decode(&payload)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	err := NewHttpNotifier(srv.URL, "sms-token").Notify(ctx, "+254712345678", "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer sms-token", authorization)
+	assert.Equal(t, map[string]string{"recipient": "+254712345678", "message": "hello"}, payload)
+
+	// the custodial API token is never sent to the gateway
+	err = NewHttpNotifier(srv.URL, "").Notify(ctx, "+254712345678", "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "", authorization)
+}
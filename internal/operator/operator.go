@@ -0,0 +1,228 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"git.defalsify.org/vise.git/logging"
+
+	"git.grassecon.net/urdt/ussd/config"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("operator")
+)
+
+const (
+	// DefaultSimSwapWindow is how long transfers are blocked after a SIM change when no window is configured.
+	DefaultSimSwapWindow = 72 * time.Hour
+	// DefaultCacheTtl is how long recent SIM changes are cached when no TTL is configured.
+	DefaultCacheTtl = time.Hour
+	// NoChangeCacheTtl is how long a result without a recent SIM change is cached, kept short so that
+	// a SIM change made after the check is noticed within a session or two.
+	NoChangeCacheTtl = time.Minute
+)
+
+// SimChecker reports when the SIM of a phone number was last changed.
+type SimChecker interface {
+	// LastSimChange returns the time of the last SIM change of the phone number, and the zero time if the
+	// operator has no record of one.
+	LastSimChange(ctx context.Context, msisdn string) (time.Time, error)
+}
+
+// NewSimChecker returns a cached operator check if an endpoint is configured, and a StubChecker otherwise.
+func NewSimChecker() SimChecker {
+	if config.SimCheckURL == "" {
+		logg.Warnf("no SIM check endpoint configured, SIM changes are not detected")
+		return NewStubChecker()
+	}
+	ttl := DefaultCacheTtl
+	if config.SimCheckCacheTtl > 0 {
+		ttl = time.Duration(config.SimCheckCacheTtl) * time.Second
+	}
+	return NewCachedChecker(NewHttpChecker(config.SimCheckURL), ttl, SwapWindow())
+}
+
+// SwapWindow returns how long transfers are blocked after a SIM change.
+func SwapWindow() time.Duration {
+	if config.SimSwapWindow > 0 {
+		return time.Duration(config.SimSwapWindow) * time.Second
+	}
+	return DefaultSimSwapWindow
+}
+
+// RecentSimChange checks whether the SIM of the phone number was changed within the window.
+//
+// The time of the change is returned alongside.
+func RecentSimChange(ctx context.Context, checker SimChecker, msisdn string, window time.Duration) (bool, time.Time, error) {
+	changedAt, err := checker.LastSimChange(ctx, msisdn)
+	if err != nil {
+		return false, changedAt, err
+	}
+	if changedAt.IsZero() {
+		return false, changedAt, nil
+	}
+	return time.Since(changedAt) < window, changedAt, nil
+}
+
+// StubChecker is a local SimChecker reporting the SIM changes it has been given, for development and testing.
+type StubChecker struct {
+	changes map[string]time.Time
+	mu      sync.RWMutex
+}
+
+// NewStubChecker creates a StubChecker with no SIM changes.
+func NewStubChecker() *StubChecker {
+	return &StubChecker{
+		changes: make(map[string]time.Time),
+	}
+}
+
+// WithSimChange records a SIM change of the phone number at the given time.
+func (c *StubChecker) WithSimChange(msisdn string, t time.Time) *StubChecker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.changes[msisdn] = t
+	return c
+}
+
+func (c *StubChecker) LastSimChange(ctx context.Context, msisdn string) (time.Time, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.changes[msisdn], nil
+}
+
+// simChangeResponse is the response of the operator check endpoint.
+type simChangeResponse struct {
+	Msisdn string `json:"msisdn"`
+	// LastSimChange is empty if there is no record of a SIM change.
+	LastSimChange string `json:"last_sim_change"`
+}
+
+// HttpChecker queries an operator check endpoint over HTTP.
+//
+// The endpoint is called with the phone number in the msisdn query parameter, and responds with the
+// date of the last SIM change in RFC 3339 format. A 404 response means that there is no record of a change.
+type HttpChecker struct {
+	url string
+}
+
+// NewHttpChecker creates a new HttpChecker for the endpoint.
+func NewHttpChecker(endpoint string) *HttpChecker {
+	return &HttpChecker{
+		url: endpoint,
+	}
+}
+
+func (c *HttpChecker) LastSimChange(ctx context.Context, msisdn string) (time.Time, error) {
+	var t time.Time
+	u, err := url.Parse(c.url)
+	if err != nil {
+		return t, err
+	}
+	q := u.Query()
+	q.Set("msisdn", msisdn)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return t, err
+	}
+	req.Header.Set("Authorization", "Bearer "+config.BearerToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return t, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return t, nil
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return t, fmt.Errorf("operator check returned status %d", resp.StatusCode)
+	}
+
+	var r simChangeResponse
+	err = json.NewDecoder(resp.Body).Decode(&r)
+	if err != nil {
+		return t, err
+	}
+	if r.LastSimChange == "" {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, r.LastSimChange)
+}
+
+type cacheEntry struct {
+	changedAt time.Time
+	expires   time.Time
+}
+
+// CachedChecker caches the results of another SimChecker. A SIM change made within the swap window is cached
+// for the TTL, as transfers are blocked either way. Any other result is cached for NoChangeCacheTtl at most, so that
+// a new SIM change is not hidden by the cache. Errors are not cached.
+type CachedChecker struct {
+	checker   SimChecker
+	ttl       time.Duration
+	window    time.Duration
+	entries   map[string]cacheEntry
+	nextPrune time.Time
+	mu        sync.Mutex
+}
+
+// NewCachedChecker creates a CachedChecker keeping the results of checker for ttl, if they show a SIM change
+// within window.
+func NewCachedChecker(checker SimChecker, ttl time.Duration, window time.Duration) *CachedChecker {
+	return &CachedChecker{
+		checker: checker,
+		ttl:     ttl,
+		window:  window,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+func (c *CachedChecker) LastSimChange(ctx context.Context, msisdn string) (time.Time, error) {
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.entries[msisdn]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.changedAt, nil
+	}
+
+	changedAt, err := c.checker.LastSimChange(ctx, msisdn)
+	if err != nil {
+		return changedAt, err
+	}
+	ttl := c.ttl
+	if (changedAt.IsZero() || now.Sub(changedAt) >= c.window) && ttl > NoChangeCacheTtl {
+		ttl = NoChangeCacheTtl
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune(now)
+	c.entries[msisdn] = cacheEntry{
+		changedAt: changedAt,
+		expires:   now.Add(ttl),
+	}
+	return changedAt, nil
+}
+
+// prune evicts the expired entries, at most once every NoChangeCacheTtl.
+func (c *CachedChecker) prune(now time.Time) {
+	if now.Before(c.nextPrune) {
+		return
+	}
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.nextPrune = now.Add(NoChangeCacheTtl)
+}
@@ -0,0 +1,113 @@
+package operator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestHttpChecker(t *testing.T) {
+	changedAt := time.Date(2024, 11, 1, 10, 0, 0, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Query().Get("msisdn") {
+		case "+254700000000":
+			w.Write([]byte(`{"msisdn":"+254700000000","last_sim_change":"2024-11-01T10:00:00Z"}`))
+		case "+254711111111":
+			w.Write([]byte(`{"msisdn":"+254711111111","last_sim_change":""}`))
+		case "+254722222222":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := NewHttpChecker(srv.URL)
+	v, err := c.LastSimChange(ctx, "+254700000000")
+	assert.NoError(t, err)
+	assert.True(t, changedAt.Equal(v))
+	v, err = c.LastSimChange(ctx, "+254711111111")
+	assert.NoError(t, err)
+	assert.True(t, v.IsZero())
+	v, err = c.LastSimChange(ctx, "+254722222222")
+	assert.NoError(t, err)
+	assert.True(t, v.IsZero())
+	_, err = c.LastSimChange(ctx, "+254733333333")
+	assert.Error(t, err)
+}
+
+type countingChecker struct {
+	SimChecker
+	calls int
+}
+
+func (c *countingChecker) LastSimChange(ctx context.Context, msisdn string) (time.Time, error) {
+	c.calls++
+	return c.SimChecker.LastSimChange(ctx, msisdn)
+}
+
+func TestCachedChecker(t *testing.T) {
+	ctx := context.Background()
+	msisdn := "+254700000000"
+	stub := NewStubChecker()
+	counter := &countingChecker{SimChecker: stub}
+	c := NewCachedChecker(counter, time.Hour, DefaultSimSwapWindow)
+
+	v, err := c.LastSimChange(ctx, msisdn)
+	assert.NoError(t, err)
+	assert.True(t, v.IsZero())
+
+	// a result without a SIM change is only cached briefly
+	stub.WithSimChange(msisdn, time.Now())
+	v, err = c.LastSimChange(ctx, msisdn)
+	assert.NoError(t, err)
+	assert.True(t, v.IsZero())
+	assert.Equal(t, 1, counter.calls)
+	assert.True(t, c.entries[msisdn].expires.Before(time.Now().Add(NoChangeCacheTtl+time.Second)))
+
+	// once it expires the SIM change is seen, and cached for the TTL
+	c.entries[msisdn] = cacheEntry{expires: time.Now()}
+	v, err = c.LastSimChange(ctx, msisdn)
+	assert.NoError(t, err)
+	assert.False(t, v.IsZero())
+	assert.Equal(t, 2, counter.calls)
+	assert.True(t, c.entries[msisdn].expires.After(time.Now().Add(time.Hour-time.Second)))
+
+	// a SIM change older than the window is cached like no change
+	old := "+254711111111"
+	stub.WithSimChange(old, time.Now().Add(-2*DefaultSimSwapWindow))
+	_, err = c.LastSimChange(ctx, old)
+	assert.NoError(t, err)
+	assert.True(t, c.entries[old].expires.Before(time.Now().Add(NoChangeCacheTtl+time.Second)))
+
+	// expired entries are evicted
+	c.entries["+254722222222"] = cacheEntry{expires: time.Now()}
+	c.nextPrune = time.Time{}
+	_, err = c.LastSimChange(ctx, "+254733333333")
+	assert.NoError(t, err)
+	_, ok := c.entries["+254722222222"]
+	assert.False(t, ok)
+	assert.Equal(t, 3, len(c.entries))
+}
+
+func TestRecentSimChange(t *testing.T) {
+	ctx := context.Background()
+	c := NewStubChecker().
+		WithSimChange("+254700000000", time.Now().Add(-time.Hour)).
+		WithSimChange("+254711111111", time.Now().Add(-96*time.Hour))
+
+	changed, _, err := RecentSimChange(ctx, c, "+254700000000", DefaultSimSwapWindow)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	changed, _, err = RecentSimChange(ctx, c, "+254711111111", DefaultSimSwapWindow)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	changed, _, err = RecentSimChange(ctx, c, "+254722222222", DefaultSimSwapWindow)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+}
@@ -0,0 +1,272 @@
+package provision
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"git.defalsify.org/vise.git/db"
+
+	"git.grassecon.net/urdt/ussd/common"
+)
+
+const (
+	// ResultCreated is set when the account was requested for the member.
+	ResultCreated = "created"
+	// ResultResumed is set when an earlier pre-registration of the member was completed.
+	ResultResumed = "resumed"
+	// ResultExists is set when the member already has an account of their own, which is left alone.
+	ResultExists = "exists"
+	// ResultInvalid is set when the row cannot be used, like a malformed year of birth.
+	ResultInvalid = "invalid"
+	// ResultFailed is set when the account could not be requested.
+	ResultFailed = "failed"
+)
+
+var (
+	memberColumns = []string{"phone", "first_name", "family_name", "gender", "yob", "location", "offerings", "language", "pin"}
+	genders       = []string{"male", "female", "unspecified"}
+	languages     = []string{"eng", "swa"}
+)
+
+// Member holds the details of a member collected before launch, as read from the pre-registration CSV.
+//
+// All fields but the phone number are optional.
+type Member struct {
+	// Line is the line of the member in the CSV.
+	Line       int
+	Phone      string
+	FirstName  string
+	FamilyName string
+	Gender     string
+	Yob        string
+	Location   string
+	Offerings  string
+	// Language is the language code of the menu.
+	Language string
+	// Pin is the initial PIN. A random one is generated if it is empty.
+	Pin string
+}
+
+// Result is the outcome of pre-registering a member.
+type Result struct {
+	Member
+	Status    string
+	PublicKey string
+	// InitialPin is the PIN to hand to the member. It is kept by a rerun until the member has changed it.
+	InitialPin string
+	// Detail explains a status other than created.
+	Detail string
+}
+
+// ReadMembers reads a pre-registration CSV. The header names the columns, out of phone, first_name, family_name,
+// gender, yob, location, offerings, language and pin, in any order.
+func ReadMembers(r io.Reader) ([]Member, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	cols := make(map[string]int)
+	for i, v := range header {
+		name := strings.ToLower(strings.TrimSpace(v))
+		if !contains(memberColumns, name) {
+			return nil, fmt.Errorf("unknown column %q", v)
+		}
+		cols[name] = i
+	}
+	if _, ok := cols["phone"]; !ok {
+		return nil, fmt.Errorf("missing phone column")
+	}
+
+	var members []Member
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		get := func(name string) string {
+			i, ok := cols[name]
+			if !ok {
+				return ""
+			}
+			return strings.TrimSpace(rec[i])
+		}
+		members = append(members, Member{
+			Line:       line,
+			Phone:      get("phone"),
+			FirstName:  get("first_name"),
+			FamilyName: get("family_name"),
+			Gender:     strings.ToLower(get("gender")),
+			Yob:        get("yob"),
+			Location:   get("location"),
+			Offerings:  get("offerings"),
+			Language:   strings.ToLower(get("language")),
+			Pin:        get("pin"),
+		})
+	}
+	return members, nil
+}
+
+// WriteResults writes the results as CSV, including the initial PINs.
+func WriteResults(w io.Writer, results []Result) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"line", "phone", "status", "public_key", "initial_pin", "detail"})
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		err = cw.Write([]string{strconv.Itoa(r.Line), r.Phone, r.Status, r.PublicKey, r.InitialPin, r.Detail})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// validate returns why the details of the member cannot be used, or an empty string if they can.
+func (m Member) validate(policy *common.PinPolicy) string {
+	if m.Phone == "" {
+		return "missing phone number"
+	}
+	if m.Gender != "" && !contains(genders, m.Gender) {
+		return fmt.Sprintf("gender must be one of %s", strings.Join(genders, ", "))
+	}
+	if m.Yob != "" {
+		_, err := strconv.Atoi(m.Yob)
+		if err != nil || len(m.Yob) != 4 {
+			return "year of birth must have four digits"
+		}
+	}
+	if m.Language != "" && !contains(languages, m.Language) {
+		return fmt.Sprintf("language must be one of %s", strings.Join(languages, ", "))
+	}
+	if m.Pin != "" {
+		if !policy.IsValidFormat(m.Pin) {
+			return fmt.Sprintf("pin must have %d digits", policy.Length())
+		}
+		if common.IsWeakPin(m.Pin) {
+			return common.ErrPinWeak.Error()
+		}
+		if m.Yob != "" && strings.Contains(m.Pin, m.Yob) {
+			return common.ErrPinYob.Error()
+		}
+	}
+	return ""
+}
+
+// Preregister creates the custodial account of a member with the details collected before launch, and an initial PIN
+// the member has to change the first time they dial in.
+//
+// It is safe to run again for the same member. A member who already has an account of their own is left alone,
+// and an interrupted pre-registration is completed without replacing the initial PIN already set.
+func (p *Provisioner) Preregister(ctx context.Context, m Member, policy *common.PinPolicy, language string) (Result, error) {
+	r := Result{Member: m}
+	reason := m.validate(policy)
+	if reason != "" {
+		r.Status = ResultInvalid
+		r.Detail = reason
+		return r, nil
+	}
+	sessionId := m.Phone
+
+	st, err := p.GetState(ctx, sessionId)
+	if err != nil {
+		return r, err
+	}
+	resumed, err := common.IsPinChangeRequired(ctx, p.store, sessionId)
+	if err != nil {
+		return r, err
+	}
+	if st != StateNone && st != StateFailed && !resumed {
+		r.Status = ResultExists
+		r.Detail = "the number already has an account"
+		return r, nil
+	}
+
+	// the requirement is recorded first, so that a rerun can tell an interrupted pre-registration from an own account
+	err = common.RequirePinChange(ctx, p.store, sessionId)
+	if err != nil {
+		return r, err
+	}
+	pin, err := p.store.ReadEntry(ctx, sessionId, common.DATA_ACCOUNT_PIN)
+	if err != nil && !db.IsNotFound(err) {
+		return r, err
+	}
+	r.InitialPin = string(pin)
+	if len(pin) == 0 {
+		r.InitialPin = m.Pin
+		if r.InitialPin == "" {
+			r.InitialPin, err = policy.Generate()
+			if err != nil {
+				return r, err
+			}
+		}
+		err = p.store.WriteEntry(ctx, sessionId, common.DATA_ACCOUNT_PIN, []byte(r.InitialPin))
+		if err != nil {
+			return r, err
+		}
+		err = policy.Record(ctx, p.store, sessionId, r.InitialPin)
+		if err != nil {
+			return r, err
+		}
+	}
+
+	if m.Language != "" {
+		language = m.Language
+	}
+	data := map[common.DataTyp]string{
+		common.DATA_FIRST_NAME:    m.FirstName,
+		common.DATA_FAMILY_NAME:   m.FamilyName,
+		common.DATA_GENDER:        m.Gender,
+		common.DATA_YOB:           m.Yob,
+		common.DATA_LOCATION:      m.Location,
+		common.DATA_OFFERINGS:     m.Offerings,
+		common.DATA_LANGUAGE_CODE: language,
+	}
+	for key, value := range data {
+		if value == "" {
+			continue
+		}
+		err = p.store.WriteEntry(ctx, sessionId, key, []byte(value))
+		if err != nil {
+			return r, err
+		}
+	}
+
+	st, err = p.Request(ctx, sessionId)
+	if err != nil {
+		r.Status = ResultFailed
+		r.Detail = err.Error()
+		return r, nil
+	}
+	publicKey, err := p.store.ReadEntry(ctx, sessionId, common.DATA_PUBLIC_KEY)
+	if err != nil {
+		return r, err
+	}
+	r.PublicKey = string(publicKey)
+	r.Status = ResultCreated
+	if resumed {
+		r.Status = ResultResumed
+	}
+	logg.InfoCtxf(ctx, "member pre-registered", "session", sessionId, "state", st, "status", r.Status)
+	return r, nil
+}
+
+func contains(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package provision
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/testutil/mocks"
+	"git.grassecon.net/urdt/ussd/internal/testutil/teststore"
+	"git.grassecon.net/urdt/ussd/models"
+)
+
+func TestReadMembers(t *testing.T) {
+	members, err := ReadMembers(strings.NewReader("phone,first_name,gender,yob\n+254712345678,Jane,Female,1980\n+254787654321,,,\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Member{
+		{Line: 2, Phone: "+254712345678", FirstName: "Jane", Gender: "female", Yob: "1980"},
+		{Line: 3, Phone: "+254787654321"},
+	}, members)
+
+	_, err = ReadMembers(strings.NewReader("first_name\nJane\n"))
+	assert.Error(t, err)
+	_, err = ReadMembers(strings.NewReader("phone,nickname\n+254712345678,JJ\n"))
+	assert.Error(t, err)
+}
+
+func TestPreregister(t *testing.T) {
+	ctx, store := teststore.InitializeTestStore(t)
+	policy := common.NewPinPolicy(4, 0)
+	mockAccountService := new(mocks.MockAccountService)
+	mockAccountService.On("CreateAccount").Return(&models.AccountResult{
+		TrackingId: "1234567890",
+		PublicKey:  "0xD3adB33f",
+	}, nil)
+	p := NewProvisioner(store, mockAccountService, nil)
+
+	m := Member{Line: 2, Phone: "+254712345678", FirstName: "Jane", Yob: "1980", Pin: "4829"}
+	r, err := p.Preregister(ctx, m, policy, "swa")
+	assert.NoError(t, err)
+	assert.Equal(t, ResultCreated, r.Status)
+	assert.Equal(t, "0xD3adB33f", r.PublicKey)
+	assert.Equal(t, "4829", r.InitialPin)
+
+	entries := map[common.DataTyp]string{
+		common.DATA_ACCOUNT_PIN:   "4829",
+		common.DATA_FIRST_NAME:    "Jane",
+		common.DATA_YOB:           "1980",
+		common.DATA_LANGUAGE_CODE: "swa",
+		common.DATA_TRACKING_ID:   "1234567890",
+	}
+	for k, v := range entries {
+		b, err := store.ReadEntry(ctx, m.Phone, k)
+		assert.NoError(t, err)
+		assert.Equal(t, v, string(b))
+	}
+	sessionId, err := store.ReadEntry(ctx, "d3adb33f", common.DATA_PUBLIC_KEY_REVERSE)
+	assert.NoError(t, err)
+	assert.Equal(t, m.Phone, string(sessionId))
+	required, err := common.IsPinChangeRequired(ctx, store, m.Phone)
+	assert.NoError(t, err)
+	assert.True(t, required)
+
+	// a rerun neither requests another account nor replaces the PIN
+	m.Pin = ""
+	r, err = p.Preregister(ctx, m, policy, "swa")
+	assert.NoError(t, err)
+	assert.Equal(t, ResultResumed, r.Status)
+	assert.Equal(t, "4829", r.InitialPin)
+	mockAccountService.AssertNumberOfCalls(t, "CreateAccount", 1)
+
+	// once the member has changed the PIN, the account is theirs
+	err = common.ClearPinChange(ctx, store, m.Phone)
+	assert.NoError(t, err)
+	r, err = p.Preregister(ctx, m, policy, "swa")
+	assert.NoError(t, err)
+	assert.Equal(t, ResultExists, r.Status)
+
+	r, err = p.Preregister(ctx, Member{Phone: "+254787654321", Yob: "1980", Pin: "1980"}, policy, "eng")
+	assert.NoError(t, err)
+	assert.Equal(t, ResultInvalid, r.Status)
+
+	r, err = p.Preregister(ctx, Member{Line: 4, Phone: "+254787654321"}, policy, "eng")
+	assert.NoError(t, err)
+	assert.Equal(t, ResultCreated, r.Status)
+	assert.True(t, policy.IsValidFormat(r.InitialPin))
+
+	var b bytes.Buffer
+	err = WriteResults(&b, []Result{r})
+	assert.NoError(t, err)
+	assert.Equal(t, "line,phone,status,public_key,initial_pin,detail\n4,+254787654321,created,0xD3adB33f,"+r.InitialPin+",\n", b.String())
+}
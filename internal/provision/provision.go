@@ -0,0 +1,378 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/logging"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/notify"
+	"git.grassecon.net/urdt/ussd/internal/storage"
+	"git.grassecon.net/urdt/ussd/remote"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("provision")
+)
+
+// State is the provisioning state of a custodial account, persisted per phone number in DATA_ACCOUNT_STATUS.
+type State string
+
+const (
+	// StateNone is returned when no account has been requested for the phone number.
+	StateNone State = ""
+	// StateRequested is set before the custodial account is requested on the API.
+	StateRequested State = "requested"
+	// StatePending is set when the API has accepted the request, but the account is not yet active.
+	StatePending State = "pending"
+	// StateActive is set when the account is active on the network.
+	StateActive State = "active"
+	// StateFailed is set when the account creation request failed.
+	StateFailed State = "failed"
+)
+
+var (
+	ErrInProgress = errors.New("account provisioning already in progress")
+)
+
+const (
+	pendingKey = "pending"
+)
+
+// Provisioner creates custodial accounts and tracks their state until they are active.
+//
+// Only one provisioning request per phone number is handled at any one time by the Provisioner and the
+// copies made of it with WithStore. The guard is held in memory, so it does not extend to other processes
+// sharing the user data database; only one process may serve requests and reconcile accounts at a time.
+type Provisioner struct {
+	store          common.DataStore
+	indexStore     db.Db
+	indexDb        storage.PrefixDb
+	accountService remote.AccountServiceInterface
+	notifier       notify.Notifier
+	mu             *sync.Mutex
+	indexMu        *sync.Mutex
+	inflight       map[string]bool
+}
+
+// NewProvisioner creates a new Provisioner. If notifier is nil, notifications are only logged.
+func NewProvisioner(store common.DataStore, accountService remote.AccountServiceInterface, notifier notify.Notifier) *Provisioner {
+	if notifier == nil {
+		notifier = &notify.LogNotifier{}
+	}
+	return &Provisioner{
+		store:          store,
+		indexStore:     store,
+		indexDb:        storage.NewSubPrefixDb(store, []byte("provision")),
+		accountService: accountService,
+		notifier:       notifier,
+		mu:             &sync.Mutex{},
+		indexMu:        &sync.Mutex{},
+		inflight:       make(map[string]bool),
+	}
+}
+
+// WithStore returns a Provisioner using another store, such as another handle to the same database, which
+// shares the requests in progress and the index of pending accounts with p.
+func (p *Provisioner) WithStore(store common.DataStore) *Provisioner {
+	r := *p
+	r.store = store
+	return &r
+}
+
+// WithIndexStore returns a Provisioner keeping the index of pending accounts through its own handle to the
+// user data database, so that reading the index does not change the session of the store used for the
+// accounts. The handle is shared with the copies made with WithStore.
+func (p *Provisioner) WithIndexStore(store db.Db) *Provisioner {
+	r := *p
+	r.indexStore = store
+	r.indexDb = storage.NewSubPrefixDb(store, []byte("provision"))
+	return &r
+}
+
+func (p *Provisioner) lock(sessionId string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.inflight[sessionId] {
+		return false
+	}
+	p.inflight[sessionId] = true
+	return true
+}
+
+func (p *Provisioner) unlock(sessionId string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.inflight, sessionId)
+}
+
+// GetState returns the persisted provisioning state for the phone number.
+func (p *Provisioner) GetState(ctx context.Context, sessionId string) (State, error) {
+	v, err := p.store.ReadEntry(ctx, sessionId, common.DATA_ACCOUNT_STATUS)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return StateNone, nil
+		}
+		return StateNone, err
+	}
+	return State(v), nil
+}
+
+func (p *Provisioner) setState(ctx context.Context, sessionId string, st State) error {
+	logg.DebugCtxf(ctx, "provisioning state change", "session", sessionId, "state", st)
+	return p.store.WriteEntry(ctx, sessionId, common.DATA_ACCOUNT_STATUS, []byte(st))
+}
+
+// Request creates the custodial account for the phone number unless it has already been requested.
+//
+// It is safe to call repeatedly; the API is only called when no account exists yet or the previous attempt failed.
+// An account is never requested again for a number that already has a tracking id or a public key.
+func (p *Provisioner) Request(ctx context.Context, sessionId string) (State, error) {
+	if !p.lock(sessionId) {
+		return StateRequested, ErrInProgress
+	}
+	defer p.unlock(sessionId)
+
+	st, err := p.GetState(ctx, sessionId)
+	if err != nil {
+		return st, err
+	}
+	if st == StatePending || st == StateActive {
+		return st, nil
+	}
+
+	// the account was created before state tracking, or a previous request was interrupted or marked as failed
+	// after the API accepted it
+	created, err := p.hasAccount(ctx, sessionId)
+	if err != nil {
+		return st, err
+	}
+	if created {
+		err = p.setState(ctx, sessionId, StatePending)
+		if err != nil {
+			return st, err
+		}
+		return StatePending, p.addPending(ctx, sessionId)
+	}
+
+	err = p.setState(ctx, sessionId, StateRequested)
+	if err != nil {
+		return StateNone, err
+	}
+	err = p.addPending(ctx, sessionId)
+	if err != nil {
+		return StateRequested, err
+	}
+
+	r, err := p.accountService.CreateAccount(ctx)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed on CreateAccount", "session", sessionId, "error", err)
+		serr := p.setState(ctx, sessionId, StateFailed)
+		if serr != nil {
+			logg.ErrorCtxf(ctx, "failed to persist provisioning state", "session", sessionId, "error", serr)
+		}
+		return StateFailed, err
+	}
+
+	err = p.saveAccount(ctx, sessionId, r.TrackingId, r.PublicKey)
+	if err != nil {
+		return StateRequested, err
+	}
+
+	err = p.setState(ctx, sessionId, StatePending)
+	if err != nil {
+		return StateRequested, err
+	}
+	return StatePending, nil
+}
+
+// hasAccount checks whether the API has accepted an account request for the phone number, by the tracking id
+// or the public key it returned.
+func (p *Provisioner) hasAccount(ctx context.Context, sessionId string) (bool, error) {
+	trackingId, err := p.store.ReadEntry(ctx, sessionId, common.DATA_TRACKING_ID)
+	if err != nil && !db.IsNotFound(err) {
+		return false, err
+	}
+	publicKey, err := p.store.ReadEntry(ctx, sessionId, common.DATA_PUBLIC_KEY)
+	if err != nil && !db.IsNotFound(err) {
+		return false, err
+	}
+	if len(trackingId) > 0 && len(publicKey) == 0 {
+		logg.WarnCtxf(ctx, "account requested without a public key saved, check it by hand", "session", sessionId, "trackingId", string(trackingId))
+	}
+	return len(trackingId) > 0 || len(publicKey) > 0, nil
+}
+
+// saveAccount saves the account returned by the API. The tracking id is written first, so that an
+// interrupted save still keeps the account from being requested again.
+func (p *Provisioner) saveAccount(ctx context.Context, sessionId string, trackingId string, publicKey string) error {
+	data := []struct {
+		key   common.DataTyp
+		value string
+	}{
+		{common.DATA_TRACKING_ID, trackingId},
+		{common.DATA_PUBLIC_KEY, publicKey},
+		{common.DATA_ACCOUNT_CREATED, time.Now().UTC().Format(time.RFC3339)},
+	}
+	for _, d := range data {
+		err := p.store.WriteEntry(ctx, sessionId, d.key, []byte(d.value))
+		if err != nil {
+			return err
+		}
+	}
+	publicKeyNormalized, err := common.NormalizeHex(publicKey)
+	if err != nil {
+		return err
+	}
+	return p.store.WriteEntry(ctx, publicKeyNormalized, common.DATA_PUBLIC_KEY_REVERSE, []byte(sessionId))
+}
+
+// Check queries the account status on the API and moves a pending account to active.
+func (p *Provisioner) Check(ctx context.Context, sessionId string) (State, error) {
+	publicKey, err := p.store.ReadEntry(ctx, sessionId, common.DATA_PUBLIC_KEY)
+	if err != nil {
+		return StateNone, err
+	}
+
+	r, err := p.accountService.TrackAccountStatus(ctx, string(publicKey))
+	if err != nil {
+		return StatePending, err
+	}
+	if !r.Active {
+		return StatePending, nil
+	}
+
+	err = p.setState(ctx, sessionId, StateActive)
+	if err != nil {
+		return StateActive, err
+	}
+	err = p.removePending(ctx, sessionId)
+	return StateActive, err
+}
+
+// Reconcile walks all accounts that have not reached the active state yet.
+//
+// Accounts with a tracking id are checked for activation, and the user is notified when the account becomes active.
+// Requests that failed or were interrupted before the API accepted them are retried.
+func (p *Provisioner) Reconcile(ctx context.Context) error {
+	sessionIds, err := p.getPending(ctx)
+	if err != nil {
+		return err
+	}
+	for _, sessionId := range sessionIds {
+		st, err := p.GetState(ctx, sessionId)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to read provisioning state", "session", sessionId, "error", err)
+			continue
+		}
+		switch st {
+		case StateActive:
+			err = p.removePending(ctx, sessionId)
+		case StateRequested, StateFailed:
+			st, err = p.Request(ctx, sessionId)
+		}
+		if err != nil {
+			if err != ErrInProgress {
+				logg.WarnCtxf(ctx, "account provisioning retry failed", "session", sessionId, "state", st, "error", err)
+			}
+			continue
+		}
+		if st != StatePending {
+			continue
+		}
+		st, err = p.Check(ctx, sessionId)
+		if err != nil {
+			logg.WarnCtxf(ctx, "account status check failed", "session", sessionId, "error", err)
+			continue
+		}
+		if st == StateActive {
+			logg.InfoCtxf(ctx, "account activated", "session", sessionId)
+			err = p.notifier.Notify(ctx, sessionId, notify.Locale(ctx, p.store, sessionId).Get("Your Sarafu account is now active. Dial again to start using it."))
+			if err != nil {
+				logg.WarnCtxf(ctx, "account activation notification failed", "session", sessionId, "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Run reconciles pending accounts at the given interval until the context is done.
+func (p *Provisioner) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := p.Reconcile(ctx)
+			if err != nil {
+				logg.ErrorCtxf(ctx, "account provisioning reconcile failed", "error", err)
+			}
+		}
+	}
+}
+
+func (p *Provisioner) getPending(ctx context.Context) ([]string, error) {
+	p.indexMu.Lock()
+	defer p.indexMu.Unlock()
+	return p.readPending(ctx)
+}
+
+// readPending reads the index of accounts that are not yet active.
+//
+// The index is kept outside of any session, and is read through its own handle set with WithIndexStore.
+func (p *Provisioner) readPending(ctx context.Context) ([]string, error) {
+	p.indexStore.SetSession("")
+	v, err := p.indexDb.Get(ctx, []byte(pendingKey))
+	if err != nil {
+		if db.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, nil
+	}
+	return strings.Split(string(v), "\n"), nil
+}
+
+func (p *Provisioner) addPending(ctx context.Context, sessionId string) error {
+	p.indexMu.Lock()
+	defer p.indexMu.Unlock()
+	sessionIds, err := p.readPending(ctx)
+	if err != nil {
+		return err
+	}
+	for _, v := range sessionIds {
+		if v == sessionId {
+			return nil
+		}
+	}
+	sessionIds = append(sessionIds, sessionId)
+	return p.indexDb.Put(ctx, []byte(pendingKey), []byte(strings.Join(sessionIds, "\n")))
+}
+
+func (p *Provisioner) removePending(ctx context.Context, sessionId string) error {
+	p.indexMu.Lock()
+	defer p.indexMu.Unlock()
+	sessionIds, err := p.readPending(ctx)
+	if err != nil {
+		return err
+	}
+	var r []string
+	for _, v := range sessionIds {
+		if v != sessionId {
+			r = append(r, v)
+		}
+	}
+	if len(r) == len(sessionIds) {
+		return nil
+	}
+	return p.indexDb.Put(ctx, []byte(pendingKey), []byte(strings.Join(r, "\n")))
+}
@@ -0,0 +1,169 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"git.defalsify.org/vise.git/db"
+	"github.com/alecthomas/assert/v2"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/storage"
+	"git.grassecon.net/urdt/ussd/internal/testutil/mocks"
+	"git.grassecon.net/urdt/ussd/internal/testutil/teststore"
+	"git.grassecon.net/urdt/ussd/models"
+)
+
+type testNotifier struct {
+	sent map[string]string
+}
+
+func (n *testNotifier) Notify(ctx context.Context, sessionId string, message string) error {
+	n.sent[sessionId] = message
+	return nil
+}
+
+func TestRequestIdempotent(t *testing.T) {
+	sessionId := "+254712345678"
+	ctx, store := teststore.InitializeTestStore(t)
+	mockAccountService := new(mocks.MockAccountService)
+	mockAccountService.On("CreateAccount").Return(&models.AccountResult{
+		TrackingId: "1234567890",
+		PublicKey:  "0xD3adB33f",
+	}, nil)
+
+	p := NewProvisioner(store, mockAccountService, nil)
+	st, err := p.Request(ctx, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, StatePending, st)
+
+	st, err = p.Request(ctx, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, StatePending, st)
+	mockAccountService.AssertNumberOfCalls(t, "CreateAccount", 1)
+
+	publicKey, err := store.ReadEntry(ctx, sessionId, common.DATA_PUBLIC_KEY)
+	assert.NoError(t, err)
+	assert.Equal(t, "0xD3adB33f", string(publicKey))
+
+	pending, err := p.getPending(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{sessionId}, pending)
+}
+
+func TestReconcile(t *testing.T) {
+	sessionId := "+254712345678"
+	ctx, store := teststore.InitializeTestStore(t)
+	notifier := &testNotifier{sent: make(map[string]string)}
+
+	failing := new(mocks.MockAccountService)
+	failing.On("CreateAccount").Return((*models.AccountResult)(nil), errors.New("service unavailable"))
+	p := NewProvisioner(store, failing, notifier)
+	st, err := p.Request(ctx, sessionId)
+	assert.Error(t, err)
+	assert.Equal(t, StateFailed, st)
+
+	mockAccountService := new(mocks.MockAccountService)
+	mockAccountService.On("CreateAccount").Return(&models.AccountResult{
+		TrackingId: "1234567890",
+		PublicKey:  "0xD3adB33f",
+	}, nil)
+	mockAccountService.On("TrackAccountStatus", "0xD3adB33f").Return(&models.TrackStatusResult{
+		Active: true,
+	}, nil)
+	p = NewProvisioner(store, mockAccountService, notifier)
+	err = p.Reconcile(ctx)
+	assert.NoError(t, err)
+
+	st, err = p.GetState(ctx, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, StateActive, st)
+
+	pending, err := p.getPending(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(pending))
+
+	_, ok := notifier.sent[sessionId]
+	assert.True(t, ok)
+}
+
+func TestRequestExistingAccount(t *testing.T) {
+	tests := []struct {
+		name  string
+		state State
+		data  map[common.DataTyp]string
+	}{
+		{
+			name:  "failed after the API accepted the request",
+			state: StateFailed,
+			data: map[common.DataTyp]string{
+				common.DATA_TRACKING_ID: "1234567890",
+				common.DATA_PUBLIC_KEY:  "0xD3adB33f",
+			},
+		},
+		{
+			name:  "interrupted with only the tracking id saved",
+			state: StateRequested,
+			data: map[common.DataTyp]string{
+				common.DATA_TRACKING_ID: "1234567890",
+			},
+		},
+		{
+			name:  "interrupted with only the public key saved",
+			state: StateFailed,
+			data: map[common.DataTyp]string{
+				common.DATA_PUBLIC_KEY: "0xD3adB33f",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessionId := "+254712345678"
+			ctx, store := teststore.InitializeTestStore(t)
+			err := store.WriteEntry(ctx, sessionId, common.DATA_ACCOUNT_STATUS, []byte(tt.state))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.data {
+				err = store.WriteEntry(ctx, sessionId, k, []byte(v))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			mockAccountService := new(mocks.MockAccountService)
+			p := NewProvisioner(store, mockAccountService, nil)
+			st, err := p.Request(ctx, sessionId)
+			assert.NoError(t, err)
+			assert.Equal(t, StatePending, st)
+			mockAccountService.AssertNumberOfCalls(t, "CreateAccount", 0)
+
+			pending, err := p.getPending(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{sessionId}, pending)
+		})
+	}
+}
+
+func TestIndexStore(t *testing.T) {
+	sessionId := "+254712345678"
+	ctx, store := teststore.InitializeTestStore(t)
+	_, index := teststore.InitializeTestStore(t)
+	mockAccountService := new(mocks.MockAccountService)
+	mockAccountService.On("CreateAccount").Return(&models.AccountResult{
+		TrackingId: "1234567890",
+		PublicKey:  "0xD3adB33f",
+	}, nil)
+
+	p := NewProvisioner(store, mockAccountService, nil).WithIndexStore(index.Db)
+	_, err := p.WithStore(store).Request(ctx, sessionId)
+	assert.NoError(t, err)
+
+	// the index is only kept through its own handle
+	_, err = storage.NewSubPrefixDb(store, []byte("provision")).Get(ctx, []byte(pendingKey))
+	assert.True(t, db.IsNotFound(err))
+	pending, err := p.getPending(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{sessionId}, pending)
+}
@@ -0,0 +1,85 @@
+package risk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"git.defalsify.org/vise.git/logging"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("risk")
+)
+
+// Engine evaluates transfers against a set of rules.
+type Engine struct {
+	rules []Rule
+}
+
+// NewEngine creates a new Engine with the given rules. An Engine without rules allows all transfers.
+func NewEngine(rules ...Rule) *Engine {
+	return &Engine{
+		rules: rules,
+	}
+}
+
+// NewEngineFromConfig creates an Engine with the built-in rules set up from the given configuration.
+func NewEngineFromConfig(cfg *Config) *Engine {
+	return NewEngine(
+		NewLimitsRule(cfg),
+		NewVelocityRule(cfg),
+		NewConfirmRule(cfg),
+	)
+}
+
+// LoadEngine reads the rules configuration from the JSON file at the given path.
+//
+// If the path is empty, the returned Engine allows all transfers.
+func LoadEngine(fp string) (*Engine, error) {
+	if fp == "" {
+		logg.Warnf("no transfer risk rules configured")
+		return NewEngine(), nil
+	}
+	b, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read risk rules file: %v", err)
+	}
+	var cfg Config
+	err = json.Unmarshal(b, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse risk rules file %s: %v", fp, err)
+	}
+	logg.Infof("loaded transfer risk rules", "path", fp)
+	return NewEngineFromConfig(&cfg), nil
+}
+
+// WithRule adds a rule to the engine.
+func (e *Engine) WithRule(r Rule) *Engine {
+	e.rules = append(e.rules, r)
+	return e
+}
+
+// Evaluate runs all rules against the transfer and returns the most severe decision.
+//
+// If several rules return a decision of the same severity, the first one is returned.
+// Every decision is logged together with its reason.
+func (e *Engine) Evaluate(ctx context.Context, sessionId string, t Transfer, history *History) Decision {
+	var r Decision
+	if history == nil {
+		history = NewHistory()
+	}
+	for _, rule := range e.rules {
+		d := rule.Evaluate(ctx, t, history)
+		if d.Action == Allow {
+			continue
+		}
+		logg.DebugCtxf(ctx, "risk rule matched", "session", sessionId, "rule", d.Rule, "action", d.Action, "reason", d.Reason)
+		if d.Action > r.Action {
+			r = d
+		}
+	}
+	logg.InfoCtxf(ctx, "transfer risk decision", "session", sessionId, "voucher", t.Voucher, "amount", t.Amount, "recipient", t.Recipient, "action", r.Action, "rule", r.Rule, "reason", r.Reason)
+	return r
+}
@@ -0,0 +1,211 @@
+package risk
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+
+	"git.grassecon.net/urdt/ussd/common"
+)
+
+const (
+	// retention is how long transfers are kept in the ledger; it covers the longest rule window.
+	retention = 7 * 24 * time.Hour
+	// anonymousPrefix marks recipients that have been replaced by a hash.
+	anonymousPrefix = "anon:"
+)
+
+// Ledger keeps the recent transfers initiated by each account, for evaluating the rules.
+type Ledger struct {
+	store common.DataStore
+}
+
+// NewLedger creates a new Ledger.
+func NewLedger(store common.DataStore) *Ledger {
+	return &Ledger{
+		store: store,
+	}
+}
+
+// History holds the transfers recently made by an account, and the recipients it has sent to.
+type History struct {
+	// Transfers are the transfers made within the retention period of the ledger.
+	Transfers []Transfer
+	// recipients holds the normalised addresses of all recipients the account has sent to, however long ago.
+	recipients map[string]bool
+}
+
+// NewHistory creates a History of the given transfers.
+func NewHistory(transfers ...Transfer) *History {
+	h := &History{}
+	for _, t := range transfers {
+		h.Add(t)
+	}
+	return h
+}
+
+// Add adds a transfer that has been made, or is about to be made, to the history.
+func (h *History) Add(t Transfer) {
+	h.Transfers = append(h.Transfers, t)
+	h.addRecipient(t.Recipient)
+}
+
+// Known returns true if the account has sent to the recipient before.
+func (h *History) Known(recipient string) bool {
+	return h.recipients[normaliseRecipient(recipient)]
+}
+
+func (h *History) addRecipient(recipient string) {
+	if h.recipients == nil {
+		h.recipients = make(map[string]bool)
+	}
+	h.recipients[normaliseRecipient(recipient)] = true
+}
+
+// normaliseRecipient returns the form recipients are compared in. Addresses are hex, and may be given in any case.
+func normaliseRecipient(recipient string) string {
+	return strings.ToLower(strings.TrimSpace(recipient))
+}
+
+// History returns the transfers made by the session id within the retention period, and the recipients it has sent to.
+func (l *Ledger) History(ctx context.Context, sessionId string) (*History, error) {
+	transfers, err := l.transfers(ctx, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	h := NewHistory(transfers...)
+	recipients, err := l.recipients(ctx, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range recipients {
+		h.addRecipient(r)
+	}
+	return h, nil
+}
+
+// transfers returns the transfers in the transfer log of the session id.
+func (l *Ledger) transfers(ctx context.Context, sessionId string) ([]Transfer, error) {
+	v, err := l.store.ReadEntry(ctx, sessionId, common.DATA_TRANSFER_LOG)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var r []Transfer
+	for _, line := range strings.Split(string(v), "\n") {
+		if line == "" {
+			continue
+		}
+		t, err := parseTransfer(line)
+		if err != nil {
+			logg.WarnCtxf(ctx, "skipping invalid transfer log entry", "session", sessionId, "error", err)
+			continue
+		}
+		r = append(r, t)
+	}
+	return r, nil
+}
+
+// recipients returns the normalised recipients the session id has sent to.
+func (l *Ledger) recipients(ctx context.Context, sessionId string) ([]string, error) {
+	v, err := l.store.ReadEntry(ctx, sessionId, common.DATA_KNOWN_RECIPIENTS)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var r []string
+	for _, line := range strings.Split(string(v), "\n") {
+		if line != "" {
+			r = append(r, line)
+		}
+	}
+	return r, nil
+}
+
+// Record adds the transfer to the ledger of the session id, dropping transfers older than the retention period,
+// and adds its recipient to the recipients the session id has sent to.
+func (l *Ledger) Record(ctx context.Context, sessionId string, t Transfer) error {
+	history, err := l.transfers(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+	since := t.Time.Add(-retention)
+	var lines []string
+	for _, h := range history {
+		if h.Time.After(since) {
+			lines = append(lines, formatTransfer(h))
+		}
+	}
+	lines = append(lines, formatTransfer(t))
+	err = l.store.WriteEntry(ctx, sessionId, common.DATA_TRANSFER_LOG, []byte(strings.Join(lines, "\n")))
+	if err != nil {
+		return err
+	}
+
+	recipients, err := l.recipients(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+	recipient := normaliseRecipient(t.Recipient)
+	for _, r := range recipients {
+		if r == recipient {
+			return nil
+		}
+	}
+	recipients = append(recipients, recipient)
+	return l.store.WriteEntry(ctx, sessionId, common.DATA_KNOWN_RECIPIENTS, []byte(strings.Join(recipients, "\n")))
+}
+
+func formatTransfer(t Transfer) string {
+	return fmt.Sprintf("%d|%s|%s|%s", t.Time.Unix(), t.Voucher, t.Amount, t.Recipient)
+}
+
+func parseTransfer(s string) (Transfer, error) {
+	parts := strings.SplitN(s, "|", 4)
+	if len(parts) != 4 {
+		return Transfer{}, fmt.Errorf("invalid transfer entry: %q", s)
+	}
+	ts, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Transfer{}, err
+	}
+	amount, err := common.ParseDecimal(parts[2])
+	if err != nil {
+		return Transfer{}, err
+	}
+	return Transfer{
+		Time:      time.Unix(ts, 0),
+		Voucher:   parts[1],
+		Amount:    amount,
+		Recipient: parts[3],
+	}, nil
+}
+
+// Anonymise replaces the recipients in the ledger of the session id with a one-way hash.
+//
+// The transfers are kept for auditing, and transfers to the same recipient can still be told apart.
+func (l *Ledger) Anonymise(ctx context.Context, sessionId string) error {
+	history, err := l.transfers(ctx, sessionId)
+	if err != nil || len(history) == 0 {
+		return err
+	}
+	var lines []string
+	for _, h := range history {
+		if !strings.HasPrefix(h.Recipient, anonymousPrefix) {
+			sum := sha256.Sum256([]byte(h.Recipient))
+			h.Recipient = anonymousPrefix + hex.EncodeToString(sum[:8])
+		}
+		lines = append(lines, formatTransfer(h))
+	}
+	return l.store.WriteEntry(ctx, sessionId, common.DATA_TRANSFER_LOG, []byte(strings.Join(lines, "\n")))
+}
@@ -0,0 +1,137 @@
+package risk
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/testutil/teststore"
+)
+
+func decimal(t *testing.T, s string) common.Amount {
+	a, err := common.ParseDecimal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestEvaluate(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	cfg := &Config{
+		Default: Limits{
+			MaxPerTransaction: 100,
+			MaxDaily:          150,
+			MaxWeekly:         280,
+			ConfirmAbove:      80,
+		},
+		Vouchers: map[string]Limits{
+			"FOO": {
+				MaxPerTransaction: 10,
+			},
+		},
+		Velocity: Velocity{
+			MaxCount:      3,
+			WindowSeconds: 600,
+		},
+		ConfirmNewRecipient: true,
+	}
+	e := NewEngineFromConfig(cfg)
+	history := NewHistory(
+		Transfer{Time: now.Add(-2 * time.Hour), Voucher: "SRF", Amount: decimal(t, "100"), Recipient: "alice"},
+		Transfer{Time: now.Add(-3 * 24 * time.Hour), Voucher: "SRF", Amount: decimal(t, "150"), Recipient: "bob"},
+		Transfer{Time: now.Add(-30 * 24 * time.Hour), Voucher: "SRF", Amount: decimal(t, "1"), Recipient: "0xDAVE"},
+	)
+
+	tests := []struct {
+		name     string
+		transfer Transfer
+		history  *History
+		action   Action
+		rule     string
+	}{
+		{"allowed", Transfer{Voucher: "SRF", Amount: decimal(t, "20"), Recipient: "alice"}, history, Allow, ""},
+		{"new recipient", Transfer{Voucher: "SRF", Amount: decimal(t, "20"), Recipient: "carol"}, history, Confirm, RuleNewRecipient},
+		{"known recipient", Transfer{Voucher: "SRF", Amount: decimal(t, "20"), Recipient: " 0xdave"}, history, Allow, ""},
+		{"fractional limit", Transfer{Voucher: "SRF", Amount: decimal(t, "100.000000000000000001"), Recipient: "alice"}, nil, Deny, RuleMaxPerTransaction},
+		{"large amount", Transfer{Voucher: "SRF", Amount: decimal(t, "90"), Recipient: "alice"}, nil, Confirm, RuleLargeAmount},
+		{"per transaction", Transfer{Voucher: "SRF", Amount: decimal(t, "101"), Recipient: "alice"}, nil, Deny, RuleMaxPerTransaction},
+		{"sweep", Transfer{Voucher: "SRF", Amount: decimal(t, "500"), Recipient: "alice", Sweep: true}, history, Confirm, RuleLargeAmount},
+		{"voucher limit", Transfer{Voucher: "FOO", Amount: decimal(t, "11"), Recipient: "alice"}, nil, Deny, RuleMaxPerTransaction},
+		{"daily", Transfer{Voucher: "SRF", Amount: decimal(t, "60"), Recipient: "alice"}, history, Deny, RuleMaxDaily},
+		{"daily other voucher", Transfer{Voucher: "BAR", Amount: decimal(t, "60"), Recipient: "alice"}, history, Allow, ""},
+		{"weekly", Transfer{Voucher: "SRF", Amount: decimal(t, "40"), Recipient: "alice"}, history, Deny, RuleMaxWeekly},
+		{"velocity", Transfer{Voucher: "SRF", Amount: decimal(t, "1"), Recipient: "alice"}, NewHistory(
+			Transfer{Time: now.Add(-time.Minute), Voucher: "SRF", Amount: decimal(t, "1"), Recipient: "alice"},
+			Transfer{Time: now.Add(-2 * time.Minute), Voucher: "SRF", Amount: decimal(t, "1"), Recipient: "alice"},
+			Transfer{Time: now.Add(-3 * time.Minute), Voucher: "SRF", Amount: decimal(t, "1"), Recipient: "alice"},
+		), Deny, RuleVelocity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.transfer.Time = now
+			d := e.Evaluate(ctx, "+254712345678", tt.transfer, tt.history)
+			assert.Equal(t, tt.action, d.Action)
+			assert.Equal(t, tt.rule, d.Rule)
+		})
+	}
+}
+
+func TestEmptyEngine(t *testing.T) {
+	e, err := LoadEngine("")
+	assert.NoError(t, err)
+	d := e.Evaluate(context.Background(), "+254712345678", Transfer{Voucher: "SRF", Amount: decimal(t, "1000000000"), Time: time.Now()}, nil)
+	assert.Equal(t, Allow, d.Action)
+
+	_, err = LoadEngine("/nonexistent/risk_rules.json")
+	assert.Error(t, err)
+}
+
+func TestLedger(t *testing.T) {
+	sessionId := "+254712345678"
+	ctx, store := teststore.InitializeTestStore(t)
+	l := NewLedger(store)
+	now := time.Unix(time.Now().Unix(), 0)
+
+	history, err := l.History(ctx, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(history.Transfers))
+	assert.False(t, history.Known("alice"))
+
+	old := Transfer{Time: now.Add(-8 * 24 * time.Hour), Voucher: "SRF", Amount: decimal(t, "5"), Recipient: "alice"}
+	recent := Transfer{Time: now.Add(-time.Hour), Voucher: "SRF", Amount: decimal(t, "2.5"), Recipient: "bob"}
+	last := Transfer{Time: now, Voucher: "SRF", Amount: decimal(t, "1"), Recipient: "carol"}
+	for _, tr := range []Transfer{old, recent, last} {
+		err = l.Record(ctx, sessionId, tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history, err = l.History(ctx, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, []Transfer{recent, last}, history.Transfers)
+	assert.True(t, history.Known("ALICE"))
+	assert.True(t, history.Known("carol"))
+	assert.False(t, history.Known("dave"))
+
+	err = l.Anonymise(ctx, sessionId)
+	assert.NoError(t, err)
+	anonymised, err := l.History(ctx, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(anonymised.Transfers))
+	assert.Equal(t, recent.Amount, anonymised.Transfers[0].Amount)
+	assert.True(t, strings.HasPrefix(anonymised.Transfers[0].Recipient, anonymousPrefix))
+	assert.NotEqual(t, anonymised.Transfers[0].Recipient, anonymised.Transfers[1].Recipient)
+
+	err = l.Anonymise(ctx, sessionId)
+	assert.NoError(t, err)
+	again, err := l.History(ctx, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, anonymised.Transfers, again.Transfers)
+}
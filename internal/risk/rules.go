@@ -0,0 +1,237 @@
+package risk
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"git.grassecon.net/urdt/ussd/common"
+)
+
+// Action is the outcome of evaluating a transfer.
+type Action int
+
+const (
+	// Allow lets the transfer proceed.
+	Allow Action = iota
+	// Confirm requires the user to explicitly confirm the transfer before it proceeds.
+	Confirm
+	// Deny blocks the transfer.
+	Deny
+)
+
+func (a Action) String() string {
+	switch a {
+	case Confirm:
+		return "confirm"
+	case Deny:
+		return "deny"
+	}
+	return "allow"
+}
+
+// Rule names, used in decisions and logs.
+const (
+	RuleMaxPerTransaction = "max_per_transaction"
+	RuleMaxDaily          = "max_daily"
+	RuleMaxWeekly         = "max_weekly"
+	RuleVelocity          = "velocity"
+	RuleNewRecipient      = "new_recipient"
+	RuleLargeAmount       = "large_amount"
+)
+
+// Transfer describes a transfer that is about to be made, or has been made.
+type Transfer struct {
+	Time      time.Time
+	Voucher   string
+	Amount    common.Amount
+	Recipient string
+	// Sweep marks the transfer of the whole balance of an account that is being closed, which the amount limits do not apply to.
+	Sweep bool
+}
+
+// Decision is the result of evaluating a rule against a transfer.
+type Decision struct {
+	Action Action
+	// Rule is the name of the rule that made the decision, empty if no rule applied.
+	Rule string
+	// Reason explains the decision for the logs.
+	Reason string
+	// Limit is the limit or threshold that was hit, where applicable.
+	Limit float64
+}
+
+// Rule evaluates a transfer against the transfers previously made by the same account.
+type Rule interface {
+	Name() string
+	Evaluate(ctx context.Context, t Transfer, history *History) Decision
+}
+
+// Limits holds the amount limits for a voucher. A zero value disables the limit.
+type Limits struct {
+	MaxPerTransaction float64 `json:"max_per_transaction"`
+	MaxDaily          float64 `json:"max_daily"`
+	MaxWeekly         float64 `json:"max_weekly"`
+	ConfirmAbove      float64 `json:"confirm_above"`
+}
+
+// Velocity limits the number of transfers within a time window. A zero MaxCount disables the limit.
+type Velocity struct {
+	MaxCount      int `json:"max_count"`
+	WindowSeconds int `json:"window_seconds"`
+}
+
+// Config is the rules configuration, as read from the rules file.
+type Config struct {
+	// Default limits apply to vouchers that have no entry in Vouchers.
+	Default Limits `json:"default"`
+	// Vouchers holds limits by voucher symbol.
+	Vouchers            map[string]Limits `json:"vouchers"`
+	Velocity            Velocity          `json:"velocity"`
+	ConfirmNewRecipient bool              `json:"confirm_new_recipient"`
+}
+
+func (c *Config) limits(voucher string) Limits {
+	if l, ok := c.Vouchers[voucher]; ok {
+		return l
+	}
+	return c.Default
+}
+
+// amount returns a configured limit as an exact amount, to compare with amounts of any voucher.
+func amount(limit float64) common.Amount {
+	a, err := common.ParseDecimal(strconv.FormatFloat(limit, 'f', -1, 64))
+	if err != nil {
+		logg.Errorf("invalid limit", "limit", limit, "error", err)
+	}
+	return a
+}
+
+type limitsRule struct {
+	cfg *Config
+}
+
+// NewLimitsRule denies transfers above the per-transaction limit, or that would take the
+// total sent of the voucher over the daily or weekly limit. Sweep transfers are not limited.
+func NewLimitsRule(cfg *Config) Rule {
+	return &limitsRule{cfg: cfg}
+}
+
+func (r *limitsRule) Name() string {
+	return "limits"
+}
+
+func (r *limitsRule) Evaluate(ctx context.Context, t Transfer, history *History) Decision {
+	if t.Sweep {
+		return Decision{}
+	}
+	l := r.cfg.limits(t.Voucher)
+	if l.MaxPerTransaction > 0 && t.Amount.Cmp(amount(l.MaxPerTransaction)) > 0 {
+		return Decision{
+			Action: Deny,
+			Rule:   RuleMaxPerTransaction,
+			Reason: fmt.Sprintf("amount %v exceeds per transaction limit %v", t.Amount, l.MaxPerTransaction),
+			Limit:  l.MaxPerTransaction,
+		}
+	}
+	day := sumSince(history, t.Voucher, t.Time.Add(-24*time.Hour))
+	if l.MaxDaily > 0 && day.Add(t.Amount).Cmp(amount(l.MaxDaily)) > 0 {
+		return Decision{
+			Action: Deny,
+			Rule:   RuleMaxDaily,
+			Reason: fmt.Sprintf("amount %v with %v sent in the last day exceeds daily limit %v", t.Amount, day, l.MaxDaily),
+			Limit:  l.MaxDaily,
+		}
+	}
+	week := sumSince(history, t.Voucher, t.Time.Add(-7*24*time.Hour))
+	if l.MaxWeekly > 0 && week.Add(t.Amount).Cmp(amount(l.MaxWeekly)) > 0 {
+		return Decision{
+			Action: Deny,
+			Rule:   RuleMaxWeekly,
+			Reason: fmt.Sprintf("amount %v with %v sent in the last week exceeds weekly limit %v", t.Amount, week, l.MaxWeekly),
+			Limit:  l.MaxWeekly,
+		}
+	}
+	return Decision{}
+}
+
+func sumSince(history *History, voucher string, since time.Time) common.Amount {
+	var total common.Amount
+	for _, h := range history.Transfers {
+		if h.Voucher == voucher && h.Time.After(since) {
+			total = total.Add(h.Amount)
+		}
+	}
+	return total
+}
+
+type velocityRule struct {
+	cfg *Config
+}
+
+// NewVelocityRule denies a transfer if too many transfers have been made within the configured window.
+func NewVelocityRule(cfg *Config) Rule {
+	return &velocityRule{cfg: cfg}
+}
+
+func (r *velocityRule) Name() string {
+	return RuleVelocity
+}
+
+func (r *velocityRule) Evaluate(ctx context.Context, t Transfer, history *History) Decision {
+	v := r.cfg.Velocity
+	if v.MaxCount <= 0 || v.WindowSeconds <= 0 {
+		return Decision{}
+	}
+	since := t.Time.Add(-time.Duration(v.WindowSeconds) * time.Second)
+	var count int
+	for _, h := range history.Transfers {
+		if h.Time.After(since) {
+			count++
+		}
+	}
+	if count >= v.MaxCount {
+		return Decision{
+			Action: Deny,
+			Rule:   RuleVelocity,
+			Reason: fmt.Sprintf("%d transfers in the last %d seconds, limit is %d", count, v.WindowSeconds, v.MaxCount),
+			Limit:  float64(v.MaxCount),
+		}
+	}
+	return Decision{}
+}
+
+type confirmRule struct {
+	cfg *Config
+}
+
+// NewConfirmRule asks for an extra confirmation for transfers to recipients the account has never sent to before,
+// and for transfers above the confirmation threshold of the voucher.
+func NewConfirmRule(cfg *Config) Rule {
+	return &confirmRule{cfg: cfg}
+}
+
+func (r *confirmRule) Name() string {
+	return "confirm"
+}
+
+func (r *confirmRule) Evaluate(ctx context.Context, t Transfer, history *History) Decision {
+	l := r.cfg.limits(t.Voucher)
+	if l.ConfirmAbove > 0 && t.Amount.Cmp(amount(l.ConfirmAbove)) > 0 {
+		return Decision{
+			Action: Confirm,
+			Rule:   RuleLargeAmount,
+			Reason: fmt.Sprintf("amount %v is above confirmation threshold %v", t.Amount, l.ConfirmAbove),
+			Limit:  l.ConfirmAbove,
+		}
+	}
+	if !r.cfg.ConfirmNewRecipient || history.Known(t.Recipient) {
+		return Decision{}
+	}
+	return Decision{
+		Action: Confirm,
+		Rule:   RuleNewRecipient,
+		Reason: "no previous transfers to recipient",
+	}
+}
@@ -0,0 +1,278 @@
+package schedule
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.defalsify.org/vise.git/logging"
+	"gopkg.in/leonelquinteros/gotext.v1"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/balance"
+	"git.grassecon.net/urdt/ussd/internal/notify"
+	"git.grassecon.net/urdt/ussd/internal/operator"
+	"git.grassecon.net/urdt/ussd/internal/risk"
+	"git.grassecon.net/urdt/ussd/remote"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("schedule")
+)
+
+// Scheduler makes the scheduled transfers of all accounts once they are due, and tells the senders how they went.
+type Scheduler struct {
+	store          common.DataStore
+	accountService remote.AccountServiceInterface
+	notifier       notify.Notifier
+	riskEngine     *risk.Engine
+	simChecker     operator.SimChecker
+}
+
+// NewScheduler creates a new Scheduler. If notifier is nil, notifications are only logged.
+func NewScheduler(store common.DataStore, accountService remote.AccountServiceInterface, notifier notify.Notifier) *Scheduler {
+	if notifier == nil {
+		notifier = &notify.LogNotifier{}
+	}
+	return &Scheduler{
+		store:          store,
+		accountService: accountService,
+		notifier:       notifier,
+	}
+}
+
+// WithRiskEngine sets the engine the transfers are evaluated by before they are made.
+func (s *Scheduler) WithRiskEngine(engine *risk.Engine) *Scheduler {
+	s.riskEngine = engine
+	return s
+}
+
+// WithSimChecker sets the checker used to hold the transfers of accounts with a recent SIM change.
+func (s *Scheduler) WithSimChecker(checker operator.SimChecker) *Scheduler {
+	s.simChecker = checker
+	return s
+}
+
+func (s *Scheduler) getRiskEngine() *risk.Engine {
+	if s.riskEngine == nil {
+		s.riskEngine = risk.NewEngine()
+	}
+	return s.riskEngine
+}
+
+func (s *Scheduler) getSimChecker() operator.SimChecker {
+	if s.simChecker == nil {
+		s.simChecker = operator.NewSimChecker()
+	}
+	return s.simChecker
+}
+
+// RunDue makes the scheduled transfers that are due at now.
+//
+// Each schedule is moved to its next date before its transfer is made, so a transfer that fails is not retried.
+// The schedules of closed accounts are cancelled without a transfer.
+func (s *Scheduler) RunDue(ctx context.Context, now time.Time) error {
+	sessionIds, err := common.ScheduledAccounts(ctx, s.store)
+	if err != nil {
+		return err
+	}
+	for _, sessionId := range sessionIds {
+		holder, err := common.ResolvePorted(ctx, s.store, sessionId)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to resolve ported number", "session", sessionId, "error", err)
+			continue
+		}
+		if holder != sessionId {
+			// the schedules have moved along with the account to its new number
+			err = common.ReindexSchedules(ctx, s.store, sessionId)
+			if err == nil {
+				err = common.ReindexSchedules(ctx, s.store, holder)
+			}
+			if err != nil {
+				logg.ErrorCtxf(ctx, "failed to move scheduled transfers", "session", sessionId, "holder", holder, "error", err)
+				continue
+			}
+		}
+
+		schedules, err := common.ReadSchedules(ctx, s.store, holder)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to read schedules entry with", "key", common.DATA_SCHEDULES, "session", holder, "error", err)
+			continue
+		}
+		if len(schedules) == 0 {
+			err = common.ReindexSchedules(ctx, s.store, holder)
+			if err != nil {
+				logg.ErrorCtxf(ctx, "failed to update the index of scheduled transfers", "session", holder, "error", err)
+			}
+			continue
+		}
+		_, closed, err := common.ReadClosure(ctx, s.store, holder)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to read closure entry with", "key", common.DATA_ACCOUNT_CLOSED, "error", err)
+			continue
+		}
+		for _, sc := range schedules {
+			if closed {
+				_, err = common.RemoveSchedule(ctx, s.store, holder, sc.Id)
+				if err != nil {
+					logg.ErrorCtxf(ctx, "failed to cancel scheduled transfer of closed account", "session", holder, "error", err)
+				}
+				continue
+			}
+			if sc.IsDue(now) {
+				s.run(ctx, holder, sc.Id, now)
+			}
+		}
+	}
+	return nil
+}
+
+// Run makes the due scheduled transfers at the given interval until the context is done.
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := s.RunDue(ctx, time.Now())
+			if err != nil {
+				logg.ErrorCtxf(ctx, "scheduled transfers run failed", "error", err)
+			}
+		}
+	}
+}
+
+// run makes the due transfer of the schedule with the given id, and notifies the sender.
+func (s *Scheduler) run(ctx context.Context, sessionId string, id string, now time.Time) {
+	sc, _, err := common.AdvanceSchedule(ctx, s.store, sessionId, id, now)
+	if err != nil {
+		if err != common.ErrNoSchedule {
+			logg.ErrorCtxf(ctx, "failed to write schedules entry with", "key", common.DATA_SCHEDULES, "session", sessionId, "error", err)
+		}
+		return
+	}
+
+	msg := s.transfer(ctx, sessionId, sc, notify.Locale(ctx, s.store, sessionId))
+	err = s.notifier.Notify(ctx, sessionId, msg)
+	if err != nil {
+		logg.WarnCtxf(ctx, "scheduled transfer notification failed", "session", sessionId, "error", err)
+	}
+}
+
+// transfer makes the transfer of the schedule from the session id, and returns the message telling the sender how it went.
+func (s *Scheduler) transfer(ctx context.Context, sessionId string, sc common.Schedule, l *gotext.Locale) string {
+	failed := l.Get("Your scheduled transfer of %s %s to %s failed.", sc.Amount, sc.Symbol, sc.Recipient)
+
+	_, frozen, err := common.ReadFreeze(ctx, s.store, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read freeze entry with", "key", common.DATA_ACCOUNT_FROZEN, "error", err)
+		return failed
+	}
+	if frozen {
+		logg.WarnCtxf(ctx, "scheduled transfer blocked on frozen account", "session", sessionId)
+		return l.Get("Your scheduled transfer of %s %s to %s was not made, as your account is frozen.", sc.Amount, sc.Symbol, sc.Recipient)
+	}
+
+	changed, changedAt, err := operator.RecentSimChange(ctx, s.getSimChecker(), sessionId, operator.SwapWindow())
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to check SIM change", "session", sessionId, "error", err)
+		return failed
+	}
+	if changed {
+		logg.WarnCtxf(ctx, "scheduled transfer blocked after SIM change", "session", sessionId, "changed", changedAt)
+		return l.Get("Your scheduled transfer of %s %s to %s was not made, as your SIM card was recently changed.", sc.Amount, sc.Symbol, sc.Recipient)
+	}
+
+	amount, err := common.ParseAmount(sc.Amount, sc.Decimals)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "invalid scheduled transfer amount", "session", sessionId, "amount", sc.Amount, "error", err)
+		return failed
+	}
+
+	// the rules asking for a confirmation were confirmed when the transfer was scheduled
+	t := risk.Transfer{
+		Time:      time.Now(),
+		Voucher:   sc.Symbol,
+		Amount:    amount,
+		Recipient: sc.RecipientAddress,
+	}
+	ledger := risk.NewLedger(s.store)
+	history, err := ledger.History(ctx, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read transfer log entry with", "key", common.DATA_TRANSFER_LOG, "error", err)
+		return failed
+	}
+	d := s.getRiskEngine().Evaluate(ctx, sessionId, t, history)
+	if d.Action == risk.Deny {
+		logg.WarnCtxf(ctx, "scheduled transfer denied by risk rules", "session", sessionId, "schedule", sc.Id, "rule", d.Rule)
+		return deniedReason(l, d, sc)
+	}
+	if d.Action == risk.Confirm {
+		logg.InfoCtxf(ctx, "scheduled transfer confirmed when scheduled", "session", sessionId, "schedule", sc.Id, "rule", d.Rule)
+	}
+
+	publicKey, err := s.store.ReadEntry(ctx, sessionId, common.DATA_PUBLIC_KEY)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read publicKey entry with", "key", common.DATA_PUBLIC_KEY, "error", err)
+		return failed
+	}
+
+	holdings, err := s.accountService.FetchVouchers(ctx, string(publicKey))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed on FetchVouchers", "error", err)
+		return failed
+	}
+	var bal common.Amount
+	for _, v := range holdings {
+		if strings.EqualFold(v.ContractAddress, sc.Address) {
+			bal, err = common.NewAmount(v.Balance, v.TokenDecimals)
+			if err != nil {
+				logg.ErrorCtxf(ctx, "invalid voucher balance", "session", sessionId, "balance", v.Balance, "error", err)
+				return failed
+			}
+			break
+		}
+	}
+	if amount.Cmp(bal) > 0 {
+		logg.InfoCtxf(ctx, "scheduled transfer skipped for insufficient balance", "session", sessionId, "amount", sc.Amount, "voucher", sc.Symbol)
+		return l.Get("Your scheduled transfer of %s %s to %s was not made, as you do not have enough %s.", sc.Amount, sc.Symbol, sc.Recipient, sc.Symbol)
+	}
+
+	r, err := s.accountService.TokenTransfer(ctx, amount.Raw(), string(publicKey), sc.RecipientAddress, sc.Address)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed on TokenTransfer", "error", err)
+		return failed
+	}
+	logg.InfoCtxf(ctx, "scheduled transfer made", "session", sessionId, "schedule", sc.Id, "trackingId", r.TrackingId)
+
+	// the transfer counts towards the limits of the transfer risk rules
+	err = ledger.Record(ctx, sessionId, t)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write transfer log entry with", "key", common.DATA_TRANSFER_LOG, "error", err)
+	}
+	err = balance.NewDefaultService(s.store, s.accountService).Invalidate(ctx, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write active balance timestamp entry with", "key", common.DATA_ACTIVE_BAL_UPDATED, "error", err)
+	}
+
+	return l.Get("Your scheduled transfer of %s %s to %s has been sent.", sc.Amount, sc.Symbol, sc.Recipient)
+}
+
+// deniedReason returns the message telling the sender which transfer risk rule the scheduled transfer was denied by.
+func deniedReason(l *gotext.Locale, d risk.Decision, sc common.Schedule) string {
+	limit := strconv.FormatFloat(d.Limit, 'f', -1, 64)
+	switch d.Rule {
+	case risk.RuleMaxPerTransaction:
+		return l.Get("Your scheduled transfer of %s %s to %s was not made, as it is above the limit of %s %s per transaction.", sc.Amount, sc.Symbol, sc.Recipient, limit, sc.Symbol)
+	case risk.RuleMaxDaily:
+		return l.Get("Your scheduled transfer of %s %s to %s was not made, as it would exceed your daily limit of %s %s.", sc.Amount, sc.Symbol, sc.Recipient, limit, sc.Symbol)
+	case risk.RuleMaxWeekly:
+		return l.Get("Your scheduled transfer of %s %s to %s was not made, as it would exceed your weekly limit of %s %s.", sc.Amount, sc.Symbol, sc.Recipient, limit, sc.Symbol)
+	case risk.RuleVelocity:
+		return l.Get("Your scheduled transfer of %s %s to %s was not made, as you have made too many transfers.", sc.Amount, sc.Symbol, sc.Recipient)
+	}
+	return l.Get("Your scheduled transfer of %s %s to %s was not made, as it is not allowed by the transfer rules.", sc.Amount, sc.Symbol, sc.Recipient)
+}
@@ -0,0 +1,154 @@
+package schedule
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	dataserviceapi "github.com/grassrootseconomics/ussd-data-service/pkg/api"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/operator"
+	"git.grassecon.net/urdt/ussd/internal/risk"
+	"git.grassecon.net/urdt/ussd/internal/testutil/mocks"
+	"git.grassecon.net/urdt/ussd/internal/testutil/teststore"
+	"git.grassecon.net/urdt/ussd/models"
+)
+
+type testNotifier struct {
+	sent map[string][]string
+}
+
+func (n *testNotifier) Notify(ctx context.Context, sessionId string, message string) error {
+	n.sent[sessionId] = append(n.sent[sessionId], message)
+	return nil
+}
+
+func TestRunDue(t *testing.T) {
+	sessionId := "+254712345678"
+	publicKey := "0x8a6ad4e22c1e5d4be5ee8e1d7f57d7c5c4e6ef21"
+	ctx, store := teststore.InitializeTestStore(t)
+	now := time.Date(2026, time.March, 2, 9, 0, 0, 0, time.Local)
+
+	err := store.WriteEntry(ctx, sessionId, common.DATA_PUBLIC_KEY, []byte(publicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := common.Schedule{
+		Recipient:        "+254787654321",
+		RecipientAddress: "0x5523058cdFfe5F3c1EaDADD5015E55C6E00fb439",
+		Amount:           "2.5",
+		Symbol:           "SRF",
+		Address:          "0xd4c288865Ce",
+		Decimals:         "6",
+		Frequency:        common.FrequencyWeekly,
+		Start:            now.Add(-time.Hour),
+	}
+	weekly, err := common.AddSchedule(ctx, store, sessionId, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Amount = "5"
+	s.Frequency = common.FrequencyOnce
+	_, err = common.AddSchedule(ctx, store, sessionId, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start = now.Add(time.Hour)
+	later, err := common.AddSchedule(ctx, store, sessionId, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockAccountService := new(mocks.MockAccountService)
+	mockAccountService.On("FetchVouchers", publicKey).Return([]dataserviceapi.TokenHoldings{
+		{ContractAddress: "0xd4c288865Ce", TokenSymbol: "SRF", TokenDecimals: "6", Balance: "3000000"},
+	}, nil)
+	mockAccountService.On("TokenTransfer").Return(&models.TokenTransferResponse{TrackingId: "1234567890"}, nil)
+	notifier := &testNotifier{sent: make(map[string][]string)}
+
+	sc := NewScheduler(store, mockAccountService, notifier)
+	err = sc.RunDue(ctx, now)
+	assert.NoError(t, err)
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 1)
+	assert.Equal(t, []string{
+		"Your scheduled transfer of 2.5 SRF to +254787654321 has been sent.",
+		"Your scheduled transfer of 5 SRF to +254787654321 was not made, as you do not have enough SRF.",
+	}, notifier.sent[sessionId])
+
+	// the weekly transfer moves to the next week, the one-off transfer is done
+	schedules, err := common.ReadSchedules(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(schedules))
+	assert.Equal(t, weekly.Id, schedules[0].Id)
+	assert.Equal(t, weekly.Start.AddDate(0, 0, 7), schedules[0].Next)
+	assert.Equal(t, later.Id, schedules[1].Id)
+
+	// nothing more is due
+	err = sc.RunDue(ctx, now)
+	assert.NoError(t, err)
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 1)
+
+	// the schedules of a closed account are cancelled
+	_, err = common.CloseAccount(ctx, store, sessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sc.RunDue(ctx, now.AddDate(0, 0, 7))
+	assert.NoError(t, err)
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 1)
+	schedules, err = common.ReadSchedules(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(schedules))
+	sessionIds, err := common.ScheduledAccounts(ctx, store)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(sessionIds))
+}
+
+func TestRunDueChecks(t *testing.T) {
+	swapped := "+254712345678"
+	limited := "+254711111111"
+	publicKey := "0x8a6ad4e22c1e5d4be5ee8e1d7f57d7c5c4e6ef21"
+	ctx, store := teststore.InitializeTestStore(t)
+	now := time.Now()
+
+	s := common.Schedule{
+		Recipient:        "+254787654321",
+		RecipientAddress: "0x5523058cdFfe5F3c1EaDADD5015E55C6E00fb439",
+		Amount:           "2.5",
+		Symbol:           "SRF",
+		Address:          "0xd4c288865Ce",
+		Decimals:         "6",
+		Frequency:        common.FrequencyOnce,
+		Start:            now.Add(-time.Hour),
+	}
+	for _, sessionId := range []string{swapped, limited} {
+		err := store.WriteEntry(ctx, sessionId, common.DATA_PUBLIC_KEY, []byte(publicKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = common.AddSchedule(ctx, store, sessionId, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mockAccountService := new(mocks.MockAccountService)
+	mockAccountService.On("FetchVouchers", publicKey).Return([]dataserviceapi.TokenHoldings{
+		{ContractAddress: "0xd4c288865Ce", TokenSymbol: "SRF", TokenDecimals: "6", Balance: "3000000"},
+	}, nil)
+	mockAccountService.On("TokenTransfer").Return(&models.TokenTransferResponse{TrackingId: "1234567890"}, nil)
+	notifier := &testNotifier{sent: make(map[string][]string)}
+	engine := risk.NewEngineFromConfig(&risk.Config{
+		Default: risk.Limits{MaxPerTransaction: 2},
+	})
+	checker := operator.NewStubChecker().WithSimChange(swapped, now.Add(-time.Hour))
+
+	sc := NewScheduler(store, mockAccountService, notifier).WithRiskEngine(engine).WithSimChecker(checker)
+	err := sc.RunDue(ctx, now)
+	assert.NoError(t, err)
+	mockAccountService.AssertNumberOfCalls(t, "TokenTransfer", 0)
+	assert.Equal(t, []string{"Your scheduled transfer of 2.5 SRF to +254787654321 was not made, as your SIM card was recently changed."}, notifier.sent[swapped])
+	assert.Equal(t, []string{"Your scheduled transfer of 2.5 SRF to +254787654321 was not made, as it is above the limit of 2 SRF per transaction."}, notifier.sent[limited])
+}
@@ -8,10 +8,14 @@ import (
 type Storage struct {
 	Persister *persist.Persister
 	UserdataDb db.Db	
+	// StateDb is the store the persister keeps the menu state in.
+	StateDb db.Db
 }
 
 type StorageProvider interface {
 	Get(sessionId string) (*Storage, error)
+	// GetTransport returns the storage for a transport session of the user, keeping the menu state apart from other sessions of the user.
+	GetTransport(sessionId string, transportId string) (*Storage, error)
 	Put(sessionId string, storage *Storage) error
 	Close() error
 }
@@ -27,6 +31,7 @@ func NewSimpleStorageProvider(stateStore db.Db, userdataStore db.Db) StorageProv
 		Storage: &Storage{
 			Persister: pe,
 			UserdataDb: userdataStore,
+			StateDb: stateStore,
 		},
 	}
 }
@@ -35,6 +40,17 @@ func (p *SimpleStorageProvider) Get(sessionId string) (*Storage, error) {
 	return p.Storage, nil
 }
 
+func (p *SimpleStorageProvider) GetTransport(sessionId string, transportId string) (*Storage, error) {
+	stateDb := NewTransportStateDb(p.StateDb, transportId)
+	pe := persist.NewPersister(stateDb)
+	pe = pe.WithFlush()
+	return &Storage{
+		Persister: pe,
+		UserdataDb: p.UserdataDb,
+		StateDb: stateDb,
+	}, nil
+}
+
 func (p *SimpleStorageProvider) Put(sessionId string, storage *Storage) error {
 	return nil
 }
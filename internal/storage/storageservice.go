@@ -30,8 +30,8 @@ type MenuStorageService struct {
 	dbDir         string
 	resourceDir   string
 	resourceStore db.Db
-	stateStore    db.Db
-	userDataStore db.Db
+	stateStore    *SyncDb
+	userDataStore *SyncDb
 }
 
 func buildConnStr() string {
@@ -103,15 +103,25 @@ func (ms *MenuStorageService) GetUserdataDb(ctx context.Context) (db.Db, error)
 		return ms.userDataStore, nil
 	}
 
-	userDataStore, err := ms.getOrCreateDb(ctx, ms.userDataStore, "userdata.gdbm")
+	userDataStore, err := ms.getOrCreateDb(ctx, nil, "userdata.gdbm")
 	if err != nil {
 		return nil, err
 	}
 
-	ms.userDataStore = userDataStore
+	ms.userDataStore = NewSyncDb(userDataStore)
 	return ms.userDataStore, nil
 }
 
+// GetUserdataHandle returns a new handle to the user data database, for use by a goroutine other than the one
+// the database from GetUserdataDb is used by.
+func (ms *MenuStorageService) GetUserdataHandle(ctx context.Context) (db.Db, error) {
+	_, err := ms.GetUserdataDb(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ms.userDataStore.Handle(), nil
+}
+
 func (ms *MenuStorageService) GetResource(ctx context.Context) (resource.Resource, error) {
 	ms.resourceStore = fsdb.NewFsDb()
 	err := ms.resourceStore.Connect(ctx, ms.resourceDir)
@@ -127,15 +137,25 @@ func (ms *MenuStorageService) GetStateStore(ctx context.Context) (db.Db, error)
 		return ms.stateStore, nil
 	}
 
-	stateStore, err := ms.getOrCreateDb(ctx, ms.stateStore, "state.gdbm")
+	stateStore, err := ms.getOrCreateDb(ctx, nil, "state.gdbm")
 	if err != nil {
 		return nil, err
 	}
 
-	ms.stateStore = stateStore
+	ms.stateStore = NewSyncDb(stateStore)
 	return ms.stateStore, nil
 }
 
+// GetStateHandle returns a new handle to the state database, for use by a goroutine other than the one the
+// database from GetStateStore is used by.
+func (ms *MenuStorageService) GetStateHandle(ctx context.Context) (db.Db, error) {
+	_, err := ms.GetStateStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ms.stateStore.Handle(), nil
+}
+
 func (ms *MenuStorageService) EnsureDbDir() error {
 	err := os.MkdirAll(ms.dbDir, 0700)
 	if err != nil {
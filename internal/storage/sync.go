@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"sync"
+
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/lang"
+)
+
+var _ db.Db = (*SyncDb)(nil)
+
+// SyncDb serializes access to a database shared between goroutines.
+//
+// A db.Db keeps the session, prefix and language of the next Get or Put, so goroutines sharing one can read and
+// write each other's sessions. Each goroutine should use its own handle from Handle instead: a handle keeps its
+// own session, prefix and language, and applies them to the database under a lock shared by all handles, for
+// each Get or Put.
+type SyncDb struct {
+	db        db.Db
+	mu        *sync.Mutex
+	sessionId string
+	pfx       uint8
+	lng       *lang.Language
+}
+
+// NewSyncDb creates a new SyncDb over the connected database.
+func NewSyncDb(store db.Db) *SyncDb {
+	return &SyncDb{
+		db:  store,
+		mu:  &sync.Mutex{},
+		pfx: store.Prefix(),
+	}
+}
+
+// Handle returns a new handle to the database, sharing the lock of the handle it is made from.
+func (s *SyncDb) Handle() *SyncDb {
+	return &SyncDb{
+		db:  s.db,
+		mu:  s.mu,
+		pfx: s.pfx,
+	}
+}
+
+// Connect connects the database of all handles.
+func (s *SyncDb) Connect(ctx context.Context, connStr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Connect(ctx, connStr)
+}
+
+// Close closes the database of all handles.
+func (s *SyncDb) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Close()
+}
+
+func (s *SyncDb) SetPrefix(pfx uint8) {
+	s.pfx = pfx
+}
+
+func (s *SyncDb) SetSession(sessionId string) {
+	s.sessionId = sessionId
+}
+
+func (s *SyncDb) SetLanguage(lng *lang.Language) {
+	s.lng = lng
+}
+
+func (s *SyncDb) Prefix() uint8 {
+	return s.pfx
+}
+
+func (s *SyncDb) Safe() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Safe()
+}
+
+func (s *SyncDb) SetLock(typ uint8, locked bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.SetLock(typ, locked)
+}
+
+func (s *SyncDb) Get(ctx context.Context, key []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apply()
+	return s.db.Get(ctx, key)
+}
+
+func (s *SyncDb) Put(ctx context.Context, key []byte, val []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apply()
+	return s.db.Put(ctx, key, val)
+}
+
+// apply sets the session, prefix and language of the handle on the database. The lock must be held.
+func (s *SyncDb) apply() {
+	s.db.SetSession(s.sessionId)
+	s.db.SetPrefix(s.pfx)
+	s.db.SetLanguage(s.lng)
+}
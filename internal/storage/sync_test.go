@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"git.defalsify.org/vise.git/db"
+	memdb "git.defalsify.org/vise.git/db/mem"
+)
+
+func TestSyncDb(t *testing.T) {
+	ctx := context.Background()
+	store := memdb.NewMemDb()
+	err := store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sdb := NewSyncDb(store)
+
+	// each handle writes to its own session while the others change theirs
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		h := sdb.Handle()
+		sessionId := fmt.Sprintf("+25471234567%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				h.SetPrefix(db.DATATYPE_USERDATA)
+				h.SetSession(sessionId)
+				err := h.Put(ctx, []byte("foo"), []byte(sessionId))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 8; i++ {
+		sessionId := fmt.Sprintf("+25471234567%d", i)
+		sdb.SetPrefix(db.DATATYPE_USERDATA)
+		sdb.SetSession(sessionId)
+		r, err := sdb.Get(ctx, []byte("foo"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(r, []byte(sessionId)) {
+			t.Fatalf("expected %s, got %s", sessionId, r)
+		}
+	}
+}
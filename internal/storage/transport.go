@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"git.defalsify.org/vise.git/db"
+)
+
+// TransportStateDb keeps the menu state of each transport session of a user apart, by adding the
+// transport session id to the keys of the state store.
+//
+// A new transport session finds no state, and starts from the root menu. An empty entry is treated
+// as missing, so that the state of a session that has ended can be cleared.
+type TransportStateDb struct {
+	db.Db
+	transportId string
+}
+
+// NewTransportStateDb creates a new TransportStateDb for the transport session id.
+func NewTransportStateDb(store db.Db, transportId string) *TransportStateDb {
+	return &TransportStateDb{
+		Db:          store,
+		transportId: transportId,
+	}
+}
+
+func (tdb *TransportStateDb) toKey(key []byte) []byte {
+	k := make([]byte, 0, len(key)+1+len(tdb.transportId))
+	k = append(k, key...)
+	k = append(k, ':')
+	return append(k, tdb.transportId...)
+}
+
+func (tdb *TransportStateDb) Get(ctx context.Context, key []byte) ([]byte, error) {
+	v, err := tdb.Db.Get(ctx, tdb.toKey(key))
+	if err != nil {
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, db.NewErrNotFound(key)
+	}
+	return v, nil
+}
+
+func (tdb *TransportStateDb) Put(ctx context.Context, key []byte, val []byte) error {
+	return tdb.Db.Put(ctx, tdb.toKey(key), val)
+}
+
+// ReadInputCount returns the number of inputs of the transport session that have been fed to the engine,
+// and whether any request of the transport session has been handled yet.
+func ReadInputCount(ctx context.Context, store db.Db, sessionId string) (int, bool, error) {
+	store.SetSession("")
+	v, err := NewSubPrefixDb(store, []byte("input")).Get(ctx, []byte(sessionId))
+	if err != nil {
+		if db.IsNotFound(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	if len(v) == 0 {
+		return 0, false, nil
+	}
+	n, err := strconv.Atoi(string(v))
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid input count: %v", err)
+	}
+	return n, true, nil
+}
+
+// WriteInputCount stores the number of inputs of the transport session that have been fed to the engine.
+//
+// A negative count clears it.
+func WriteInputCount(ctx context.Context, store db.Db, sessionId string, n int) error {
+	var v []byte
+	if n >= 0 {
+		v = []byte(strconv.Itoa(n))
+	}
+	store.SetSession("")
+	return NewSubPrefixDb(store, []byte("input")).Put(ctx, []byte(sessionId), v)
+}
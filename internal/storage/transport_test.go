@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"git.defalsify.org/vise.git/db"
+	memdb "git.defalsify.org/vise.git/db/mem"
+)
+
+func TestTransportStateDb(t *testing.T) {
+	ctx := context.Background()
+	store := memdb.NewMemDb()
+	err := store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetPrefix(db.DATATYPE_STATE)
+
+	tdba := NewTransportStateDb(store, "ATUid_1")
+	err = tdba.Put(ctx, []byte("+254712345678"), []byte("tinkywinky"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := tdba.Get(ctx, []byte("+254712345678"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r, []byte("tinkywinky")) {
+		t.Fatalf("expected 'tinkywinky', got %s", r)
+	}
+
+	tdbb := NewTransportStateDb(store, "ATUid_2")
+	_, err = tdbb.Get(ctx, []byte("+254712345678"))
+	if !db.IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	_, err = store.Get(ctx, []byte("+254712345678"))
+	if !db.IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	err = tdba.Put(ctx, []byte("+254712345678"), []byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tdba.Get(ctx, []byte("+254712345678"))
+	if !db.IsNotFound(err) {
+		t.Fatalf("expected not found after clearing, got %v", err)
+	}
+}
+
+func TestInputCount(t *testing.T) {
+	ctx := context.Background()
+	store := memdb.NewMemDb()
+	err := store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tdb := NewTransportStateDb(store, "ATUid_1")
+
+	n, seen, err := ReadInputCount(ctx, tdb, "+254712345678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen || n != 0 {
+		t.Fatalf("expected no input count, got %d", n)
+	}
+
+	err = WriteInputCount(ctx, tdb, "+254712345678", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, seen, err = ReadInputCount(ctx, tdb, "+254712345678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !seen || n != 3 {
+		t.Fatalf("expected input count 3, got %d", n)
+	}
+
+	_, seen, err = ReadInputCount(ctx, NewTransportStateDb(store, "ATUid_2"), "+254712345678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen {
+		t.Fatal("expected no input count for other transport session")
+	}
+
+	err = WriteInputCount(ctx, tdb, "+254712345678", -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, seen, err = ReadInputCount(ctx, tdb, "+254712345678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen {
+		t.Fatal("expected input count to be cleared")
+	}
+}
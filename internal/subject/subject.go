@@ -0,0 +1,309 @@
+package subject
+
+import (
+	"context"
+	"encoding/base64"
+	"time"
+	"unicode/utf8"
+
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/logging"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/risk"
+	"git.grassecon.net/urdt/ussd/internal/storage"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("subject")
+)
+
+// Policy is what happens to an entry when the data of a session id is erased.
+type Policy int
+
+const (
+	// Erase overwrites the entry with an empty value.
+	Erase Policy = iota
+	// Anonymise removes the personal data from the entry and keeps the rest.
+	Anonymise
+	// Keep leaves the entry as it is.
+	Keep
+)
+
+// field describes a DataTyp entry held for each session id.
+type field struct {
+	typ    common.DataTyp
+	name   string
+	policy Policy
+	// redact hides the value in exports, for secrets such as PINs.
+	redact bool
+	// reason explains why an entry is kept.
+	reason string
+}
+
+var (
+	fields = []field{
+		{typ: common.DATA_ACCOUNT, name: "account", policy: Erase},
+		{typ: common.DATA_ACCOUNT_CREATED, name: "account_created", policy: Keep, reason: "records that an account was created with the custodial service"},
+		{typ: common.DATA_TRACKING_ID, name: "tracking_id", policy: Keep, reason: "references the account creation at the custodial service"},
+		{typ: common.DATA_PUBLIC_KEY, name: "public_key", policy: Keep, reason: "the account address, which transfers on the chain refer to"},
+		{typ: common.DATA_CUSTODIAL_ID, name: "custodial_id", policy: Keep, reason: "references the account at the custodial service"},
+		{typ: common.DATA_ACCOUNT_PIN, name: "account_pin", policy: Erase, redact: true},
+		{typ: common.DATA_ACCOUNT_STATUS, name: "account_status", policy: Keep, reason: "records the state of the account at the custodial service"},
+		{typ: common.DATA_FIRST_NAME, name: "first_name", policy: Erase},
+		{typ: common.DATA_FAMILY_NAME, name: "family_name", policy: Erase},
+		{typ: common.DATA_YOB, name: "yob", policy: Erase},
+		{typ: common.DATA_LOCATION, name: "location", policy: Erase},
+		{typ: common.DATA_GENDER, name: "gender", policy: Erase},
+		{typ: common.DATA_OFFERINGS, name: "offerings", policy: Erase},
+		{typ: common.DATA_RECIPIENT, name: "recipient", policy: Erase},
+		{typ: common.DATA_AMOUNT, name: "amount", policy: Erase},
+		{typ: common.DATA_TEMPORARY_VALUE, name: "temporary_value", policy: Erase, redact: true},
+		{typ: common.DATA_ACTIVE_SYM, name: "active_sym", policy: Erase},
+		{typ: common.DATA_ACTIVE_BAL, name: "active_bal", policy: Erase},
+		{typ: common.DATA_BLOCKED_NUMBER, name: "blocked_number", policy: Erase},
+		{typ: common.DATA_ACTIVE_DECIMAL, name: "active_decimal", policy: Erase},
+		{typ: common.DATA_ACTIVE_ADDRESS, name: "active_address", policy: Erase},
+		{typ: common.DATA_TRANSACTIONS, name: "transactions", policy: Erase},
+		{typ: common.DATA_ALIAS, name: "alias", policy: Erase},
+		{typ: common.DATA_PIN_HISTORY, name: "pin_history", policy: Erase, redact: true},
+		{typ: common.DATA_AUTH_TOKEN, name: "auth_token", policy: Erase, redact: true},
+		{typ: common.DATA_TRANSFER_LOG, name: "transfer_log", policy: Anonymise, reason: "transfers are kept for auditing, with the recipients replaced by a hash"},
+		{typ: common.DATA_ACCOUNT_FROZEN, name: "account_frozen", policy: Keep, reason: "protects a frozen account against misuse"},
+		{typ: common.DATA_GUARDIANS, name: "guardians", policy: Erase},
+		{typ: common.DATA_RECOVERY_REQUEST, name: "recovery_request", policy: Erase},
+		{typ: common.DATA_TERMS_ACCEPTANCE, name: "terms_acceptance", policy: Keep, reason: "record of consent to the terms and conditions"},
+		{typ: common.DATA_NUMBER_CHANGE, name: "number_change", policy: Erase, redact: true},
+		{typ: common.DATA_PORTED_TO, name: "ported_to", policy: Keep, reason: "redirects transfers to the number the account was moved to"},
+		{typ: common.DATA_ACCOUNT_CLOSED, name: "account_closed", policy: Keep, reason: "keeps a closed account from being used until support reactivates it"},
+		{typ: common.DATA_LANGUAGE_CODE, name: "language_code", policy: Erase},
+		{typ: common.DATA_ACTIVE_BAL_UPDATED, name: "active_bal_updated", policy: Erase},
+		{typ: common.DATA_BATCH, name: "batch", policy: Erase},
+		{typ: common.DATA_PIN_CHANGE_REQUIRED, name: "pin_change_required", policy: Erase},
+		{typ: common.DATA_PAYMENT_REQUESTS, name: "payment_requests", policy: Erase},
+		{typ: common.DATA_PAYMENT_REQUEST_DRAFT, name: "payment_request_draft", policy: Erase},
+		{typ: common.DATA_SCHEDULES, name: "schedules", policy: Erase},
+		{typ: common.DATA_SCHEDULE_DRAFT, name: "schedule_draft", policy: Erase},
+		{typ: common.DATA_KNOWN_RECIPIENTS, name: "known_recipients", policy: Erase},
+	}
+
+	// unreachable explains the data that is held about a session id but cannot be removed by the service.
+	unreachable = []Retention{
+		{Name: "chain", Reason: "transfers recorded on the chain and by the custodial service cannot be removed"},
+		{Name: "menu_state", Reason: "the menu state of the last session cannot be deleted from the state store, it is replaced on the next session"},
+		{Name: "indexes", Reason: "the number may remain listed in the terms acceptance, account creation, closed account, scheduled transfer and escrowed transfer indexes"},
+		{Name: "other_accounts", Reason: "the number may remain in the guardians, transfer logs, known recipients and blocked numbers of other accounts"},
+		{Name: "public_key_reverse", Reason: "the lookup from the account address to the number is kept to route incoming transfers"},
+	}
+)
+
+// Entry is a value held about a session id.
+type Entry struct {
+	Name string `json:"name"`
+	// Value is the value as text, or base64 if Encoding is "base64".
+	Value    string `json:"value,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Redacted bool   `json:"redacted,omitempty"`
+}
+
+func newEntry(name string, v []byte, redact bool) Entry {
+	e := Entry{
+		Name:     name,
+		Redacted: redact,
+	}
+	if redact {
+		return e
+	}
+	if utf8.Valid(v) {
+		e.Value = string(v)
+	} else {
+		e.Value = base64.StdEncoding.EncodeToString(v)
+		e.Encoding = "base64"
+	}
+	return e
+}
+
+// Retention explains why data about a session id was kept.
+type Retention struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// Export is everything held about a session id.
+type Export struct {
+	SessionId string    `json:"session_id"`
+	Time      time.Time `json:"time"`
+	Entries   []Entry   `json:"entries"`
+	Vouchers  []Entry   `json:"vouchers"`
+	// State is the serialized menu state of the last session.
+	State []byte `json:"state,omitempty"`
+	// Retained lists the data that would be kept on erasure.
+	Retained []Retention `json:"retained"`
+}
+
+// Report is the outcome of erasing the data of a session id.
+type Report struct {
+	SessionId  string      `json:"session_id"`
+	Time       time.Time   `json:"time"`
+	Erased     []string    `json:"erased"`
+	Anonymised []string    `json:"anonymised"`
+	Retained   []Retention `json:"retained"`
+}
+
+// Service exports and erases the data held about a session id.
+//
+// The store does not support deleting keys, so erased entries are overwritten with an empty value,
+// which the handlers treat the same as a missing entry.
+type Service struct {
+	store      common.DataStore
+	stateStore db.Db
+	voucherDb  storage.PrefixDb
+}
+
+// NewService creates a new Service.
+//
+// The store should be the one used by the handlers, so that encrypted entries can be exported. The
+// state store may be nil, in which case the menu state is not exported.
+func NewService(store common.DataStore, stateStore db.Db) *Service {
+	return &Service{
+		store:      store,
+		stateStore: stateStore,
+		voucherDb:  storage.NewSubPrefixDb(store, []byte("vouchers")),
+	}
+}
+
+// Export returns everything held about the session id.
+func (s *Service) Export(ctx context.Context, sessionId string) (*Export, error) {
+	r := &Export{
+		SessionId: sessionId,
+		Time:      time.Now(),
+	}
+	for _, f := range fields {
+		v, err := s.store.ReadEntry(ctx, sessionId, f.typ)
+		if err != nil {
+			if db.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if len(v) == 0 {
+			continue
+		}
+		r.Entries = append(r.Entries, newEntry(f.name, v, f.redact))
+		if f.policy != Erase {
+			r.Retained = append(r.Retained, Retention{Name: f.name, Reason: f.reason})
+		}
+	}
+
+	vouchers, err := s.readVouchers(ctx, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range common.VoucherKeys {
+		if v, ok := vouchers[key]; ok {
+			r.Vouchers = append(r.Vouchers, newEntry(key, v, false))
+		}
+	}
+
+	if s.stateStore != nil {
+		s.stateStore.SetPrefix(db.DATATYPE_STATE)
+		s.stateStore.SetSession("")
+		v, err := s.stateStore.Get(ctx, []byte(sessionId))
+		if err != nil && !db.IsNotFound(err) {
+			return nil, err
+		}
+		r.State = v
+	}
+	r.Retained = append(r.Retained, unreachable...)
+	return r, nil
+}
+
+// Erase erases or anonymises the data held about the session id, and reports what was kept.
+func (s *Service) Erase(ctx context.Context, sessionId string) (*Report, error) {
+	r := &Report{
+		SessionId: sessionId,
+		Time:      time.Now(),
+	}
+
+	err := common.ReleaseAlias(ctx, s.store, sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range fields {
+		v, err := s.store.ReadEntry(ctx, sessionId, f.typ)
+		if err != nil {
+			if db.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if len(v) == 0 {
+			continue
+		}
+		switch f.policy {
+		case Erase:
+			err = s.store.WriteEntry(ctx, sessionId, f.typ, []byte{})
+			if err != nil {
+				return nil, err
+			}
+			r.Erased = append(r.Erased, f.name)
+		case Anonymise:
+			err = s.anonymise(ctx, sessionId, f.typ)
+			if err != nil {
+				return nil, err
+			}
+			r.Anonymised = append(r.Anonymised, f.name)
+			r.Retained = append(r.Retained, Retention{Name: f.name, Reason: f.reason})
+		case Keep:
+			r.Retained = append(r.Retained, Retention{Name: f.name, Reason: f.reason})
+		}
+	}
+
+	vouchers, err := s.readVouchers(ctx, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range common.VoucherKeys {
+		if _, ok := vouchers[key]; !ok {
+			continue
+		}
+		s.store.SetSession(sessionId)
+		err = s.voucherDb.Put(ctx, []byte(key), []byte{})
+		if err != nil {
+			return nil, err
+		}
+		r.Erased = append(r.Erased, "vouchers."+key)
+	}
+
+	r.Retained = append(r.Retained, unreachable...)
+	logg.InfoCtxf(ctx, "erased data subject", "session", sessionId, "erased", len(r.Erased), "anonymised", len(r.Anonymised), "retained", len(r.Retained))
+	return r, nil
+}
+
+func (s *Service) anonymise(ctx context.Context, sessionId string, typ common.DataTyp) error {
+	switch typ {
+	case common.DATA_TRANSFER_LOG:
+		return risk.NewLedger(s.store).Anonymise(ctx, sessionId)
+	}
+	return nil
+}
+
+// readVouchers returns the non-empty voucher entries cached for the session id.
+func (s *Service) readVouchers(ctx context.Context, sessionId string) (map[string][]byte, error) {
+	r := make(map[string][]byte)
+	for _, key := range common.VoucherKeys {
+		s.store.SetSession(sessionId)
+		v, err := s.voucherDb.Get(ctx, []byte(key))
+		if err != nil {
+			if db.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if len(v) > 0 {
+			r[key] = v
+		}
+	}
+	return r, nil
+}
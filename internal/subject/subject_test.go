@@ -0,0 +1,91 @@
+package subject
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/risk"
+	"git.grassecon.net/urdt/ussd/internal/storage"
+	"git.grassecon.net/urdt/ussd/internal/testutil/teststore"
+)
+
+func names(entries []Entry) []string {
+	var r []string
+	for _, e := range entries {
+		r = append(r, e.Name)
+	}
+	return r
+}
+
+func TestExportErase(t *testing.T) {
+	sessionId := "+254700000000"
+	ctx, store := teststore.InitializeTestStore(t)
+	svc := NewService(store, nil)
+
+	for typ, v := range map[common.DataTyp]string{
+		common.DATA_FIRST_NAME:       "Jane",
+		common.DATA_ACCOUNT_PIN:      "1357",
+		common.DATA_PUBLIC_KEY:       "0x1234",
+		common.DATA_TERMS_ACCEPTANCE: "1|1700000000|ussd",
+	} {
+		err := store.WriteEntry(ctx, sessionId, typ, []byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := common.ClaimAlias(ctx, store, sessionId, "jane")
+	if err != nil {
+		t.Fatal(err)
+	}
+	amount, err := common.ParseDecimal("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = risk.NewLedger(store).Record(ctx, sessionId, risk.Transfer{Time: time.Now(), Voucher: "SRF", Amount: amount, Recipient: "+254711111111"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetSession(sessionId)
+	err = storage.NewSubPrefixDb(store, []byte("vouchers")).Put(ctx, []byte("sym"), []byte("1:SRF"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	export, err := svc.Export(ctx, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"public_key", "account_pin", "first_name", "alias", "transfer_log", "terms_acceptance", "known_recipients"}, names(export.Entries))
+	for _, e := range export.Entries {
+		if e.Name == "account_pin" {
+			assert.True(t, e.Redacted)
+			assert.Equal(t, "", e.Value)
+		}
+	}
+	assert.Equal(t, []string{"sym"}, names(export.Vouchers))
+
+	report, err := svc.Erase(ctx, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"account_pin", "first_name", "alias", "known_recipients", "vouchers.sym"}, report.Erased)
+	assert.Equal(t, []string{"transfer_log"}, report.Anonymised)
+	var retained []string
+	for _, r := range report.Retained {
+		assert.NotEqual(t, "", r.Reason)
+		retained = append(retained, r.Name)
+	}
+	assert.Equal(t, []string{"public_key", "transfer_log", "terms_acceptance"}, retained[:3])
+
+	_, err = common.ResolveAlias(ctx, store, "jane")
+	assert.Error(t, err)
+	history, err := risk.NewLedger(store).History(ctx, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(history.Transfers))
+	assert.NotEqual(t, "+254711111111", history.Transfers[0].Recipient)
+	assert.False(t, history.Known("+254711111111"))
+
+	export, err = svc.Export(ctx, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"public_key", "transfer_log", "terms_acceptance"}, names(export.Entries))
+	assert.Equal(t, 0, len(export.Vouchers))
+}
@@ -0,0 +1,112 @@
+package terms
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"git.defalsify.org/vise.git/logging"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("terms")
+)
+
+const (
+	// DefaultVersion is the version of the terms used when no registry is configured.
+	DefaultVersion = "1"
+	// MaxTextLength is the longest terms text that fits on the terms menu screens.
+	MaxTextLength = 96
+)
+
+// Version is a published version of the terms and conditions.
+type Version struct {
+	Id        string `json:"id"`
+	Published string `json:"published"`
+	// Texts holds the text shown to the user by ISO 639-3 language code, such as "eng" or "swa".
+	Texts map[string]string `json:"texts"`
+}
+
+// Text returns the text of the terms in the given language, if there is one.
+func (v Version) Text(lang string) (string, bool) {
+	s, ok := v.Texts[lang]
+	return s, ok && s != ""
+}
+
+// Registry holds the published versions of the terms and conditions.
+type Registry struct {
+	Current  string    `json:"current"`
+	Versions []Version `json:"versions"`
+}
+
+// NewRegistry creates a Registry with a single DefaultVersion without texts.
+func NewRegistry() *Registry {
+	return &Registry{
+		Current: DefaultVersion,
+		Versions: []Version{
+			{Id: DefaultVersion},
+		},
+	}
+}
+
+// LoadRegistry reads the registry from the JSON file at the given path.
+//
+// If the path is empty, the registry returned by NewRegistry is used.
+func LoadRegistry(fp string) (*Registry, error) {
+	if fp == "" {
+		return NewRegistry(), nil
+	}
+	b, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read terms registry file: %v", err)
+	}
+	var r Registry
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse terms registry file %s: %v", fp, err)
+	}
+	err = r.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid terms registry file %s: %v", fp, err)
+	}
+	logg.Infof("loaded terms registry", "path", fp, "current", r.Current)
+	return &r, nil
+}
+
+func (r *Registry) validate() error {
+	seen := make(map[string]bool)
+	for _, v := range r.Versions {
+		if v.Id == "" {
+			return fmt.Errorf("version without id")
+		}
+		if seen[v.Id] {
+			return fmt.Errorf("duplicate version %s", v.Id)
+		}
+		seen[v.Id] = true
+		for lang, s := range v.Texts {
+			if len(s) > MaxTextLength {
+				return fmt.Errorf("text of version %s in %s is longer than %d", v.Id, lang, MaxTextLength)
+			}
+		}
+	}
+	if !seen[r.Current] {
+		return fmt.Errorf("current version %q is not in the registry", r.Current)
+	}
+	return nil
+}
+
+// Get returns the version with the given id.
+func (r *Registry) Get(id string) (Version, bool) {
+	for _, v := range r.Versions {
+		if v.Id == id {
+			return v, true
+		}
+	}
+	return Version{}, false
+}
+
+// CurrentVersion returns the version users must have accepted.
+func (r *Registry) CurrentVersion() Version {
+	v, _ := r.Get(r.Current)
+	return v
+}
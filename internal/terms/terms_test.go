@@ -0,0 +1,67 @@
+package terms
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"git.grassecon.net/urdt/ussd/internal/testutil/teststore"
+)
+
+func TestLoadRegistry(t *testing.T) {
+	r, err := LoadRegistry("")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultVersion, r.CurrentVersion().Id)
+
+	fp := path.Join(t.TempDir(), "terms.json")
+	err = os.WriteFile(fp, []byte(`{"current":"2","versions":[{"id":"1"},{"id":"2","texts":{"eng":"Accept v2?"}}]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = LoadRegistry(fp)
+	assert.NoError(t, err)
+	text, ok := r.CurrentVersion().Text("eng")
+	assert.True(t, ok)
+	assert.Equal(t, "Accept v2?", text)
+	_, ok = r.CurrentVersion().Text("swa")
+	assert.False(t, ok)
+
+	err = os.WriteFile(fp, []byte(`{"current":"3","versions":[{"id":"1"},{"id":"2"}]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadRegistry(fp)
+	assert.Error(t, err)
+}
+
+func TestTracker(t *testing.T) {
+	ctx, store := teststore.InitializeTestStore(t)
+	tracker := NewTracker(store)
+
+	_, accepted, err := tracker.Latest(ctx, "+254700000001")
+	assert.NoError(t, err)
+	assert.False(t, accepted)
+
+	_, err = tracker.Accept(ctx, "+254700000001", "1", ChannelUssd)
+	assert.NoError(t, err)
+	_, err = tracker.Accept(ctx, "+254700000001", "2", ChannelUssd)
+	assert.NoError(t, err)
+	_, err = tracker.Accept(ctx, "+254700000002", "1", ChannelUssd)
+	assert.NoError(t, err)
+	err = tracker.Track(ctx, "+254700000003")
+	assert.NoError(t, err)
+
+	history, err := tracker.History(ctx, "+254700000001")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, "2", history[1].Version)
+	assert.Equal(t, ChannelUssd, history[1].Channel)
+
+	pending, latest, err := tracker.Pending(ctx, "2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"+254700000002", "+254700000003"}, pending)
+	assert.Equal(t, "1", latest[0].Version)
+	assert.Equal(t, "", latest[1].Version)
+}
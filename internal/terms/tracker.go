@@ -0,0 +1,189 @@
+package terms
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/storage"
+)
+
+const (
+	// ChannelUssd is the channel of acceptances made in a USSD session.
+	ChannelUssd = "ussd"
+
+	accountsKey = "accounts"
+)
+
+// Acceptance records that a user accepted a version of the terms.
+type Acceptance struct {
+	Version string
+	Time    time.Time
+	Channel string
+}
+
+func (a Acceptance) String() string {
+	return fmt.Sprintf("%s|%d|%s", a.Version, a.Time.Unix(), a.Channel)
+}
+
+func parseAcceptance(s string) (Acceptance, error) {
+	parts := strings.SplitN(s, "|", 3)
+	if len(parts) != 3 {
+		return Acceptance{}, fmt.Errorf("invalid terms acceptance: %q", s)
+	}
+	ts, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return Acceptance{}, err
+	}
+	return Acceptance{
+		Version: parts[0],
+		Time:    time.Unix(ts, 0),
+		Channel: parts[2],
+	}, nil
+}
+
+// Tracker records the terms accepted by each account.
+//
+// It also keeps an index of the accounts it has seen, so that accounts that have not accepted
+// a version can be listed.
+type Tracker struct {
+	store   common.DataStore
+	indexDb storage.PrefixDb
+	mu      sync.Mutex
+}
+
+// NewTracker creates a new Tracker.
+func NewTracker(store common.DataStore) *Tracker {
+	return &Tracker{
+		store:   store,
+		indexDb: storage.NewSubPrefixDb(store, []byte("terms")),
+	}
+}
+
+// History returns all acceptances of the session id, oldest first.
+func (t *Tracker) History(ctx context.Context, sessionId string) ([]Acceptance, error) {
+	v, err := t.store.ReadEntry(ctx, sessionId, common.DATA_TERMS_ACCEPTANCE)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var r []Acceptance
+	for _, line := range strings.Split(string(v), "\n") {
+		if line == "" {
+			continue
+		}
+		a, err := parseAcceptance(line)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, a)
+	}
+	return r, nil
+}
+
+// Latest returns the most recent acceptance of the session id, and false if it has never accepted any terms.
+func (t *Tracker) Latest(ctx context.Context, sessionId string) (Acceptance, bool, error) {
+	history, err := t.History(ctx, sessionId)
+	if err != nil || len(history) == 0 {
+		return Acceptance{}, false, err
+	}
+	return history[len(history)-1], true, nil
+}
+
+// Accept records the acceptance of the given version by the session id.
+func (t *Tracker) Accept(ctx context.Context, sessionId string, version string, channel string) (Acceptance, error) {
+	a := Acceptance{
+		Version: version,
+		Time:    time.Now(),
+		Channel: channel,
+	}
+	history, err := t.History(ctx, sessionId)
+	if err != nil {
+		return a, err
+	}
+	var lines []string
+	for _, h := range history {
+		lines = append(lines, h.String())
+	}
+	lines = append(lines, a.String())
+	err = t.store.WriteEntry(ctx, sessionId, common.DATA_TERMS_ACCEPTANCE, []byte(strings.Join(lines, "\n")))
+	if err != nil {
+		return a, err
+	}
+	logg.InfoCtxf(ctx, "terms accepted", "session", sessionId, "version", version, "channel", channel)
+	return a, t.Track(ctx, sessionId)
+}
+
+// Track adds the session id to the index of accounts.
+func (t *Tracker) Track(ctx context.Context, sessionId string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sessionIds, err := t.readAccounts(ctx)
+	if err != nil {
+		return err
+	}
+	for _, v := range sessionIds {
+		if v == sessionId {
+			return nil
+		}
+	}
+	sessionIds = append(sessionIds, sessionId)
+	return t.indexDb.Put(ctx, []byte(accountsKey), []byte(strings.Join(sessionIds, "\n")))
+}
+
+// Accounts returns the index of accounts.
+func (t *Tracker) Accounts(ctx context.Context) ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.readAccounts(ctx)
+}
+
+// readAccounts reads the index of accounts.
+//
+// The index is kept outside of any session.
+func (t *Tracker) readAccounts(ctx context.Context) ([]string, error) {
+	t.store.SetSession("")
+	v, err := t.indexDb.Get(ctx, []byte(accountsKey))
+	if err != nil {
+		if db.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, nil
+	}
+	return strings.Split(string(v), "\n"), nil
+}
+
+// Pending returns the accounts in the index whose latest acceptance is not of the given version.
+//
+// The latest acceptance of each account is returned alongside, with an empty Version if it has never accepted any terms.
+func (t *Tracker) Pending(ctx context.Context, version string) ([]string, []Acceptance, error) {
+	sessionIds, err := t.Accounts(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var pending []string
+	var latest []Acceptance
+	for _, sessionId := range sessionIds {
+		a, _, err := t.Latest(ctx, sessionId)
+		if err != nil {
+			return nil, nil, err
+		}
+		if a.Version == version {
+			continue
+		}
+		pending = append(pending, sessionId)
+		latest = append(latest, a)
+	}
+	return pending, latest, nil
+}
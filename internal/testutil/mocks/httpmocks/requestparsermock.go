@@ -1,9 +1,12 @@
 package httpmocks
 
-// MockRequestParser implements the handlers.RequestParser interface for testing
+// MockRequestParser implements the handlers.RequestParser, handlers.TransportSessionParser and handlers.InputHistoryParser
+// interfaces for testing
 type MockRequestParser struct {
-	GetSessionIdFunc func(any) (string, error)
-	GetInputFunc     func(any) ([]byte, error)
+	GetSessionIdFunc          func(any) (string, error)
+	GetInputFunc              func(any) ([]byte, error)
+	GetTransportSessionIdFunc func(any) (string, error)
+	GetInputHistoryFunc       func(any) ([][]byte, error)
 }
 
 func (m *MockRequestParser) GetSessionId(rq any) (string, error) {
@@ -13,3 +16,17 @@ func (m *MockRequestParser) GetSessionId(rq any) (string, error) {
 func (m *MockRequestParser) GetInput(rq any) ([]byte, error) {
 	return m.GetInputFunc(rq)
 }
+
+func (m *MockRequestParser) GetTransportSessionId(rq any) (string, error) {
+	if m.GetTransportSessionIdFunc == nil {
+		return "", nil
+	}
+	return m.GetTransportSessionIdFunc(rq)
+}
+
+func (m *MockRequestParser) GetInputHistory(rq any) ([][]byte, error) {
+	if m.GetInputHistoryFunc == nil {
+		return nil, nil
+	}
+	return m.GetInputHistoryFunc(rq)
+}
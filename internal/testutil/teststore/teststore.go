@@ -0,0 +1,24 @@
+package teststore
+
+import (
+	"context"
+	"testing"
+
+	memdb "git.defalsify.org/vise.git/db/mem"
+
+	"git.grassecon.net/urdt/ussd/common"
+)
+
+// InitializeTestStore sets up and returns an in-memory database and store, which is closed when the test ends.
+func InitializeTestStore(t *testing.T) (context.Context, *common.UserDataStore) {
+	ctx := context.Background()
+	db := memdb.NewMemDb()
+	err := db.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return ctx, &common.UserDataStore{Db: db}
+}